@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// errorCode is a machine-readable reason a request failed, sent alongside
+// the message so clients don't have to match on wording that may change.
+type errorCode string
+
+const (
+	codeBadRequest           errorCode = "BAD_REQUEST"
+	codeValidationFailed     errorCode = "VALIDATION_FAILED"
+	codeInvalidID            errorCode = "INVALID_ID"
+	codeUnauthorized         errorCode = "UNAUTHORIZED"
+	codeForbidden            errorCode = "FORBIDDEN"
+	codeNotOwner             errorCode = "NOT_OWNER"
+	codeNotFound             errorCode = "NOT_FOUND"
+	codeVideoNotFound        errorCode = "VIDEO_NOT_FOUND"
+	codeNotAcceptable        errorCode = "NOT_ACCEPTABLE"
+	codeConflict             errorCode = "CONFLICT"
+	codeVideoAlreadyUploaded errorCode = "VIDEO_ALREADY_UPLOADED"
+	codeGone                 errorCode = "GONE"
+	codePayloadTooLarge      errorCode = "PAYLOAD_TOO_LARGE"
+	codeUnsupportedMediaType errorCode = "UNSUPPORTED_MEDIA_TYPE"
+	codeRangeNotSatisfiable  errorCode = "RANGE_NOT_SATISFIABLE"
+	codeUnprocessable        errorCode = "UNPROCESSABLE"
+	codeQuotaExceeded        errorCode = "QUOTA_EXCEEDED"
+	codePlanLimitExceeded    errorCode = "PLAN_LIMIT_EXCEEDED"
+	codeRateLimited          errorCode = "RATE_LIMITED"
+	codeInternal             errorCode = "INTERNAL_ERROR"
+	codeNotImplemented       errorCode = "NOT_IMPLEMENTED"
+	codeUpstreamFailed       errorCode = "UPSTREAM_FAILED"
+	codeStorageUnavailable   errorCode = "STORAGE_UNAVAILABLE"
+	codeUnavailable          errorCode = "UNAVAILABLE"
+	codeInsufficientStorage  errorCode = "INSUFFICIENT_STORAGE"
+)
+
+// statusErrorCodes is the code for errors that don't have a more specific
+// one.
+var statusErrorCodes = map[int]errorCode{
+	http.StatusBadRequest:                   codeBadRequest,
+	http.StatusUnauthorized:                 codeUnauthorized,
+	http.StatusPaymentRequired:              codePlanLimitExceeded,
+	http.StatusForbidden:                    codeForbidden,
+	http.StatusNotFound:                     codeNotFound,
+	http.StatusNotAcceptable:                codeNotAcceptable,
+	http.StatusConflict:                     codeConflict,
+	http.StatusGone:                         codeGone,
+	http.StatusRequestEntityTooLarge:        codePayloadTooLarge,
+	http.StatusUnsupportedMediaType:         codeUnsupportedMediaType,
+	http.StatusRequestedRangeNotSatisfiable: codeRangeNotSatisfiable,
+	http.StatusUnprocessableEntity:          codeUnprocessable,
+	http.StatusTooManyRequests:              codeRateLimited,
+	http.StatusInternalServerError:          codeInternal,
+	http.StatusNotImplemented:               codeNotImplemented,
+	http.StatusBadGateway:                   codeUpstreamFailed,
+	http.StatusServiceUnavailable:           codeUnavailable,
+	http.StatusInsufficientStorage:          codeInsufficientStorage,
+}
+
+// apiError is a failure as a client sees it: the status it's sent with, its
+// code and its message.
+type apiError struct {
+	status  int
+	code    errorCode
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// Failures that are reported the same way wherever they happen.
+var (
+	errInvalidID      = &apiError{http.StatusBadRequest, codeInvalidID, "Invalid ID"}
+	errInvalidVideoID = &apiError{http.StatusBadRequest, codeInvalidID, "Invalid video ID"}
+	errVideoNotFound  = &apiError{http.StatusNotFound, codeVideoNotFound, "Video not found"}
+	errNotOwner       = &apiError{http.StatusForbidden, codeNotOwner, "You don't own this video"}
+)
+
+// apiErrorFor maps a failure to what the client is told about it. An
+// *apiError anywhere in err's chain wins, then errors that mean the same
+// thing to a client whichever handler hit them, like storage being down.
+// Otherwise the status and message given are kept, with the status's code.
+func apiErrorFor(status int, msg string, err error) *apiError {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	// Whatever failed, it was because S3 is down, which retrying later fixes
+	if errors.Is(err, errS3Unavailable) {
+		return &apiError{http.StatusServiceUnavailable, codeStorageUnavailable, "Storage is temporarily unavailable, try again later"}
+	}
+
+	code, ok := statusErrorCodes[status]
+	if !ok {
+		code = codeBadRequest
+		if status > 499 {
+			code = codeInternal
+		}
+	}
+	return &apiError{status, code, msg}
+}
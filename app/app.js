@@ -263,6 +263,9 @@ function viewVideo(video) {
     thumbnailImg.style.display = 'block';
     thumbnailImg.src = video.thumbnail_url;
   }
+  // Play the animated preview while hovering, if there is one
+  thumbnailImg.onmouseenter = video.preview_url ? () => { thumbnailImg.src = video.preview_url; } : null;
+  thumbnailImg.onmouseleave = video.preview_url ? () => { thumbnailImg.src = video.thumbnail_url; } : null;
 
   const videoPlayer = document.getElementById('video-player');
   if (videoPlayer) {
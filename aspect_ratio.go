@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type aspectClass struct {
+	Ratio     string
+	Width     int
+	Height    int
+	Directory string
+}
+
+// defaultAspectClasses is the table videos are bucketed by unless
+// ASPECT_CLASSES says otherwise: comma separated ratio=directory pairs,
+// checked in order.
+const defaultAspectClasses = "16:9=landscape,9:16=portrait,1:1=square,21:9=ultrawide"
+
+// aspectClasses is the table in use, set from ASPECT_CLASSES at startup.
+// Each gets its own S3 prefix; anything unmatched lands in "other".
+var aspectClasses = mustParseAspectClasses(defaultAspectClasses)
+
+var aspectDirectoryPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// reservedDirectories are prefixes the server already writes other things
+// under, so no aspect class can be stored there.
+var reservedDirectories = []string{"other", "uploads", "renditions", "hls", "sprites", "audio", strings.TrimSuffix(thumbnailKeyPrefix, "/")}
+
+// parseAspectClasses reads a table in the ASPECT_CLASSES format.
+func parseAspectClasses(value string) ([]aspectClass, error) {
+	classes := []aspectClass{}
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		ratio, directory, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q isn't ratio=directory", entry)
+		}
+		w, h, ok := strings.Cut(strings.TrimSpace(ratio), ":")
+		width, errW := strconv.Atoi(w)
+		height, errH := strconv.Atoi(h)
+		if !ok || errW != nil || errH != nil || width < 1 || height < 1 {
+			return nil, fmt.Errorf("%q isn't a ratio like 16:9", ratio)
+		}
+		directory = strings.TrimSpace(directory)
+		if !aspectDirectoryPattern.MatchString(directory) {
+			return nil, fmt.Errorf("%q isn't a valid directory name", directory)
+		}
+		for _, reserved := range reservedDirectories {
+			if directory == reserved {
+				return nil, fmt.Errorf("directory %q is reserved", directory)
+			}
+		}
+		if seen[directory] {
+			return nil, fmt.Errorf("directory %q is listed twice", directory)
+		}
+		seen[directory] = true
+		classes = append(classes, aspectClass{Ratio: fmt.Sprintf("%d:%d", width, height), Width: width, Height: height, Directory: directory})
+	}
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("no aspect classes given")
+	}
+	return classes, nil
+}
+
+func mustParseAspectClasses(value string) []aspectClass {
+	classes, err := parseAspectClasses(value)
+	if err != nil {
+		panic(err)
+	}
+	return classes
+}
+
+// aspectTolerance is how far (as a fraction) a video may be from a ratio and
+// still count as it, to absorb encoder rounding like 1920x1088.
+const aspectTolerance = 0.02
+
+func matchAspectClass(width, height int) (aspectClass, bool) {
+	if width <= 0 || height <= 0 {
+		return aspectClass{}, false
+	}
+	actual := float64(width) / float64(height)
+	for _, class := range aspectClasses {
+		expected := float64(class.Width) / float64(class.Height)
+		if math.Abs(actual-expected)/expected <= aspectTolerance {
+			return class, true
+		}
+	}
+	return aspectClass{}, false
+}
+
+func aspectRatioFromDimensions(width, height int) string {
+	class, ok := matchAspectClass(width, height)
+	if !ok {
+		return "other"
+	}
+	return class.Ratio
+}
+
+// aspectRatioForDirectory maps an S3 directory name back to the ratio
+// recorded for videos stored there.
+func aspectRatioForDirectory(directory string) (string, bool) {
+	if directory == "other" {
+		return "other", true
+	}
+	for _, class := range aspectClasses {
+		if class.Directory == directory {
+			return class.Ratio, true
+		}
+	}
+	return "", false
+}
+
+// classifyAspect returns the S3 directory a video with these dimensions is
+// stored under.
+func classifyAspect(width, height int) string {
+	class, ok := matchAspectClass(width, height)
+	if !ok {
+		return "other"
+	}
+	return class.Directory
+}
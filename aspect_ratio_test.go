@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestClassifyAspect(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		want          string
+	}{
+		{"1:1", 1080, 1080, "square"},
+		{"21:9", 2560, 1080, "ultrawide"},
+		{"4:3", 1440, 1080, "other"},
+		{"16:9", 1920, 1080, "landscape"},
+		{"16:9 with encoder padding", 1920, 1088, "landscape"},
+		{"9:16", 1080, 1920, "portrait"},
+		{"no dimensions", 0, 0, "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyAspect(tt.width, tt.height); got != tt.want {
+				t.Errorf("classifyAspect(%d, %d) = %q, want %q", tt.width, tt.height, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyAspectConfigured(t *testing.T) {
+	classes, err := parseAspectClasses("4:3=standard, 16:9=landscape")
+	if err != nil {
+		t.Fatalf("parseAspectClasses: %v", err)
+	}
+	defaults := aspectClasses
+	aspectClasses = classes
+	t.Cleanup(func() { aspectClasses = defaults })
+
+	tests := []struct {
+		width, height int
+		want          string
+	}{
+		{1440, 1080, "standard"},
+		{1920, 1080, "landscape"},
+		{1080, 1920, "other"},
+	}
+	for _, tt := range tests {
+		if got := classifyAspect(tt.width, tt.height); got != tt.want {
+			t.Errorf("classifyAspect(%d, %d) = %q, want %q", tt.width, tt.height, got, tt.want)
+		}
+	}
+	if ratio, ok := aspectRatioForDirectory("standard"); !ok || ratio != "4:3" {
+		t.Errorf("aspectRatioForDirectory(standard) = %q, %t, want 4:3, true", ratio, ok)
+	}
+}
+
+func TestParseAspectClassesRejects(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"16:9",
+		"16x9=landscape",
+		"0:9=landscape",
+		"16:9=Landscape",
+		"16:9=other",
+		"16:9=thumbnails",
+		"16:9=landscape,4:3=landscape",
+	} {
+		if _, err := parseAspectClasses(value); err == nil {
+			t.Errorf("parseAspectClasses(%q) succeeded, want an error", value)
+		}
+	}
+}
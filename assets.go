@@ -1,7 +1,15 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/google/uuid"
 )
 
 func (cfg apiConfig) ensureAssetsDir() error {
@@ -10,3 +18,73 @@ func (cfg apiConfig) ensureAssetsDir() error {
 	}
 	return nil
 }
+
+func (cfg apiConfig) ensureTempDirWritable() error {
+	info, err := os.Stat(cfg.tempDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", cfg.tempDir)
+	}
+	f, err := os.CreateTemp(cfg.tempDir, "tubely-check")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// getVersionedAssetName builds a file name that changes whenever the content
+// changes, so a replaced asset always gets a fresh URL and busts CDN caches.
+func getVersionedAssetName(videoID uuid.UUID, data []byte, extension string) string {
+	sum := sha256.Sum256(data)
+	version := hex.EncodeToString(sum[:8])
+	return fmt.Sprintf("%s-%s.%s", videoID, version, extension)
+}
+
+// writeAsset saves a file in the local assets dir and records the content
+// type it's to be served with.
+func (cfg apiConfig) writeAsset(assetName, contentType string, data []byte) error {
+	err := os.WriteFile(cfg.getAssetDiskPath(assetName), data, 0644)
+	if err != nil {
+		return err
+	}
+	err = cfg.db.CreateAsset(assetName, contentType)
+	if err != nil {
+		os.Remove(cfg.getAssetDiskPath(assetName))
+		return err
+	}
+	return nil
+}
+
+func (cfg apiConfig) getAssetDiskPath(assetName string) string {
+	return filepath.Join(cfg.assetsRoot, assetName)
+}
+
+func (cfg apiConfig) getAssetURL(assetName string) string {
+	return cfg.getAssetURLPrefix() + assetName
+}
+
+// getAssetURLPrefix is what every URL for a local asset starts with.
+func (cfg apiConfig) getAssetURLPrefix() string {
+	return fmt.Sprintf("http://localhost:%s/assets/", cfg.port)
+}
+
+// deleteAssetByURL removes the local asset a previously issued URL points
+// to. URLs that don't point at our assets route are left alone.
+func (cfg apiConfig) deleteAssetByURL(assetURL string) error {
+	u, err := url.Parse(assetURL)
+	if err != nil {
+		return err
+	}
+	dir, name := path.Split(u.Path)
+	if dir != "/assets/" || name == "" {
+		return nil
+	}
+	err = os.Remove(cfg.getAssetDiskPath(name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return cfg.db.DeleteAsset(name)
+}
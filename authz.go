@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// authUser is who a request's access token was issued to.
+type authUser struct {
+	ID   uuid.UUID
+	Role auth.Role
+}
+
+type authUserKey struct{}
+
+type authVideoKey struct{}
+
+// requireRole only lets requests through from users who currently have one
+// of roles, or with any valid token if no roles are given. The caller is available
+// to the handler through authUserFromContext.
+func (cfg *apiConfig) requireRole(roles ...auth.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := auth.GetBearerToken(r.Header)
+			if err != nil {
+				respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+				return
+			}
+			userID, role, err := cfg.validateAccessToken(token)
+			if err != nil {
+				respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+				return
+			}
+			if len(roles) > 0 && !slices.Contains(roles, role) {
+				respondWithError(w, http.StatusForbidden, "You don't have permission to do that", nil)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), authUserKey{}, authUser{ID: userID, Role: role})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// validateAccessToken returns who an access token was issued to and their
+// role. Access tokens last long enough to outlive a demotion, so any role
+// above user is checked against the database.
+func (cfg *apiConfig) validateAccessToken(token string) (uuid.UUID, auth.Role, error) {
+	userID, role, err := auth.ValidateJWTWithRole(token, cfg.jwtSecret)
+	if err != nil || role == auth.RoleUser {
+		return userID, role, err
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	if user == nil {
+		return uuid.Nil, "", errors.New("user no longer exists")
+	}
+	return userID, auth.Role(user.Role), nil
+}
+
+// requireOwnerOrAdmin only lets requests through from the owner of the video
+// named by the videoID path value, or from an admin, and not for videos in
+// the trash. The video is available to the handler through
+// authVideoFromContext.
+func (cfg *apiConfig) requireOwnerOrAdmin(next http.Handler) http.Handler {
+	return cfg.requireVideoOwner(false, next)
+}
+
+// requireOwnerOrAdminWithTrash is requireOwnerOrAdmin that also lets
+// requests through for trashed videos, so they can be restored or deleted
+// for good.
+func (cfg *apiConfig) requireOwnerOrAdminWithTrash(next http.Handler) http.Handler {
+	return cfg.requireVideoOwner(true, next)
+}
+
+func (cfg *apiConfig) requireVideoOwner(allowTrashed bool, next http.Handler) http.Handler {
+	return cfg.requireRole()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		videoID, err := uuid.Parse(r.PathValue("videoID"))
+		if err != nil {
+			respondWithAPIError(w, errInvalidID, err)
+			return
+		}
+		video, err := cfg.db.GetVideoWithDeleted(videoID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		if video.ID == uuid.Nil {
+			respondWithAPIError(w, errVideoNotFound, nil)
+			return
+		}
+
+		user := authUserFromContext(r.Context())
+		if video.UserID != user.ID && user.Role != auth.RoleAdmin {
+			respondWithAPIError(w, errNotOwner, nil)
+			return
+		}
+		if video.DeletedAt != nil && !allowTrashed {
+			respondWithError(w, http.StatusConflict, "Video is in the trash", nil)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), authVideoKey{}, video)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}))
+}
+
+// authUserFromContext returns the caller set by requireRole.
+func authUserFromContext(ctx context.Context) authUser {
+	user, _ := ctx.Value(authUserKey{}).(authUser)
+	return user
+}
+
+// authVideoFromContext returns the video loaded by requireOwnerOrAdmin or
+// requireOwnerOrAdminWithTrash.
+func authVideoFromContext(ctx context.Context) database.Video {
+	video, _ := ctx.Value(authVideoKey{}).(database.Video)
+	return video
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// newTestUser creates a user with role and returns them with an access
+// token for that role.
+func newTestUser(t *testing.T, cfg *apiConfig, email string, role auth.Role) (*database.User, string) {
+	t.Helper()
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: email, Password: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := cfg.db.SetUserRole(user.ID, string(role)); err != nil {
+		t.Fatalf("SetUserRole: %v", err)
+	}
+	token, err := auth.MakeJWT(user.ID, role, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	return user, token
+}
+
+func newAuthzTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	db, err := database.NewClient(database.Config{DSN: filepath.Join(t.TempDir(), "tubely.db")})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return &apiConfig{db: db, jwtSecret: "secret"}
+}
+
+func serveRequireAdmin(cfg *apiConfig, token string) int {
+	handler := cfg.requireRole(auth.RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/admin/reconcile", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestRequireRoleAdmin(t *testing.T) {
+	cfg := newAuthzTestConfig(t)
+	tests := []struct {
+		name string
+		role auth.Role
+		want int
+	}{
+		{"admin is accepted", auth.RoleAdmin, http.StatusNoContent},
+		{"user is rejected", auth.RoleUser, http.StatusForbidden},
+		{"moderator is rejected", auth.RoleModerator, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, token := newTestUser(t, cfg, string(tt.role)+"@example.com", tt.role)
+			if got := serveRequireAdmin(cfg, token); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("missing role is rejected", func(t *testing.T) {
+		token, err := auth.MakeJWT(uuid.New(), "", cfg.jwtSecret, time.Hour)
+		if err != nil {
+			t.Fatalf("MakeJWT: %v", err)
+		}
+		if got := serveRequireAdmin(cfg, token); got != http.StatusForbidden {
+			t.Errorf("status = %d, want %d", got, http.StatusForbidden)
+		}
+	})
+
+	t.Run("no token is unauthorized", func(t *testing.T) {
+		if got := serveRequireAdmin(cfg, ""); got != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", got, http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestRequireRoleRejectsDemotedAdmin(t *testing.T) {
+	cfg := newAuthzTestConfig(t)
+	admin, token := newTestUser(t, cfg, "admin@example.com", auth.RoleAdmin)
+	if got := serveRequireAdmin(cfg, token); got != http.StatusNoContent {
+		t.Fatalf("before demotion: status = %d, want %d", got, http.StatusNoContent)
+	}
+
+	if _, err := cfg.db.SetUserRole(admin.ID, string(auth.RoleUser)); err != nil {
+		t.Fatalf("SetUserRole: %v", err)
+	}
+	if got := serveRequireAdmin(cfg, token); got != http.StatusForbidden {
+		t.Errorf("old token after demotion: status = %d, want %d", got, http.StatusForbidden)
+	}
+}
+
+func TestRequireRoleRejectsDeletedUser(t *testing.T) {
+	cfg := newAuthzTestConfig(t)
+	admin, token := newTestUser(t, cfg, "admin@example.com", auth.RoleAdmin)
+	if err := cfg.db.DeleteUser(admin.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if got := serveRequireAdmin(cfg, token); got != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", got, http.StatusUnauthorized)
+	}
+}
+
+func TestRequireOwnerOrAdminTrashedVideo(t *testing.T) {
+	cfg := newAuthzTestConfig(t)
+	owner, token := newTestUser(t, cfg, "owner@example.com", auth.RoleUser)
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "t", UserID: owner.ID}, database.VideoStatusPending)
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	if _, err := cfg.db.TrashVideo(video.ID); err != nil {
+		t.Fatalf("TrashVideo: %v", err)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	tests := []struct {
+		name    string
+		handler http.Handler
+		want    int
+	}{
+		{"requireOwnerOrAdmin", cfg.requireOwnerOrAdmin(ok), http.StatusConflict},
+		{"requireOwnerOrAdminWithTrash", cfg.requireOwnerOrAdminWithTrash(ok), http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/api/videos/"+video.ID.String(), nil)
+			req.SetPathValue("videoID", video.ID.String())
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
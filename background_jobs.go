@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/webhook"
+	"github.com/google/uuid"
+)
+
+// Kinds of background job.
+const (
+	jobKindWebhookDelivery = "webhook.delivery"
+	jobKindTranscription   = "video.transcription"
+	jobKindModeration      = "video.moderation"
+)
+
+const (
+	defaultJobWorkers  = 4
+	defaultJobPageSize = 100
+	maxJobPageSize     = 1000
+)
+
+// startJobQueue starts workers running the jobs in the database, telling
+// the queue how to run each kind.
+func (cfg *apiConfig) startJobQueue(workers int) {
+	cfg.jobQueue = jobs.NewQueue(cfg.db, workers, logDeadJob, logJobQueueError)
+	cfg.jobQueue.Register(jobKindWebhookDelivery, jobs.Kind{
+		Handler:     cfg.runWebhookDelivery,
+		MaxAttempts: webhook.MaxAttempts,
+		Backoff:     webhook.FirstRetryDelay,
+	})
+	if cfg.transcriber != nil {
+		cfg.jobQueue.Register(jobKindTranscription, jobs.Kind{
+			Handler:     cfg.runTranscription,
+			MaxAttempts: transcriptionMaxAttempts,
+			Backoff:     transcriptionBackoff,
+			Timeout:     transcriptionTimeout,
+		})
+	}
+	if cfg.moderation.moderator != nil {
+		cfg.jobQueue.Register(jobKindModeration, jobs.Kind{
+			Handler:     cfg.runModeration,
+			MaxAttempts: moderationMaxAttempts,
+			Backoff:     moderationBackoff,
+			Timeout:     moderationTimeout,
+		})
+	}
+	cfg.jobQueue.Start()
+}
+
+// logDeadJob is called for jobs that won't be retried, which stay listed
+// under GET /admin/jobs until they're requeued.
+func logDeadJob(job database.Job, err error) {
+	slog.Warn("Background job failed", "job_id", job.ID, "kind", job.Kind, "attempts", job.Attempts, "error", err)
+}
+
+func logJobQueueError(err error) {
+	slog.Error("Job queue error", "error", err)
+}
+
+// handlerAdminJobsList lists jobs in one status, dead by default, most
+// recently updated first.
+func (cfg *apiConfig) handlerAdminJobsList(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = database.JobStatusDead
+	case database.JobStatusQueued, database.JobStatusRunning, database.JobStatusDead:
+	default:
+		respondWithError(w, http.StatusBadRequest, "status must be queued, running or dead", nil)
+		return
+	}
+
+	var err error
+	limit := defaultJobPageSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxJobPageSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxJobPageSize), err)
+			return
+		}
+	}
+
+	list, err := cfg.db.GetJobs(status, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get jobs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, list)
+}
+
+// handlerAdminJobRequeue gives a dead job a fresh set of attempts.
+func (cfg *apiConfig) handlerAdminJobRequeue(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidID, err)
+		return
+	}
+	job, err := cfg.db.GetJob(jobID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get job", err)
+		return
+	}
+	if job.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Job not found", nil)
+		return
+	}
+	// Another admin may have requeued it in the meantime
+	requeued := false
+	if job.Status == database.JobStatusDead {
+		requeued, err = cfg.db.RequeueJob(jobID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't requeue job", err)
+			return
+		}
+	}
+	if !requeued {
+		respondWithError(w, http.StatusConflict, "Only dead jobs can be requeued", nil)
+		return
+	}
+	cfg.jobQueue.Wake()
+	loggerFromContext(r.Context()).Info("Job requeued", "job_id", jobID, "kind", job.Kind, "by", authUserFromContext(r.Context()).ID)
+
+	job.Status = database.JobStatusQueued
+	job.Attempts = 0
+	job.RunAt = time.Now().UTC()
+	respondWithJSON(w, http.StatusOK, job)
+}
@@ -1,10 +1,44 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"time"
+)
 
-func noCacheMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Cache-Control", "no-store")
-		next.ServeHTTP(w, r)
-	})
+const (
+	// redisKeyPrefix namespaces this server's keys in Redis
+	redisKeyPrefix = "tubely:"
+	// signedURLCacheTimeout bounds each cache call made while signing, so a
+	// slow cache costs little more than a miss
+	signedURLCacheTimeout = 250 * time.Millisecond
+)
+
+// cachedSignature returns the cached signed URL for objectURL, or signs it
+// and caches the result. Entries last half of S3_PRESIGN_EXPIRY, so a URL
+// handed out from the cache is always good for at least the other half.
+// Object keys aren't reused, so an entry can't outlive what it points at
+// except by pointing at something deleted.
+func (cfg *apiConfig) cachedSignature(ctx context.Context, objectURL string, sign func() (string, error)) (string, error) {
+	if cfg.cache == nil {
+		return sign()
+	}
+	key := "signed_url:" + objectURL
+	getCtx, cancel := context.WithTimeout(ctx, signedURLCacheTimeout)
+	cached, ok, err := cfg.cache.Get(getCtx, key)
+	cancel()
+	if err == nil && ok {
+		return string(cached), nil
+	}
+
+	signed, err := sign()
+	if err != nil {
+		return "", err
+	}
+	setCtx, cancel := context.WithTimeout(ctx, signedURLCacheTimeout)
+	defer cancel()
+	err = cfg.cache.Set(setCtx, key, []byte(signed), cfg.s3PresignExpiry/2)
+	if err != nil {
+		loggerFromContext(ctx).Warn("Couldn't cache signed URL", "error", err)
+	}
+	return signed, nil
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go/middleware"
+)
+
+const (
+	defaultS3BreakerThreshold = 5
+	defaultS3BreakerCooldown  = 30 * time.Second
+)
+
+// errS3Unavailable is returned without calling S3 while the circuit breaker
+// is open. respondWithError turns it into a 503.
+var errS3Unavailable = errors.New("S3 is unavailable, try again later")
+
+// States of the circuit breaker, as reported by tubely_s3_circuit_state.
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+// circuitBreaker stops calling S3 once threshold calls in a row have failed
+// after their retries. Calls fail fast for cooldown, then a single trial call
+// decides whether it closes again or stays open for another cooldown.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	trialing bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	s3CircuitState.Set(float64(circuitClosed))
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a call may go ahead. Every allowed call must be
+// followed by a call to record.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(circuitHalfOpen)
+		b.trialing = true
+		return true
+	case circuitHalfOpen:
+		// Only the trial call goes through until it's back
+		if b.trialing {
+			return false
+		}
+		b.trialing = true
+		return true
+	}
+	return true
+}
+
+// record counts the outcome of a call that allow let through.
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitHalfOpen:
+		b.trialing = false
+		if failed {
+			b.open()
+		} else {
+			b.failures = 0
+			b.setState(circuitClosed)
+		}
+	case circuitClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.threshold {
+			b.open()
+		}
+	}
+}
+
+func (b *circuitBreaker) open() {
+	b.openedAt = time.Now()
+	b.setState(circuitOpen)
+}
+
+func (b *circuitBreaker) setState(state circuitState) {
+	b.state = state
+	s3CircuitState.Set(float64(state))
+}
+
+// s3Degraded reports whether a failed call says something about S3's health.
+// Requests S3 refused, like those for missing objects, and calls abandoned by
+// the client don't count.
+func s3Degraded(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		// Requests that never got a response have a status of 0
+		status := respErr.HTTPStatusCode()
+		return status == 0 || status >= 500 || status == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// s3Middleware fails S3 calls fast while the breaker is open. It goes after
+// the SDK's retries are set up, so a call only counts once however many
+// attempts it took.
+func (b *circuitBreaker) s3Middleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("TubelyCircuitBreaker", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		if !b.allow() {
+			s3CircuitRejectionsTotal.Inc(awsmiddleware.GetOperationName(ctx))
+			return middleware.InitializeOutput{}, middleware.Metadata{}, errS3Unavailable
+		}
+		out, metadata, err := next.HandleInitialize(ctx, in)
+		b.record(s3Degraded(err))
+		return out, metadata, err
+	}), middleware.After)
+}
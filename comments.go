@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxCommentLength       = 2000
+	defaultCommentPageSize = 20
+	maxCommentPageSize     = 100
+	defaultCommentRate     = 0.1
+	defaultCommentBurst    = 5
+)
+
+// getViewableVideo loads the video in the path if the request may see it,
+// which only private videos restrict. It responds itself and returns false
+// otherwise.
+func (cfg *apiConfig) getViewableVideo(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidVideoID, err)
+		return database.Video{}, false
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil || !cfg.canViewVideo(r, video) {
+		respondWithAPIError(w, errVideoNotFound, nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// handlerCommentsCreate adds the caller's comment to a video they can see.
+// Each user can only comment so often, to keep spam down.
+func (cfg *apiConfig) handlerCommentsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	user := authUserFromContext(r.Context())
+	video, ok := cfg.getViewableVideo(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	body := sanitizeVideoText(params.Body, true)
+	if body == "" {
+		respondWithError(w, http.StatusBadRequest, "Comment can't be empty", nil)
+		return
+	}
+	if utf8.RuneCountInString(body) > maxCommentLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Comments can be at most %d characters", maxCommentLength), nil)
+		return
+	}
+
+	if cfg.commentRateLimiter != nil {
+		ok, wait := cfg.commentRateLimiter.allow(user.ID.String())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			respondWithError(w, http.StatusTooManyRequests, "Too many comments, slow down", nil)
+			return
+		}
+	}
+
+	comment, err := cfg.db.CreateComment(database.CreateCommentParams{
+		VideoID: video.ID,
+		UserID:  user.ID,
+		Body:    body,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create comment", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, comment)
+}
+
+// handlerCommentsList lists a video's comments a page at a time, newest
+// first, to anyone who can see the video. The total is sent in
+// X-Total-Count and the next page, if any, in a Link header.
+func (cfg *apiConfig) handlerCommentsList(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getViewableVideo(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	limit, offset := defaultCommentPageSize, 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxCommentPageSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxCommentPageSize), err)
+			return
+		}
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			respondWithError(w, http.StatusBadRequest, "offset must be a non-negative number", err)
+			return
+		}
+	}
+
+	comments, total, err := cfg.db.ListComments(video.ID, limit, offset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get comments", err)
+		return
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if next := offset + len(comments); next < total {
+		nextURL := *r.URL
+		nextQuery := nextURL.Query()
+		nextQuery.Set("offset", strconv.Itoa(next))
+		nextQuery.Set("limit", strconv.Itoa(limit))
+		nextURL.RawQuery = nextQuery.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL.RequestURI()))
+	}
+	respondWithJSON(w, http.StatusOK, comments)
+}
+
+// handlerCommentDelete removes a comment. Its author can delete it, and so
+// can the video's owner, moderators and admins, so owners can moderate
+// their own videos.
+func (cfg *apiConfig) handlerCommentDelete(w http.ResponseWriter, r *http.Request) {
+	user := authUserFromContext(r.Context())
+	commentID, err := uuid.Parse(r.PathValue("commentID"))
+	if err != nil {
+		respondWithAPIError(w, &apiError{http.StatusBadRequest, codeInvalidID, "Invalid comment ID"}, err)
+		return
+	}
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidVideoID, err)
+		return
+	}
+
+	comment, err := cfg.db.GetComment(commentID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get comment", err)
+		return
+	}
+	if comment.ID == uuid.Nil || comment.VideoID != videoID {
+		respondWithError(w, http.StatusNotFound, "Comment not found", nil)
+		return
+	}
+
+	allowed := comment.UserID == user.ID || user.Role == auth.RoleAdmin || user.Role == auth.RoleModerator
+	if !allowed {
+		video, err := cfg.db.GetVideoWithDeleted(videoID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		allowed = video.UserID == user.ID
+	}
+	if !allowed {
+		respondWithError(w, http.StatusForbidden, "You can't delete this comment", nil)
+		return
+	}
+
+	err = cfg.db.DeleteComment(comment.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete comment", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/rekognition"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultModerationFlagConfidence  = 50
+	defaultModerationBlockConfidence = 90
+	defaultModerationFrames          = 5
+	maxModerationFrames              = 50
+	moderationMaxAttempts            = 3
+	moderationBackoff                = time.Minute
+	moderationTimeout                = 30 * time.Minute
+)
+
+// defaultModerationCategories are the kinds of content that count against a
+// video unless MODERATION_CATEGORIES says otherwise, named as in
+// Rekognition's taxonomy.
+var defaultModerationCategories = []string{"Explicit", "Explicit Nudity", "Non-Explicit Nudity of Intimate parts and Kissing", "Suggestive"}
+
+// moderationPolicy is how moderation checks a video's thumbnail and frames,
+// and how sure it has to be of finding content in one of its categories for
+// the video to be flagged or blocked. A nil moderator turns it off.
+type moderationPolicy struct {
+	moderator  moderation.Moderator
+	flagAt     float64
+	blockAt    float64
+	categories []string
+	frames     int
+}
+
+// loadModerationPolicy reads MODERATION_BACKEND, MODERATION_FLAG_CONFIDENCE,
+// MODERATION_BLOCK_CONFIDENCE, MODERATION_CATEGORIES and MODERATION_FRAMES.
+func loadModerationPolicy(region string) (moderationPolicy, error) {
+	policy := moderationPolicy{
+		flagAt:     defaultModerationFlagConfidence,
+		blockAt:    defaultModerationBlockConfidence,
+		categories: defaultModerationCategories,
+		frames:     defaultModerationFrames,
+	}
+	for name, value := range map[string]*float64{
+		"MODERATION_FLAG_CONFIDENCE":  &policy.flagAt,
+		"MODERATION_BLOCK_CONFIDENCE": &policy.blockAt,
+	} {
+		env := os.Getenv(name)
+		if env == "" {
+			continue
+		}
+		confidence, err := strconv.ParseFloat(env, 64)
+		if err != nil || confidence < 0 || confidence > 100 {
+			return moderationPolicy{}, fmt.Errorf("%s must be a confidence from 0 to 100", name)
+		}
+		*value = confidence
+	}
+	if policy.blockAt < policy.flagAt {
+		return moderationPolicy{}, errors.New("MODERATION_BLOCK_CONFIDENCE can't be below MODERATION_FLAG_CONFIDENCE")
+	}
+	if value := os.Getenv("MODERATION_CATEGORIES"); value != "" {
+		policy.categories = parseCommaList(value)
+	}
+	if value := os.Getenv("MODERATION_FRAMES"); value != "" {
+		frames, err := strconv.Atoi(value)
+		if err != nil || frames < 0 || frames > maxModerationFrames {
+			return moderationPolicy{}, fmt.Errorf("MODERATION_FRAMES must be a number from 0 to %d", maxModerationFrames)
+		}
+		policy.frames = frames
+	}
+
+	switch backend := os.Getenv("MODERATION_BACKEND"); backend {
+	case "":
+	case "noop":
+		policy.moderator = moderation.Noop{}
+	case "rekognition":
+		c, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+		if err != nil {
+			return moderationPolicy{}, fmt.Errorf("loading AWS config: %w", err)
+		}
+		// Less certain labels can't flag anything, so they needn't be sent
+		policy.moderator = moderation.NewRekognition(rekognition.NewFromConfig(c), policy.flagAt)
+	default:
+		return moderationPolicy{}, fmt.Errorf("MODERATION_BACKEND must be rekognition or noop, not %q", backend)
+	}
+	return policy, nil
+}
+
+// status is what a video's moderation results add up to, going by the most
+// confident label in one of the policy's categories.
+func (p moderationPolicy) status(m database.Moderation) string {
+	worst := 0.0
+	for _, label := range slices.Concat(m.Thumbnail, m.Frames) {
+		if slices.Contains(p.categories, label.Name) || slices.Contains(p.categories, label.Parent) {
+			worst = max(worst, label.Confidence)
+		}
+	}
+	switch {
+	case worst >= p.blockAt:
+		return database.ModerationStatusBlocked
+	case worst >= p.flagAt:
+		return database.ModerationStatusFlagged
+	}
+	return database.ModerationStatusClear
+}
+
+// moderateThumbnail checks an uploaded thumbnail and records the result on
+// the video, replacing what was found in the one before.
+func (cfg *apiConfig) moderateThumbnail(ctx context.Context, video *database.Video, img image.Image) error {
+	if cfg.moderation.moderator == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	if err != nil {
+		return err
+	}
+	labels, err := cfg.moderation.moderator.ModerateImage(ctx, buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	result := database.Moderation{}
+	if video.Moderation != nil {
+		result = *video.Moderation
+	}
+	now := time.Now().UTC()
+	result.Thumbnail = moderationLabels(labels, nil)
+	result.ThumbnailCheckedAt = &now
+	return cfg.setVideoModeration(ctx, video, result)
+}
+
+// moderationJob is the payload of a jobKindModeration job, which checks
+// frames of the file at VideoURL.
+type moderationJob struct {
+	VideoID  uuid.UUID `json:"video_id"`
+	VideoURL string    `json:"video_url"`
+}
+
+// enqueueModeration queues a check of frames from a freshly uploaded
+// video, if moderation is on and it's a video rather than audio.
+func (cfg *apiConfig) enqueueModeration(ctx context.Context, video database.Video) {
+	if cfg.moderation.moderator == nil || cfg.moderation.frames == 0 || video.VideoURL == nil || video.MediaInfo == nil || video.MediaInfo.Codec == "" {
+		return
+	}
+	_, err := cfg.jobQueue.Enqueue(jobKindModeration, moderationJob{
+		VideoID:  video.ID,
+		VideoURL: *video.VideoURL,
+	})
+	if err != nil {
+		loggerFromContext(ctx).Error("Couldn't queue moderation", "video_id", video.ID, "error", err)
+	}
+}
+
+// runModeration checks frames spread evenly across a video, replacing what
+// was found in the frames of its previous file.
+func (cfg *apiConfig) runModeration(ctx context.Context, job database.Job) error {
+	var payload moderationJob
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	video, err := cfg.db.GetVideo(payload.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil || *video.VideoURL != payload.VideoURL || video.MediaInfo == nil {
+		return nil
+	}
+	videoKey, ok := cfg.objectKeyFromURL(payload.VideoURL)
+	if !ok {
+		return jobs.Permanent(fmt.Errorf("video %s isn't in storage", payload.VideoURL))
+	}
+
+	sourcePath, err := cfg.downloadObject(ctx, videoKey)
+	if err != nil {
+		return fmt.Errorf("downloading video: %w", err)
+	}
+	defer os.Remove(sourcePath)
+
+	labels := []database.ModerationLabel{}
+	frames := cfg.moderation.frames
+	for i := range frames {
+		at := video.MediaInfo.Duration * (float64(i) + 0.5) / float64(frames)
+		frame, err := extractFrameJPEG(ctx, sourcePath, at)
+		if err != nil {
+			return err
+		}
+		found, err := cfg.moderation.moderator.ModerateImage(ctx, frame)
+		if err != nil {
+			return err
+		}
+		labels = append(labels, moderationLabels(found, &at)...)
+	}
+
+	// The video may have changed while its frames were checked
+	video, err = cfg.db.GetVideo(payload.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil || *video.VideoURL != payload.VideoURL {
+		return nil
+	}
+	result := database.Moderation{}
+	if video.Moderation != nil {
+		result = *video.Moderation
+	}
+	now := time.Now().UTC()
+	result.Frames = labels
+	result.FramesCheckedAt = &now
+	return cfg.setVideoModeration(ctx, &video, result)
+}
+
+// setVideoModeration records moderation results on a video, along with the
+// status they add up to.
+func (cfg *apiConfig) setVideoModeration(ctx context.Context, video *database.Video, result database.Moderation) error {
+	status := cfg.moderation.status(result)
+	err := cfg.db.SetVideoModeration(video.ID, status, result)
+	if err != nil {
+		return err
+	}
+	if status != database.ModerationStatusClear && status != video.ModerationStatus {
+		loggerFromContext(ctx).Warn("Video "+status+" by moderation", "video_id", video.ID, "user_id", video.UserID)
+	}
+	video.ModerationStatus = status
+	video.Moderation = &result
+	return nil
+}
+
+func moderationLabels(labels []moderation.Label, timestamp *float64) []database.ModerationLabel {
+	converted := make([]database.ModerationLabel, 0, len(labels))
+	for _, label := range labels {
+		converted = append(converted, database.ModerationLabel{
+			Name:       label.Name,
+			Parent:     label.Parent,
+			Confidence: label.Confidence,
+			Timestamp:  timestamp,
+		})
+	}
+	return converted
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// copyAndHashWithContext is copyWithContext that also returns the hex
+// SHA-256 of everything copied.
+func copyAndHashWithContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, string, error) {
+	h := sha256.New()
+	written, err := copyWithContext(ctx, io.MultiWriter(dst, h), src)
+	return written, hex.EncodeToString(h.Sum(nil)), err
+}
+
+// hashFile returns the hex SHA-256 of a file's contents.
+func hashFile(ctx context.Context, filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	_, checksum, err := copyAndHashWithContext(ctx, io.Discard, file)
+	return checksum, err
+}
+
+// copyWithContext behaves like io.Copy but stops as soon as ctx is done, so
+// an abandoned request doesn't keep streaming data to disk.
+func copyWithContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	return io.Copy(dst, storage.NewContextReader(ctx, src))
+}
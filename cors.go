@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// corsPolicy is which browser origins may call the API and what they may
+// send. Without configured origins, a dev server allows pages served from
+// localhost on any port, so a frontend dev server can call it.
+type corsPolicy struct {
+	origins        []string
+	allowLocalhost bool
+	methods        []string
+	headers        []string
+	maxAge         int
+}
+
+var (
+	defaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type", "X-Request-ID", uploadOffsetHeader, "Idempotency-Key", "If-None-Match", "If-Modified-Since", "Range"}
+)
+
+// corsExposedHeaders are the response headers browser clients can read
+// beyond the few they always can.
+const corsExposedHeaders = "X-Request-ID, Upload-Offset, Location, X-Total-Count, Link, Idempotent-Replayed, ETag, Last-Modified, Retry-After, Content-Range, Accept-Ranges"
+
+// loadCORSPolicy reads ALLOWED_ORIGINS, CORS_ALLOWED_METHODS,
+// CORS_ALLOWED_HEADERS and CORS_MAX_AGE_SECONDS.
+func loadCORSPolicy(platform string) (corsPolicy, error) {
+	policy := corsPolicy{
+		origins: parseAllowedOrigins(os.Getenv("ALLOWED_ORIGINS")),
+		methods: defaultCORSMethods,
+		headers: defaultCORSHeaders,
+		maxAge:  600,
+	}
+	policy.allowLocalhost = platform == "dev" && len(policy.origins) == 0
+
+	if value := os.Getenv("CORS_ALLOWED_METHODS"); value != "" {
+		policy.methods = nil
+		for _, method := range parseCommaList(value) {
+			policy.methods = append(policy.methods, strings.ToUpper(method))
+		}
+	}
+	if value := os.Getenv("CORS_ALLOWED_HEADERS"); value != "" {
+		policy.headers = nil
+		for _, header := range parseCommaList(value) {
+			policy.headers = append(policy.headers, http.CanonicalHeaderKey(header))
+		}
+	}
+	if value := os.Getenv("CORS_MAX_AGE_SECONDS"); value != "" {
+		maxAge, err := strconv.Atoi(value)
+		if err != nil || maxAge < 0 {
+			return corsPolicy{}, fmt.Errorf("CORS_MAX_AGE_SECONDS must be a non-negative number of seconds")
+		}
+		policy.maxAge = maxAge
+	}
+	return policy, nil
+}
+
+// corsMiddleware lets browser clients on the allowed origins call the API.
+// Only allowed origins are echoed back, never "*", because requests carry
+// credentials in the Authorization header.
+func (cfg *apiConfig) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") && !strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		allowed := origin != "" && cfg.cors.allowsOrigin(origin)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !preflight {
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Answer preflights here, the mux has no OPTIONS routes
+		w.Header().Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+		if !allowed || !cfg.cors.allowsPreflight(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(cfg.cors.methods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(cfg.cors.headers, ", "))
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cfg.cors.maxAge))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (p corsPolicy) allowsOrigin(origin string) bool {
+	for _, allowed := range p.origins {
+		if strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	if p.allowLocalhost {
+		u, err := url.Parse(origin)
+		if err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			switch u.Hostname() {
+			case "localhost", "127.0.0.1", "::1":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowsPreflight reports whether the method and headers a preflight asks
+// for are all allowed. Headers browsers always let through needn't be.
+func (p corsPolicy) allowsPreflight(r *http.Request) bool {
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	if !slices.Contains(p.methods, method) {
+		return false
+	}
+	for _, header := range parseCommaList(strings.Join(r.Header.Values("Access-Control-Request-Headers"), ",")) {
+		header = http.CanonicalHeaderKey(header)
+		if !slices.Contains(p.headers, header) && !corsSafelistedHeader(header) {
+			return false
+		}
+	}
+	return true
+}
+
+func corsSafelistedHeader(header string) bool {
+	switch header {
+	case "Accept", "Accept-Language", "Content-Language":
+		return true
+	}
+	return false
+}
+
+// parseAllowedOrigins splits a comma separated ALLOWED_ORIGINS value.
+func parseAllowedOrigins(value string) []string {
+	origins := []string{}
+	for _, origin := range parseCommaList(value) {
+		if origin = strings.TrimRight(origin, "/"); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+func parseCommaList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	cfg := &apiConfig{cors: corsPolicy{
+		origins: []string{"https://app.example.com"},
+		methods: defaultCORSMethods,
+		headers: defaultCORSHeaders,
+		maxAge:  600,
+	}}
+	handler := cfg.corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		method     string
+		origin     string
+		header     map[string]string
+		wantStatus int
+		wantOrigin string
+	}{
+		{"allowed origin", http.MethodPost, "https://app.example.com", nil, http.StatusOK, "https://app.example.com"},
+		{"disallowed origin", http.MethodPost, "https://evil.example.com", nil, http.StatusOK, ""},
+		{"no origin", http.MethodGet, "", nil, http.StatusOK, ""},
+		{"preflight", http.MethodOptions, "https://app.example.com", map[string]string{
+			"Access-Control-Request-Method":  "PUT",
+			"Access-Control-Request-Headers": "authorization, content-type",
+		}, http.StatusNoContent, "https://app.example.com"},
+		{"preflight from a disallowed origin", http.MethodOptions, "https://evil.example.com", map[string]string{
+			"Access-Control-Request-Method": "PUT",
+		}, http.StatusForbidden, ""},
+		{"preflight for a disallowed header", http.MethodOptions, "https://app.example.com", map[string]string{
+			"Access-Control-Request-Method":  "PUT",
+			"Access-Control-Request-Headers": "X-Secret",
+		}, http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/video_upload/abc", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if rec.Code == http.StatusNoContent {
+				if got := rec.Header().Get("Access-Control-Allow-Headers"); !containsCommaItem(got, "Authorization") {
+					t.Errorf("Access-Control-Allow-Headers = %q, want Authorization listed", got)
+				}
+				if got := rec.Header().Get("Access-Control-Allow-Methods"); !containsCommaItem(got, "PUT") {
+					t.Errorf("Access-Control-Allow-Methods = %q, want PUT listed", got)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSLocalhostInDev(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "")
+	for platform, want := range map[string]bool{"dev": true, "prod": false} {
+		policy, err := loadCORSPolicy(platform)
+		if err != nil {
+			t.Fatalf("loadCORSPolicy: %v", err)
+		}
+		if got := policy.allowsOrigin("http://localhost:5173"); got != want {
+			t.Errorf("%s: allowsOrigin(localhost) = %t, want %t", platform, got, want)
+		}
+	}
+}
+
+func containsCommaItem(list, item string) bool {
+	for _, got := range parseCommaList(list) {
+		if got == item {
+			return true
+		}
+	}
+	return false
+}
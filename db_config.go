@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Connection pool defaults for Postgres, which every server sharing the
+// database opens connections to. SQLite keeps database/sql's defaults.
+const (
+	defaultPostgresMaxOpenConns    = 20
+	defaultPostgresMaxIdleConns    = 10
+	defaultPostgresConnMaxLifetime = 30 * time.Minute
+)
+
+// loadDatabaseConfig reads which database to use from the environment:
+// DB_DRIVER picks SQLite, the default, with the file at DB_PATH, or Postgres
+// at DATABASE_URL. DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS and
+// DB_CONN_MAX_LIFETIME size the connection pool.
+func loadDatabaseConfig() (database.Config, error) {
+	cfg := database.Config{Driver: os.Getenv("DB_DRIVER")}
+	switch cfg.Driver {
+	case "", database.DriverSQLite:
+		cfg.Driver = database.DriverSQLite
+		cfg.DSN = os.Getenv("DB_PATH")
+		if cfg.DSN == "" {
+			return database.Config{}, errors.New("DB_PATH must be set")
+		}
+	case database.DriverPostgres:
+		cfg.DSN = os.Getenv("DATABASE_URL")
+		if cfg.DSN == "" {
+			return database.Config{}, errors.New("DATABASE_URL must be set when DB_DRIVER is postgres")
+		}
+		cfg.MaxOpenConns = defaultPostgresMaxOpenConns
+		cfg.MaxIdleConns = defaultPostgresMaxIdleConns
+		cfg.ConnMaxLifetime = defaultPostgresConnMaxLifetime
+	default:
+		return database.Config{}, errors.New(`DB_DRIVER must be "sqlite" or "postgres"`)
+	}
+
+	var err error
+	if value := os.Getenv("DB_MAX_OPEN_CONNS"); value != "" {
+		cfg.MaxOpenConns, err = strconv.Atoi(value)
+		if err != nil || cfg.MaxOpenConns < 0 {
+			return database.Config{}, errors.New("DB_MAX_OPEN_CONNS must be a non-negative number")
+		}
+	}
+	if value := os.Getenv("DB_MAX_IDLE_CONNS"); value != "" {
+		cfg.MaxIdleConns, err = strconv.Atoi(value)
+		if err != nil || cfg.MaxIdleConns < 1 {
+			return database.Config{}, errors.New("DB_MAX_IDLE_CONNS must be a positive number")
+		}
+	}
+	if value := os.Getenv("DB_CONN_MAX_LIFETIME"); value != "" {
+		cfg.ConnMaxLifetime, err = time.ParseDuration(value)
+		if err != nil || cfg.ConnMaxLifetime < 0 {
+			return database.Config{}, errors.New("DB_CONN_MAX_LIFETIME must be a non-negative duration")
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func videoETag(video database.Video) string {
+	return fmt.Sprintf(`W/"%s-%d"`, video.ID, video.UpdatedAt.UnixNano())
+}
+
+// videosETag covers the whole list, so adding, removing or editing any video
+// changes it.
+func videosETag(videos []database.Video) string {
+	h := sha256.New()
+	for _, video := range videos {
+		fmt.Fprintf(h, "%s-%d;", video.ID, video.UpdatedAt.UnixNano())
+	}
+	return fmt.Sprintf(`W/"%d-%s"`, len(videos), hex.EncodeToString(h.Sum(nil)[:8]))
+}
+
+// respondNotModified sets the caching headers for a metadata response and
+// answers 304 if the client already has this version. It returns true when
+// the response has been written. lastModified is left out when it's zero,
+// for responses where it can't tell every change, like a video dropping out
+// of a list. If-Modified-Since is only used without If-None-Match, which is
+// more precise.
+func respondNotModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, max-age=0, must-revalidate")
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagMatches(ifNoneMatch, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+		return false
+	}
+	if !lastModified.IsZero() && notModifiedSince(r.Header.Get("If-Modified-Since"), lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// notModifiedSince reports whether lastModified is no later than an
+// If-Modified-Since date, which only has whole seconds.
+func notModifiedSince(ifModifiedSince string, lastModified time.Time) bool {
+	if ifModifiedSince == "" {
+		return false
+	}
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
+// etagMatches uses the weak comparison If-None-Match calls for.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// serveWithETag answers r the way the metadata handlers do, writing a body
+// unless respondNotModified already answered.
+func serveWithETag(r *http.Request, etag string, lastModified time.Time) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	if !respondNotModified(rec, r, etag, lastModified) {
+		rec.WriteHeader(http.StatusOK)
+		rec.WriteString(`{}`)
+	}
+	return rec
+}
+
+func TestRespondNotModified(t *testing.T) {
+	updated := time.Date(2026, 3, 1, 12, 0, 0, 500, time.UTC)
+	video := database.Video{ID: uuid.New(), UpdatedAt: updated}
+	etag := videoETag(video)
+	stale := videoETag(database.Video{ID: video.ID, UpdatedAt: updated.Add(-time.Minute)})
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{"no validators", nil, http.StatusOK},
+		{"matching ETag", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"matching ETag without W/", map[string]string{"If-None-Match": etag[2:]}, http.StatusNotModified},
+		{"one of several ETags", map[string]string{"If-None-Match": stale + ", " + etag}, http.StatusNotModified},
+		{"wildcard", map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+		{"stale ETag", map[string]string{"If-None-Match": stale}, http.StatusOK},
+		{"stale ETag beats a current date", map[string]string{"If-None-Match": stale, "If-Modified-Since": updated.Format(http.TimeFormat)}, http.StatusOK},
+		{"not modified since", map[string]string{"If-Modified-Since": updated.Format(http.TimeFormat)}, http.StatusNotModified},
+		{"modified since", map[string]string{"If-Modified-Since": updated.Add(-time.Hour).Format(http.TimeFormat)}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID.String(), nil)
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			rec := serveWithETag(req, etag, video.UpdatedAt)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %q, want %q", got, etag)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != "private, max-age=0, must-revalidate" {
+				t.Errorf("Cache-Control = %q", got)
+			}
+			if tt.want == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Errorf("304 has a body: %q", rec.Body)
+			}
+		})
+	}
+}
+
+func TestVideosETagChangesWithList(t *testing.T) {
+	a := database.Video{ID: uuid.New(), UpdatedAt: time.Now()}
+	b := database.Video{ID: uuid.New(), UpdatedAt: time.Now()}
+	etag := videosETag([]database.Video{a, b})
+
+	edited := b
+	edited.UpdatedAt = edited.UpdatedAt.Add(time.Second)
+	for name, videos := range map[string][]database.Video{
+		"video removed": {a},
+		"video edited":  {a, edited},
+		"video added":   {a, b, {ID: uuid.New()}},
+	} {
+		if videosETag(videos) == etag {
+			t.Errorf("%s: ETag didn't change", name)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/videos", nil)
+	req.Header.Set("If-None-Match", etag)
+	if rec := serveWithETag(req, videosETag([]database.Video{a, b}), time.Time{}); rec.Code != http.StatusNotModified {
+		t.Errorf("unchanged list: status = %d, want %d", rec.Code, http.StatusNotModified)
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	// exportFormatZIP exports are a single archive that can be downloaded
+	exportFormatZIP = "zip"
+	// exportFormatCopy exports are a copy of every object under the
+	// export's prefix, made inside storage where it can
+	exportFormatCopy = "copy"
+
+	exportPrefix       = "exports/"
+	exportManifestName = "videos.json"
+	// exportPollInterval is how often the exporter checks for exports it
+	// wasn't woken for, such as ones another process created
+	exportPollInterval = time.Minute
+	// A running export is touched every exportHeartbeatInterval, and taken
+	// to be abandoned by a server that stopped once it hasn't been for
+	// exportStaleAfter
+	exportHeartbeatInterval = 30 * time.Second
+	exportStaleAfter        = 5 * time.Minute
+)
+
+// exportDestination is where an export's archive or copies are stored.
+// Exports live outside the managed prefixes, so the orphan sweep leaves them
+// alone.
+func exportDestination(export database.Export) string {
+	if export.Format == exportFormatZIP {
+		return fmt.Sprintf("%s%s/%s.zip", exportPrefix, export.UserID, export.ID)
+	}
+	return fmt.Sprintf("%s%s/%s/", exportPrefix, export.UserID, export.ID)
+}
+
+// exportEntry is a file that goes into an export, read from storage when it
+// has a key and from the local assets dir otherwise.
+type exportEntry struct {
+	name     string
+	key      string
+	diskPath string
+}
+
+// exportEntries lists everything stored for a user's videos, named by the
+// video they belong to. Videos in the trash are left out.
+func (cfg *apiConfig) exportEntries(ctx context.Context, videos []database.Video) ([]exportEntry, error) {
+	entries := []exportEntry{}
+	for _, video := range videos {
+		keys, err := cfg.videoStoredKeys(ctx, video)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			// Half-finished uploads aren't part of the video yet
+			if video.PendingUploadKey != nil && key == *video.PendingUploadKey {
+				continue
+			}
+			entries = append(entries, exportEntry{name: video.ID.String() + "/" + key, key: key})
+		}
+		for _, thumbnailURL := range videoThumbnailURLs(video) {
+			if !strings.HasPrefix(thumbnailURL, cfg.getAssetURLPrefix()) {
+				continue
+			}
+			name := path.Base(thumbnailURL)
+			entries = append(entries, exportEntry{name: video.ID.String() + "/assets/" + name, diskPath: cfg.getAssetDiskPath(name)})
+		}
+	}
+	return entries, nil
+}
+
+func (cfg *apiConfig) openExportEntry(ctx context.Context, entry exportEntry) (io.ReadCloser, error) {
+	if entry.key != "" {
+		return cfg.store.Get(ctx, entry.key)
+	}
+	return os.Open(entry.diskPath)
+}
+
+// runExporter works through pending exports one at a time until ctx is
+// cancelled, along with any a stopped server left running. Servers sharing
+// a database share the queue, each export being claimed by one of them.
+func (cfg *apiConfig) runExporter(ctx context.Context) {
+	ticker := time.NewTicker(exportPollInterval)
+	defer ticker.Stop()
+	for {
+		err := cfg.db.ResetStaleExports(time.Now().Add(-exportStaleAfter))
+		if err != nil {
+			slog.Error("Couldn't requeue abandoned exports", "error", err)
+		}
+		exports, err := cfg.db.GetPendingExports()
+		if err != nil {
+			slog.Error("Couldn't get pending exports", "error", err)
+		}
+		for _, export := range exports {
+			if ctx.Err() != nil {
+				return
+			}
+			cfg.runExport(ctx, export)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-cfg.exportWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// wakeExporter tells the exporter there's a new export, without waiting if
+// it's already been told.
+func (cfg *apiConfig) wakeExporter() {
+	select {
+	case cfg.exportWake <- struct{}{}:
+	default:
+	}
+}
+
+// runExport carries out an export and records how it went, unless another
+// server claimed it first. One cut short by shutdown goes back to pending,
+// to be started over after the restart.
+func (cfg *apiConfig) runExport(ctx context.Context, export database.Export) {
+	logger := slog.With("export_id", export.ID, "user_id", export.UserID)
+	claimed, err := cfg.db.ClaimExport(export.ID)
+	if err != nil {
+		logger.Error("Couldn't start export", "error", err)
+		return
+	}
+	if !claimed {
+		return
+	}
+	export.Status = database.ExportStatusRunning
+
+	heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+	go func(id uuid.UUID) {
+		ticker := time.NewTicker(exportHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-heartbeatCtx.Done():
+				return
+			case <-ticker.C:
+				if err := cfg.db.TouchExport(id); err != nil {
+					logger.Warn("Couldn't record export heartbeat", "error", err)
+				}
+			}
+		}
+	}(export.ID)
+	err = cfg.writeExport(ctx, &export)
+	stopHeartbeat()
+	switch {
+	case err != nil && ctx.Err() != nil:
+		export.Status = database.ExportStatusPending
+		export.Objects, export.Bytes = 0, 0
+	case err != nil:
+		logger.Error("Export failed", "error", err)
+		message := err.Error()
+		export.Status = database.ExportStatusFailed
+		export.Error = &message
+	default:
+		now := time.Now().UTC()
+		export.Status = database.ExportStatusCompleted
+		export.CompletedAt = &now
+	}
+	// Record the outcome even though ctx may be done
+	err = cfg.db.UpdateExport(export)
+	if err != nil {
+		logger.Error("Couldn't record export", "status", export.Status, "error", err)
+		return
+	}
+
+	switch export.Status {
+	case database.ExportStatusCompleted:
+		logger.Info("Export completed", "objects", export.Objects, "bytes", export.Bytes)
+		cfg.notifyWebhooks(ctx, export.UserID, eventExportCompleted, cfg.exportResponse(ctx, export))
+	case database.ExportStatusFailed:
+		cfg.notifyWebhooks(ctx, export.UserID, eventExportFailed, cfg.exportResponse(ctx, export))
+	}
+}
+
+// writeExport copies everything a user has stored, along with a manifest of
+// their videos, to the export's destination. Progress is recorded as it
+// goes so it can be polled.
+func (cfg *apiConfig) writeExport(ctx context.Context, export *database.Export) error {
+	videos, err := cfg.db.GetVideos(export.UserID)
+	if err != nil {
+		return err
+	}
+	live := []database.Video{}
+	for _, video := range videos {
+		if video.DeletedAt == nil {
+			live = append(live, video)
+		}
+	}
+	entries, err := cfg.exportEntries(ctx, live)
+	if err != nil {
+		return err
+	}
+	manifest, err := json.MarshalIndent(live, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	progress := func(size int64) {
+		export.Objects++
+		export.Bytes += size
+		if err := cfg.db.UpdateExport(*export); err != nil {
+			slog.Warn("Couldn't record export progress", "export_id", export.ID, "error", err)
+		}
+	}
+	if export.Format == exportFormatZIP {
+		return cfg.writeExportZIP(ctx, export, entries, manifest, progress)
+	}
+	return cfg.writeExportCopy(ctx, export, entries, manifest, progress)
+}
+
+// writeExportZIP streams the entries into a ZIP archive as it's stored, so
+// nothing is staged on disk. Videos are already compressed, so entries are
+// stored as they are.
+func (cfg *apiConfig) writeExportZIP(ctx context.Context, export *database.Export, entries []exportEntry, manifest []byte, progress func(int64)) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(cfg.writeZIPEntries(ctx, pw, entries, manifest, progress))
+	}()
+	key := exportDestination(*export)
+	_, err := cfg.store.Put(ctx, key, pr, cfg.objectPutOptions(key, storage.PutOptions{
+		ContentType: "application/zip",
+		Size:        -1,
+	}))
+	// Unblock the writer if the store gave up early
+	pr.CloseWithError(err)
+	return err
+}
+
+func (cfg *apiConfig) writeZIPEntries(ctx context.Context, w io.Writer, entries []exportEntry, manifest []byte, progress func(int64)) error {
+	archive := zip.NewWriter(w)
+	out, err := archive.Create(exportManifestName)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(manifest)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		out, err := archive.CreateHeader(&zip.FileHeader{Name: entry.name, Method: zip.Store, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		body, err := cfg.openExportEntry(ctx, entry)
+		if errors.Is(err, storage.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", entry.name, err)
+		}
+		n, err := copyWithContext(ctx, out, body)
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("archiving %s: %w", entry.name, err)
+		}
+		progress(n)
+	}
+	return archive.Close()
+}
+
+// writeExportCopy copies each entry under the export's prefix, inside the
+// store where it supports that.
+func (cfg *apiConfig) writeExportCopy(ctx context.Context, export *database.Export, entries []exportEntry, manifest []byte, progress func(int64)) error {
+	prefix := exportDestination(*export)
+	err := cfg.putObjectBytes(ctx, prefix+exportManifestName, "application/json", nil, manifest)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		dstKey := prefix + entry.name
+		opts := storage.PutOptions{ContentType: objectContentType(entry.name)}
+		var object storage.ObjectInfo
+		if entry.key != "" {
+			object, err = cfg.copyObject(ctx, entry.key, dstKey, opts)
+		} else {
+			var data []byte
+			data, err = os.ReadFile(entry.diskPath)
+			if err == nil {
+				err = cfg.putObjectBytes(ctx, dstKey, opts.ContentType, nil, data)
+				object.Size = int64(len(data))
+			}
+		}
+		if errors.Is(err, storage.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("copying %s: %w", entry.name, err)
+		}
+		progress(object.Size)
+	}
+	return nil
+}
+
+// deleteExportObjects removes whatever an export has stored so far.
+func (cfg *apiConfig) deleteExportObjects(ctx context.Context, export database.Export) error {
+	destination := exportDestination(export)
+	if export.Format == exportFormatZIP {
+		return cfg.store.Delete(ctx, destination)
+	}
+	keys, err := cfg.listObjectKeys(ctx, destination)
+	if err != nil {
+		return err
+	}
+	failed, err := cfg.deleteObjects(ctx, keys)
+	if err != nil {
+		return err
+	}
+	for key, keyErr := range failed {
+		return fmt.Errorf("deleting %s: %w", key, keyErr)
+	}
+	return nil
+}
+
+// exportView is an export as reported to its owner. Finished ZIP exports
+// come with a link to download the archive, and copies with the prefix
+// they were made under.
+type exportView struct {
+	database.Export
+	DownloadURL *string `json:"download_url,omitempty"`
+	Prefix      string  `json:"prefix,omitempty"`
+}
+
+func (cfg *apiConfig) exportResponse(ctx context.Context, export database.Export) exportView {
+	view := exportView{Export: export}
+	if export.Status != database.ExportStatusCompleted {
+		return view
+	}
+	if export.Format != exportFormatZIP {
+		view.Prefix = exportDestination(export)
+		return view
+	}
+	downloadURL, err := cfg.store.PresignGet(ctx, exportDestination(export), cfg.s3PresignExpiry)
+	if err != nil {
+		loggerFromContext(ctx).Error("Couldn't sign export download", "export_id", export.ID, "error", err)
+		return view
+	}
+	view.DownloadURL = &downloadURL
+	return view
+}
+
+// handlerExportsCreate queues an export of everything the caller has stored,
+// as a ZIP archive or a copy under an archive prefix. Only one export can be
+// in progress at a time. Its progress is polled at the returned Location.
+func (cfg *apiConfig) handlerExportsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Format string `json:"format"`
+	}
+
+	user := authUserFromContext(r.Context())
+	params := parameters{}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&params)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+	}
+	if params.Format == "" {
+		params.Format = exportFormatZIP
+	}
+	if params.Format != exportFormatZIP && params.Format != exportFormatCopy {
+		respondWithError(w, http.StatusBadRequest, "format must be zip or copy", nil)
+		return
+	}
+	// Archives hold decrypted videos, which would undo the encryption
+	if params.Format == exportFormatZIP && cfg.encryptVideos {
+		respondWithError(w, http.StatusBadRequest, "ZIP exports aren't available while videos are encrypted", nil)
+		return
+	}
+
+	exports, err := cfg.db.GetExports(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get exports", err)
+		return
+	}
+	for _, export := range exports {
+		if export.Status == database.ExportStatusPending || export.Status == database.ExportStatusRunning {
+			respondWithError(w, http.StatusConflict, "An export is already in progress", nil)
+			return
+		}
+	}
+
+	export, err := cfg.db.CreateExport(database.CreateExportParams{UserID: user.ID, Format: params.Format})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create export", err)
+		return
+	}
+	cfg.wakeExporter()
+	w.Header().Set("Location", "/api/exports/"+export.ID.String())
+	respondWithJSON(w, http.StatusAccepted, cfg.exportResponse(r.Context(), export))
+}
+
+func (cfg *apiConfig) handlerExportsList(w http.ResponseWriter, r *http.Request) {
+	exports, err := cfg.db.GetExports(authUserFromContext(r.Context()).ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get exports", err)
+		return
+	}
+	views := make([]exportView, 0, len(exports))
+	for _, export := range exports {
+		views = append(views, cfg.exportResponse(r.Context(), export))
+	}
+	respondWithJSON(w, http.StatusOK, views)
+}
+
+// getOwnedExport loads the export in the path if it belongs to the caller.
+// It responds itself and returns false otherwise.
+func (cfg *apiConfig) getOwnedExport(w http.ResponseWriter, r *http.Request) (database.Export, bool) {
+	exportID, err := uuid.Parse(r.PathValue("exportID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidID, err)
+		return database.Export{}, false
+	}
+	export, err := cfg.db.GetExport(exportID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get export", err)
+		return database.Export{}, false
+	}
+	if export.ID == uuid.Nil || export.UserID != authUserFromContext(r.Context()).ID {
+		respondWithError(w, http.StatusNotFound, "Export not found", nil)
+		return database.Export{}, false
+	}
+	return export, true
+}
+
+func (cfg *apiConfig) handlerExportGet(w http.ResponseWriter, r *http.Request) {
+	export, ok := cfg.getOwnedExport(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.exportResponse(r.Context(), export))
+}
+
+// handlerExportDelete removes an export and everything it stored. Exports
+// still in progress can't be deleted.
+func (cfg *apiConfig) handlerExportDelete(w http.ResponseWriter, r *http.Request) {
+	export, ok := cfg.getOwnedExport(w, r)
+	if !ok {
+		return
+	}
+	if export.Status == database.ExportStatusPending || export.Status == database.ExportStatusRunning {
+		respondWithError(w, http.StatusConflict, "Export is still in progress", nil)
+		return
+	}
+	err := cfg.deleteExportObjects(r.Context(), export)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete export files", err)
+		return
+	}
+	err = cfg.db.DeleteExport(export.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete export", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// handlerAdminVideosList lists everyone's videos, or one owner's with
+// owner=<user ID>, taking the same filters as GET /api/videos. The trash is
+// listed with trashed=true.
+func (cfg *apiConfig) handlerAdminVideosList(w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := parseVideoOwner(w, r, nil)
+	if !ok {
+		return
+	}
+	cfg.respondWithVideoPage(w, r, ownerID, r.URL.Query().Get("trashed") == "true", false)
+}
+
+// handlerAdminVideoDelete takes down any user's video for good, skipping the
+// trash.
+func (cfg *apiConfig) handlerAdminVideoDelete(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidID, err)
+		return
+	}
+	video, err := cfg.db.GetVideoWithDeleted(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithAPIError(w, errVideoNotFound, nil)
+		return
+	}
+
+	err = cfg.deleteVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		return
+	}
+	loggerFromContext(r.Context()).Info("Video taken down", "video_id", video.ID, "owner_id", video.UserID, "by", authUserFromContext(r.Context()).ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerAdminStorageUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := cfg.db.GetStorageUsageByUser()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, usage)
+}
+
+// handlerAdminRetagObjects brings the S3 tags on every video's objects up to
+// date, for objects stored before they were tagged or whose video's
+// visibility couldn't be retagged when it changed. It's safe to run
+// repeatedly; videos that fail are reported so it can be retried.
+func (cfg *apiConfig) handlerAdminRetagObjects(w http.ResponseWriter, r *http.Request) {
+	if cfg.objectTagger == nil {
+		respondWithError(w, http.StatusNotImplemented, "Object tags aren't supported by this storage backend", nil)
+		return
+	}
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get videos", err)
+		return
+	}
+
+	type response struct {
+		Retagged int               `json:"retagged"`
+		Failed   map[string]string `json:"failed"`
+	}
+	resp := response{Failed: map[string]string{}}
+	for _, video := range videos {
+		err := cfg.retagVideoObjects(r.Context(), video)
+		if err != nil {
+			loggerFromContext(r.Context()).Error("Couldn't retag video objects", "video_id", video.ID, "error", err)
+			resp.Failed[video.ID.String()] = "couldn't tag objects"
+			continue
+		}
+		resp.Retagged++
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerAdminSetUserRole changes a user's role. Demotions take effect right
+// away; promotions the next time they log in or refresh their access token.
+func (cfg *apiConfig) handlerAdminSetUserRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role auth.Role `json:"role"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidID, err)
+		return
+	}
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.Role.Valid() {
+		respondWithError(w, http.StatusBadRequest, "role must be user, moderator or admin", nil)
+		return
+	}
+	// Otherwise the last admin could lock everyone out
+	if userID == authUserFromContext(r.Context()).ID && params.Role != auth.RoleAdmin {
+		respondWithError(w, http.StatusBadRequest, "You can't remove your own admin role", nil)
+		return
+	}
+
+	found, err := cfg.db.SetUserRole(userID, string(params.Role))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update role", err)
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
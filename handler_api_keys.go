@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// apiKeyAccessTokenTTL is how long the access token an API key is swapped
+// for lasts. It only has to outlive one request.
+const apiKeyAccessTokenTTL = 5 * time.Minute
+
+const maxAPIKeyNameLength = 100
+
+// apiKeyMiddleware lets a request authenticate with "Authorization: ApiKey
+// <key>" instead of a bearer JWT. The key is swapped for a short-lived
+// access token, so the wrapped handlers keep authenticating as they do for
+// browser logins.
+func (cfg *apiConfig) apiKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "ApiKey ") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := auth.GetAPIKey(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find API key", err)
+			return
+		}
+		apiKey, err := cfg.db.GetAPIKeyByHash(auth.HashAPIKey(key))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't look up API key", err)
+			return
+		}
+		if apiKey.ID == uuid.Nil {
+			respondWithError(w, http.StatusUnauthorized, "Invalid API key", nil)
+			return
+		}
+		user, err := cfg.db.GetUser(apiKey.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user for API key", err)
+			return
+		}
+		if user == nil {
+			respondWithError(w, http.StatusUnauthorized, "Invalid API key", nil)
+			return
+		}
+
+		accessToken, err := auth.MakeJWT(user.ID, auth.Role(user.Role), cfg.jwtSecret, apiKeyAccessTokenTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
+			return
+		}
+		setRequestUser(r.Context(), user.ID)
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+accessToken)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (cfg *apiConfig) handlerAPIKeysCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+	type response struct {
+		database.APIKey
+		Key string `json:"key"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > maxAPIKeyNameLength {
+		respondWithError(w, http.StatusBadRequest, "API keys need a name of at most 100 characters", nil)
+		return
+	}
+
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+	apiKey, err := cfg.db.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID: userID,
+		Name:   params.Name,
+	}, auth.HashAPIKey(key))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save API key", err)
+		return
+	}
+
+	// This is the only time the key itself is returned
+	respondWithJSON(w, http.StatusCreated, response{APIKey: apiKey, Key: key})
+}
+
+func (cfg *apiConfig) handlerAPIKeysList(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	keys, err := cfg.db.GetAPIKeys(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve API keys", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+func (cfg *apiConfig) handlerAPIKeysRevoke(w http.ResponseWriter, r *http.Request) {
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidID, err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	revoked, err := cfg.db.RevokeAPIKey(keyID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+	if !revoked {
+		respondWithError(w, http.StatusNotFound, "API key not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// handlerAsset serves a file from the local assets dir with the content type
+// it was stored with. http.ServeContent answers range and conditional
+// requests, against an ETag from the file's size and modification time and
+// its Last-Modified.
+func (cfg *apiConfig) handlerAsset(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if name == "" || strings.HasPrefix(name, ".") || strings.Contains(name, `\`) {
+		respondWithError(w, http.StatusNotFound, "Asset not found", nil)
+		return
+	}
+	file, err := os.Open(cfg.getAssetDiskPath(name))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Asset not found", nil)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		respondWithError(w, http.StatusNotFound, "Asset not found", err)
+		return
+	}
+
+	asset, err := cfg.db.GetAsset(name)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get asset", err)
+		return
+	}
+	contentType := asset.ContentType
+	if asset.Name == "" {
+		// Stored before content types were recorded
+		contentType = mime.TypeByExtension(path.Ext(name))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	sw := &successHeaderWriter{ResponseWriter: w, headers: http.Header{"Cache-Control": {cfg.objectCache.thumbnails}}}
+	http.ServeContent(sw, r, name, info.ModTime(), file)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxBatchUploadFiles       = 20
+	defaultBatchUploadWorkers = 2
+)
+
+// batchUploadResult is how one file of a batch upload went: the response a
+// single upload of it would have had, with the video on success and the
+// error otherwise.
+type batchUploadResult struct {
+	Filename string          `json:"filename"`
+	Status   int             `json:"status"`
+	Video    json.RawMessage `json:"video,omitempty"`
+	Error    json.RawMessage `json:"error,omitempty"`
+}
+
+// resultRecorder is the ResponseWriter each file of a batch upload is
+// processed against, so the upload pipeline can respond as usual and the
+// batch can report what it said.
+type resultRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// newResultRecorder starts a recorder for a file of the batch w answers,
+// whose errors carry the batch's request ID.
+func newResultRecorder(w http.ResponseWriter) *resultRecorder {
+	header := http.Header{}
+	header.Set(requestIDHeader, w.Header().Get(requestIDHeader))
+	return &resultRecorder{header: header, status: http.StatusOK}
+}
+
+func (rec *resultRecorder) Header() http.Header { return rec.header }
+
+func (rec *resultRecorder) Write(p []byte) (int, error) { return rec.body.Write(p) }
+
+func (rec *resultRecorder) WriteHeader(status int) { rec.status = status }
+
+func (rec *resultRecorder) result(filename string) batchUploadResult {
+	result := batchUploadResult{Filename: filename, Status: rec.status}
+	body := bytes.TrimSpace(rec.body.Bytes())
+	if !json.Valid(body) {
+		body, _ = json.Marshal(errorResponse{Error: http.StatusText(rec.status), RequestID: rec.header.Get(requestIDHeader)})
+	}
+	if rec.status < http.StatusBadRequest {
+		result.Video = body
+	} else {
+		result.Error = body
+	}
+	return result
+}
+
+// handlerBatchUploadVideos creates a video for each "video" file in a
+// multipart request, titled after its filename, and uploads it the same way
+// handlerUploadVideo would. visibility and storage_class fields sent before
+// the files apply to all of them. Files are read off the request in order
+// but processed up to cfg.batchUploadWorkers at a time, and the response
+// lists how each went, in the order they were sent. Videos whose upload
+// failed are deleted again.
+func (cfg *apiConfig) handlerBatchUploadVideos(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Results []batchUploadResult `json:"results"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.maxVideoUploadBytes*maxBatchUploadFiles)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
+		return
+	}
+
+	// Each file's worker only writes to its own recorder, which is read
+	// once they've all finished
+	type file struct {
+		filename string
+		rec      *resultRecorder
+	}
+	files := []file{}
+	var wg sync.WaitGroup
+	// Taking a slot before reading a file keeps at most that many on disk
+	slots := make(chan struct{}, cfg.batchUploadWorkers)
+	form := url.Values{}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && len(files) > 0 {
+			// What's been uploaded stays, and the results show how far it got
+			loggerFromContext(r.Context()).Warn("Batch upload cut short", "user_id", userID, "files", len(files), "error", err)
+			break
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
+			return
+		}
+		if part.FormName() != "video" {
+			// Settings only apply to the files after them, so later ones
+			// would be ambiguous
+			if len(files) > 0 {
+				continue
+			}
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldBytes))
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
+				return
+			}
+			form.Add(part.FormName(), string(value))
+			continue
+		}
+
+		rec := newResultRecorder(w)
+		files = append(files, file{filename: part.FileName(), rec: rec})
+		if len(files) > maxBatchUploadFiles {
+			respondWithError(rec, http.StatusBadRequest, fmt.Sprintf("Can upload at most %d videos at once", maxBatchUploadFiles), nil)
+			continue
+		}
+
+		slots <- struct{}{}
+		// Files' sizes aren't known until they've been read
+		releaseSpace, ok := cfg.reserveTempSpace(rec, cfg.maxVideoUploadBytes)
+		if !ok {
+			<-slots
+			continue
+		}
+		upload, ok := cfg.receiveBatchFile(rec, r, part, form)
+		if !ok {
+			releaseSpace()
+			<-slots
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			defer releaseSpace()
+			defer os.Remove(upload.tempFilePath)
+			cfg.storeBatchFile(rec, r, userID, upload)
+		}()
+	}
+	wg.Wait()
+
+	if r.Context().Err() != nil {
+		return
+	}
+	if len(files) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Missing video file", http.ErrMissingFile)
+		return
+	}
+	results := make([]batchUploadResult, 0, len(files))
+	for _, f := range files {
+		results = append(results, f.rec.result(f.filename))
+	}
+	respondWithJSON(w, http.StatusOK, response{Results: results})
+}
+
+// batchFile is one file of a batch upload, written to a temp file.
+type batchFile struct {
+	tempFilePath   string
+	filename       string
+	mediaType      string
+	sourceChecksum string
+	size           int64
+	visibility     string
+	storageClass   types.StorageClass
+}
+
+// receiveBatchFile checks a file's declared type and the form's settings
+// and writes it to a temp file, which the caller must remove. It responds
+// to rec and returns false if the file won't be uploaded.
+func (cfg *apiConfig) receiveBatchFile(rec *resultRecorder, r *http.Request, part *multipart.Part, form url.Values) (batchFile, bool) {
+	upload := batchFile{filename: part.FileName(), visibility: form.Get("visibility")}
+	if upload.visibility != "" && !database.ValidVideoVisibility(upload.visibility) {
+		respondWithError(rec, http.StatusBadRequest, "visibility must be public, unlisted or private", nil)
+		return batchFile{}, false
+	}
+	storageClass, err := cfg.resolveStorageClass(form.Get("storage_class"))
+	if err != nil {
+		respondWithError(rec, http.StatusBadRequest, "Invalid storage class", err)
+		return batchFile{}, false
+	}
+	upload.storageClass = storageClass
+
+	mediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+	if err != nil {
+		respondWithError(rec, http.StatusBadRequest, "Invalid Content-Type", err)
+		return batchFile{}, false
+	}
+	if !cfg.isAllowedVideoType(mediaType) {
+		respondWithError(rec, http.StatusUnsupportedMediaType, "Invalid file upload", nil)
+		return batchFile{}, false
+	}
+	upload.mediaType = mediaType
+
+	tempFile, err := os.CreateTemp(cfg.workDir, "tubely-batch-upload")
+	if err != nil {
+		respondWithError(rec, http.StatusInternalServerError, "Couldn't create temp file", err)
+		return batchFile{}, false
+	}
+	defer tempFile.Close()
+
+	// One byte over the limit is enough to know it's too large
+	size, checksum, err := copyAndHashWithContext(r.Context(), tempFile, io.LimitReader(part, cfg.maxVideoUploadBytes+1))
+	if err == nil && size > cfg.maxVideoUploadBytes {
+		err = fmt.Errorf("file is over %s", formatByteLimit(cfg.maxVideoUploadBytes))
+		respondWithError(rec, http.StatusRequestEntityTooLarge, fmt.Sprintf("Videos can be at most %s", formatByteLimit(cfg.maxVideoUploadBytes)), err)
+	} else if err != nil {
+		respondWithError(rec, http.StatusInternalServerError, "Couldn't write video data", err)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return batchFile{}, false
+	}
+	upload.tempFilePath = tempFile.Name()
+	upload.sourceChecksum = checksum
+	upload.size = size
+	return upload, true
+}
+
+// storeBatchFile creates a video for a received file and uploads it. It
+// responds to rec either way.
+func (cfg *apiConfig) storeBatchFile(rec *resultRecorder, r *http.Request, userID uuid.UUID, upload batchFile) {
+	if !cfg.checkMonthlyUploads(rec, userID) {
+		return
+	}
+	succeeded := false
+	finishUpload := startUpload(uploadTypeVideo)
+	defer func() { finishUpload(succeeded, upload.size) }()
+
+	title := strings.TrimSuffix(upload.filename, path.Ext(upload.filename))
+	if title == "" {
+		title = "Untitled video"
+	}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:      title,
+		Visibility: upload.visibility,
+		UserID:     userID,
+	}, database.VideoStatusPending)
+	if err != nil {
+		respondWithError(rec, http.StatusInternalServerError, "Couldn't create video", err)
+		return
+	}
+	video.OriginalFilename = uploadedFilename(upload.filename)
+	defer func() {
+		if succeeded {
+			return
+		}
+		if err := cfg.db.DeleteVideo(video.ID); err != nil {
+			loggerFromContext(r.Context()).Error("Couldn't delete video after failed batch upload", "video_id", video.ID, "error", err)
+		}
+	}()
+
+	succeeded = cfg.storeVideoFile(rec, r, video, upload.tempFilePath, upload.mediaType, upload.sourceChecksum, upload.size, upload.storageClass)
+}
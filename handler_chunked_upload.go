@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Chunked uploads are how browser upload widgets like Dropzone split a
+// file: each chunk is POSTed as its own multipart form, with fileID,
+// chunkIndex and totalChunks fields before the "video" file. Chunks can
+// come in any order, even at once, and a failed one is simply sent again.
+// Each is kept under TEMP_DIR until the last arrives, when they're joined
+// and the whole file runs through the usual pipeline.
+const (
+	maxUploadChunks     = 10000
+	maxUploadFileIDLen  = 128
+	chunkedUploadPrefix = "tubely-chunks-"
+	// claimedChunksSuffix marks a chunk directory being assembled, so only
+	// one of two last chunks arriving together does it
+	claimedChunksSuffix = ".assembling"
+)
+
+type chunkedUploadResponse struct {
+	FileID      string `json:"file_id"`
+	TotalChunks int    `json:"total_chunks,omitempty"`
+	Received    []int  `json:"received"`
+}
+
+// handlerUploadVideoChunk stores one chunk of a video's file, and once all
+// totalChunks have arrived, responds as handlerUploadVideo would for the
+// whole file. Until then it answers 202 with the chunks received so far.
+func (cfg *apiConfig) handlerUploadVideoChunk(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.maxVideoUploadBytes+multipartOverheadBytes)
+
+	// Validating would skip the checks on storing, which the last chunk does
+	if r.URL.Query().Get("validate") == "true" {
+		respondWithError(w, http.StatusBadRequest, "Chunked uploads can't be validated, use POST /api/video_upload/{videoID}?validate=true", nil)
+		return
+	}
+	videoMetadata, ok := cfg.authorizeVideoUpload(w, r)
+	if !ok {
+		return
+	}
+	chunk, form, err := videoFormPart(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse video file", err)
+		return
+	}
+
+	fileID := form.Get("fileID")
+	if fileID == "" || len(fileID) > maxUploadFileIDLen {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("fileID must be 1 to %d characters", maxUploadFileIDLen), nil)
+		return
+	}
+	totalChunks, err := strconv.Atoi(form.Get("totalChunks"))
+	if err != nil || totalChunks < 1 || totalChunks > maxUploadChunks {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("totalChunks must be a number from 1 to %d", maxUploadChunks), err)
+		return
+	}
+	chunkIndex, err := strconv.Atoi(form.Get("chunkIndex"))
+	if err != nil || chunkIndex < 0 || chunkIndex >= totalChunks {
+		respondWithError(w, http.StatusBadRequest, "chunkIndex must be from 0 to totalChunks-1", err)
+		return
+	}
+	mediaType, ok := chunkMediaType(chunk)
+	if !ok || !cfg.isAllowedVideoType(mediaType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Invalid file upload", nil)
+		return
+	}
+	storageClass, err := cfg.resolveStorageClass(form.Get("storage_class"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid storage class", err)
+		return
+	}
+
+	dir := cfg.chunkDir(videoMetadata, fileID)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chunk directory", err)
+		return
+	}
+	received, err := receivedChunks(dir)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list received chunks", err)
+		return
+	}
+	if received.size() > cfg.maxVideoUploadBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Videos can be at most %s", formatByteLimit(cfg.maxVideoUploadBytes)), nil)
+		return
+	}
+
+	releaseSpace, ok := cfg.reserveTempSpace(w, cfg.expectedUploadSize(r))
+	if !ok {
+		return
+	}
+	defer releaseSpace()
+
+	// Written under another name first, so a chunk that's cut short never
+	// counts as received
+	partial, err := os.CreateTemp(dir, "partial-")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create temp file", err)
+		return
+	}
+	defer os.Remove(partial.Name())
+	limit := cfg.maxVideoUploadBytes - received.sizeWithout(chunkIndex)
+	written, err := copyWithContext(r.Context(), partial, io.LimitReader(chunk, limit+1))
+	partial.Close()
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't write video data", err)
+		return
+	}
+	if written > limit {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Videos can be at most %s", formatByteLimit(cfg.maxVideoUploadBytes)), nil)
+		return
+	}
+	err = os.Rename(partial.Name(), filepath.Join(dir, strconv.Itoa(chunkIndex)))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save chunk", err)
+		return
+	}
+
+	received, err = receivedChunks(dir)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list received chunks", err)
+		return
+	}
+	if !received.complete(totalChunks) {
+		respondWithJSON(w, http.StatusAccepted, chunkedUploadResponse{FileID: fileID, TotalChunks: totalChunks, Received: received.indexes()})
+		return
+	}
+
+	// The chunks stay until the file is stored, so assembling it takes as
+	// much again. Without room they're kept, and sending the last again
+	// retries.
+	releaseSpace()
+	releaseSpace, ok = cfg.reserveTempSpace(w, received.size())
+	if !ok {
+		return
+	}
+	defer releaseSpace()
+
+	// Whichever request claims the directory assembles it, and any other
+	// sees its chunk was received
+	claimed := dir + claimedChunksSuffix
+	if err := os.Rename(dir, claimed); err != nil {
+		respondWithJSON(w, http.StatusAccepted, chunkedUploadResponse{FileID: fileID, TotalChunks: totalChunks, Received: received.indexes()})
+		return
+	}
+	defer os.RemoveAll(claimed)
+	cfg.storeChunkedUpload(w, r, videoMetadata, claimed, totalChunks, chunk.FileName(), mediaType, storageClass)
+}
+
+// storeChunkedUpload joins the chunks in dir into one file and runs it
+// through the pipeline, responding either way.
+func (cfg *apiConfig) storeChunkedUpload(w http.ResponseWriter, r *http.Request, videoMetadata database.Video, dir string, totalChunks int, filename, mediaType string, storageClass types.StorageClass) {
+	unlock, ok := cfg.lockVideoUpload(w, videoMetadata.ID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	succeeded := false
+	var size int64
+	finishUpload := startUpload(uploadTypeVideo)
+	defer func() { finishUpload(succeeded, size) }()
+
+	assembled, err := os.CreateTemp(cfg.workDir, "tubely-chunked-upload")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create temp file", err)
+		return
+	}
+	defer os.Remove(assembled.Name())
+	defer assembled.Close()
+	h := sha256.New()
+	for i := range totalChunks {
+		written, err := appendChunk(r.Context(), io.MultiWriter(assembled, h), filepath.Join(dir, strconv.Itoa(i)))
+		size += written
+		if r.Context().Err() != nil {
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't write video data", err)
+			return
+		}
+	}
+	sourceChecksum := hex.EncodeToString(h.Sum(nil))
+
+	videoMetadata.OriginalFilename = uploadedFilename(filename)
+	succeeded = cfg.storeVideoFile(w, r, videoMetadata, assembled.Name(), mediaType, sourceChecksum, size, storageClass)
+}
+
+// appendChunk copies the chunk at chunkPath to dst.
+func appendChunk(ctx context.Context, dst io.Writer, chunkPath string) (int64, error) {
+	chunk, err := os.Open(chunkPath)
+	if err != nil {
+		return 0, err
+	}
+	defer chunk.Close()
+	return copyWithContext(ctx, dst, chunk)
+}
+
+// handlerVideoChunks lists the chunks of an upload the server has, so a
+// client resuming it only sends the rest, given the same fileID.
+// It's served behind requireOwnerOrAdmin.
+func (cfg *apiConfig) handlerVideoChunks(w http.ResponseWriter, r *http.Request) {
+	videoMetadata := authVideoFromContext(r.Context())
+	fileID := r.URL.Query().Get("fileID")
+	if fileID == "" || len(fileID) > maxUploadFileIDLen {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("fileID must be 1 to %d characters", maxUploadFileIDLen), nil)
+		return
+	}
+	received, err := receivedChunks(cfg.chunkDir(videoMetadata, fileID))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list received chunks", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chunkedUploadResponse{FileID: fileID, Received: received.indexes()})
+}
+
+// chunkDir is where the chunks of a video's upload are kept. fileID is the
+// client's, so it's hashed rather than used in the path.
+func (cfg *apiConfig) chunkDir(video database.Video, fileID string) string {
+	sum := sha256.Sum256([]byte(video.UserID.String() + "/" + video.ID.String() + "/" + fileID))
+	return filepath.Join(cfg.tempDir, chunkedUploadPrefix+hex.EncodeToString(sum[:16]))
+}
+
+// chunkMediaType is a chunk's declared type. Browsers send slices of a file
+// as application/octet-stream, so then it's guessed from the filename.
+func chunkMediaType(chunk *multipart.Part) (string, bool) {
+	mediaType, _, err := mime.ParseMediaType(chunk.Header.Get("Content-Type"))
+	if err == nil && mediaType != "application/octet-stream" {
+		return mediaType, true
+	}
+	mediaType, _, err = mime.ParseMediaType(mime.TypeByExtension(path.Ext(chunk.FileName())))
+	return mediaType, err == nil
+}
+
+// chunkSet is the sizes of the chunks received so far, by index.
+type chunkSet map[int]int64
+
+// receivedChunks lists the chunks in dir, which may not exist yet.
+func receivedChunks(dir string) (chunkSet, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return chunkSet{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	chunks := chunkSet{}
+	for _, entry := range entries {
+		index, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		chunks[index] = info.Size()
+	}
+	return chunks, nil
+}
+
+func (c chunkSet) size() int64 {
+	return c.sizeWithout(-1)
+}
+
+// sizeWithout is the total size of the chunks other than index, which a
+// chunk sent again replaces.
+func (c chunkSet) sizeWithout(index int) int64 {
+	var total int64
+	for i, size := range c {
+		if i != index {
+			total += size
+		}
+	}
+	return total
+}
+
+func (c chunkSet) complete(totalChunks int) bool {
+	for i := range totalChunks {
+		if _, ok := c[i]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (c chunkSet) indexes() []int {
+	indexes := make([]int, 0, len(c))
+	for i := range c {
+		indexes = append(indexes, i)
+	}
+	slices.Sort(indexes)
+	return indexes
+}
+
+// expireChunkedUploads removes the chunks of uploads that haven't had one
+// in uploadSessionTTL, along with any left mid-assembly by a restart.
+func (cfg *apiConfig) expireChunkedUploads() (int, error) {
+	dirs, err := filepath.Glob(filepath.Join(cfg.tempDir, chunkedUploadPrefix+"*"))
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-cfg.uploadSessionTTL)
+	removed := 0
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			slog.Error("Couldn't remove abandoned chunks", "path", dir, "error", err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
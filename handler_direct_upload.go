@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const directUploadURLExpiry = 5 * time.Minute
+
+// handlerRequestUploadURL reserves a key for a video and hands back a
+// presigned PUT so the client can send the file straight to S3.
+func (cfg *apiConfig) handlerRequestUploadURL(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ContentType  string `json:"content_type"`
+		Size         int64  `json:"size"`
+		StorageClass string `json:"storage_class"`
+	}
+	type response struct {
+		UploadURL string            `json:"upload_url"`
+		Method    string            `json:"method"`
+		Headers   map[string]string `json:"headers"`
+		ExpiresAt time.Time         `json:"expires_at"`
+	}
+
+	videoMetadata, ok := cfg.authorizeVideoUpload(w, r)
+	if !ok {
+		return
+	}
+	presigner, ok := cfg.store.(storage.PutPresigner)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads aren't supported by this storage backend", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !cfg.isAllowedVideoType(params.ContentType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Invalid file upload", nil)
+		return
+	}
+	if params.Size <= 0 || params.Size > cfg.maxVideoUploadBytes {
+		respondWithError(w, http.StatusBadRequest, "Invalid file size", nil)
+		return
+	}
+	if !cfg.checkQuota(w, videoMetadata, params.Size) {
+		return
+	}
+
+	storageClass, err := cfg.resolveStorageClass(params.StorageClass)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid storage class", err)
+		return
+	}
+
+	key, err := newObjectKey("uploads", strings.Split(params.ContentType, "/")[1])
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate random name", err)
+		return
+	}
+
+	// Content type, length, caching headers, storage class and tags are
+	// signed, so S3 rejects a PUT that doesn't match what was declared here
+	presigned, err := presigner.PresignPut(r.Context(), key, cfg.objectPutOptions(key, storage.PutOptions{
+		ContentType:  params.ContentType,
+		StorageClass: string(storageClass),
+		Tags:         videoObjectTags(videoMetadata),
+		Size:         params.Size,
+	}), directUploadURLExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload URL", err)
+		return
+	}
+
+	videoMetadata.PendingUploadKey = &key
+	err = cfg.db.UpdateVideo(videoMetadata)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		UploadURL: presigned.URL,
+		Method:    presigned.Method,
+		Headers:   presigned.Headers,
+		ExpiresAt: time.Now().UTC().Add(directUploadURLExpiry),
+	})
+}
+
+// handlerConfirmUpload is called once a presigned upload finishes. It probes
+// the object the client sent and makes it the video's file. The body is
+// optional and can give the uploaded file's name.
+func (cfg *apiConfig) handlerConfirmUpload(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Filename string `json:"filename"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidID, err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	// Locked before loading the video so a confirm that just finished can't
+	// leave this one with its pending key
+	unlock, ok := cfg.lockVideoUpload(w, videoID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	videoMetadata, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video metadata", err)
+		return
+	}
+	if videoMetadata.ID == uuid.Nil {
+		respondWithAPIError(w, errVideoNotFound, nil)
+		return
+	}
+	if videoMetadata.UserID != userID {
+		respondWithAPIError(w, errNotOwner, nil)
+		return
+	}
+	if videoMetadata.PendingUploadKey == nil {
+		respondWithError(w, http.StatusBadRequest, "No upload in progress for this video", nil)
+		return
+	}
+	key := *videoMetadata.PendingUploadKey
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	cfg.setVideoStatus(r.Context(), videoMetadata.ID, database.VideoStatusReceived)
+	stored := false
+	defer func() {
+		if !stored {
+			cfg.setVideoStatus(r.Context(), videoMetadata.ID, database.VideoStatusFailed)
+		}
+	}()
+
+	head, err := cfg.store.Head(r.Context(), key)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Uploaded video not found", err)
+		return
+	}
+	if head.Size > cfg.maxVideoUploadBytes {
+		cfg.discardPendingUpload(r, videoMetadata)
+		respondWithError(w, http.StatusBadRequest, "Invalid file size", nil)
+		return
+	}
+	// Checked again in case other uploads finished since the URL was issued
+	if !cfg.checkQuota(w, videoMetadata, head.Size) {
+		cfg.discardPendingUpload(r, videoMetadata)
+		return
+	}
+
+	if cfg.scanner != nil {
+		object, err := cfg.store.Get(r.Context(), key)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't read uploaded video", err)
+			return
+		}
+		clean, infected := cfg.scanUpload(w, r, videoMetadata, object)
+		object.Close()
+		if infected {
+			cfg.discardPendingUpload(r, videoMetadata)
+		}
+		if !clean {
+			return
+		}
+	}
+
+	cfg.setVideoStatus(r.Context(), videoMetadata.ID, database.VideoStatusProbing)
+	probe, err := cfg.probeStoredObject(r.Context(), key)
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		cfg.discardPendingUpload(r, videoMetadata)
+		respondWithError(w, http.StatusBadRequest, "Couldn't probe uploaded video, it must be a fast-start mp4", err)
+		return
+	}
+	// The key's extension records the type the upload URL was signed for
+	mediaType := mime.TypeByExtension(path.Ext(key))
+	if !containerMatches(mediaType, probe.Container) {
+		cfg.discardPendingUpload(r, videoMetadata)
+		respondWithError(w, http.StatusUnsupportedMediaType, "Uploaded file doesn't match its Content-Type", fmt.Errorf("%w: container %s, declared %s", errContentMismatch, probe.Container, mediaType))
+		return
+	}
+	if !cfg.checkPlanDuration(w, videoMetadata.UserID, probe.Duration) {
+		cfg.discardPendingUpload(r, videoMetadata)
+		return
+	}
+
+	// Remove the object being replaced so it isn't orphaned
+	err = cfg.deleteVideoFile(r.Context(), videoMetadata)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete previous video", err)
+		return
+	}
+	cfg.deleteTranscodedOutputs(r, &videoMetadata)
+	cfg.deletePreview(r.Context(), &videoMetadata)
+
+	videoURL := cfg.getObjectURL(key)
+	videoMetadata.VideoURL = &videoURL
+	videoMetadata.VideoChecksum = nil
+	if head.ChecksumSHA256 != "" {
+		videoMetadata.VideoChecksum = &head.ChecksumSHA256
+	}
+	videoMetadata.VideoEncryption = objectEncryption(head)
+	probe.Size = head.Size
+	videoMetadata.MediaInfo = &probe
+	videoMetadata.PendingUploadKey = nil
+	videoMetadata.OriginalFilename = uploadedFilename(params.Filename)
+
+	err = cfg.db.UpdateVideo(videoMetadata)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	stored = true
+	cfg.setVideoStatus(r.Context(), videoMetadata.ID, database.VideoStatusReady)
+	videoMetadata.Status = database.VideoStatusReady
+	cfg.recordVideoUpload(r.Context(), videoMetadata)
+	cfg.notifyWebhooks(r.Context(), videoMetadata.UserID, eventVideoUploaded, videoMetadata)
+
+	videoMetadata, err = cfg.dbVideoToSignedVideo(r.Context(), videoMetadata)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, videoMetadata)
+}
+
+// discardPendingUpload deletes a rejected direct upload and forgets its key.
+func (cfg *apiConfig) discardPendingUpload(r *http.Request, video database.Video) {
+	if video.PendingUploadKey == nil {
+		return
+	}
+	if err := cfg.store.Delete(r.Context(), *video.PendingUploadKey); err != nil {
+		loggerFromContext(r.Context()).Error("Couldn't delete rejected upload", "video_id", video.ID, "key", *video.PendingUploadKey, "error", err)
+	}
+	video.PendingUploadKey = nil
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		loggerFromContext(r.Context()).Error("Couldn't clear pending upload", "video_id", video.ID, "error", err)
+	}
+}
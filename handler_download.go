@@ -0,0 +1,111 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxFilenameLength is in bytes, the limit most filesystems have.
+const maxFilenameLength = 255
+
+// handlerVideoDownload sends a video's file as an attachment, named after
+// the file that was uploaded. Access is checked as for streaming.
+func (cfg *apiConfig) handlerVideoDownload(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidVideoID, err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canStreamVideo(r, video) {
+		respondWithAPIError(w, errVideoNotFound, nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no file yet", nil)
+		return
+	}
+
+	cfg.serveVideoObject(w, r, video, *video.VideoURL, downloadFilename(video))
+}
+
+// downloadFilename is the name a video's file is saved under: the uploaded
+// name if there was one, otherwise the title with the stored file's
+// extension.
+func downloadFilename(video database.Video) string {
+	if video.OriginalFilename != nil && *video.OriginalFilename != "" {
+		return *video.OriginalFilename
+	}
+	extension := path.Ext(*video.VideoURL)
+	base := sanitizeFilename(video.Title)
+	if base == "" {
+		base = video.ID.String()
+	}
+	return base + extension
+}
+
+// sanitizeFilename reduces a client-supplied filename to something safe to
+// store and send back in a Content-Disposition header: no directories,
+// invalid UTF-8, control characters or characters Windows forbids, and at
+// most maxFilenameLength bytes.
+func sanitizeFilename(name string) string {
+	// Browsers may send Windows paths
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.ToValidUTF8(name, "")
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+	if name == "" {
+		return ""
+	}
+
+	// Trim the base rather than the extension, a rune at a time
+	if len(name) > maxFilenameLength {
+		extension := path.Ext(name)
+		if len(extension) > maxFilenameLength/2 {
+			extension = ""
+		}
+		base := strings.TrimSuffix(name, extension)
+		for len(base)+len(extension) > maxFilenameLength {
+			_, size := utf8.DecodeLastRuneInString(base)
+			base = base[:len(base)-size]
+		}
+		name = base + extension
+	}
+	return name
+}
+
+// uploadedFilename sanitizes the name a file was uploaded under, returning
+// nil if nothing usable is left.
+func uploadedFilename(name string) *string {
+	name = sanitizeFilename(name)
+	if name == "" {
+		return nil
+	}
+	return &name
+}
+
+// filenameFromContentDisposition returns the filename parameter of a
+// Content-Disposition header, or "" if there's none.
+func filenameFromContentDisposition(header string) string {
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
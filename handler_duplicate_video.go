@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+)
+
+// handlerDuplicateVideo creates a copy of a video for its owner, with its own
+// copy of the file and everything derived from it, so either can be edited
+// or deleted without affecting the other. Objects are copied inside the
+// store where it supports that, without a round trip through the server.
+// It's served behind requireOwnerOrAdmin.
+func (cfg *apiConfig) handlerDuplicateVideo(w http.ResponseWriter, r *http.Request) {
+	source := authVideoFromContext(r.Context())
+	if source.Status != database.VideoStatusReady {
+		respondWithError(w, http.StatusConflict, "Only ready videos can be duplicated", nil)
+		return
+	}
+	// The file mustn't be replaced halfway through copying it
+	unlock, ok := cfg.lockVideoUpload(w, source.ID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	var size int64
+	if source.VideoURL != nil && source.MediaInfo != nil {
+		size = source.MediaInfo.Size
+	}
+	if !cfg.checkQuota(w, database.Video{CreateVideoParams: source.CreateVideoParams}, size) {
+		return
+	}
+
+	// It stays pending until everything's copied, so a failed duplicate is
+	// never listed
+	duplicate, err := cfg.db.CreateVideo(source.CreateVideoParams, database.VideoStatusPending)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+		return
+	}
+	duplicate.VideoChecksum = source.VideoChecksum
+	duplicate.SourceChecksum = source.SourceChecksum
+	duplicate.MediaInfo = source.MediaInfo
+	duplicate.PerceptualHash = source.PerceptualHash
+	duplicate.OriginalFilename = source.OriginalFilename
+
+	copied, err := cfg.copyVideoObjects(r.Context(), source, &duplicate)
+	if err == nil && len(source.Tags) > 0 {
+		err = cfg.db.AddVideoTags(duplicate.ID, source.Tags)
+		duplicate.Tags = source.Tags
+	}
+	// Copies of a blocked video are blocked too
+	if err == nil && source.Moderation != nil {
+		err = cfg.db.SetVideoModeration(duplicate.ID, source.ModerationStatus, *source.Moderation)
+		duplicate.ModerationStatus = source.ModerationStatus
+		duplicate.Moderation = source.Moderation
+	}
+	if err == nil {
+		err = cfg.db.UpdateVideo(duplicate)
+	}
+	if err == nil {
+		err = cfg.db.SetVideoStatus(duplicate.ID, database.VideoStatusReady)
+		duplicate.Status = database.VideoStatusReady
+	}
+	if err != nil {
+		cfg.discardDuplicate(r.Context(), duplicate, copied)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't copy video", err)
+		return
+	}
+
+	duplicate, err = cfg.dbVideoToSignedVideo(r.Context(), duplicate)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, duplicate)
+}
+
+// copyVideoObjects copies source's file, captions, renditions, HLS output,
+// sprite sheets and thumbnails, pointing duplicate at the copies. It
+// returns the URLs of what it copied, even on failure, so they can be
+// removed again.
+func (cfg *apiConfig) copyVideoObjects(ctx context.Context, source database.Video, duplicate *database.Video) ([]string, error) {
+	copied := []string{}
+	tags := videoObjectTags(*duplicate)
+	copyKey := func(srcKey, dstKey string) (storage.ObjectInfo, error) {
+		object, err := cfg.copyObject(ctx, srcKey, dstKey, storage.PutOptions{
+			ContentType:  objectContentType(srcKey),
+			StorageClass: string(cfg.s3StorageClass),
+			Tags:         tags,
+		})
+		if err != nil {
+			return storage.ObjectInfo{}, err
+		}
+		copied = append(copied, cfg.getObjectURL(dstKey))
+		return object, nil
+	}
+	copyPrefix := func(srcPrefix, dstPrefix string) error {
+		keys, err := cfg.listObjectKeys(ctx, srcPrefix)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			_, err := copyKey(key, dstPrefix+strings.TrimPrefix(key, srcPrefix))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if source.VideoURL != nil {
+		srcKey, ok := cfg.objectKeyFromURL(*source.VideoURL)
+		if !ok {
+			return copied, errors.New("video file isn't in storage")
+		}
+		dstKey, err := newObjectKey(path.Dir(srcKey), strings.TrimPrefix(path.Ext(srcKey), "."))
+		if err != nil {
+			return copied, err
+		}
+		object, err := copyKey(srcKey, dstKey)
+		if err != nil {
+			return copied, err
+		}
+		videoURL := cfg.getObjectURL(dstKey)
+		duplicate.VideoURL = &videoURL
+		duplicate.VideoEncryption = objectEncryption(object)
+
+		// Caption tracks live alongside the video file
+		duplicate.CaptionsURL = database.URLMap{}
+		for lang, captionsURL := range source.CaptionsURL {
+			captionsKey, ok := cfg.objectKeyFromURL(captionsURL)
+			if !ok {
+				continue
+			}
+			dstCaptionsKey := captionsObjectKey(dstKey, lang)
+			_, err := copyKey(captionsKey, dstCaptionsKey)
+			if err != nil {
+				return copied, err
+			}
+			duplicate.CaptionsURL[lang] = cfg.getObjectURL(dstCaptionsKey)
+		}
+		duplicate.AutoCaptions = source.AutoCaptions
+	}
+
+	duplicate.Renditions = database.URLMap{}
+	for name, renditionURL := range source.Renditions {
+		srcKey, ok := cfg.objectKeyFromURL(renditionURL)
+		if !ok {
+			continue
+		}
+		dstKey := renditionKey(*duplicate, name)
+		_, err := copyKey(srcKey, dstKey)
+		if err != nil {
+			return copied, err
+		}
+		duplicate.Renditions[name] = cfg.getObjectURL(dstKey)
+	}
+	if source.HLSURL != nil {
+		err := copyPrefix(hlsPrefix(source), hlsPrefix(*duplicate))
+		if err != nil {
+			return copied, err
+		}
+		masterURL := cfg.getObjectURL(hlsPrefix(*duplicate) + transcode.HLSMasterPlaylist)
+		duplicate.HLSURL = &masterURL
+		// The copied subtitle playlists still point at the source's captions
+		if len(duplicate.CaptionsURL) > 0 {
+			err = cfg.updateHLSSubtitles(ctx, *duplicate)
+			if err != nil {
+				return copied, err
+			}
+		}
+	}
+	if source.SpritesVTTURL != nil {
+		err := copyPrefix(spritesPrefix(source), spritesPrefix(*duplicate))
+		if err != nil {
+			return copied, err
+		}
+		vttURL := cfg.getObjectURL(spritesPrefix(*duplicate) + transcode.SpritesVTT)
+		duplicate.SpritesVTTURL = &vttURL
+	}
+
+	thumbnails := []struct {
+		src *string
+		dst **string
+	}{
+		{source.ThumbnailURL, &duplicate.ThumbnailURL},
+		{source.ThumbnailSmallURL, &duplicate.ThumbnailSmallURL},
+		{source.PreviewURL, &duplicate.PreviewURL},
+	}
+	for _, thumbnail := range thumbnails {
+		if thumbnail.src == nil {
+			continue
+		}
+		// Versioned names start with the video's ID
+		name := duplicate.ID.String() + strings.TrimPrefix(path.Base(*thumbnail.src), source.ID.String())
+		var thumbnailURL string
+		if srcKey, ok := cfg.objectKeyFromURL(*thumbnail.src); ok {
+			_, err := copyKey(srcKey, thumbnailKeyPrefix+name)
+			if err != nil {
+				return copied, err
+			}
+			thumbnailURL = cfg.getObjectURL(thumbnailKeyPrefix + name)
+		} else if strings.HasPrefix(*thumbnail.src, cfg.getAssetURLPrefix()) {
+			data, err := os.ReadFile(cfg.getAssetDiskPath(path.Base(*thumbnail.src)))
+			if err != nil {
+				return copied, err
+			}
+			asset, err := cfg.db.GetAsset(path.Base(*thumbnail.src))
+			if err != nil {
+				return copied, err
+			}
+			contentType := asset.ContentType
+			if asset.Name == "" {
+				contentType = mime.TypeByExtension(path.Ext(name))
+			}
+			err = cfg.writeAsset(name, contentType, data)
+			if err != nil {
+				return copied, err
+			}
+			thumbnailURL = cfg.getAssetURL(name)
+			copied = append(copied, thumbnailURL)
+		} else {
+			continue
+		}
+		*thumbnail.dst = &thumbnailURL
+	}
+	return copied, nil
+}
+
+// objectContentType guesses a stored object's content type from its key's
+// extension.
+func objectContentType(key string) string {
+	if contentType, ok := outputContentTypes[path.Ext(key)]; ok {
+		return contentType
+	}
+	return mime.TypeByExtension(path.Ext(key))
+}
+
+// discardDuplicate removes a duplicate that couldn't be finished, along with
+// whatever was copied for it.
+func (cfg *apiConfig) discardDuplicate(ctx context.Context, duplicate database.Video, copied []string) {
+	logger := loggerFromContext(ctx)
+	keys := []string{}
+	for _, copiedURL := range copied {
+		if key, ok := cfg.objectKeyFromURL(copiedURL); ok {
+			keys = append(keys, key)
+			continue
+		}
+		if err := cfg.deleteAssetByURL(copiedURL); err != nil {
+			logger.Error("Couldn't delete copied thumbnail", "video_id", duplicate.ID, "url", copiedURL, "error", err)
+		}
+	}
+	failed, err := cfg.deleteObjects(ctx, keys)
+	if err != nil {
+		logger.Error("Couldn't delete copied objects", "video_id", duplicate.ID, "error", err)
+	}
+	for key, keyErr := range failed {
+		logger.Error("Couldn't delete copied object", "video_id", duplicate.ID, "key", key, "error", keyErr)
+	}
+	if err := cfg.db.DeleteVideo(duplicate.ID); err != nil {
+		logger.Error("Couldn't delete unfinished duplicate", "video_id", duplicate.ID, "error", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// handlerGenerateThumbnail replaces a video's thumbnail with the frame at a
+// chosen timestamp, defaulting to the same point automatic thumbnails use.
+func (cfg *apiConfig) handlerGenerateThumbnail(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Timestamp *float64 `json:"timestamp"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithAPIError(w, errInvalidID, err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithAPIError(w, errVideoNotFound, nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithAPIError(w, errNotOwner, nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Video hasn't been uploaded yet", nil)
+		return
+	}
+	if isAudioVideo(video) {
+		respondWithError(w, http.StatusConflict, "Audio has no frames to take a thumbnail from", nil)
+		return
+	}
+	key, ok := cfg.objectKeyFromURL(*video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "Video isn't stored in this bucket", nil)
+		return
+	}
+
+	var duration float64
+	if video.MediaInfo != nil {
+		duration = video.MediaInfo.Duration
+	}
+	at := duration * autoThumbnailPosition
+	if params.Timestamp != nil {
+		at = *params.Timestamp
+		if at < 0 || (duration > 0 && at >= duration) {
+			respondWithError(w, http.StatusBadRequest, "timestamp is outside the video", nil)
+			return
+		}
+	}
+
+	// ffmpeg seeks with range requests, so only the data around the frame
+	// is downloaded. Encrypted videos have to be decrypted in full first.
+	var source string
+	if cfg.encryptVideos {
+		source, err = cfg.downloadObject(r.Context(), key)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't download video", err)
+			return
+		}
+		defer os.Remove(source)
+	} else {
+		source, err = cfg.store.PresignGet(r.Context(), key, cfg.s3PresignExpiry)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video link", err)
+			return
+		}
+	}
+	data, err := extractFrameJPEG(r.Context(), source, at)
+	if err != nil {
+		respondWithMediaError(w, "Couldn't extract frame", err)
+		return
+	}
+
+	img, err := decodeThumbnail(data)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode extracted frame", err)
+		return
+	}
+	replaced, err := cfg.setThumbnail(r.Context(), &video, img, nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't write data", err)
+		return
+	}
+
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to update video", err)
+		return
+	}
+	cfg.notifyWebhooks(r.Context(), video.UserID, eventThumbnailUpdated, video)
+
+	cfg.deleteThumbnails(r.Context(), video.ID, replaced)
+
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"syscall"
+	"time"
+)
+
+const videoImportTimeout = 10 * time.Minute
+
+var errDisallowedImportAddress = errors.New("source address is not allowed")
+
+func (cfg *apiConfig) handlerImportVideo(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		SourceURL    string `json:"source_url"`
+		StorageClass string `json:"storage_class"`
+	}
+
+	videoMetadata, ok := cfg.authorizeVideoUpload(w, r)
+	if !ok {
+		return
+	}
+	unlock, ok := cfg.lockVideoUpload(w, videoMetadata.ID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	sourceURL, err := url.Parse(params.SourceURL)
+	if err != nil || sourceURL.Scheme != "https" || sourceURL.Host == "" {
+		respondWithError(w, http.StatusBadRequest, "source_url must be an https URL", err)
+		return
+	}
+
+	storageClass, err := cfg.resolveStorageClass(params.StorageClass)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid storage class", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), videoImportTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL.String(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid source_url", err)
+		return
+	}
+
+	resp, err := newImportHTTPClient().Do(req)
+	if errors.Is(err, errDisallowedImportAddress) {
+		respondWithError(w, http.StatusBadRequest, "source_url points to a disallowed address", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't download source video", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respondWithError(w, http.StatusBadGateway, fmt.Sprintf("Source responded with %d", resp.StatusCode), nil)
+		return
+	}
+	if resp.ContentLength > cfg.maxVideoUploadBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Source video is too large", nil)
+		return
+	}
+	if resp.ContentLength > 0 && !cfg.checkQuota(w, videoMetadata, resp.ContentLength) {
+		return
+	}
+	expectedSize := cfg.maxVideoUploadBytes
+	if resp.ContentLength > 0 {
+		expectedSize = resp.ContentLength
+	}
+	releaseSpace, ok := cfg.reserveTempSpace(w, expectedSize)
+	if !ok {
+		return
+	}
+	defer releaseSpace()
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !cfg.isAllowedVideoType(mediaType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Source is not an mp4 video", err)
+		return
+	}
+
+	// Prefer the name the source gives the file over the one in its URL
+	filename := filenameFromContentDisposition(resp.Header.Get("Content-Disposition"))
+	if filename == "" {
+		filename = path.Base(resp.Request.URL.Path)
+	}
+	videoMetadata.OriginalFilename = uploadedFilename(filename)
+
+	stored := false
+	var written int64
+	finishUpload := startUpload(uploadTypeVideo)
+	defer func() { finishUpload(stored, written) }()
+
+	tempFile, err := os.CreateTemp(cfg.workDir, "tubely-import.mp4")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create temp file", err)
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	// Read one byte past the cap so an oversized body is detectable even
+	// when the source didn't send a Content-Length
+	written, sourceChecksum, err := copyAndHashWithContext(ctx, tempFile, io.LimitReader(resp.Body, cfg.maxVideoUploadBytes+1))
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't download source video", err)
+		return
+	}
+	if written > cfg.maxVideoUploadBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Source video is too large", nil)
+		return
+	}
+
+	stored = cfg.storeUploadedVideo(w, r, videoMetadata, tempFile.Name(), mediaType, sourceChecksum, storageClass)
+}
+
+// newImportHTTPClient returns a client that refuses to connect to loopback,
+// private and other internal addresses, and only follows https redirects.
+func newImportHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: newPublicTransport(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "https" {
+				return errors.New("redirected to a non-https URL")
+			}
+			return nil
+		},
+	}
+}
+
+// newPublicTransport returns a transport that only connects to public
+// addresses. The check runs on the resolved address at dial time, so it also
+// covers redirects and DNS rebinding.
+func newPublicTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublicIP(ip) {
+				return errDisallowedImportAddress
+			}
+			return nil
+		},
+	}
+	return &http.Transport{
+		Proxy:               nil,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast())
+}
@@ -42,6 +42,7 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
+		auth.Role(user.Role),
 		cfg.jwtSecret,
 		time.Hour*24*30,
 	)
@@ -58,7 +59,7 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 
 	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
 		UserID:    user.ID,
-		Token:     refreshToken,
+		Token:     auth.HashRefreshToken(refreshToken),
 		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
 	})
 	if err != nil {
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// probeRangeBytes is how much of a stored video is fetched for probing.
+// Uploads are processed for fast start, so the moov atom ffprobe needs is at
+// the front of the file.
+const probeRangeBytes = 32 << 20
+
+func (cfg *apiConfig) handlerProbeVideo(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidID, err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithAPIError(w, errVideoNotFound, nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithAPIError(w, errNotOwner, nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusBadRequest, "Video has not been uploaded", nil)
+		return
+	}
+
+	// Serve the cached result unless the caller asks for a fresh probe
+	if video.MediaInfo != nil && r.URL.Query().Get("refresh") != "true" {
+		respondWithJSON(w, http.StatusOK, video.MediaInfo)
+		return
+	}
+
+	key, ok := cfg.objectKeyFromURL(*video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video in storage", nil)
+		return
+	}
+
+	probe, err := cfg.probeStoredObject(r.Context(), key)
+	if r.Context().Err() != nil {
+		return
+	}
+	if errors.Is(err, errNoVideoStream) {
+		respondWithError(w, http.StatusBadRequest, "File has no video stream", err)
+		return
+	}
+	if err != nil {
+		respondWithMediaError(w, "Couldn't probe video", err)
+		return
+	}
+
+	video.MediaInfo = &probe
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, probe)
+}
+
+// probeStoredObject downloads the start of a stored object and probes it.
+func (cfg *apiConfig) probeStoredObject(ctx context.Context, key string) (database.MediaInfo, error) {
+	head, err := cfg.store.Head(ctx, key)
+	if err != nil {
+		return database.MediaInfo{}, err
+	}
+	object, err := cfg.store.GetRange(ctx, key, 0, min(probeRangeBytes, head.Size))
+	if err != nil {
+		return database.MediaInfo{}, err
+	}
+	defer object.Close()
+
+	tempFile, err := os.CreateTemp(cfg.workDir, "tubely-probe.mp4")
+	if err != nil {
+		return database.MediaInfo{}, err
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	_, err = copyWithContext(ctx, tempFile, object)
+	if err != nil {
+		return database.MediaInfo{}, err
+	}
+
+	getMetadata := getVideoMetadata
+	if strings.HasPrefix(key, audioKeyPrefix+"/") {
+		getMetadata = getAudioMetadata
+	}
+	probe, err := getMetadata(ctx, tempFile.Name())
+	if err != nil {
+		return database.MediaInfo{}, err
+	}
+	// ffprobe only saw the downloaded range, so take the size from the store
+	probe.Size = head.Size
+	return probe, nil
+}
@@ -18,14 +18,34 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	storedToken, err := cfg.db.GetRefreshToken(auth.HashRefreshToken(refreshToken))
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up refresh token", err)
+		return
+	}
+	if storedToken.Token == "" {
+		respondWithError(w, http.StatusUnauthorized, "Unknown refresh token", nil)
+		return
+	}
+	err = auth.ValidateRefreshToken(storedToken.ExpiresAt, storedToken.RevokedAt)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token is no longer valid", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(storedToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user for refresh token", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "User no longer exists", nil)
 		return
 	}
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
+		auth.Role(user.Role),
 		cfg.jwtSecret,
 		time.Hour,
 	)
@@ -46,7 +66,7 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.db.RevokeRefreshToken(refreshToken)
+	err = cfg.db.RevokeRefreshToken(auth.HashRefreshToken(refreshToken))
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
 		return
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Resumable uploads send a video as a series of PATCH requests, each
+// appending a chunk at the offset given in the Upload-Offset header. The
+// confirmed offset is stored in the DB, so after a dropped connection the
+// client asks for it and carries on from there. The request that delivers
+// the last byte runs the usual processing pipeline.
+const uploadOffsetHeader = "Upload-Offset"
+
+// uploadSessionParams describe the file an upload session will receive.
+type uploadSessionParams struct {
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	Filename    string `json:"filename"`
+}
+
+// uploadSessionResponse is an upload session as sent to clients, with where
+// its chunks go and when it expires unless another one arrives.
+type uploadSessionResponse struct {
+	database.UploadSession
+	UploadURL string    `json:"upload_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (cfg *apiConfig) uploadSessionResponse(session database.UploadSession) uploadSessionResponse {
+	return uploadSessionResponse{
+		UploadSession: session,
+		UploadURL:     fmt.Sprintf("/api/uploads/%s", session.ID),
+		ExpiresAt:     cfg.uploadSessionExpiry(session),
+	}
+}
+
+// uploadSessionExpiry is when a session is given up on. Every chunk pushes
+// it back, so slow uploads survive as long as they keep going.
+func (cfg *apiConfig) uploadSessionExpiry(session database.UploadSession) time.Time {
+	return session.UpdatedAt.Add(cfg.uploadSessionTTL)
+}
+
+func (cfg *apiConfig) handlerCreateResumableUpload(w http.ResponseWriter, r *http.Request) {
+	videoMetadata, ok := cfg.authorizeVideoUpload(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := uploadSessionParams{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !cfg.checkUploadSessionParams(w, videoMetadata, params) {
+		return
+	}
+	// Chunks are reserved as they arrive, so this only checks there's room
+	releaseSpace, ok := cfg.reserveTempSpace(w, params.Size)
+	if !ok {
+		return
+	}
+	releaseSpace()
+	session, ok := cfg.openUploadSession(w, videoMetadata, params)
+	if !ok {
+		return
+	}
+
+	response := cfg.uploadSessionResponse(session)
+	w.Header().Set("Location", response.UploadURL)
+	w.Header().Set(uploadOffsetHeader, "0")
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+// checkUploadSessionParams makes sure video can take the file params
+// describe. It responds itself and returns false if it can't.
+func (cfg *apiConfig) checkUploadSessionParams(w http.ResponseWriter, video database.Video, params uploadSessionParams) bool {
+	if !cfg.isAllowedVideoType(params.ContentType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Invalid file upload", nil)
+		return false
+	}
+	if params.Size <= 0 || params.Size > cfg.maxVideoUploadBytes {
+		respondWithError(w, http.StatusBadRequest, "Invalid file size", nil)
+		return false
+	}
+	return cfg.checkQuota(w, video, params.Size)
+}
+
+// openUploadSession starts a resumable upload of video's file. It responds
+// itself and returns false if it can't.
+func (cfg *apiConfig) openUploadSession(w http.ResponseWriter, video database.Video, params uploadSessionParams) (database.UploadSession, bool) {
+	file, err := os.CreateTemp(cfg.tempDir, "tubely-resumable-*.mp4")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create temp file", err)
+		return database.UploadSession{}, false
+	}
+	file.Close()
+
+	session, err := cfg.db.CreateUploadSession(database.CreateUploadSessionParams{
+		VideoID:     video.ID,
+		UserID:      video.UserID,
+		Size:        params.Size,
+		ContentType: params.ContentType,
+		Filename:    sanitizeFilename(params.Filename),
+	}, file.Name())
+	if err != nil {
+		os.Remove(file.Name())
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload session", err)
+		return database.UploadSession{}, false
+	}
+	return session, true
+}
+
+func (cfg *apiConfig) handlerGetResumableUpload(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.getOwnedUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+	respondWithJSON(w, http.StatusOK, cfg.uploadSessionResponse(session))
+}
+
+func (cfg *apiConfig) handlerPatchResumableUpload(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.getOwnedUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	lockKey := "upload-session:" + session.ID.String()
+	if !cfg.uploadLocks.TryLock(lockKey) {
+		respondWithError(w, http.StatusConflict, "Another chunk for this upload is in progress", nil)
+		return
+	}
+	defer cfg.uploadLocks.Unlock(lockKey)
+
+	offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Missing or invalid Upload-Offset header", err)
+		return
+	}
+	if offset != session.Offset {
+		w.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Upload is at offset %d", session.Offset), nil)
+		return
+	}
+
+	if session.Offset < session.Size {
+		releaseSpace, ok := cfg.reserveTempSpace(w, min(cfg.expectedUploadSize(r), session.Size-session.Offset))
+		if !ok {
+			return
+		}
+		defer releaseSpace()
+		r.Body = http.MaxBytesReader(w, r.Body, session.Size-session.Offset)
+		written, err := appendUploadChunk(r, session)
+
+		// Whatever reached the disk is kept, even if the chunk was cut short
+		if written > 0 {
+			session.Offset += written
+			if dbErr := cfg.db.UpdateUploadSessionOffset(session.ID, session.Offset); dbErr != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't save upload progress", dbErr)
+				return
+			}
+		}
+		w.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+
+		if r.Context().Err() != nil {
+			return
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Chunk goes past the declared upload size", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't write video data", err)
+			return
+		}
+	}
+
+	if session.Offset < session.Size {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	unlock, ok := cfg.lockVideoUpload(w, session.VideoID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	// All bytes are in: reload the video in case it changed since the session
+	// started, then hand the file to the normal pipeline
+	videoMetadata, err := cfg.db.GetVideo(session.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video metadata", err)
+		return
+	}
+	if videoMetadata.ID == uuid.Nil || videoMetadata.UserID != session.UserID {
+		cfg.discardUploadSession(r.Context(), session)
+		respondWithError(w, http.StatusNotFound, "Video no longer exists", nil)
+		return
+	}
+
+	// Chunks arrive across requests, so the file is hashed once it's whole
+	sourceChecksum, err := hashFile(r.Context(), session.FilePath)
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video data", err)
+		return
+	}
+
+	videoMetadata.OriginalFilename = uploadedFilename(session.Filename)
+	// On failure the session is kept so an empty PATCH can retry processing
+	if cfg.storeUploadedVideo(w, r, videoMetadata, session.FilePath, session.ContentType, sourceChecksum, cfg.s3StorageClass) {
+		cfg.discardUploadSession(r.Context(), session)
+	}
+}
+
+func (cfg *apiConfig) handlerDeleteResumableUpload(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.getOwnedUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	cfg.discardUploadSession(r.Context(), session)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// appendUploadChunk writes the request body to the session's file at the
+// confirmed offset, dropping anything past it left by an interrupted chunk.
+func appendUploadChunk(r *http.Request, session database.UploadSession) (int64, error) {
+	file, err := os.OpenFile(session.FilePath, os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	err = file.Truncate(session.Offset)
+	if err != nil {
+		return 0, err
+	}
+	_, err = file.Seek(session.Offset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	written, copyErr := copyWithContext(r.Context(), file, r.Body)
+	// Only count bytes as confirmed once they're flushed
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+	return written, copyErr
+}
+
+func (cfg *apiConfig) getOwnedUploadSession(w http.ResponseWriter, r *http.Request) (database.UploadSession, bool) {
+	uploadID, err := uuid.Parse(r.PathValue("uploadID"))
+	if err != nil {
+		respondWithAPIError(w, &apiError{http.StatusBadRequest, codeInvalidID, "Invalid upload ID"}, err)
+		return database.UploadSession{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.UploadSession{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.UploadSession{}, false
+	}
+
+	session, err := cfg.db.GetUploadSession(uploadID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload session", err)
+		return database.UploadSession{}, false
+	}
+	if session.ID == uuid.Nil || session.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Upload session not found", nil)
+		return database.UploadSession{}, false
+	}
+	// The sweeper may not have got to it yet
+	if time.Now().After(cfg.uploadSessionExpiry(session)) {
+		cfg.discardUploadSession(r.Context(), session)
+		respondWithError(w, http.StatusGone, "Upload session expired", nil)
+		return database.UploadSession{}, false
+	}
+
+	return session, true
+}
+
+func (cfg *apiConfig) discardUploadSession(ctx context.Context, session database.UploadSession) {
+	if err := os.Remove(session.FilePath); err != nil && !os.IsNotExist(err) {
+		loggerFromContext(ctx).Error("Couldn't remove upload file", "upload_id", session.ID, "path", session.FilePath, "error", err)
+	}
+	if err := cfg.db.DeleteUploadSession(session.ID); err != nil {
+		loggerFromContext(ctx).Error("Couldn't delete upload session", "upload_id", session.ID, "error", err)
+	}
+}
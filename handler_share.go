@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultShareLinkExpiry = 24 * time.Hour
+	maxShareLinkExpiry     = 7 * 24 * time.Hour
+)
+
+// handlerCreateShareLink mints a link anyone can watch the video through
+// until it expires, whatever its visibility. The body is optional and can
+// set expires_in as a duration like "1h". It's served behind
+// requireOwnerOrAdmin.
+func (cfg *apiConfig) handlerCreateShareLink(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresIn string `json:"expires_in"`
+	}
+	type response struct {
+		URL       string    `json:"url"`
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	video := authVideoFromContext(r.Context())
+	if video.ModerationStatus == database.ModerationStatusBlocked {
+		respondWithError(w, http.StatusConflict, "Video has been blocked by moderation", nil)
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	expiry := defaultShareLinkExpiry
+	if params.ExpiresIn != "" {
+		expiry, err = time.ParseDuration(params.ExpiresIn)
+		if err != nil || expiry <= 0 || expiry > maxShareLinkExpiry {
+			respondWithError(w, http.StatusBadRequest, "expires_in must be a duration between 1s and 168h", err)
+			return
+		}
+	}
+
+	expiresAt := time.Now().UTC().Add(expiry).Truncate(time.Second)
+	token := auth.MakeShareToken(video.ID, expiresAt, cfg.jwtSecret)
+	respondWithJSON(w, http.StatusCreated, response{
+		URL:       "/share/" + token,
+		Token:     token,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// handlerShareLink sends whoever holds a valid share token to the video
+// file, presigned if the bucket is private. Links to videos moderation has
+// blocked stop working.
+func (cfg *apiConfig) handlerShareLink(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	videoID, err := auth.ValidateShareToken(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, "Invalid or expired share link", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	// Only the owner and admins can still watch a blocked video
+	if video.ID == uuid.Nil || (video.ModerationStatus == database.ModerationStatusBlocked && !cfg.canViewVideo(r, video)) {
+		respondWithAPIError(w, errVideoNotFound, nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no file yet", nil)
+		return
+	}
+
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
+		return
+	}
+	target := *video.VideoURL
+	if cfg.encryptVideos {
+		// The stream endpoint needs the token too, for private videos
+		target += "?share=" + url.QueryEscape(token)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target, http.StatusFound)
+}
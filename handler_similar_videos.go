@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerSimilarVideos(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidVideoID, err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, role, err := cfg.validateAccessToken(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithAPIError(w, errVideoNotFound, nil)
+		return
+	}
+	if video.UserID != userID && role != auth.RoleAdmin {
+		respondWithAPIError(w, errNotOwner, nil)
+		return
+	}
+	if video.PerceptualHash == nil {
+		respondWithError(w, http.StatusBadRequest, "Video has no perceptual hash", nil)
+		return
+	}
+
+	threshold := defaultSimilarityThreshold
+	if t := r.URL.Query().Get("threshold"); t != "" {
+		threshold, err = strconv.Atoi(t)
+		if err != nil || threshold < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid threshold", err)
+			return
+		}
+	}
+
+	candidates, err := cfg.db.FindSimilarVideos(*video.PerceptualHash, threshold)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find similar videos", err)
+		return
+	}
+
+	// Only admins get to see matches in other users' libraries
+	similar := []database.Video{}
+	for _, candidate := range candidates {
+		if candidate.ID == video.ID {
+			continue
+		}
+		if candidate.UserID != userID && role != auth.RoleAdmin {
+			continue
+		}
+		similar = append(similar, candidate)
+	}
+
+	err = cfg.dbVideosToSignedVideos(r.Context(), similar)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, similar)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// errRangeNotSatisfiable is returned by parseByteRange for ranges that start
+// past the end of the object.
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// handlerVideoStream proxies a video's file from storage, so viewers never
+// get a storage URL and access is checked on every request. Range requests
+// are passed through, letting players seek. ?rendition=720p streams a
+// transcoded rendition instead, and players that can't send an access token
+// can pass a share token as ?share=.
+func (cfg *apiConfig) handlerVideoStream(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidVideoID, err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canStreamVideo(r, video) {
+		respondWithAPIError(w, errVideoNotFound, nil)
+		return
+	}
+
+	objectURL := video.VideoURL
+	if name := r.URL.Query().Get("rendition"); name != "" {
+		rendition, ok := video.Renditions[name]
+		if !ok {
+			respondWithError(w, http.StatusNotFound, "Video has no such rendition", nil)
+			return
+		}
+		objectURL = &rendition
+	}
+	if objectURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no file yet", nil)
+		return
+	}
+	cfg.recordView(r, video)
+	cfg.serveVideoObject(w, r, video, *objectURL, "")
+}
+
+// serveVideoObject sends one of video's stored files, honouring Range and
+// conditional requests. A non-empty filename makes it a download saved
+// under that name.
+func (cfg *apiConfig) serveVideoObject(w http.ResponseWriter, r *http.Request, video database.Video, objectURL, filename string) {
+	key, ok := cfg.objectKeyFromURL(objectURL)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Video isn't in this server's storage", nil)
+		return
+	}
+
+	object, err := cfg.store.Head(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video file is missing", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't read video from storage", err)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%x-%x"`, object.LastModified.UnixNano(), object.Size)
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	if respondNotModified(w, r, etag, object.LastModified) {
+		return
+	}
+
+	offset, length := int64(0), object.Size
+	status := http.StatusOK
+	rangeHeader := r.Header.Get("Range")
+	// A range asked for against an older copy of the file is ignored, so
+	// the client gets the whole new one
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		rangeHeader = ""
+	}
+	if rangeHeader != "" {
+		var partial bool
+		offset, length, partial, err = parseByteRange(rangeHeader, object.Size)
+		if errors.Is(err, errRangeNotSatisfiable) {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", object.Size))
+			respondWithError(w, http.StatusRequestedRangeNotSatisfiable, "Range is past the end of the video", nil)
+			return
+		}
+		if err != nil {
+			// Ranges that can't be parsed, or in units other than bytes, are
+			// ignored and the whole file sent, as RFC 9110 asks
+			offset, length, partial = 0, object.Size, false
+		}
+		if partial {
+			status = http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, object.Size))
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	if filename != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	}
+	if r.Method == http.MethodHead || length == 0 {
+		w.WriteHeader(status)
+		return
+	}
+
+	body, err := cfg.store.GetRange(r.Context(), key, offset, length)
+	if err != nil {
+		w.Header().Del("Content-Range")
+		w.Header().Del("Content-Length")
+		respondWithError(w, http.StatusBadGateway, "Couldn't read video from storage", err)
+		return
+	}
+	defer body.Close()
+
+	w.WriteHeader(status)
+	_, err = io.Copy(w, body)
+	if err != nil && r.Context().Err() == nil {
+		// The status is already sent, so all that's left is to log it
+		loggerFromContext(r.Context()).Error("Couldn't stream video", "video_id", video.ID, "key", key, "error", err)
+	}
+}
+
+// canStreamVideo is canViewVideo, also accepting a share token for the video
+// in the share query parameter unless moderation has blocked it.
+func (cfg *apiConfig) canStreamVideo(r *http.Request, video database.Video) bool {
+	if token := r.URL.Query().Get("share"); token != "" && video.ModerationStatus != database.ModerationStatusBlocked {
+		sharedID, err := auth.ValidateShareToken(token, cfg.jwtSecret)
+		if err == nil && sharedID == video.ID {
+			return true
+		}
+	}
+	return cfg.canViewVideo(r, video)
+}
+
+// parseByteRange reads a Range header for an object of size bytes, returning
+// the offset and length to send and whether that's less than the whole
+// object. Multiple ranges aren't supported, so they get the whole object,
+// which the spec allows.
+func parseByteRange(header string, size int64) (int64, int64, bool, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return 0, 0, false, fmt.Errorf("unsupported range unit in %q", header)
+	}
+	if strings.Contains(spec, ",") {
+		return 0, size, false, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, false, fmt.Errorf("malformed range %q", spec)
+	}
+
+	var start, end int64
+	var err error
+	if first == "" {
+		// A suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, fmt.Errorf("malformed range %q", spec)
+		}
+		if n == 0 {
+			return 0, 0, false, errRangeNotSatisfiable
+		}
+		start = max(size-n, 0)
+		end = size - 1
+	} else {
+		start, err = strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return 0, 0, false, fmt.Errorf("malformed range %q", spec)
+		}
+		end = size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return 0, 0, false, fmt.Errorf("malformed range %q", spec)
+			}
+			end = min(end, size-1)
+		}
+	}
+	if start >= size {
+		return 0, 0, false, errRangeNotSatisfiable
+	}
+
+	length := end - start + 1
+	return start, length, length < size, nil
+}
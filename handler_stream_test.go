@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+func TestServeVideoObjectRanges(t *testing.T) {
+	store, err := storage.NewLocal(t.TempDir(), "http://localhost/assets", []byte("secret"), false)
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	data := []byte("0123456789")
+	_, err = store.Put(context.Background(), "landscape/a.mp4", bytes.NewReader(data), storage.PutOptions{ContentType: "video/mp4", Size: int64(len(data))})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	cfg := &apiConfig{store: store, s3ObjectBaseURL: "http://localhost/assets"}
+
+	tests := []struct {
+		name       string
+		rangeValue string
+		wantStatus int
+		wantBody   string
+	}{
+		{"no range", "", http.StatusOK, "0123456789"},
+		{"byte range", "bytes=2-4", http.StatusPartialContent, "234"},
+		{"suffix range", "bytes=-3", http.StatusPartialContent, "789"},
+		{"malformed range", "bytes=abc", http.StatusOK, "0123456789"},
+		{"backwards range", "bytes=5-2", http.StatusOK, "0123456789"},
+		{"unsupported unit", "items=0-1", http.StatusOK, "0123456789"},
+		{"several ranges", "bytes=0-1,4-5", http.StatusOK, "0123456789"},
+		{"past the end", "bytes=20-", http.StatusRequestedRangeNotSatisfiable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/videos/x/stream", nil)
+			if tt.rangeValue != "" {
+				req.Header.Set("Range", tt.rangeValue)
+			}
+			rec := httptest.NewRecorder()
+			cfg.serveVideoObject(rec, req, database.Video{}, "http://localhost/assets/landscape/a.mp4", "")
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body, tt.wantBody)
+			}
+			if tt.wantStatus == http.StatusOK && rec.Header().Get("Content-Range") != "" {
+				t.Errorf("Content-Range = %q on a full response", rec.Header().Get("Content-Range"))
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+var errNoAudioStream = errors.New("no audio stream found")
+
+// audioKeyPrefix is where audio files are stored, rather than under an
+// aspect ratio directory.
+const audioKeyPrefix = "audio"
+
+// audioExtensions are the extensions audio files are stored with.
+var audioExtensions = map[string]string{
+	"audio/mpeg": "mp3",
+	"audio/mp4":  "m4a",
+}
+
+const (
+	waveformWidth  = 1280
+	waveformHeight = 240
+	waveformColor  = "0x3ea6ff"
+)
+
+// handlerUploadAudio stores an audio file, such as a podcast episode, as a
+// video's file. It takes the same query parameters as handlerUploadVideo,
+// with the file in an "audio" field. Audio has no shape to sort it by and
+// nothing to transcode, so it's stored as uploaded, with a waveform for its
+// preview.
+func (cfg *apiConfig) handlerUploadAudio(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.maxVideoUploadBytes)
+
+	videoMetadata, ok := cfg.authorizeVideoUpload(w, r)
+	if !ok {
+		return
+	}
+	unlock, ok := cfg.lockVideoUpload(w, videoMetadata.ID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	r, finishProgress, ok := cfg.trackUploadProgress(w, r, videoMetadata.UserID)
+	if !ok {
+		return
+	}
+	succeeded := false
+	var size int64
+	finishUpload := startUpload(uploadTypeAudio)
+	defer func() {
+		finishProgress(succeeded)
+		finishUpload(succeeded, size)
+	}()
+
+	audioFile, form, err := fileFormPart(r, "audio")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse audio file", err)
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(audioFile.Header.Get("Content-Type"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
+		return
+	}
+	if !cfg.isAllowedAudioType(mediaType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Invalid file upload", nil)
+		return
+	}
+	videoMetadata.OriginalFilename = uploadedFilename(audioFile.FileName())
+
+	requestedClass := form.Get("storage_class")
+	if requestedClass == "" {
+		requestedClass = r.URL.Query().Get("storage_class")
+	}
+	storageClass, err := cfg.resolveStorageClass(requestedClass)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid storage class", err)
+		return
+	}
+
+	// The request is a little larger than the file, so this errs on the side
+	// of refusing. The exact size is checked once it's been received.
+	if r.ContentLength > 0 && !cfg.checkQuota(w, videoMetadata, r.ContentLength) {
+		return
+	}
+	releaseSpace, ok := cfg.reserveTempSpace(w, cfg.expectedUploadSize(r))
+	if !ok {
+		return
+	}
+	defer releaseSpace()
+
+	tempFile, err := os.CreateTemp(cfg.workDir, "tubely-upload-audio")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create temp file", err)
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	size, sourceChecksum, err := copyAndHashWithContext(r.Context(), tempFile, audioFile)
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't write audio data", err)
+		return
+	}
+
+	succeeded = cfg.storeUploadedAudio(w, r, videoMetadata, tempFile.Name(), mediaType, sourceChecksum, size, storageClass)
+}
+
+// storeUploadedAudio is storeUploadedVideo for audio files. It writes the
+// response either way and reports whether it succeeded.
+func (cfg *apiConfig) storeUploadedAudio(w http.ResponseWriter, r *http.Request, videoMetadata database.Video, tempFilePath, mediaType, sourceChecksum string, size int64, storageClass types.StorageClass) bool {
+	setUploadStage(r.Context(), uploadStageProcessing)
+
+	validateOnly := r.URL.Query().Get("validate") == "true"
+	stored := false
+	if !validateOnly {
+		cfg.setVideoStatus(r.Context(), videoMetadata.ID, database.VideoStatusReceived)
+		defer func() {
+			if !stored {
+				cfg.setVideoStatus(r.Context(), videoMetadata.ID, database.VideoStatusFailed)
+			}
+		}()
+	}
+
+	sniffedType, err := sniffAudioFile(tempFilePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read uploaded file", err)
+		return false
+	}
+	if sniffedType != mediaType {
+		respondWithError(w, http.StatusUnsupportedMediaType, "File contents don't match its Content-Type", fmt.Errorf("%w: got %s, declared %s", errContentMismatch, sniffedType, mediaType))
+		return false
+	}
+	if !cfg.scanUploadedFile(w, r, videoMetadata, tempFilePath) {
+		return false
+	}
+
+	if !validateOnly {
+		cfg.setVideoStatus(r.Context(), videoMetadata.ID, database.VideoStatusProbing)
+	}
+	probe, err := getAudioMetadata(r.Context(), tempFilePath)
+	if r.Context().Err() != nil {
+		return false
+	}
+	if errors.Is(err, errNoAudioStream) {
+		respondWithError(w, http.StatusBadRequest, "File has no audio stream", err)
+		return false
+	}
+	if err != nil {
+		respondWithMediaError(w, "Couldn't read audio, it may be corrupt or not audio", err)
+		return false
+	}
+	if !containerMatches(mediaType, probe.Container) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "File contents don't match its Content-Type", fmt.Errorf("%w: container %s, declared %s", errContentMismatch, probe.Container, mediaType))
+		return false
+	}
+	if !cfg.checkPlanDuration(w, videoMetadata.UserID, probe.Duration) {
+		return false
+	}
+	if validateOnly {
+		respondWithJSON(w, http.StatusOK, probe)
+		return true
+	}
+
+	// Nothing is done to the file, so what's stored is what was uploaded
+	probe.Size = size
+	if !cfg.checkQuota(w, videoMetadata, probe.Size) {
+		return false
+	}
+	key, err := newObjectKey(audioKeyPrefix, audioExtensions[mediaType])
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate random name", err)
+		return false
+	}
+
+	// What the new file replaces is only deleted once the video is saved
+	replaced := videoMetadata
+	clearReplacedOutputs(&videoMetadata)
+
+	setUploadStage(r.Context(), uploadStageStoring)
+	cfg.setVideoStatus(r.Context(), videoMetadata.ID, database.VideoStatusUploading)
+	if !cfg.beginVideoUpload(w, videoMetadata.ID, key) {
+		return false
+	}
+	object, err := cfg.putFile(r.Context(), key, mediaType, storageClass, videoObjectTags(videoMetadata), tempFilePath)
+	if err != nil {
+		cfg.abortVideoUpload(r.Context(), videoMetadata.ID, key)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't upload to S3", err)
+		return false
+	}
+	audioURL := cfg.getObjectURL(key)
+	videoMetadata.VideoURL = &audioURL
+	videoMetadata.VideoChecksum = &object.ChecksumSHA256
+	videoMetadata.VideoEncryption = objectEncryption(object)
+	videoMetadata.SourceChecksum = &sourceChecksum
+	videoMetadata.MediaInfo = &probe
+
+	// The upload still counts if the waveform can't be drawn
+	err = cfg.setWaveform(r.Context(), &videoMetadata, tempFilePath)
+	if err != nil {
+		loggerFromContext(r.Context()).Warn("Couldn't generate waveform", "video_id", videoMetadata.ID, "error", err)
+	}
+
+	err = cfg.finalizeVideoUpload(r, videoMetadata, replaced, key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return false
+	}
+	stored = true
+	cfg.recordVideoUpload(r.Context(), videoMetadata)
+	cfg.notifyWebhooks(r.Context(), videoMetadata.UserID, eventVideoUploaded, videoMetadata)
+	cfg.enqueueTranscription(r.Context(), videoMetadata)
+	cfg.setVideoStatus(r.Context(), videoMetadata.ID, database.VideoStatusReady)
+	videoMetadata.Status = database.VideoStatusReady
+
+	videoMetadata, err = cfg.dbVideoToSignedVideo(r.Context(), videoMetadata)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
+		return false
+	}
+	respondWithJSON(w, http.StatusOK, videoMetadata)
+	return true
+}
+
+// getAudioMetadata is getVideoMetadata for audio files.
+func getAudioMetadata(ctx context.Context, filePath string) (database.MediaInfo, error) {
+	output, err := runFFProbe(ctx, filePath)
+	if err != nil {
+		return database.MediaInfo{}, err
+	}
+	return parseAudioMetadata(output)
+}
+
+// parseAudioMetadata is parseVideoMetadata for audio files, which only have
+// an audio codec, duration, bitrate and container.
+func parseAudioMetadata(output []byte) (database.MediaInfo, error) {
+	data := ffprobeOutput{}
+	if err := json.Unmarshal(output, &data); err != nil {
+		return database.MediaInfo{}, err
+	}
+
+	probe := database.MediaInfo{}
+	for _, stream := range data.Streams {
+		if stream.CodecType == "audio" {
+			probe.AudioCodec = stream.CodecName
+			break
+		}
+	}
+	if probe.AudioCodec == "" {
+		return database.MediaInfo{}, errNoAudioStream
+	}
+	probe.Container = data.Format.FormatName
+	probe.Duration, _ = strconv.ParseFloat(data.Format.Duration, 64)
+	probe.Size, _ = strconv.ParseInt(data.Format.Size, 10, 64)
+	probe.Bitrate, _ = strconv.ParseInt(data.Format.BitRate, 10, 64)
+	return probe, nil
+}
+
+// extractWaveformPNG draws the whole of an audio file's waveform as a PNG.
+func extractWaveformPNG(ctx context.Context, input string) ([]byte, error) {
+	filter := fmt.Sprintf("aformat=channel_layouts=mono,showwavespic=s=%dx%d:colors=%s", waveformWidth, waveformHeight, waveformColor)
+	var out bytes.Buffer
+	err := runMediaCommand(ctx, "ffmpeg_waveform", ffmpegTimeout, &out, "ffmpeg", "-v", "error",
+		"-i", input, "-filter_complex", filter,
+		"-frames:v", "1", "-f", "image2pipe", "-c:v", "png", "pipe:1")
+	if err != nil {
+		return nil, fmt.Errorf("drawing waveform: %w", err)
+	}
+	if out.Len() == 0 {
+		return nil, errors.New("no waveform drawn")
+	}
+	return out.Bytes(), nil
+}
+
+// setWaveform stores the waveform of the audio file at input as the video's
+// preview, and as its thumbnail too if it doesn't have one.
+func (cfg *apiConfig) setWaveform(ctx context.Context, video *database.Video, input string) error {
+	data, err := extractWaveformPNG(ctx, input)
+	if err != nil {
+		return err
+	}
+	waveformURL, err := cfg.storeThumbnail(ctx, *video, "image/png", data)
+	if err != nil {
+		return err
+	}
+	video.PreviewURL = &waveformURL
+
+	if video.ThumbnailURL == nil {
+		var img image.Image
+		img, err = decodeThumbnail(data)
+		if err == nil {
+			_, err = cfg.setThumbnail(ctx, video, img, nil)
+		}
+		if err != nil {
+			loggerFromContext(ctx).Warn("Couldn't generate thumbnail", "video_id", video.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// isAudioVideo reports whether a video's file is audio only, having been
+// uploaded through handlerUploadAudio.
+func isAudioVideo(video database.Video) bool {
+	return video.MediaInfo != nil && video.MediaInfo.Codec == "" && video.MediaInfo.AudioCodec != ""
+}
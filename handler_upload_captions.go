@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxCaptionsUploadBytes = 5 << 20
+
+var (
+	captionLangPattern   = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+	srtTimestampPattern  = regexp.MustCompile(`^\d{2}:\d{2}:\d{2},\d{3} --> \d{2}:\d{2}:\d{2},\d{3}`)
+	webVTTTimingPattern  = regexp.MustCompile(`(\d{2}:)?\d{2}:\d{2}\.\d{3} --> (\d{2}:)?\d{2}:\d{2}\.\d{3}`)
+	errInvalidCaptionFmt = errors.New("captions are not valid WebVTT or SRT")
+)
+
+// ownedCaptionsVideo loads the video in the path for its owner to manage
+// its captions, responding itself and returning false otherwise.
+func (cfg *apiConfig) ownedCaptionsVideo(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidID, err)
+		return database.Video{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, false
+	}
+
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video metadata", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithAPIError(w, errVideoNotFound, nil)
+		return database.Video{}, false
+	}
+	if video.UserID != userID {
+		respondWithAPIError(w, errNotOwner, nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+func (cfg *apiConfig) handlerUploadCaptions(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedCaptionsVideo(w, r)
+	if !ok {
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusBadRequest, "Upload the video before its captions", nil)
+		return
+	}
+	videoKey, ok := cfg.objectKeyFromURL(*video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video in storage", nil)
+		return
+	}
+
+	header, ok := parseUploadForm(w, r, "captions", "Captions files", maxCaptionsUploadBytes)
+	if !ok {
+		return
+	}
+	lang := r.FormValue("lang")
+	if lang == "" {
+		lang = "en"
+	}
+	if !captionLangPattern.MatchString(lang) {
+		respondWithError(w, http.StatusBadRequest, "Invalid language tag", nil)
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read uploaded file", err)
+		return
+	}
+	defer file.Close()
+
+	format, err := captionFormat(header.Header.Get("Content-Type"), header.Filename)
+	if err != nil {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Invalid file type", err)
+		return
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read captions file", err)
+		return
+	}
+
+	vtt, err := normalizeCaptions(data, format)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid captions file", err)
+		return
+	}
+
+	captionsKey := captionsObjectKey(videoKey, lang)
+	err = cfg.putObjectBytes(r.Context(), captionsKey, "text/vtt", videoObjectTags(video), vtt)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't upload to S3", err)
+		return
+	}
+
+	if video.CaptionsURL == nil {
+		video.CaptionsURL = database.URLMap{}
+	}
+	video.CaptionsURL[lang] = cfg.getObjectURL(captionsKey)
+	// An uploaded track replaces a generated one for good
+	video.AutoCaptions = slices.DeleteFunc(video.AutoCaptions, func(l string) bool { return l == lang })
+
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	// The track is saved either way, players just won't be offered it yet
+	if err := cfg.updateHLSSubtitles(r.Context(), video); err != nil {
+		loggerFromContext(r.Context()).Warn("Couldn't add captions to HLS playlist", "video_id", video.ID, "error", err)
+	}
+
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// captionTrack is one of a video's caption tracks as it's listed.
+type captionTrack struct {
+	Lang          string `json:"lang"`
+	URL           string `json:"url"`
+	AutoGenerated bool   `json:"auto_generated"`
+}
+
+// handlerCaptionsList lists a video's caption tracks by language, to
+// anyone who can watch it.
+func (cfg *apiConfig) handlerCaptionsList(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidVideoID, err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canViewVideo(r, video) {
+		respondWithAPIError(w, errVideoNotFound, nil)
+		return
+	}
+
+	tracks := []captionTrack{}
+	for _, lang := range slices.Sorted(maps.Keys(video.CaptionsURL)) {
+		captionsURL, err := cfg.signObjectURL(r.Context(), video.CaptionsURL[lang])
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed captions link", err)
+			return
+		}
+		tracks = append(tracks, captionTrack{
+			Lang:          lang,
+			URL:           captionsURL,
+			AutoGenerated: slices.Contains(video.AutoCaptions, lang),
+		})
+	}
+	respondWithJSON(w, http.StatusOK, tracks)
+}
+
+// handlerCaptionsDelete removes a video's caption track in one language.
+func (cfg *apiConfig) handlerCaptionsDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedCaptionsVideo(w, r)
+	if !ok {
+		return
+	}
+	lang := r.PathValue("lang")
+	captionsURL, ok := video.CaptionsURL[lang]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Video has no captions in that language", nil)
+		return
+	}
+
+	delete(video.CaptionsURL, lang)
+	video.AutoCaptions = slices.DeleteFunc(video.AutoCaptions, func(l string) bool { return l == lang })
+	err := cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	if err := cfg.updateHLSSubtitles(r.Context(), video); err != nil {
+		loggerFromContext(r.Context()).Warn("Couldn't remove captions from HLS playlist", "video_id", video.ID, "error", err)
+	}
+	if key, ok := cfg.objectKeyFromURL(captionsURL); ok {
+		if err := cfg.store.Delete(r.Context(), key); err != nil {
+			loggerFromContext(r.Context()).Error("Couldn't delete captions file", "video_id", video.ID, "key", key, "error", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// captionFormat works out whether an upload is "vtt" or "srt", trusting a
+// specific Content-Type first and falling back to the file extension for the
+// generic types browsers often send.
+func captionFormat(contentType, filename string) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", err
+	}
+	switch mediaType {
+	case "text/vtt":
+		return "vtt", nil
+	case "application/x-subrip", "text/srt":
+		return "srt", nil
+	case "text/plain", "application/octet-stream":
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".vtt":
+			return "vtt", nil
+		case ".srt":
+			return "srt", nil
+		}
+	}
+	return "", errors.New("captions must be .vtt or .srt")
+}
+
+// normalizeCaptions checks the file looks like the declared format and
+// returns it as WebVTT.
+func normalizeCaptions(data []byte, format string) ([]byte, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+
+	switch format {
+	case "vtt":
+		if !bytes.HasPrefix(data, []byte("WEBVTT")) || !webVTTTimingPattern.Match(data) {
+			return nil, errInvalidCaptionFmt
+		}
+		return data, nil
+	case "srt":
+		return srtToVTT(data)
+	}
+	return nil, errInvalidCaptionFmt
+}
+
+func srtToVTT(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteString("WEBVTT\n\n")
+
+	cues := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if srtTimestampPattern.MatchString(line) {
+			// SRT uses a comma before the milliseconds, WebVTT a dot
+			line = strings.ReplaceAll(line, ",", ".")
+			cues++
+		}
+		out.WriteString(line)
+		out.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if cues == 0 {
+		return nil, errInvalidCaptionFmt
+	}
+	return out.Bytes(), nil
+}
@@ -1,15 +1,10 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/base64"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/google/uuid"
@@ -19,7 +14,7 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		respondWithAPIError(w, errInvalidID, err)
 		return
 	}
 
@@ -35,15 +30,20 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
+	loggerFromContext(r.Context()).Debug("Uploading thumbnail", "video_id", videoID, "user_id", userID)
 
-	// TODO: implement the upload here
-	const maxMemory = 10 << 20
-	r.ParseMultipartForm(maxMemory)
+	stored := false
+	var size int64
+	finishUpload := startUpload(uploadTypeThumbnail)
+	defer func() { finishUpload(stored, size) }()
 
-	file, headers, err := r.FormFile("thumbnail")
+	headers, ok := parseUploadForm(w, r, "thumbnail", "Thumbnails", cfg.maxThumbnailBytes)
+	if !ok {
+		return
+	}
+	file, err := headers.Open()
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read uploaded file", err)
 		return
 	}
 	defer file.Close()
@@ -53,54 +53,85 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
 		return
 	}
-	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type", nil)
+	if !cfg.isAllowedImageType(mediaType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Invalid file type", nil)
 		return
 	}
 
 	videoMetadata, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unabel to fetch video metadata", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video metadata", err)
+		return
+	}
+	if videoMetadata.ID == uuid.Nil {
+		respondWithAPIError(w, errVideoNotFound, nil)
 		return
 	}
-
 	if userID != videoMetadata.UserID {
-		respondWithError(w, http.StatusUnauthorized, "Not allowed", err)
+		respondWithAPIError(w, errNotOwner, nil)
 		return
 	}
 
-	fileSize := make([]byte, 32)
-	_, err = rand.Read(fileSize)
+	data, err := io.ReadAll(file)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to create file name", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't read thumbnail", err)
 		return
 	}
 
-	encodedFileName := base64.RawURLEncoding.EncodeToString(fileSize)
-
-	filePath := filepath.Join(cfg.assetsRoot, encodedFileName)
-	fileExtension := strings.Split(mediaType, "/")[1]
+	// The extension comes from the declared type, so make sure it's real
+	if sniffedType := sniffedMediaType(data); sniffedType != mediaType {
+		respondWithError(w, http.StatusUnsupportedMediaType, "File contents don't match its Content-Type", fmt.Errorf("%w: got %s, declared %s", errContentMismatch, sniffedType, mediaType))
+		return
+	}
 
-	out, err := os.Create(filePath + "." + fileExtension)
+	img, err := decodeThumbnail(data)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode thumbnail", err)
+		return
+	}
+	err = checkThumbnailSize(img)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create file", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
 
-	_, err = io.Copy(out, file)
+	// Re-encoding drops the upload's metadata, which can include where the
+	// photo was taken, unless it's been asked for
+	var exif []byte
+	if !cfg.stripImageMetadata {
+		exif = uprightEXIF(data)
+	}
+
+	size = int64(len(data))
+	replaced, err := cfg.setThumbnail(r.Context(), &videoMetadata, img, exif)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't write data", err)
 		return
 	}
 
-	thumbnailURL := fmt.Sprintf("http://localhost:%s/assets/%s.%s", cfg.port, encodedFileName, fileExtension)
-	videoMetadata.ThumbnailURL = &thumbnailURL
-
 	err = cfg.db.UpdateVideo(videoMetadata)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Unable to update video", err)
 		return
 	}
+	stored = true
+	cfg.notifyWebhooks(r.Context(), videoMetadata.UserID, eventThumbnailUpdated, videoMetadata)
+
+	// The thumbnail stays either way, but a video can be blocked by what's
+	// found in it
+	err = cfg.moderateThumbnail(r.Context(), &videoMetadata, img)
+	if err != nil {
+		loggerFromContext(r.Context()).Warn("Couldn't moderate thumbnail", "video_id", videoID, "error", err)
+	}
+
+	// Remove the replaced thumbnail now that the new one is stored
+	cfg.deleteThumbnails(r.Context(), videoID, replaced)
+
+	videoMetadata, err = cfg.dbVideoToSignedVideo(r.Context(), videoMetadata)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
+		return
+	}
 
 	respondWithJSON(w, http.StatusOK, videoMetadata)
 }
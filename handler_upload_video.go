@@ -1,90 +1,113 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
-	"crypto/rand"
-	"encoding/base64"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
 	"io"
+	"math"
 	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"strconv"
 	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 	"github.com/google/uuid"
 )
 
-func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	// Set limit of 1GB on file upload
-	r.Body = http.MaxBytesReader(w, r.Body, 1<<30)
+var errNoVideoStream = errors.New("no video stream found")
 
-	// Get video id from path
-	videoIdString := r.PathValue("videoID")
+// maxFormFieldBytes caps the text fields sent alongside an uploaded file.
+const maxFormFieldBytes = 1 << 10
 
-	// Convert video id from string to uuid
-	videoId, err := uuid.Parse(videoIdString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
+	// Set limit on file upload
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.maxVideoUploadBytes)
+
+	videoMetadata, ok := cfg.authorizeVideoUpload(w, r)
+	if !ok {
 		return
 	}
-
-	// Get jwt token
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+	unlock, ok := cfg.lockVideoUpload(w, videoMetadata.ID)
+	if !ok {
 		return
 	}
+	defer unlock()
 
-	// Validate jwt and get user id from it
-	userId, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	r, finishProgress, ok := cfg.trackUploadProgress(w, r, videoMetadata.UserID)
+	if !ok {
+		return
+	}
+	succeeded := false
+	var size int64
+	finishUpload := startUpload(uploadTypeVideo)
+	defer func() {
+		finishProgress(succeeded)
+		finishUpload(succeeded, size)
+	}()
+
+	// Read the video straight out of the request rather than letting
+	// ParseMultipartForm spool it to disk first
+	videoFile, form, err := videoFormPart(r)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusBadRequest, "Unable to parse video file", err)
 		return
 	}
 
-	// Get metadata of video from db using video id
-	videoMetadata, err := cfg.db.GetVideo(videoId)
+	// Get media type of uploaded video
+	mediaType, _, err := mime.ParseMediaType(videoFile.Header.Get("Content-Type"))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't find video metadata", err)
+		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
 		return
 	}
 
-	// Check if user is owner of the video
-	if videoMetadata.UserID != userId {
-		respondWithError(w, http.StatusUnauthorized, "User not authorized", err)
+	if !cfg.isAllowedVideoType(mediaType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Invalid file upload", nil)
 		return
 	}
+	videoMetadata.OriginalFilename = uploadedFilename(videoFile.FileName())
 
-	// Get the uploaded video info
-	videoFile, header, err := r.FormFile("video")
+	requestedClass := form.Get("storage_class")
+	if requestedClass == "" {
+		requestedClass = r.URL.Query().Get("storage_class")
+	}
+	storageClass, err := cfg.resolveStorageClass(requestedClass)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to parse video file", err)
+		respondWithError(w, http.StatusBadRequest, "Invalid storage class", err)
 		return
 	}
-	defer videoFile.Close()
 
-	// Get media type of uploaded video
-	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+	// Without processing there's nothing to do locally, so the upload goes
+	// straight through to S3. Validation still needs ffprobe.
+	if !cfg.processVideos && r.URL.Query().Get("validate") != "true" {
+		succeeded, size = cfg.streamUploadedVideo(w, r, videoMetadata, videoFile, mediaType, storageClass)
 		return
 	}
 
-	// Get file extension
-	extension := strings.Split(mediaType, "/")[1]
-
-	// Check if mp4 is uploaded
-	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Invalid file upload", err)
+	// As with streamed uploads, the request is a little larger than the file
+	if r.ContentLength > 0 && !cfg.checkQuota(w, videoMetadata, r.ContentLength) {
+		return
+	}
+	releaseSpace, ok := cfg.reserveTempSpace(w, cfg.expectedUploadSize(r))
+	if !ok {
 		return
 	}
+	defer releaseSpace()
 
 	// Create temp file
-	tempFile, err := os.CreateTemp("", "tubely-upload.mp4")
+	tempFile, err := os.CreateTemp(cfg.workDir, "tubely-upload.mp4")
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create temp file", err)
 		return
@@ -92,143 +115,611 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	defer os.Remove(tempFile.Name())
 	defer tempFile.Close()
 
-	// Copy video data into tempfile
-	_, err = io.Copy(tempFile, videoFile)
+	// Copy video data into tempfile, giving up if the client goes away
+	size, sourceChecksum, err := copyAndHashWithContext(r.Context(), tempFile, videoFile)
+	if r.Context().Err() != nil {
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't write video data", err)
 		return
 	}
 
-	// Move pointer to beginning to read again
-	tempFile.Seek(0, io.SeekStart)
+	succeeded = cfg.storeUploadedVideo(w, r, videoMetadata, tempFile.Name(), mediaType, sourceChecksum, storageClass)
+}
+
+// videoFormPart reads a multipart upload up to its "video" file and returns
+// it along with the form fields sent before it. Fields after the file can't
+// be read without buffering it, so they're ignored.
+func videoFormPart(r *http.Request) (*multipart.Part, url.Values, error) {
+	return fileFormPart(r, "video")
+}
+
+// fileFormPart is videoFormPart for a file in the named field.
+func fileFormPart(r *http.Request, field string) (*multipart.Part, url.Values, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+	form := url.Values{}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, nil, http.ErrMissingFile
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if part.FormName() == field {
+			return part, form, nil
+		}
+		value, err := io.ReadAll(io.LimitReader(part, maxFormFieldBytes))
+		if err != nil {
+			return nil, nil, err
+		}
+		form.Add(part.FormName(), string(value))
+	}
+}
+
+// streamUploadedVideo stores an upload in S3 as it's received, without
+// probing or processing it. It writes the response either way and reports
+// whether it succeeded and how many bytes were stored.
+func (cfg *apiConfig) streamUploadedVideo(w http.ResponseWriter, r *http.Request, videoMetadata database.Video, body io.Reader, mediaType string, storageClass types.StorageClass) (bool, int64) {
+	cfg.setVideoStatus(r.Context(), videoMetadata.ID, database.VideoStatusReceived)
+	stored := false
+	defer func() {
+		if !stored {
+			cfg.setVideoStatus(r.Context(), videoMetadata.ID, database.VideoStatusFailed)
+		}
+	}()
+
+	// The request is a little larger than the file, so this errs on the side
+	// of refusing. The exact size is checked once it's stored.
+	if r.ContentLength > 0 && !cfg.checkQuota(w, videoMetadata, r.ContentLength) {
+		return false, 0
+	}
+
+	// Check the magic bytes before anything is sent to S3
+	buffered := bufio.NewReaderSize(storage.NewContextReader(r.Context(), body), sniffLen)
+	header, err := buffered.Peek(sniffLen)
+	if r.Context().Err() != nil {
+		return false, 0
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video data", err)
+		return false, 0
+	}
+	if sniffedType := sniffedMediaType(header); sniffedType != mediaType {
+		respondWithError(w, http.StatusUnsupportedMediaType, "File contents don't match its Content-Type", fmt.Errorf("%w: got %s, declared %s", errContentMismatch, sniffedType, mediaType))
+		return false, 0
+	}
 
-	//Generate random video name
-	videoRandomName := make([]byte, 32)
-	_, err = rand.Read(videoRandomName)
+	// Without ffprobe the shape is unknown, so streamed videos go in "other"
+	extension := strings.Split(mediaType, "/")[1]
+	key, err := newObjectKey(classifyAspect(0, 0), extension)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't generate random name", err)
-		return
+		return false, 0
 	}
 
-	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
-	directory := ""
+	setUploadStage(r.Context(), uploadStageStoring)
+	cfg.setVideoStatus(r.Context(), videoMetadata.ID, database.VideoStatusUploading)
+	if !cfg.beginVideoUpload(w, videoMetadata.ID, key) {
+		return false, 0
+	}
+	h := sha256.New()
+	object, err := cfg.store.Put(r.Context(), key, io.TeeReader(buffered, h), cfg.objectPutOptions(key, storage.PutOptions{
+		ContentType:  mediaType,
+		StorageClass: string(storageClass),
+		Tags:         videoObjectTags(videoMetadata),
+		Size:         -1,
+	}))
+	if err != nil || r.Context().Err() != nil {
+		cfg.abortVideoUpload(r.Context(), videoMetadata.ID, key)
+	}
+	if r.Context().Err() != nil {
+		return false, 0
+	}
+	if errors.Is(err, storage.ErrEmptyObject) {
+		respondWithError(w, http.StatusBadRequest, "Video file is empty", err)
+		return false, 0
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't get aspect ratio", err)
-		return
+		respondWithError(w, http.StatusInternalServerError, "Couldn't upload to S3", err)
+		return false, 0
+	}
+	checksum, size := object.ChecksumSHA256, object.Size
+	sourceChecksum := hex.EncodeToString(h.Sum(nil))
+
+	if !cfg.checkQuota(w, videoMetadata, size) {
+		cfg.abortVideoUpload(r.Context(), videoMetadata.ID, key)
+		return false, 0
+	}
+
+	// What the new file replaces is only deleted once the video is saved
+	replaced := videoMetadata
+	clearReplacedOutputs(&videoMetadata)
+
+	// Duplicates can only be spotted once the whole file has been hashed, so
+	// the new copy is dropped in favour of the earlier one
+	pendingKey := key
+	videoURL := cfg.getObjectURL(key)
+	videoMetadata.VideoURL = &videoURL
+	videoMetadata.VideoChecksum = &checksum
+	videoMetadata.VideoEncryption = objectEncryption(object)
+	if cfg.enableDedupe {
+		duplicate, err := cfg.db.FindVideoBySourceChecksum(videoMetadata.UserID, videoMetadata.ID, sourceChecksum)
+		if err != nil {
+			loggerFromContext(r.Context()).Error("Couldn't look for duplicates", "video_id", videoMetadata.ID, "error", err)
+		} else if duplicate.ID != uuid.Nil {
+			cfg.abortVideoUpload(r.Context(), videoMetadata.ID, key)
+			pendingKey = ""
+			videoMetadata.VideoURL = duplicate.VideoURL
+			videoMetadata.VideoChecksum = duplicate.VideoChecksum
+			videoMetadata.VideoEncryption = duplicate.VideoEncryption
+		}
 	}
 
-	processedVideoPath, err := processVideoForFastStart(tempFile.Name())
+	videoMetadata.SourceChecksum = &sourceChecksum
+	videoMetadata.MediaInfo = &database.MediaInfo{Size: size}
+	err = cfg.finalizeVideoUpload(r, videoMetadata, replaced, pendingKey)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-processed video path", err)
-		return
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return false, 0
 	}
+	stored = true
+	cfg.setVideoStatus(r.Context(), videoMetadata.ID, database.VideoStatusReady)
+	videoMetadata.Status = database.VideoStatusReady
+	cfg.recordVideoUpload(r.Context(), videoMetadata)
+	cfg.notifyWebhooks(r.Context(), videoMetadata.UserID, eventVideoUploaded, videoMetadata)
 
-	processedVideo, err := os.ReadFile(processedVideoPath)
+	videoMetadata, err = cfg.dbVideoToSignedVideo(r.Context(), videoMetadata)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't pre-process video", err)
-		return
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
+		return false, 0
 	}
-	reader := bytes.NewReader(processedVideo)
 
-	switch aspectRatio {
-	case "16:9":
-		directory = "landscape"
-	case "9:16":
-		directory = "portrait"
-	default:
-		directory = "other"
+	respondWithJSON(w, http.StatusOK, videoMetadata)
+	return true, size
+}
+
+// authorizeVideoUpload loads the video named in the path and checks the
+// caller owns it and may (re)place its file. It responds itself and returns
+// false when the upload shouldn't go ahead.
+func (cfg *apiConfig) authorizeVideoUpload(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	// Convert video id from string to uuid
+	videoId, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidID, err)
+		return database.Video{}, false
 	}
 
-	// Encode video name
-	prefix := fmt.Sprintf("%s/", directory)
-	encodedVideoName := prefix + base64.RawURLEncoding.EncodeToString(videoRandomName) + "." + extension
+	// Get jwt token
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, false
+	}
 
-	// Upload to S3
-	_, err = cfg.s3Client.PutObject(r.Context(), &s3.PutObjectInput{
-		Bucket:      &cfg.s3Bucket,
-		Key:         &encodedVideoName,
-		Body:        reader,
-		ContentType: &mediaType,
-	})
+	// Validate jwt and get user id from it
+	userId, err := auth.ValidateJWT(token, cfg.jwtSecret)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't upload to S3", err)
-		return
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, false
 	}
 
-	// Updating Video URL
-	// videoURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", cfg.s3Bucket, cfg.s3Region, encodedVideoName)
-	// videoURL := fmt.Sprintf("%s,%s", cfg.s3Bucket, encodedVideoName)
-	videoURL := fmt.Sprintf("https://%s/%s", cfg.s3CfDistribution, encodedVideoName)
-	videoMetadata.VideoURL = &videoURL
+	// Get metadata of video from db using video id
+	videoMetadata, err := cfg.db.GetVideo(videoId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video metadata", err)
+		return database.Video{}, false
+	}
 
-	err = cfg.db.UpdateVideo(videoMetadata)
+	if videoMetadata.ID == uuid.Nil {
+		respondWithAPIError(w, errVideoNotFound, nil)
+		return database.Video{}, false
+	}
+	// Check if user is owner of the video
+	if videoMetadata.UserID != userId {
+		respondWithAPIError(w, errNotOwner, nil)
+		return database.Video{}, false
+	}
+
+	// Refuse to replace an existing upload unless explicitly asked to
+	validateOnly := r.URL.Query().Get("validate") == "true"
+	overwrite := r.URL.Query().Get("overwrite") == "true"
+	if videoMetadata.VideoURL != nil && !overwrite && !validateOnly {
+		type conflictResponse struct {
+			errorResponse
+			VideoURL string `json:"video_url"`
+		}
+		videoURL, err := cfg.signObjectURL(r.Context(), *videoMetadata.VideoURL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
+			return database.Video{}, false
+		}
+		respondWithJSON(w, http.StatusConflict, conflictResponse{
+			errorResponse: newErrorResponse(w, codeVideoAlreadyUploaded, "Video already uploaded, use ?overwrite=true to replace it"),
+			VideoURL:      videoURL,
+		})
+		return database.Video{}, false
+	}
+
+	// Validation doesn't store anything, so it doesn't count as an upload
+	if !validateOnly && !cfg.checkMonthlyUploads(w, videoMetadata.UserID) {
+		return database.Video{}, false
+	}
+
+	return videoMetadata, true
+}
+
+// lockVideoUpload stops two uploads replacing a video's file at once, where
+// whichever finished last would silently win. It responds itself with a 409
+// and returns false if another upload holds the lock, otherwise the caller
+// must call unlock once it's done.
+func (cfg *apiConfig) lockVideoUpload(w http.ResponseWriter, videoID uuid.UUID) (unlock func(), ok bool) {
+	key := "video-upload:" + videoID.String()
+	if !cfg.uploadLocks.TryLock(key) {
+		respondWithError(w, http.StatusConflict, "Another upload to this video is in progress", nil)
+		return nil, false
+	}
+	return func() { cfg.uploadLocks.Unlock(key) }, true
+}
+
+// storeUploadedVideo runs a video that has been written to a temp file
+// through probing and fast-start processing, uploads it to S3 and records
+// its URL on the video. It writes the response either way and reports
+// whether it succeeded. sourceChecksum is the hex SHA-256 of the file as
+// uploaded.
+func (cfg *apiConfig) storeUploadedVideo(w http.ResponseWriter, r *http.Request, videoMetadata database.Video, tempFilePath, mediaType, sourceChecksum string, storageClass types.StorageClass) bool {
+	setUploadStage(r.Context(), uploadStageProcessing)
+
+	// Validation doesn't store anything, so it leaves the status alone
+	validateOnly := r.URL.Query().Get("validate") == "true"
+	stored := false
+	if !validateOnly {
+		cfg.setVideoStatus(r.Context(), videoMetadata.ID, database.VideoStatusReceived)
+		defer func() {
+			if !stored {
+				cfg.setVideoStatus(r.Context(), videoMetadata.ID, database.VideoStatusFailed)
+			}
+		}()
+	}
+
+	// Get file extension
+	extension := strings.Split(mediaType, "/")[1]
+
+	// Don't trust the declared type; check the file's magic bytes and what
+	// ffprobe makes of the container
+	sniffedType, err := sniffFile(tempFilePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read uploaded file", err)
+		return false
+	}
+	if sniffedType != mediaType {
+		respondWithError(w, http.StatusUnsupportedMediaType, "File contents don't match its Content-Type", fmt.Errorf("%w: got %s, declared %s", errContentMismatch, sniffedType, mediaType))
+		return false
+	}
+
+	// Scanned before ffmpeg ever parses it
+	if !cfg.scanUploadedFile(w, r, videoMetadata, tempFilePath) {
+		return false
+	}
+
+	if !validateOnly {
+		cfg.setVideoStatus(r.Context(), videoMetadata.ID, database.VideoStatusProbing)
+	}
+	probe, err := getVideoMetadata(r.Context(), tempFilePath)
+	if r.Context().Err() != nil {
+		return false
+	}
+	if errors.Is(err, errNoVideoStream) {
+		respondWithError(w, http.StatusBadRequest, "File has no video stream", err)
+		return false
+	}
+	if err != nil {
+		respondWithMediaError(w, "Couldn't read video, it may be corrupt or not a video", err)
+		return false
+	}
+	if !containerMatches(mediaType, probe.Container) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "File contents don't match its Content-Type", fmt.Errorf("%w: container %s, declared %s", errContentMismatch, probe.Container, mediaType))
+		return false
+	}
+	if !cfg.checkPlanDuration(w, videoMetadata.UserID, probe.Duration) {
+		return false
+	}
+
+	// Validation-only mode: report what we detected without storing anything
+	if validateOnly {
+		respondWithJSON(w, http.StatusOK, probe)
+		return true
+	}
+
+	// Perceptual hashing decodes frames, so it's only done when enabled
+	if cfg.enablePerceptualHash {
+		hash, err := perceptualHash(r.Context(), tempFilePath, probe.Duration)
+		if r.Context().Err() != nil {
+			return false
+		}
+		if err != nil {
+			respondWithMediaError(w, "Couldn't hash video frames", err)
+			return false
+		}
+		videoMetadata.PerceptualHash = &hash
+	}
+
+	processedVideoPath, err := processVideoForFastStart(r.Context(), tempFilePath, mediaType)
+	if r.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		respondWithMediaError(w, "Couldn't process video", err)
+		return false
+	}
+	defer os.Remove(processedVideoPath)
+
+	// Quotas count what's stored, which is the processed file
+	processedInfo, err := os.Stat(processedVideoPath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read processed video", err)
+		return false
+	}
+	probe.Size = processedInfo.Size()
+
+	// An identical earlier upload's object is reused rather than stored twice
+	var duplicate database.Video
+	if cfg.enableDedupe {
+		duplicate, err = cfg.db.FindVideoBySourceChecksum(videoMetadata.UserID, videoMetadata.ID, sourceChecksum)
+		if err != nil {
+			loggerFromContext(r.Context()).Error("Couldn't look for duplicates", "video_id", videoMetadata.ID, "error", err)
+			duplicate = database.Video{}
+		}
+	}
+	if duplicate.ID == uuid.Nil && !cfg.checkQuota(w, videoMetadata, probe.Size) {
+		return false
+	}
+
+	directory := classifyAspect(probe.Width, probe.Height)
+
+	// Generate random video name
+	encodedVideoName, err := newObjectKey(directory, extension)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate random name", err)
+		return false
+	}
+
+	// What the new file replaces is only deleted once the video is saved
+	replaced := videoMetadata
+	clearReplacedOutputs(&videoMetadata)
+
+	pendingKey := ""
+	if duplicate.ID != uuid.Nil {
+		videoMetadata.VideoURL = duplicate.VideoURL
+		videoMetadata.VideoChecksum = duplicate.VideoChecksum
+		videoMetadata.VideoEncryption = duplicate.VideoEncryption
+	} else {
+		// Upload to S3 and confirm it arrived intact
+		setUploadStage(r.Context(), uploadStageStoring)
+		cfg.setVideoStatus(r.Context(), videoMetadata.ID, database.VideoStatusUploading)
+		if !cfg.beginVideoUpload(w, videoMetadata.ID, encodedVideoName) {
+			return false
+		}
+		object, err := cfg.putFile(r.Context(), encodedVideoName, mediaType, storageClass, videoObjectTags(videoMetadata), processedVideoPath)
+		if err != nil {
+			cfg.abortVideoUpload(r.Context(), videoMetadata.ID, encodedVideoName)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't upload to S3", err)
+			return false
+		}
+		pendingKey = encodedVideoName
+
+		// Updating Video URL
+		// videoURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", cfg.s3Bucket, cfg.s3Region, encodedVideoName)
+		// videoURL := fmt.Sprintf("%s,%s", cfg.s3Bucket, encodedVideoName)
+		videoURL := cfg.getObjectURL(encodedVideoName)
+		videoMetadata.VideoURL = &videoURL
+		videoMetadata.VideoChecksum = &object.ChecksumSHA256
+		videoMetadata.VideoEncryption = objectEncryption(object)
+	}
+	videoMetadata.SourceChecksum = &sourceChecksum
+	videoMetadata.MediaInfo = &probe
+
+	// Give videos without a thumbnail one taken from the footage. The upload
+	// still counts if this fails.
+	if videoMetadata.ThumbnailURL == nil {
+		data, err := extractFrameJPEG(r.Context(), processedVideoPath, probe.Duration*autoThumbnailPosition)
+		if err == nil {
+			var img image.Image
+			img, err = decodeThumbnail(data)
+			if err == nil {
+				_, err = cfg.setThumbnail(r.Context(), &videoMetadata, img, nil)
+			}
+		}
+		if err != nil {
+			loggerFromContext(r.Context()).Warn("Couldn't generate thumbnail", "video_id", videoMetadata.ID, "error", err)
+		}
+	}
+	if cfg.previewFormat != "" {
+		err = cfg.setPreview(r.Context(), &videoMetadata, processedVideoPath, probe.Duration)
+		if err != nil {
+			loggerFromContext(r.Context()).Warn("Couldn't generate preview", "video_id", videoMetadata.ID, "error", err)
+		}
+	}
+
+	err = cfg.finalizeVideoUpload(r, videoMetadata, replaced, pendingKey)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
-		return
+		return false
+	}
+	stored = true
+	cfg.recordVideoUpload(r.Context(), videoMetadata)
+	cfg.notifyWebhooks(r.Context(), videoMetadata.UserID, eventVideoUploaded, videoMetadata)
+	cfg.enqueueTranscription(r.Context(), videoMetadata)
+	cfg.enqueueModeration(r.Context(), videoMetadata)
+
+	// Renditions are produced in the background; the upload succeeds without them
+	status := database.VideoStatusReady
+	if cfg.transcodeQueue != nil {
+		err = cfg.enqueueTranscode(videoMetadata, encodedVideoName, processedVideoPath)
+		if err != nil {
+			loggerFromContext(r.Context()).Error("Couldn't queue transcoding", "video_id", videoMetadata.ID, "error", err)
+		} else {
+			status = database.VideoStatusTranscoding
+		}
 	}
+	cfg.setVideoStatus(r.Context(), videoMetadata.ID, status)
+	videoMetadata.Status = status
 
 	// Pre-sign video url
-	// videoMetadata, err = cfg.dbVideoToSignedVideo(videoMetadata)
-	// if err != nil {
-	// 	respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
-	// 	return
-	// }
+	videoMetadata, err = cfg.dbVideoToSignedVideo(r.Context(), videoMetadata)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
+		return false
+	}
 
 	respondWithJSON(w, http.StatusOK, videoMetadata)
+	return true
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
-	var out bytes.Buffer
-	type FFProbeOutput struct {
-		Streams []struct {
-			CodecType string `json:"codec_type"`
-			Width     int    `json:"width"`
-			Height    int    `json:"height"`
-		} `json:"streams"`
+// storeVideoFile runs a whole video file that came in some other way than
+// as a request body of its own, such as in a batch or as chunks, through
+// the pipeline handlerUploadVideo would: storeUploadedVideo, or without
+// processing streamUploadedVideo. r is the request that delivered the last
+// of it, whose query and size don't describe the file, so the pipeline
+// sees neither. It responds either way and reports whether it succeeded.
+func (cfg *apiConfig) storeVideoFile(w http.ResponseWriter, r *http.Request, video database.Video, filePath, mediaType, sourceChecksum string, size int64, storageClass types.StorageClass) bool {
+	fileRequest := r.Clone(r.Context())
+	fileRequest.URL.RawQuery = ""
+	fileRequest.ContentLength = size
+	fileRequest.Body = http.NoBody
+
+	if cfg.processVideos {
+		return cfg.storeUploadedVideo(w, fileRequest, video, filePath, mediaType, sourceChecksum, storageClass)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video data", err)
+		return false
 	}
+	defer file.Close()
+	stored, _ := cfg.streamUploadedVideo(w, fileRequest, video, file, mediaType, storageClass)
+	return stored
+}
 
-	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
-	cmd.Stdout = &out
-	cmd.Run()
+// ffprobeOutput is the part of ffprobe's JSON output the API uses.
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType    string `json:"codec_type"`
+		CodecName    string `json:"codec_name"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+		AvgFrameRate string `json:"avg_frame_rate"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		Size       string `json:"size"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+}
 
-	data := FFProbeOutput{}
-	err := json.Unmarshal(out.Bytes(), &data)
+// runFFProbe runs ffprobe over a file, returning its JSON listing of the
+// streams and container.
+func runFFProbe(ctx context.Context, filePath string) ([]byte, error) {
+	var out bytes.Buffer
+	err := runMediaCommand(ctx, "ffprobe", ffprobeTimeout, &out,
+		"ffprobe", "-v", "error", "-print_format", "json", "-show_streams", "-show_format", filePath)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// getVideoMetadata runs ffprobe over a file and collects what the API
+// reports about a video.
+func getVideoMetadata(ctx context.Context, filePath string) (database.MediaInfo, error) {
+	output, err := runFFProbe(ctx, filePath)
+	if err != nil {
+		return database.MediaInfo{}, err
+	}
+	return parseVideoMetadata(output)
+}
+
+// parseVideoMetadata reads a video's dimensions, codecs, duration, bitrate,
+// frame rate and container from ffprobe's JSON output.
+func parseVideoMetadata(output []byte) (database.MediaInfo, error) {
+	data := ffprobeOutput{}
+	if err := json.Unmarshal(output, &data); err != nil {
+		return database.MediaInfo{}, err
 	}
 
-	var width, height int
+	probe := database.MediaInfo{}
+	foundVideo := false
 	for _, stream := range data.Streams {
-		if stream.CodecType == "video" {
-			width = stream.Width
-			height = stream.Height
+		switch {
+		case stream.CodecType == "video" && !foundVideo:
+			probe.Codec = stream.CodecName
+			probe.Width = stream.Width
+			probe.Height = stream.Height
+			probe.FrameRate = parseFrameRate(stream.AvgFrameRate)
+			foundVideo = true
+		case stream.CodecType == "audio" && probe.AudioCodec == "":
+			probe.AudioCodec = stream.CodecName
 		}
 	}
+	// Audio-only files would otherwise be classified from 0x0 dimensions
+	if !foundVideo || probe.Width <= 0 || probe.Height <= 0 {
+		return database.MediaInfo{}, errNoVideoStream
+	}
+	probe.Container = data.Format.FormatName
+	probe.Duration, _ = strconv.ParseFloat(data.Format.Duration, 64)
+	probe.Size, _ = strconv.ParseInt(data.Format.Size, 10, 64)
+	probe.Bitrate, _ = strconv.ParseInt(data.Format.BitRate, 10, 64)
+	probe.AspectRatio = aspectRatioFromDimensions(probe.Width, probe.Height)
 
-	if width == 16*height/9 {
-		return "16:9", nil
-	} else if height == 16*width/9 {
-		return "9:16", nil
+	return probe, nil
+}
+
+// parseFrameRate reads ffprobe's rational frame rates like "30000/1001",
+// returning 0 when it's unknown.
+func parseFrameRate(value string) float64 {
+	num, den, found := strings.Cut(value, "/")
+	numerator, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
 	}
-	return "other", nil
+	if !found {
+		return numerator
+	}
+	denominator, err := strconv.ParseFloat(den, 64)
+	if err != nil || denominator == 0 {
+		return 0
+	}
+	return math.Round(numerator/denominator*1000) / 1000
 }
 
-func processVideoForFastStart(filePath string) (string, error) {
-	outputPath := filePath + ".processing"
-	cmd := exec.Command("ffmpeg", "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputPath)
-	err := cmd.Run()
+func getVideoAspectRatio(ctx context.Context, filePath string) (string, error) {
+	probe, err := getVideoMetadata(ctx, filePath)
 	if err != nil {
 		return "", err
 	}
-	return outputPath, nil
+	return probe.AspectRatio, nil
 }
 
-// func generatePresignedURL(s3Client *s3.Client, bucket, key string, expireTime time.Duration) (string, error) {
-// 	preSignClient := s3.NewPresignClient(s3Client)
-// 	preSignReq, err := preSignClient.PresignGetObject(context.TODO(), &s3.GetObjectInput{Bucket: &bucket, Key: &key}, s3.WithPresignExpires(expireTime))
-// 	if err != nil {
-// 		return "", err
-// 	}
-
-// 	return preSignReq.URL, nil
-// }
+func processVideoForFastStart(ctx context.Context, filePath, mediaType string) (string, error) {
+	outputPath := filePath + ".processing"
+	// Relocating the moov atom to the front lets browsers start playback
+	// before the whole file has downloaded. WebM has no equivalent, so it's
+	// just remuxed.
+	args := []string{"-y", "-v", "error", "-i", filePath, "-c", "copy"}
+	if mediaType == "video/webm" {
+		args = append(args, "-f", "webm", outputPath)
+	} else {
+		args = append(args, "-movflags", "faststart", "-f", "mp4", outputPath)
+	}
+	err := runMediaCommand(ctx, "ffmpeg_faststart", ffmpegTimeout, nil, "ffmpeg", args...)
+	if err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("faststart: %w", err)
+	}
+	return outputPath, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseVideoMetadata(t *testing.T) {
+	output := []byte(`{
+		"streams": [
+			{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080, "avg_frame_rate": "30000/1001"},
+			{"codec_type": "audio", "codec_name": "aac"}
+		],
+		"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "12.500000", "size": "1048576", "bit_rate": "671088"}
+	}`)
+	probe, err := parseVideoMetadata(output)
+	if err != nil {
+		t.Fatalf("parseVideoMetadata: %v", err)
+	}
+	if probe.Width != 1920 || probe.Height != 1080 || probe.AspectRatio != "16:9" {
+		t.Errorf("dimensions = %dx%d %s, want 1920x1080 16:9", probe.Width, probe.Height, probe.AspectRatio)
+	}
+	if probe.Codec != "h264" || probe.AudioCodec != "aac" {
+		t.Errorf("codecs = %q, %q, want h264, aac", probe.Codec, probe.AudioCodec)
+	}
+	if probe.FrameRate != 29.97 || probe.Duration != 12.5 || probe.Size != 1048576 || probe.Bitrate != 671088 {
+		t.Errorf("frame rate, duration, size, bitrate = %v, %v, %d, %d", probe.FrameRate, probe.Duration, probe.Size, probe.Bitrate)
+	}
+}
+
+func TestParseVideoMetadataWithoutVideo(t *testing.T) {
+	tests := map[string]string{
+		"audio only": `{
+			"streams": [{"codec_type": "audio", "codec_name": "aac"}],
+			"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "180.000000"}
+		}`,
+		"video without dimensions": `{
+			"streams": [{"codec_type": "video", "codec_name": "h264"}],
+			"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2"}
+		}`,
+		"no streams": `{"streams": [], "format": {}}`,
+	}
+	for name, output := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseVideoMetadata([]byte(output)); !errors.Is(err, errNoVideoStream) {
+				t.Errorf("error = %v, want errNoVideoStream", err)
+			}
+		})
+	}
+}
+
+func TestParseVideoMetadataMalformed(t *testing.T) {
+	if _, err := parseVideoMetadata([]byte("not json")); err == nil || errors.Is(err, errNoVideoStream) {
+		t.Errorf("error = %v, want a decoding error", err)
+	}
+}
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
@@ -12,6 +19,12 @@ import (
 func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		database.CreateVideoParams
+		// Upload opens an upload session for the video's file straight away
+		Upload *uploadSessionParams `json:"upload"`
+	}
+	type response struct {
+		database.Video
+		UploadSession *uploadSessionResponse `json:"upload_session,omitempty"`
 	}
 
 	token, err := auth.GetBearerToken(r.Header)
@@ -33,123 +46,631 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	params.UserID = userID
+	if params.Visibility != "" && !database.ValidVideoVisibility(params.Visibility) {
+		respondWithError(w, http.StatusBadRequest, "visibility must be public, unlisted or private", nil)
+		return
+	}
 
-	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
+	// Checked up front so a refused upload doesn't leave a video behind
+	if params.Upload != nil {
+		if !cfg.checkMonthlyUploads(w, userID) || !cfg.checkUploadSessionParams(w, database.Video{CreateVideoParams: params.CreateVideoParams}, *params.Upload) {
+			return
+		}
+	}
+
+	// Until its file arrives the video is pending. One created with an
+	// upload session is deleted if that doesn't happen within
+	// UPLOAD_SESSION_TTL, while a draft waits however long it takes.
+	params.ExpireWithoutUpload = params.Upload != nil
+	video, err := cfg.db.CreateVideo(params.CreateVideoParams, database.VideoStatusPending)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, video)
+	resp := response{Video: video}
+	if params.Upload != nil {
+		session, ok := cfg.openUploadSession(w, video, *params.Upload)
+		if !ok {
+			if err := cfg.db.DeleteVideo(video.ID); err != nil {
+				loggerFromContext(r.Context()).Error("Couldn't delete video without upload session", "video_id", video.ID, "error", err)
+			}
+			return
+		}
+		sessionResponse := cfg.uploadSessionResponse(session)
+		resp.UploadSession = &sessionResponse
+		w.Header().Set("Location", sessionResponse.UploadURL)
+	}
+
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+const (
+	maxVideoTitleLength       = 100
+	maxVideoDescriptionLength = 5000
+)
+
+// handlerVideoMetaUpdate changes a video's title, description and
+// visibility. Fields left out of the body are kept. It's served behind
+// requireOwnerOrAdmin.
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		Visibility  *string `json:"visibility"`
+	}
+
+	video := authVideoFromContext(r.Context())
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Title == nil && params.Description == nil && params.Visibility == nil {
+		respondWithError(w, http.StatusBadRequest, "Nothing to update", nil)
+		return
+	}
+
+	if params.Title != nil {
+		video.Title = sanitizeVideoText(*params.Title, false)
+		if video.Title == "" {
+			respondWithError(w, http.StatusBadRequest, "Title can't be empty", nil)
+			return
+		}
+		if utf8.RuneCountInString(video.Title) > maxVideoTitleLength {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Title can be at most %d characters", maxVideoTitleLength), nil)
+			return
+		}
+	}
+	if params.Description != nil {
+		video.Description = sanitizeVideoText(*params.Description, true)
+		if utf8.RuneCountInString(video.Description) > maxVideoDescriptionLength {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Description can be at most %d characters", maxVideoDescriptionLength), nil)
+			return
+		}
+	}
+
+	previousVisibility := video.Visibility
+	if params.Visibility != nil {
+		if !database.ValidVideoVisibility(*params.Visibility) {
+			respondWithError(w, http.StatusBadRequest, "visibility must be public, unlisted or private", nil)
+			return
+		}
+		video.Visibility = *params.Visibility
+	}
+
+	err = cfg.db.UpdateVideoDetails(video.ID, video.Title, video.Description, video.Visibility)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	// The change is saved either way; /admin/retag_objects can catch up
+	if params.Visibility != nil && video.Visibility != previousVisibility {
+		err = cfg.retagVideoObjects(r.Context(), video)
+		if err != nil {
+			loggerFromContext(r.Context()).Error("Couldn't retag video objects", "video_id", video.ID, "error", err)
+		}
+	}
+
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// sanitizeVideoText drops invalid UTF-8 and control characters from s and
+// trims surrounding space. Descriptions keep their newlines and tabs.
+func sanitizeVideoText(s string, multiline bool) string {
+	s = strings.ToValidUTF8(s, "")
+	s = strings.Map(func(r rune) rune {
+		if multiline && (r == '\n' || r == '\t') {
+			return r
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(s)
 }
 
+// handlerVideoMetaDelete moves a video the caller owns to the trash, or
+// deletes it for good with ?permanent=true. It's served behind
+// requireOwnerOrAdminWithTrash, so admins can force-delete anyone's.
 func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
+	video := authVideoFromContext(r.Context())
+	if r.URL.Query().Get("permanent") == "true" {
+		err := cfg.deleteVideo(r.Context(), video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Trashing a video that's already in the trash is a no-op
+	trashed, err := cfg.db.TrashVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't move video to the trash", err)
+		return
+	}
+	if trashed {
+		cfg.notifyWebhooks(r.Context(), video.UserID, eventVideoDeleted, deletedVideoEvent{Video: video})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerVideoRestore takes a video out of the trash. It's served behind
+// requireOwnerOrAdminWithTrash.
+func (cfg *apiConfig) handlerVideoRestore(w http.ResponseWriter, r *http.Request) {
+	video := authVideoFromContext(r.Context())
+	restored, err := cfg.db.RestoreVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video", err)
+		return
+	}
+	if !restored {
+		respondWithError(w, http.StatusConflict, "Video isn't in the trash", nil)
+		return
+	}
+	video.DeletedAt = nil
+
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
 		return
 	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerVideosTrash lists the caller's videos in the trash, taking the same
+// filters as GET /api/videos.
+func (cfg *apiConfig) handlerVideosTrash(w http.ResponseWriter, r *http.Request) {
+	user := authUserFromContext(r.Context())
+	cfg.respondWithVideoPage(w, r, &user.ID, true, false)
+}
+
+// deleteVideo removes a video's files and then its row. The row is kept if
+// the files can't be removed, so the delete can be retried instead of
+// leaking the objects.
+func (cfg *apiConfig) deleteVideo(ctx context.Context, video database.Video) error {
+	logger := loggerFromContext(ctx)
+	keys, err := cfg.videoObjectKeys(ctx, video)
+	if err != nil {
+		return fmt.Errorf("couldn't list video objects: %w", err)
+	}
+	failedKeys, err := cfg.deleteObjects(ctx, keys)
+	if err != nil {
+		return fmt.Errorf("couldn't delete video from S3: %w", err)
+	}
+	if len(failedKeys) > 0 {
+		for key, keyErr := range failedKeys {
+			logger.Error("Couldn't delete S3 object", "key", key, "error", keyErr)
+		}
+		return fmt.Errorf("couldn't delete %d objects from S3", len(failedKeys))
+	}
+
+	err = cfg.db.DeleteVideo(video.ID)
+	if err != nil {
+		return err
+	}
+	cfg.notifyWebhooks(ctx, video.UserID, eventVideoDeleted, deletedVideoEvent{Video: video, Permanent: true})
+	for _, thumbnailURL := range videoThumbnailURLs(video) {
+		if err := cfg.deleteAssetByURL(thumbnailURL); err != nil {
+			logger.Error("Couldn't delete thumbnail", "video_id", video.ID, "url", thumbnailURL, "error", err)
+		}
+	}
+	return nil
+}
+
+const maxBatchDeleteVideos = 1000
+
+// handlerBatchDeleteVideos moves videos to the trash, or deletes them for good
+// with ?permanent=true, and reports the outcome for each ID.
+func (cfg *apiConfig) handlerBatchDeleteVideos(w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Deleted bool   `json:"deleted"`
+		Error   string `json:"error,omitempty"`
+	}
+	type response struct {
+		Results map[string]result `json:"results"`
+	}
 
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, role, err := cfg.validateAccessToken(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	decoder := json.NewDecoder(r.Body)
+	ids := []string{}
+	err = decoder.Decode(&ids)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
+	if len(ids) == 0 {
+		respondWithError(w, http.StatusBadRequest, "No video IDs given", nil)
+		return
+	}
+	if len(ids) > maxBatchDeleteVideos {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Can delete at most %d videos at once", maxBatchDeleteVideos), nil)
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	permanent := r.URL.Query().Get("permanent") == "true"
+	results := map[string]result{}
+	videos := []database.Video{}
+	keys := []string{}
+	keyOwners := map[string]string{}
+	for _, id := range ids {
+		videoID, err := uuid.Parse(id)
+		if err != nil {
+			results[id] = result{Error: "invalid id"}
+			continue
+		}
+		video, err := cfg.db.GetVideoWithDeleted(videoID)
+		if err != nil {
+			results[id] = result{Error: "couldn't get video"}
+			continue
+		}
+		if video.ID == uuid.Nil {
+			results[id] = result{Error: "not found"}
+			continue
+		}
+		if video.UserID != userID && role != auth.RoleAdmin {
+			results[id] = result{Error: "not owner"}
+			continue
+		}
+		if !permanent {
+			trashed, err := cfg.db.TrashVideo(video.ID)
+			if err != nil {
+				loggerFromContext(r.Context()).Error("Couldn't trash video", "video_id", id, "error", err)
+				results[id] = result{Error: "couldn't move to trash"}
+				continue
+			}
+			if trashed {
+				cfg.notifyWebhooks(r.Context(), video.UserID, eventVideoDeleted, deletedVideoEvent{Video: video})
+			}
+			results[id] = result{Deleted: true}
+			continue
+		}
+		videoKeys, err := cfg.videoObjectKeys(r.Context(), video)
+		if err != nil {
+			results[id] = result{Error: "couldn't list video objects"}
+			continue
+		}
+		videos = append(videos, video)
+		for _, key := range videoKeys {
+			keys = append(keys, key)
+			keyOwners[key] = id
+		}
+	}
+
+	failedKeys, err := cfg.deleteObjects(r.Context(), keys)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete videos from S3", err)
 		return
 	}
+	failedIDs := map[string]bool{}
+	for key, keyErr := range failedKeys {
+		loggerFromContext(r.Context()).Error("Couldn't delete S3 object", "key", key, "error", keyErr)
+		failedIDs[keyOwners[key]] = true
+	}
 
-	w.WriteHeader(http.StatusNoContent)
+	for _, video := range videos {
+		id := video.ID.String()
+		// Keep the row if its file is still in S3 so it can be retried
+		if failedIDs[id] {
+			results[id] = result{Error: "couldn't delete from storage"}
+			continue
+		}
+		err = cfg.db.DeleteVideo(video.ID)
+		if err != nil {
+			loggerFromContext(r.Context()).Error("Couldn't delete video", "video_id", id, "error", err)
+			results[id] = result{Error: "couldn't delete video"}
+			continue
+		}
+		for _, thumbnailURL := range videoThumbnailURLs(video) {
+			if err := cfg.deleteAssetByURL(thumbnailURL); err != nil {
+				loggerFromContext(r.Context()).Error("Couldn't delete thumbnail", "video_id", video.ID, "url", thumbnailURL, "error", err)
+			}
+		}
+		cfg.notifyWebhooks(r.Context(), video.UserID, eventVideoDeleted, deletedVideoEvent{Video: video, Permanent: true})
+		results[id] = result{Deleted: true}
+	}
+
+	respondWithJSON(w, http.StatusOK, response{Results: results})
 }
 
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		respondWithAPIError(w, errInvalidVideoID, err)
 		return
 	}
 
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canViewVideo(r, video) {
+		respondWithAPIError(w, errVideoNotFound, nil)
+		return
+	}
+
+	// Presigned links expire, so a cached copy can't be revalidated
+	if !cfg.signsObjectURLs() && respondNotModified(w, r, videoETag(video), video.UpdatedAt) {
 		return
 	}
 
 	// Pre-sign video url
-	// video, err = cfg.dbVideoToSignedVideo(video)
-	// if err != nil {
-	// 	respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
-	// 	return
-	// }
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
+		return
+	}
 
 	respondWithJSON(w, http.StatusOK, video)
 }
 
+// handlerVideoPlaylist redirects players to the video's HLS master playlist.
+func (cfg *apiConfig) handlerVideoPlaylist(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithAPIError(w, errInvalidVideoID, err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canViewVideo(r, video) {
+		respondWithAPIError(w, errVideoNotFound, nil)
+		return
+	}
+	if video.HLSURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no HLS playlist", nil)
+		return
+	}
+
+	cfg.recordView(r, video)
+	http.Redirect(w, r, *video.HLSURL, http.StatusFound)
+}
+
+const (
+	defaultVideoPageSize = 100
+	maxVideoPageSize     = 100
+	maxSearchLength      = 200
+)
+
+// handlerVideosRetrieve lists the caller's videos a page at a time. Another
+// owner's videos, or everyone's with owner=all, can be listed too, though
+// only admins see more than the public ones.
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
+	user := authUserFromContext(r.Context())
+	ownerID, ok := parseVideoOwner(w, r, &user.ID)
+	if !ok {
+		return
+	}
+	publicOnly := (ownerID == nil || *ownerID != user.ID) && user.Role != auth.RoleAdmin
+	cfg.respondWithVideoPage(w, r, ownerID, false, publicOnly)
+}
+
+// handlerVideosSearch finds videos with every word of q in their title or
+// description, best matches first. It covers everyone's videos unless
+// owner says otherwise and, like listings, only admins see more than the
+// public ones of other users.
+func (cfg *apiConfig) handlerVideosSearch(w http.ResponseWriter, r *http.Request) {
+	user := authUserFromContext(r.Context())
+	search := r.URL.Query().Get("q")
+	if strings.TrimSpace(search) == "" {
+		respondWithError(w, http.StatusBadRequest, "q is required", nil)
+		return
+	}
+	if len(search) > maxSearchLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("q can be at most %d characters", maxSearchLength), nil)
+		return
+	}
+	ownerID, ok := parseVideoOwner(w, r, nil)
+	if !ok {
+		return
+	}
+	publicOnly := (ownerID == nil || *ownerID != user.ID) && user.Role != auth.RoleAdmin
+	cfg.respondWithVideoPage(w, r, ownerID, false, publicOnly)
+}
+
+// canViewVideo reports whether the request may see video, which only private
+// videos and those blocked by moderation restrict. The access token is
+// optional, since anyone can view the others.
+func (cfg *apiConfig) canViewVideo(r *http.Request, video database.Video) bool {
+	if video.Visibility != database.VideoVisibilityPrivate && video.ModerationStatus != database.ModerationStatusBlocked {
+		return true
+	}
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
+		return false
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, role, err := cfg.validateAccessToken(token)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return false
+	}
+	return video.UserID == userID || role == auth.RoleAdmin
+}
+
+// parseVideoOwner reads the owner query parameter, which is a user ID or
+// "all". It responds itself and returns false if it's malformed.
+func parseVideoOwner(w http.ResponseWriter, r *http.Request, defaultOwner *uuid.UUID) (*uuid.UUID, bool) {
+	owner := r.URL.Query().Get("owner")
+	switch owner {
+	case "":
+		return defaultOwner, true
+	case "all":
+		return nil, true
+	}
+	id, err := uuid.Parse(owner)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "owner must be a user ID or \"all\"", err)
+		return nil, false
+	}
+	return &id, true
+}
+
+// respondWithVideoPage responds with one page of ownerID's videos, or
+// everyone's if it's nil, filtered and sorted by the query parameters. It
+// lists the trash instead if trashed is set, and only public videos that
+// moderation hasn't blocked if publicOnly is. The total is sent in
+// X-Total-Count and the next page, if any, in a Link header.
+func (cfg *apiConfig) respondWithVideoPage(w http.ResponseWriter, r *http.Request, ownerID *uuid.UUID, trashed, publicOnly bool) {
+	var err error
+	query := r.URL.Query()
+	params := database.ListVideosParams{
+		UserID:     ownerID,
+		Trashed:    trashed,
+		SortBy:     database.VideoSortCreatedAt,
+		Descending: true,
+		Limit:      defaultVideoPageSize,
+	}
+
+	if aspect := query.Get("aspect"); aspect != "" {
+		ratio, ok := aspectRatioForDirectory(aspect)
+		if !ok {
+			respondWithError(w, http.StatusBadRequest, "Unknown aspect", nil)
+			return
+		}
+		params.AspectRatio = ratio
+	}
+	tags, ok := parseTagFilter(w, r)
+	if !ok {
 		return
 	}
+	params.Tags = tags
 
-	videos, err := cfg.db.GetVideos(userID)
+	switch visibility := query.Get("visibility"); {
+	case visibility == "":
+	case !database.ValidVideoVisibility(visibility):
+		respondWithError(w, http.StatusBadRequest, "visibility must be public, unlisted or private", nil)
+		return
+	case publicOnly && visibility != database.VideoVisibilityPublic:
+		respondWithError(w, http.StatusForbidden, "Only public videos of other users can be listed", nil)
+		return
+	default:
+		params.Visibility = visibility
+	}
+	if publicOnly {
+		params.Visibility = database.VideoVisibilityPublic
+		params.HideBlocked = true
+	}
+
+	switch status := query.Get("status"); status {
+	case "", database.UploadStateDraft, database.UploadStateUploading, database.UploadStateReady:
+		params.Status = status
+	default:
+		respondWithError(w, http.StatusBadRequest, "status must be draft, uploading or ready", nil)
+		return
+	}
+	// Nobody else needs to see a video before its file has arrived
+	if publicOnly {
+		if params.Status != "" && params.Status != database.UploadStateReady {
+			respondWithError(w, http.StatusForbidden, "Only other users' ready videos can be listed", nil)
+			return
+		}
+		params.Status = database.UploadStateReady
+	}
+
+	// Searches are ranked by relevance unless sorted otherwise
+	params.Search = query.Get("q")
+	if params.Search != "" {
+		params.SortBy = database.VideoSortRelevance
+	}
+	switch sortBy := query.Get("sort"); sortBy {
+	case "":
+	case database.VideoSortCreatedAt:
+		params.SortBy = sortBy
+	case database.VideoSortTitle:
+		// Titles read naturally A to Z, dates newest first
+		params.SortBy = sortBy
+		params.Descending = false
+	case database.VideoSortRelevance:
+		if params.Search == "" {
+			respondWithError(w, http.StatusBadRequest, "sort=relevance needs a search", nil)
+			return
+		}
+	default:
+		respondWithError(w, http.StatusBadRequest, "sort must be created_at, title or relevance", nil)
+		return
+	}
+	switch order := query.Get("order"); order {
+	case "":
+	case "asc", "desc":
+		params.Descending = order == "desc"
+	default:
+		respondWithError(w, http.StatusBadRequest, "order must be asc or desc", nil)
+		return
+	}
+
+	if value := query.Get("limit"); value != "" {
+		params.Limit, err = strconv.Atoi(value)
+		if err != nil || params.Limit < 1 || params.Limit > maxVideoPageSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxVideoPageSize), err)
+			return
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		params.Offset, err = strconv.Atoi(value)
+		if err != nil || params.Offset < 0 {
+			respondWithError(w, http.StatusBadRequest, "offset must be a non-negative number", err)
+			return
+		}
+	}
+
+	videos, total, err := cfg.db.ListVideos(params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
 
-	// for i, video := range videos {
-	// Pre-sign video url
-	// newVideo, err := cfg.dbVideoToSignedVideo(video)
-	// 	if err != nil {
-	// 		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
-	// 		return
-	// 	}
-	// 	videos[i] = newVideo
-	// }
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if next := params.Offset + len(videos); next < total {
+		nextURL := *r.URL
+		nextQuery := nextURL.Query()
+		nextQuery.Set("offset", strconv.Itoa(next))
+		nextQuery.Set("limit", strconv.Itoa(params.Limit))
+		nextURL.RawQuery = nextQuery.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL.RequestURI()))
+	}
+
+	if !cfg.signsObjectURLs() && respondNotModified(w, r, videosETag(videos), time.Time{}) {
+		return
+	}
+
+	// Pre-sign video urls
+	err = cfg.dbVideosToSignedVideos(r.Context(), videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
+		return
+	}
 
 	respondWithJSON(w, http.StatusOK, videos)
 }
-
-// func (cfg *apiConfig) dbVideoToSignedVideo(video database.Video) (database.Video, error) {
-// 	if video.VideoURL == nil {
-// 		return video, nil
-// 	}
-// 	parts := strings.Split(*video.VideoURL, ",")
-// 	if len(parts) < 2 {
-// 		return video, nil
-// 	}
-// 	bucket := parts[0]
-// 	key := parts[1]
-// 	presigned, err := generatePresignedURL(cfg.s3Client, bucket, key, 5*time.Minute)
-// 	if err != nil {
-// 		return video, err
-// 	}
-// 	video.VideoURL = &presigned
-// 	return video, nil
-// }
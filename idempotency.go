@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+const (
+	// idempotencyKeyTTL is how long a retry with the same key gets the
+	// original response
+	idempotencyKeyTTL       = 24 * time.Hour
+	maxIdempotencyKeyLength = 255
+)
+
+// responseCapture keeps a copy of a response so it can be replayed.
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseCapture) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseCapture) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+func (rec *responseCapture) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// idempotencyMiddleware lets clients retry POSTs safely by sending an
+// Idempotency-Key header. The first successful response for a user's key is
+// stored, and retries within idempotencyKeyTTL get it back instead of the
+// request running again. Failed requests don't use up the key.
+func (cfg *apiConfig) idempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			respondWithError(w, http.StatusBadRequest, "Idempotency-Key is too long", nil)
+			return
+		}
+		// Keys are per user; bad tokens are left for the handler to reject
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		request := r.Method + " " + r.URL.Path
+		record, reserved, err := cfg.db.ReserveIdempotencyKey(userID, key, request, time.Now().Add(-idempotencyKeyTTL))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check Idempotency-Key", err)
+			return
+		}
+		if !reserved {
+			switch {
+			case record.Request != request:
+				respondWithError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request", nil)
+			case record.StatusCode == nil:
+				respondWithError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress", nil)
+			default:
+				if record.ContentType != "" {
+					w.Header().Set("Content-Type", record.ContentType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(*record.StatusCode)
+				w.Write(record.Body)
+			}
+			return
+		}
+
+		rec := &responseCapture{ResponseWriter: w}
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if err := cfg.db.ReleaseIdempotencyKey(userID, key); err != nil {
+				loggerFromContext(r.Context()).Error("Couldn't release Idempotency-Key", "error", err)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status < 200 || rec.status >= 300 {
+			return
+		}
+		err = cfg.db.CompleteIdempotencyKey(userID, key, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes())
+		if err != nil {
+			loggerFromContext(r.Context()).Error("Couldn't store response for Idempotency-Key", "error", err)
+			return
+		}
+		completed = true
+	})
+}
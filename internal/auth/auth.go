@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -20,7 +21,31 @@ const (
 	TokenTypeAccess TokenType = "tubely-access"
 )
 
+type Role string
+
+const (
+	RoleUser Role = "user"
+	// RoleModerator can see and take down anyone's videos
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Valid reports whether r is one of the known roles.
+func (r Role) Valid() bool {
+	switch r {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
+var ErrInvalidRefreshToken = errors.New("refresh token is expired or revoked")
+
+type accessClaims struct {
+	jwt.RegisteredClaims
+	Role Role `json:"role,omitempty"`
+}
 
 func HashPassword(password string) (string, error) {
 	dat, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -36,48 +61,64 @@ func CheckPasswordHash(password, hash string) error {
 
 func MakeJWT(
 	userID uuid.UUID,
+	role Role,
 	tokenSecret string,
 	expiresIn time.Duration,
 ) (string, error) {
 	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		Role: role,
 	})
 	return token.SignedString(signingKey)
 }
 
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+	id, _, err := ValidateJWTWithRole(tokenString, tokenSecret)
+	return id, err
+}
+
+// ValidateJWTWithRole validates an access token and also returns the role it
+// was issued for. Tokens without a role claim are treated as RoleUser.
+func ValidateJWTWithRole(tokenString, tokenSecret string) (uuid.UUID, Role, error) {
+	claimsStruct := accessClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
 		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, "", err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, "", err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, "", err
 	}
 	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+		return uuid.Nil, "", errors.New("invalid issuer")
 	}
 
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+		return uuid.Nil, "", fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	role := claimsStruct.Role
+	if role == "" {
+		role = RoleUser
 	}
-	return id, nil
+	return id, role, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -102,6 +143,51 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(token), nil
 }
 
+// HashRefreshToken is the form refresh tokens are stored in, so a leaked
+// database can't be used to mint access tokens.
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateRefreshToken checks a stored refresh token can still be used.
+func ValidateRefreshToken(expiresAt time.Time, revokedAt *time.Time) error {
+	if revokedAt != nil || !time.Now().Before(expiresAt) {
+		return ErrInvalidRefreshToken
+	}
+	return nil
+}
+
+// apiKeyPrefix marks API keys so they're recognisable in config files and
+// secret scanners.
+const apiKeyPrefix = "tubely_"
+
+// MakeAPIKey returns a new random API key.
+func MakeAPIKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(key), nil
+}
+
+// MakeWebhookSecret returns a random secret for signing a webhook's events.
+func MakeWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
+
+// HashAPIKey is the form API keys are stored and looked up in.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
 func GetAPIKey(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestValidateJWTWithRole(t *testing.T) {
+	tests := []struct {
+		name string
+		role Role
+		want Role
+	}{
+		{"user", RoleUser, RoleUser},
+		{"admin", RoleAdmin, RoleAdmin},
+		{"moderator", RoleModerator, RoleModerator},
+		{"missing role defaults to user", "", RoleUser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := uuid.New()
+			token, err := MakeJWT(userID, tt.role, "secret", time.Hour)
+			if err != nil {
+				t.Fatalf("MakeJWT: %v", err)
+			}
+			gotID, gotRole, err := ValidateJWTWithRole(token, "secret")
+			if err != nil {
+				t.Fatalf("ValidateJWTWithRole: %v", err)
+			}
+			if gotID != userID {
+				t.Errorf("user ID = %s, want %s", gotID, userID)
+			}
+			if gotRole != tt.want {
+				t.Errorf("role = %q, want %q", gotRole, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateJWTWithRoleRejectsBadTokens(t *testing.T) {
+	userID := uuid.New()
+	expired, err := MakeJWT(userID, RoleAdmin, "secret", -time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	valid, err := MakeJWT(userID, RoleAdmin, "secret", time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		secret string
+	}{
+		{"wrong secret", valid, "other"},
+		{"expired", expired, "secret"},
+		{"malformed", "not.a.jwt", "secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ValidateJWTWithRole(tt.token, tt.secret); err == nil {
+				t.Error("ValidateJWTWithRole succeeded, want an error")
+			}
+		})
+	}
+}
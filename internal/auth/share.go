@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidShareToken = errors.New("share token is invalid or expired")
+
+// shareTokenContext keeps share token signatures distinct from anything else
+// signed with the same secret.
+const shareTokenContext = "tubely-share\n"
+
+// MakeShareToken returns a token granting access to one video until
+// expiresAt. It's the video ID and expiry, signed with secret, so nothing
+// needs storing; changing the secret revokes every token.
+func MakeShareToken(videoID uuid.UUID, expiresAt time.Time, secret string) string {
+	payload := make([]byte, 0, len(videoID)+8)
+	payload = append(payload, videoID[:]...)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(expiresAt.Unix()))
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(signShareToken(payload, secret))
+}
+
+// ValidateShareToken returns the video a share token grants access to, or
+// ErrInvalidShareToken if it's been tampered with or has expired.
+func ValidateShareToken(token, secret string) (uuid.UUID, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return uuid.Nil, ErrInvalidShareToken
+	}
+	payload, err := base64.RawURLEncoding.Strict().DecodeString(encodedPayload)
+	if err != nil || len(payload) != len(uuid.UUID{})+8 {
+		return uuid.Nil, ErrInvalidShareToken
+	}
+	signature, err := base64.RawURLEncoding.Strict().DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, signShareToken(payload, secret)) {
+		return uuid.Nil, ErrInvalidShareToken
+	}
+
+	videoID, err := uuid.FromBytes(payload[:16])
+	if err != nil {
+		return uuid.Nil, ErrInvalidShareToken
+	}
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint64(payload[16:])), 0)
+	if !time.Now().Before(expiresAt) {
+		return uuid.Nil, ErrInvalidShareToken
+	}
+	return videoID, nil
+}
+
+func signShareToken(payload []byte, secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(shareTokenContext))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
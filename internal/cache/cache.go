@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache keeps values for a while so they needn't be looked up or computed
+// again. Callers treat errors as misses, since the cache is only ever an
+// optimization.
+type Cache interface {
+	// Get returns a key's value, reporting false if it isn't cached
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	// Clear deletes everything in the cache
+	Clear(ctx context.Context) error
+}
+
+// Redis is a Cache in a Redis server, which servers sharing one database
+// can share too. Keys are namespaced with a prefix so the server can hold
+// other data.
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+// clearBatchSize is how many keys Clear scans for at a time.
+const clearBatchSize = 500
+
+// NewRedis connects to the server at url, a redis:// or rediss:// URL, and
+// checks it's reachable.
+func NewRedis(ctx context.Context, url, prefix string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	err = client.Ping(ctx).Err()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &Redis{client: client, prefix: prefix}, nil
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := r.client.Get(ctx, r.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, r.prefix+key, value, ttl).Err()
+}
+
+func (r *Redis) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = r.prefix + key
+	}
+	return r.client.Del(ctx, prefixed...).Err()
+}
+
+// Clear deletes every key under the prefix, scanning for them rather than
+// flushing the database so that other data is left alone.
+func (r *Redis) Clear(ctx context.Context) error {
+	var cursor uint64
+	for {
+		keys, next, err := r.client.Scan(ctx, cursor, r.prefix+"*", clearBatchSize).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			err = r.client.Del(ctx, keys...).Err()
+			if err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
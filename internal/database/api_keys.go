@@ -0,0 +1,132 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey lets scripts act as a user without logging in. Only the key's hash
+// is stored; the key itself is shown once, when it's created.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	KeyHash    string     `json:"-"`
+	CreateAPIKeyParams
+}
+
+type CreateAPIKeyParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Name   string    `json:"name"`
+}
+
+const apiKeyColumns = `
+		id,
+		created_at,
+		last_used_at,
+		revoked_at,
+		key_hash,
+		user_id,
+		name`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	err := row.Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+		&key.KeyHash,
+		&key.UserID,
+		&key.Name,
+	)
+	return key, err
+}
+
+func (c Client) CreateAPIKey(params CreateAPIKeyParams, keyHash string) (APIKey, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO api_keys (
+		id,
+		created_at,
+		key_hash,
+		user_id,
+		name
+	) VALUES (?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, time.Now().UTC(), keyHash, params.UserID, params.Name)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return scanAPIKey(c.db.QueryRow(`SELECT`+apiKeyColumns+` FROM api_keys WHERE id = ?`, id))
+}
+
+// GetAPIKeyByHash finds the unrevoked key with this hash and records that it
+// was used. The returned key's ID is uuid.Nil when there's none.
+func (c Client) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE key_hash = ? AND revoked_at IS NULL
+	`
+	key, err := scanAPIKey(c.db.QueryRow(query, keyHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+
+	now := time.Now().UTC()
+	_, err = c.db.Exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`, now, key.ID)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key.LastUsedAt = &now
+	return key, nil
+}
+
+func (c Client) GetAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes one of a user's keys, reporting whether it found an
+// unrevoked key to revoke.
+func (c Client) RevokeAPIKey(id, userID uuid.UUID) (bool, error) {
+	query := `
+	UPDATE api_keys
+	SET revoked_at = ?
+	WHERE id = ? AND user_id = ? AND revoked_at IS NULL
+	`
+	result, err := c.db.Exec(query, time.Now().UTC(), id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
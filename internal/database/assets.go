@@ -0,0 +1,48 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Asset is a file in the local assets dir, like a thumbnail, as it was
+// stored.
+type Asset struct {
+	Name        string
+	ContentType string
+	CreatedAt   time.Time
+}
+
+// CreateAsset records an asset's content type. Names are content-versioned,
+// so storing one again changes nothing.
+func (c Client) CreateAsset(name, contentType string) error {
+	query := `
+	INSERT INTO assets (name, content_type, created_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT (name) DO NOTHING
+	`
+	_, err := c.db.Exec(query, name, contentType, time.Now().UTC())
+	return err
+}
+
+// GetAsset returns what was recorded about an asset, with an empty name if
+// nothing was.
+func (c Client) GetAsset(name string) (Asset, error) {
+	query := `
+	SELECT name, content_type, created_at
+	FROM assets
+	WHERE name = ?
+	`
+	var asset Asset
+	err := c.db.QueryRow(query, name).Scan(&asset.Name, &asset.ContentType, &asset.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Asset{}, nil
+	}
+	return asset, err
+}
+
+func (c Client) DeleteAsset(name string) error {
+	_, err := c.db.Exec(`DELETE FROM assets WHERE name = ?`, name)
+	return err
+}
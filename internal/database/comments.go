@@ -0,0 +1,110 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Comment struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateCommentParams
+}
+
+type CreateCommentParams struct {
+	VideoID uuid.UUID `json:"video_id"`
+	UserID  uuid.UUID `json:"user_id"`
+	Body    string    `json:"body"`
+}
+
+const commentColumns = `
+		id,
+		created_at,
+		video_id,
+		user_id,
+		body`
+
+func scanComment(row rowScanner) (Comment, error) {
+	var comment Comment
+	err := row.Scan(
+		&comment.ID,
+		&comment.CreatedAt,
+		&comment.VideoID,
+		&comment.UserID,
+		&comment.Body,
+	)
+	return comment, err
+}
+
+func (c Client) CreateComment(params CreateCommentParams) (Comment, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO comments (
+		id,
+		created_at,
+		video_id,
+		user_id,
+		body
+	) VALUES (?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, time.Now().UTC(), params.VideoID, params.UserID, params.Body)
+	if err != nil {
+		return Comment{}, err
+	}
+	return c.GetComment(id)
+}
+
+// GetComment returns a comment, with a nil ID if there's no such comment.
+func (c Client) GetComment(id uuid.UUID) (Comment, error) {
+	query := `
+	SELECT` + commentColumns + `
+	FROM comments
+	WHERE id = ?
+	`
+	comment, err := scanComment(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Comment{}, nil
+	}
+	return comment, err
+}
+
+// ListComments returns one page of a video's comments, newest first, along
+// with how many it has in total.
+func (c Client) ListComments(videoID uuid.UUID, limit, offset int) ([]Comment, int, error) {
+	var total int
+	err := c.db.QueryRow(`SELECT COUNT(*) FROM comments WHERE video_id = ?`, videoID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+	SELECT` + commentColumns + `
+	FROM comments
+	WHERE video_id = ?
+	ORDER BY created_at DESC, id DESC
+	LIMIT ? OFFSET ?
+	`
+	rows, err := c.db.Query(query, videoID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		comments = append(comments, comment)
+	}
+	return comments, total, rows.Err()
+}
+
+func (c Client) DeleteComment(id uuid.UUID) error {
+	_, err := c.db.Exec(`DELETE FROM comments WHERE id = ?`, id)
+	return err
+}
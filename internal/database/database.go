@@ -1,89 +1,240 @@
 package database
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
+	"time"
 
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cache"
+	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Client struct {
-	db *sql.DB
+	db             sqlDB
+	fullTextSearch bool
+	cache          cache.Cache
+	cacheTTL       time.Duration
 }
 
-func NewClient(pathToDB string) (Client, error) {
-	db, err := sql.Open("sqlite3", pathToDB)
+// Config says which database to connect to and how to pool connections to
+// it. Pool settings left at zero keep database/sql's defaults.
+type Config struct {
+	// Driver is DriverSQLite, the default, or DriverPostgres
+	Driver string
+	// DSN is the database file's path for SQLite and a connection URL for
+	// Postgres
+	DSN             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// Cache, if set, keeps videos fetched by ID for CacheTTL, a minute if 0
+	Cache    cache.Cache
+	CacheTTL time.Duration
+}
+
+// NewClient connects to the database and migrates it.
+func NewClient(cfg Config) (Client, error) {
+	c, err := Open(cfg)
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db}
 	err = c.autoMigrate()
 	if err != nil {
 		return Client{}, err
 	}
 	return c, nil
+}
+
+// Open connects to the database without migrating it, for managing
+// migrations by hand.
+func Open(cfg Config) (Client, error) {
+	d, err := newDialect(cfg.Driver)
+	if err != nil {
+		return Client{}, err
+	}
+	db, err := sql.Open(d.driverName(), cfg.DSN)
+	if err != nil {
+		return Client{}, err
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return Client{}, err
+	}
+	cacheTTL := cfg.CacheTTL
+	if cacheTTL <= 0 {
+		cacheTTL = defaultCacheTTL
+	}
+	return Client{db: sqlDB{DB: db, dialect: d}, cache: cfg.Cache, cacheTTL: cacheTTL}, nil
+}
 
+func (c Client) Close() error {
+	return c.db.Close()
 }
 
+// autoMigrate brings the schema up to date at startup and tidies up data
+// older versions left behind.
 func (c *Client) autoMigrate() error {
-	userTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		password TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL
-	);
-	`
-	_, err := c.db.Exec(userTable)
+	err := c.db.dialect.upgradeLegacySchema(c)
+	if err != nil {
+		return err
+	}
+	_, err = c.MigrateUp()
+	if err != nil {
+		return err
+	}
+	// The search index depends on how SQLite was built, so it's set up
+	// outside the migrations
+	err = c.db.dialect.setUpSearch(c)
 	if err != nil {
 		return err
 	}
-	refreshTokenTable := `
-	CREATE TABLE IF NOT EXISTS refresh_tokens (
-		token TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		revoked_at TIMESTAMP,
-		user_id TEXT NOT NULL,
-		expires_at TIMESTAMP NOT NULL,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(refreshTokenTable)
+	err = c.hashLegacyRefreshTokens()
 	if err != nil {
 		return err
 	}
+	return c.recountStoredBytes()
+}
 
-	videoTable := `
-	CREATE TABLE IF NOT EXISTS videos (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		title TEXT NOT NULL,
-		description TEXT,
-		thumbnail_url TEXT,
-		video_url TEXT TEXT,
-		user_id INTEGER,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(videoTable)
+// hashLegacyRefreshTokens hashes refresh tokens stored before tokens were
+// kept hashed, so they keep working. The hash must match
+// auth.HashRefreshToken.
+func (c *Client) hashLegacyRefreshTokens() error {
+	rows, err := c.db.Query("SELECT token FROM refresh_tokens WHERE hashed = 0")
 	if err != nil {
 		return err
 	}
+	tokens := []string{}
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			rows.Close()
+			return err
+		}
+		tokens = append(tokens, token)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		sum := sha256.Sum256([]byte(token))
+		_, err := c.db.Exec("UPDATE refresh_tokens SET token = ?, hashed = 1 WHERE token = ?", hex.EncodeToString(sum[:]), token)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// ensureColumn adds a column to a table created by an older version of the
+// schema. CREATE TABLE IF NOT EXISTS leaves existing tables untouched, so new
+// columns need to be added explicitly.
+func (c *Client) ensureColumn(table, column, definition string) error {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 func (c Client) Reset() error {
+	if _, err := c.db.Exec("DELETE FROM assets"); err != nil {
+		return fmt.Errorf("failed to reset table assets: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM jobs"); err != nil {
+		return fmt.Errorf("failed to reset table jobs: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM pending_objects"); err != nil {
+		return fmt.Errorf("failed to reset table pending_objects: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM upload_sessions"); err != nil {
+		return fmt.Errorf("failed to reset table upload_sessions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM exports"); err != nil {
+		return fmt.Errorf("failed to reset table exports: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhooks"); err != nil {
+		return fmt.Errorf("failed to reset table webhooks: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_uploads"); err != nil {
+		return fmt.Errorf("failed to reset table video_uploads: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM scan_incidents"); err != nil {
+		return fmt.Errorf("failed to reset table scan_incidents: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM idempotency_keys"); err != nil {
+		return fmt.Errorf("failed to reset table idempotency_keys: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM api_keys"); err != nil {
+		return fmt.Errorf("failed to reset table api_keys: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
 	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM playlist_videos"); err != nil {
+		return fmt.Errorf("failed to reset table playlist_videos: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playlists"); err != nil {
+		return fmt.Errorf("failed to reset table playlists: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM comments"); err != nil {
+		return fmt.Errorf("failed to reset table comments: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_views"); err != nil {
+		return fmt.Errorf("failed to reset table video_views: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_tags"); err != nil {
+		return fmt.Errorf("failed to reset table video_tags: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM tags"); err != nil {
+		return fmt.Errorf("failed to reset table tags: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
+	if c.cache != nil {
+		if err := c.cache.Clear(context.Background()); err != nil {
+			return fmt.Errorf("failed to clear cache: %w", err)
+		}
+	}
 	return nil
 }
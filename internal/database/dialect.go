@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Databases the client can run against.
+const (
+	// DriverSQLite keeps everything in one file, for a single server
+	DriverSQLite = "sqlite"
+	// DriverPostgres can be shared by several servers at once
+	DriverPostgres = "postgres"
+)
+
+// dialect is what differs between the databases the client supports.
+// Queries are written once, with ? placeholders and SQL both understand,
+// and only the pieces that can't be are asked of the dialect.
+type dialect interface {
+	// driverName is the database/sql driver to open
+	driverName() string
+	// migrationsDir holds the dialect's migrations in migrationFiles
+	migrationsDir() string
+	// rebind rewrites a query's ? placeholders into the driver's own
+	rebind(query string) string
+	// jsonText and jsonInt read a key of a JSON object stored as text
+	jsonText(column, key string) string
+	jsonInt(column, key string) string
+	// caseInsensitiveLike is LIKE ignoring case, as it does in SQLite
+	caseInsensitiveLike() string
+	// upgradeLegacySchema brings tables from before there were migrations
+	// up to the first one
+	upgradeLegacySchema(c *Client) error
+	// setUpSearch creates the full-text index, if the database has one
+	setUpSearch(c *Client) error
+	// lockMigrations keeps other servers sharing the database from
+	// migrating it until unlock is called
+	lockMigrations(db *sql.DB) (unlock func(), err error)
+}
+
+func newDialect(driver string) (dialect, error) {
+	switch driver {
+	case DriverSQLite, "":
+		return sqliteDialect{}, nil
+	case DriverPostgres:
+		return postgresDialect{}, nil
+	}
+	return nil, fmt.Errorf("unsupported database driver %q, expected %q or %q", driver, DriverSQLite, DriverPostgres)
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) driverName() string          { return "sqlite3" }
+func (sqliteDialect) migrationsDir() string       { return "migrations/sqlite" }
+func (sqliteDialect) rebind(query string) string  { return query }
+func (sqliteDialect) caseInsensitiveLike() string { return "LIKE" }
+
+func (sqliteDialect) jsonText(column, key string) string {
+	return fmt.Sprintf("json_extract(%s, '$.%s')", column, key)
+}
+
+func (sqliteDialect) jsonInt(column, key string) string {
+	return fmt.Sprintf("json_extract(%s, '$.%s')", column, key)
+}
+
+func (sqliteDialect) upgradeLegacySchema(c *Client) error { return c.upgradeLegacyColumns() }
+func (sqliteDialect) setUpSearch(c *Client) error         { return c.migrateVideoSearch() }
+
+// SQLite locks the whole file for each migration's transaction already
+func (sqliteDialect) lockMigrations(db *sql.DB) (func(), error) { return func() {}, nil }
+
+// postgresDialect has no full-text index yet, so searches use ILIKE.
+type postgresDialect struct{}
+
+func (postgresDialect) driverName() string          { return "pgx" }
+func (postgresDialect) migrationsDir() string       { return "migrations/postgres" }
+func (postgresDialect) caseInsensitiveLike() string { return "ILIKE" }
+
+func (postgresDialect) jsonText(column, key string) string {
+	return fmt.Sprintf("(%s::jsonb ->> '%s')", column, key)
+}
+
+func (postgresDialect) jsonInt(column, key string) string {
+	return fmt.Sprintf("(%s::jsonb ->> '%s')::bigint", column, key)
+}
+
+// Postgres databases all postdate migrations
+func (postgresDialect) upgradeLegacySchema(c *Client) error { return nil }
+func (postgresDialect) setUpSearch(c *Client) error         { return nil }
+
+// postgresMigrationLock is the advisory lock servers take while migrating,
+// so ones starting together don't apply the same migration twice.
+const postgresMigrationLock = 7305122841
+
+// lockMigrations holds the advisory lock on a connection of its own, since
+// it belongs to the session that took it.
+func (postgresDialect) lockMigrations(db *sql.DB) (func(), error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, postgresMigrationLock)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return func() {
+		conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, postgresMigrationLock)
+		conn.Close()
+	}, nil
+}
+
+// rebind numbers placeholders $1, $2 and so on, leaving question marks in
+// string literals, quoted identifiers and comments alone.
+func (postgresDialect) rebind(query string) string {
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	n := 0
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '\'' || ch == '"':
+			end := strings.IndexByte(query[i+1:], ch)
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 1
+		case ch == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+1])
+			i += end
+		case ch == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
+
+// sqlDB runs queries through the dialect's rebind, so callers can keep the
+// rest of *sql.DB's API.
+type sqlDB struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (db sqlDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) Begin() (sqlTx, error) {
+	tx, err := db.DB.Begin()
+	return sqlTx{Tx: tx, dialect: db.dialect}, err
+}
+
+// sqlTx is sqlDB for a transaction.
+type sqlTx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (tx sqlTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+func (tx sqlTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+func (tx sqlTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
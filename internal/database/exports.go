@@ -0,0 +1,190 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Export is a copy of everything a user has stored, made in the background.
+type Export struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Status      string     `json:"status"`
+	Objects     int        `json:"objects"`
+	Bytes       int64      `json:"bytes"`
+	Error       *string    `json:"error"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreateExportParams
+}
+
+type CreateExportParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Format string    `json:"format"`
+}
+
+// Stages an export moves through. Running exports whose server stopped go
+// back to pending.
+const (
+	ExportStatusPending   = "pending"
+	ExportStatusRunning   = "running"
+	ExportStatusCompleted = "completed"
+	ExportStatusFailed    = "failed"
+)
+
+const exportColumns = `
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		format,
+		status,
+		objects,
+		bytes,
+		error,
+		completed_at`
+
+func scanExport(row rowScanner) (Export, error) {
+	var export Export
+	err := row.Scan(
+		&export.ID,
+		&export.CreatedAt,
+		&export.UpdatedAt,
+		&export.UserID,
+		&export.Format,
+		&export.Status,
+		&export.Objects,
+		&export.Bytes,
+		&export.Error,
+		&export.CompletedAt,
+	)
+	return export, err
+}
+
+func (c Client) CreateExport(params CreateExportParams) (Export, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO exports (
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		format,
+		status,
+		objects,
+		bytes
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, 0, 0)
+	`
+	_, err := c.db.Exec(query, id, params.UserID, params.Format, ExportStatusPending)
+	if err != nil {
+		return Export{}, err
+	}
+	return c.GetExport(id)
+}
+
+// GetExport returns an export, with a nil ID if there's no such export.
+func (c Client) GetExport(id uuid.UUID) (Export, error) {
+	query := `
+	SELECT` + exportColumns + `
+	FROM exports
+	WHERE id = ?
+	`
+	export, err := scanExport(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Export{}, nil
+	}
+	return export, err
+}
+
+// GetExports lists a user's exports, newest first.
+func (c Client) GetExports(userID uuid.UUID) ([]Export, error) {
+	return c.queryExports(`
+	SELECT`+exportColumns+`
+	FROM exports
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`, userID)
+}
+
+// GetPendingExports lists the exports waiting to run, oldest first.
+func (c Client) GetPendingExports() ([]Export, error) {
+	return c.queryExports(`
+	SELECT`+exportColumns+`
+	FROM exports
+	WHERE status = ?
+	ORDER BY created_at
+	`, ExportStatusPending)
+}
+
+func (c Client) queryExports(query string, args ...any) ([]Export, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []Export{}
+	for rows.Next() {
+		export, err := scanExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export)
+	}
+	return exports, rows.Err()
+}
+
+// UpdateExport records an export's status and progress.
+func (c Client) UpdateExport(export Export) error {
+	query := `
+	UPDATE exports
+	SET
+		status = ?,
+		objects = ?,
+		bytes = ?,
+		error = ?,
+		completed_at = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, export.Status, export.Objects, export.Bytes, export.Error, export.CompletedAt, export.ID)
+	return err
+}
+
+// ClaimExport starts a pending export, reporting false if it isn't pending
+// any more because another server got to it first.
+func (c Client) ClaimExport(id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE exports
+	SET status = ?, updated_at = ?
+	WHERE id = ? AND status = ?
+	`
+	return c.execAffectsRow(query, ExportStatusRunning, time.Now().UTC(), id, ExportStatusPending)
+}
+
+// TouchExport records that a running export is still going, so it isn't
+// taken for one whose server stopped.
+func (c Client) TouchExport(id uuid.UUID) error {
+	_, err := c.db.Exec(`UPDATE exports SET updated_at = ? WHERE id = ?`, time.Now().UTC(), id)
+	return err
+}
+
+// ResetStaleExports puts running exports that haven't been touched since
+// staleBefore back in the queue, as the server running them has stopped.
+func (c Client) ResetStaleExports(staleBefore time.Time) error {
+	query := `
+	UPDATE exports
+	SET status = ?, objects = 0, bytes = 0, updated_at = ?
+	WHERE status = ? AND updated_at < ?
+	`
+	_, err := c.db.Exec(query, ExportStatusPending, time.Now().UTC(), ExportStatusRunning, staleBefore.UTC())
+	return err
+}
+
+func (c Client) DeleteExport(id uuid.UUID) error {
+	_, err := c.db.Exec(`DELETE FROM exports WHERE id = ?`, id)
+	return err
+}
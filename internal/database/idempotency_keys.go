@@ -0,0 +1,87 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IdempotencyRecord is the outcome of a request made with an Idempotency-Key,
+// kept so a retry can be answered without redoing it. StatusCode is nil
+// while the first request is still running.
+type IdempotencyRecord struct {
+	Key         string
+	UserID      uuid.UUID
+	Request     string
+	CreatedAt   time.Time
+	StatusCode  *int
+	ContentType string
+	Body        []byte
+}
+
+// ReserveIdempotencyKey claims a key for a request, forgetting keys created
+// before expiredBefore first. If the key is already taken, it returns the
+// existing record and false.
+func (c Client) ReserveIdempotencyKey(userID uuid.UUID, key, request string, expiredBefore time.Time) (IdempotencyRecord, bool, error) {
+	_, err := c.db.Exec(`DELETE FROM idempotency_keys WHERE created_at < ?`, expiredBefore.UTC())
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+
+	record := IdempotencyRecord{
+		Key:       key,
+		UserID:    userID,
+		Request:   request,
+		CreatedAt: time.Now().UTC(),
+	}
+	inserted, err := c.execAffectsRow(`
+	INSERT INTO idempotency_keys (
+		user_id,
+		idempotency_key,
+		request,
+		created_at
+	) VALUES (?, ?, ?, ?)
+	ON CONFLICT DO NOTHING
+	`, userID, key, request, record.CreatedAt)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	if inserted {
+		return record, true, nil
+	}
+
+	query := `
+	SELECT request, created_at, status_code, content_type, body
+	FROM idempotency_keys
+	WHERE user_id = ? AND idempotency_key = ?
+	`
+	err = c.db.QueryRow(query, userID, key).Scan(
+		&record.Request,
+		&record.CreatedAt,
+		&record.StatusCode,
+		&record.ContentType,
+		&record.Body,
+	)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	return record, false, nil
+}
+
+// CompleteIdempotencyKey records the response to a reserved key's request.
+func (c Client) CompleteIdempotencyKey(userID uuid.UUID, key string, statusCode int, contentType string, body []byte) error {
+	query := `
+	UPDATE idempotency_keys
+	SET status_code = ?, content_type = ?, body = ?
+	WHERE user_id = ? AND idempotency_key = ?
+	`
+	_, err := c.db.Exec(query, statusCode, contentType, body, userID, key)
+	return err
+}
+
+// ReleaseIdempotencyKey frees a reserved key whose request failed, so it can
+// be retried.
+func (c Client) ReleaseIdempotencyKey(userID uuid.UUID, key string) error {
+	_, err := c.db.Exec(`DELETE FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ?`, userID, key)
+	return err
+}
@@ -0,0 +1,257 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Job is a piece of background work, kept until it succeeds. Payload is the
+// JSON its handler needs.
+type Job struct {
+	ID          uuid.UUID       `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   *string         `json:"last_error"`
+}
+
+type CreateJobParams struct {
+	Kind        string
+	Payload     json.RawMessage
+	MaxAttempts int
+	RunAt       time.Time
+}
+
+// States a job moves through. Failed attempts go back to queued until the
+// job runs out of them, when it's dead and waits for an admin to requeue it.
+// Jobs that succeed are deleted.
+const (
+	JobStatusQueued  = "queued"
+	JobStatusRunning = "running"
+	JobStatusDead    = "dead"
+)
+
+const jobColumns = `
+		id,
+		created_at,
+		updated_at,
+		kind,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		run_at,
+		last_error`
+
+func scanJob(row rowScanner) (Job, error) {
+	var job Job
+	var payload string
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Kind,
+		&payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LastError,
+	)
+	job.Payload = json.RawMessage(payload)
+	return job, err
+}
+
+func (c Client) CreateJob(params CreateJobParams) (Job, error) {
+	now := time.Now().UTC()
+	job := Job{
+		ID:          uuid.New(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Kind:        params.Kind,
+		Payload:     params.Payload,
+		Status:      JobStatusQueued,
+		MaxAttempts: params.MaxAttempts,
+		RunAt:       params.RunAt.UTC(),
+	}
+	query := `
+	INSERT INTO jobs (
+		id,
+		created_at,
+		updated_at,
+		kind,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		run_at
+	) VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?)
+	`
+	_, err := c.db.Exec(query, job.ID, job.CreatedAt, job.UpdatedAt, job.Kind, string(job.Payload), job.Status, job.MaxAttempts, job.RunAt)
+	if err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// GetJob returns a job, with a nil ID if there's no such job.
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE id = ?
+	`
+	job, err := scanJob(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, nil
+	}
+	return job, err
+}
+
+// GetJobs lists up to limit jobs in a status, most recently updated first.
+func (c Client) GetJobs(status string, limit int) ([]Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE status = ?
+	ORDER BY updated_at DESC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// ClaimJob starts the queued job that has been due the longest, counting
+// the attempt, and returns it with a nil ID if none are due. Servers sharing
+// the database can't claim the same job.
+func (c Client) ClaimJob() (Job, error) {
+	query := `
+	SELECT id
+	FROM jobs
+	WHERE status = ? AND run_at <= ?
+	ORDER BY run_at
+	LIMIT 1
+	`
+	// Another server claiming the job first just means trying the next
+	for {
+		var id uuid.UUID
+		now := time.Now().UTC()
+		err := c.db.QueryRow(query, JobStatusQueued, now).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		if err != nil {
+			return Job{}, err
+		}
+		claimed, err := c.execAffectsRow(`
+		UPDATE jobs
+		SET status = ?, attempts = attempts + 1, updated_at = ?
+		WHERE id = ? AND status = ?
+		`, JobStatusRunning, now, id, JobStatusQueued)
+		if err != nil {
+			return Job{}, err
+		}
+		if claimed {
+			return c.GetJob(id)
+		}
+	}
+}
+
+// TouchJob records that a running job is still going, so it isn't taken
+// for one whose server stopped.
+func (c Client) TouchJob(id uuid.UUID) error {
+	_, err := c.db.Exec(`UPDATE jobs SET updated_at = ? WHERE id = ?`, time.Now().UTC(), id)
+	return err
+}
+
+// CompleteJob deletes a job that succeeded.
+func (c Client) CompleteJob(id uuid.UUID) error {
+	_, err := c.db.Exec(`DELETE FROM jobs WHERE id = ?`, id)
+	return err
+}
+
+// RetryJob queues a job whose attempt failed to run again at runAt.
+func (c Client) RetryJob(id uuid.UUID, runAt time.Time, lastError string) error {
+	query := `
+	UPDATE jobs
+	SET status = ?, run_at = ?, last_error = ?, updated_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobStatusQueued, runAt.UTC(), lastError, time.Now().UTC(), id)
+	return err
+}
+
+// ReleaseJob queues a running job again straight away without counting the
+// attempt, for when it was interrupted rather than failed.
+func (c Client) ReleaseJob(id uuid.UUID) error {
+	now := time.Now().UTC()
+	query := `
+	UPDATE jobs
+	SET status = ?, attempts = attempts - 1, run_at = ?, updated_at = ?
+	WHERE id = ? AND status = ?
+	`
+	_, err := c.db.Exec(query, JobStatusQueued, now, now, id, JobStatusRunning)
+	return err
+}
+
+// KillJob moves a job that won't be retried to the dead letters.
+func (c Client) KillJob(id uuid.UUID, lastError string) error {
+	query := `
+	UPDATE jobs
+	SET status = ?, last_error = ?, updated_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobStatusDead, lastError, time.Now().UTC(), id)
+	return err
+}
+
+// RequeueJob gives a dead job a fresh set of attempts, starting now,
+// reporting whether there was such a dead job.
+func (c Client) RequeueJob(id uuid.UUID) (bool, error) {
+	now := time.Now().UTC()
+	query := `
+	UPDATE jobs
+	SET status = ?, attempts = 0, run_at = ?, updated_at = ?
+	WHERE id = ? AND status = ?
+	`
+	return c.execAffectsRow(query, JobStatusQueued, now, now, id, JobStatusDead)
+}
+
+// ResetStaleJobs puts running jobs that haven't been touched since
+// staleBefore back in the queue, as the server running them has stopped.
+// The interrupted attempt still counts, so a job that crashes its server
+// doesn't loop forever.
+func (c Client) ResetStaleJobs(staleBefore time.Time) (int64, error) {
+	query := `
+	UPDATE jobs
+	SET status = ?, updated_at = ?
+	WHERE status = ? AND updated_at < ?
+	`
+	result, err := c.db.Exec(query, JobStatusQueued, time.Now().UTC(), JobStatusRunning, staleBefore.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
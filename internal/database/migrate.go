@@ -0,0 +1,276 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations are numbered .sql files, applied in order and recorded in
+// schema_migrations. Each has an up section and a down section, marked by
+// these lines, and runs in a transaction of its own. Each dialect has its
+// own set, kept to the same versions.
+const (
+	migrateUpMarker   = "-- +migrate up"
+	migrateDownMarker = "-- +migrate down"
+)
+
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// MigrationStatus is a migration and when it was applied, nil if it's
+// pending.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// loadMigrations reads the dialect's embedded migrations, named like
+// 0001_initial.sql, in version order.
+func (c *Client) loadMigrations() ([]migration, error) {
+	dir := c.db.dialect.migrationsDir()
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	migrations := []migration{}
+	seen := map[int]string{}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s doesn't start with a version number", entry.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, entry.Name(), version)
+		}
+		seen[version] = entry.Name()
+
+		data, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		_, rest, ok := strings.Cut(string(data), migrateUpMarker)
+		if !ok {
+			return nil, fmt.Errorf("migration %s has no %q section", entry.Name(), migrateUpMarker)
+		}
+		up, down, ok := strings.Cut(rest, migrateDownMarker)
+		if !ok {
+			return nil, fmt.Errorf("migration %s has no %q section", entry.Name(), migrateDownMarker)
+		}
+		migrations = append(migrations, migration{version: version, name: name, up: up, down: down})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+func (c *Client) ensureMigrationsTable() error {
+	_, err := c.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)
+	`)
+	return err
+}
+
+func (c *Client) appliedMigrations() (map[int]time.Time, error) {
+	err := c.ensureMigrationsTable()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// MigrateUp applies every pending migration in order, returning the names
+// of the ones it applied. It stops at the first that fails, which is rolled
+// back.
+func (c *Client) MigrateUp() ([]string, error) {
+	migrations, err := c.loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := c.db.dialect.lockMigrations(c.db.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		err := c.inTransaction(func(tx sqlTx) error {
+			if _, err := tx.Exec(m.up); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`, m.version, m.name, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return names, fmt.Errorf("migration %s: %w", m.name, err)
+		}
+		names = append(names, m.name)
+	}
+	return names, nil
+}
+
+// MigrateDown rolls back the latest steps applied migrations, newest first,
+// returning the names of the ones it rolled back.
+func (c *Client) MigrateDown(steps int) ([]string, error) {
+	migrations, err := c.loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := c.db.dialect.lockMigrations(c.db.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for i := len(migrations) - 1; i >= 0 && len(names) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.version]; !ok {
+			continue
+		}
+		err := c.inTransaction(func(tx sqlTx) error {
+			if _, err := tx.Exec(m.down); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, m.version)
+			return err
+		})
+		if err != nil {
+			return names, fmt.Errorf("migration %s: %w", m.name, err)
+		}
+		names = append(names, m.name)
+	}
+	return names, nil
+}
+
+// MigrationStatus lists every migration in order and whether it's applied.
+func (c *Client) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := c.loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.version, Name: m.name}
+		if appliedAt, ok := applied[m.version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (c *Client) inTransaction(fn func(tx sqlTx) error) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// upgradeLegacyColumns adds the columns that were added to tables before
+// there were migrations, since CREATE TABLE IF NOT EXISTS leaves tables that
+// predate them untouched. Migrations can then rely on the full schema.
+func (c *Client) upgradeLegacyColumns() error {
+	legacyColumns := []struct{ table, name, definition string }{
+		{"users", "role", "TEXT NOT NULL DEFAULT 'user'"},
+		{"users", "stored_bytes", "INTEGER NOT NULL DEFAULT 0"},
+		{"users", "quota_bytes", "INTEGER"},
+		{"users", "plan", "TEXT NOT NULL DEFAULT 'free'"},
+		{"refresh_tokens", "hashed", "INTEGER NOT NULL DEFAULT 0"},
+		{"videos", "captions_url", "TEXT"},
+		{"videos", "video_checksum", "TEXT"},
+		{"videos", "media_info", "TEXT"},
+		{"videos", "perceptual_hash", "TEXT"},
+		{"videos", "pending_upload_key", "TEXT"},
+		{"videos", "renditions", "TEXT"},
+		{"videos", "hls_url", "TEXT"},
+		{"videos", "source_checksum", "TEXT"},
+		{"videos", "deleted_at", "TIMESTAMP"},
+		{"videos", "status", "TEXT NOT NULL DEFAULT ''"},
+		{"videos", "visibility", "TEXT NOT NULL DEFAULT 'public'"},
+		{"videos", "original_filename", "TEXT"},
+		{"videos", "thumbnail_small_url", "TEXT"},
+		{"videos", "preview_url", "TEXT"},
+		{"videos", "sprites_vtt_url", "TEXT"},
+		{"videos", "video_encryption", "TEXT"},
+		{"upload_sessions", "filename", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, col := range legacyColumns {
+		exists, err := c.tableExists(col.table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		err = c.ensureColumn(col.table, col.name, col.definition)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) tableExists(table string) (bool, error) {
+	var name string
+	err := c.db.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,128 @@
+-- The schema as it stood before migrations, in Postgres' types. IDs stay
+-- text, as they're written and compared as strings. There are no foreign
+-- keys, since SQLite never enforced its own and rows are deleted in any
+-- order.
+
+-- +migrate up
+CREATE TABLE users (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL,
+	role TEXT NOT NULL DEFAULT 'user',
+	stored_bytes BIGINT NOT NULL DEFAULT 0,
+	quota_bytes BIGINT,
+	plan TEXT NOT NULL DEFAULT 'free'
+);
+
+CREATE TABLE refresh_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMPTZ,
+	user_id TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	hashed INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE videos (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT,
+	video_checksum TEXT,
+	captions_url TEXT,
+	media_info TEXT,
+	perceptual_hash TEXT,
+	pending_upload_key TEXT,
+	renditions TEXT,
+	hls_url TEXT,
+	source_checksum TEXT,
+	deleted_at TIMESTAMPTZ,
+	status TEXT NOT NULL DEFAULT '',
+	visibility TEXT NOT NULL DEFAULT 'public',
+	original_filename TEXT,
+	thumbnail_small_url TEXT,
+	preview_url TEXT,
+	sprites_vtt_url TEXT,
+	video_encryption TEXT,
+	user_id TEXT
+);
+CREATE INDEX idx_videos_user ON videos(user_id, created_at);
+-- Listings of other users' videos only include public ones
+CREATE INDEX idx_videos_visibility ON videos(visibility, created_at);
+
+CREATE TABLE upload_sessions (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	size BIGINT NOT NULL,
+	upload_offset BIGINT NOT NULL DEFAULT 0,
+	content_type TEXT NOT NULL,
+	file_path TEXT NOT NULL,
+	filename TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE api_keys (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	last_used_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ,
+	key_hash TEXT NOT NULL UNIQUE,
+	user_id TEXT NOT NULL,
+	name TEXT NOT NULL
+);
+
+CREATE TABLE idempotency_keys (
+	user_id TEXT NOT NULL,
+	idempotency_key TEXT NOT NULL,
+	request TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	status_code INTEGER,
+	content_type TEXT NOT NULL DEFAULT '',
+	body BYTEA,
+	PRIMARY KEY(user_id, idempotency_key)
+);
+CREATE INDEX idx_idempotency_keys_created_at ON idempotency_keys(created_at);
+
+CREATE TABLE scan_incidents (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL,
+	user_id TEXT NOT NULL,
+	video_id TEXT NOT NULL,
+	signature TEXT NOT NULL
+);
+
+CREATE TABLE webhooks (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL,
+	secret TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	url TEXT NOT NULL,
+	events TEXT NOT NULL
+);
+
+CREATE TABLE video_uploads (
+	id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL,
+	user_id TEXT NOT NULL,
+	video_id TEXT NOT NULL
+);
+CREATE INDEX idx_video_uploads_user ON video_uploads(user_id, created_at);
+
+-- +migrate down
+DROP TABLE IF EXISTS video_uploads;
+DROP TABLE IF EXISTS webhooks;
+DROP TABLE IF EXISTS scan_incidents;
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS upload_sessions;
+DROP TABLE IF EXISTS videos;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,17 @@
+-- +migrate up
+CREATE TABLE exports (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL,
+	user_id TEXT NOT NULL,
+	format TEXT NOT NULL,
+	status TEXT NOT NULL,
+	objects INTEGER NOT NULL DEFAULT 0,
+	bytes BIGINT NOT NULL DEFAULT 0,
+	error TEXT,
+	completed_at TIMESTAMPTZ
+);
+CREATE INDEX idx_exports_user ON exports(user_id, created_at);
+
+-- +migrate down
+DROP TABLE IF EXISTS exports;
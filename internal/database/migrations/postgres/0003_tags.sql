@@ -0,0 +1,16 @@
+-- +migrate up
+CREATE TABLE tags (
+	id TEXT PRIMARY KEY,
+	name TEXT UNIQUE NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+CREATE TABLE video_tags (
+	video_id TEXT NOT NULL,
+	tag_id TEXT NOT NULL,
+	PRIMARY KEY(video_id, tag_id)
+);
+CREATE INDEX idx_video_tags_tag ON video_tags(tag_id);
+
+-- +migrate down
+DROP TABLE IF EXISTS video_tags;
+DROP TABLE IF EXISTS tags;
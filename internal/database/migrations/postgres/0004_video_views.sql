@@ -0,0 +1,12 @@
+-- Views are kept per day rather than one row per view
+
+-- +migrate up
+CREATE TABLE video_views (
+	video_id TEXT NOT NULL,
+	day TEXT NOT NULL,
+	views BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY(video_id, day)
+);
+
+-- +migrate down
+DROP TABLE IF EXISTS video_views;
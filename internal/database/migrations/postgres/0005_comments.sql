@@ -0,0 +1,12 @@
+-- +migrate up
+CREATE TABLE comments (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL,
+	video_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	body TEXT NOT NULL
+);
+CREATE INDEX idx_comments_video ON comments(video_id, created_at);
+
+-- +migrate down
+DROP TABLE IF EXISTS comments;
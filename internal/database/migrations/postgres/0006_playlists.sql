@@ -0,0 +1,22 @@
+-- +migrate up
+CREATE TABLE playlists (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL,
+	user_id TEXT NOT NULL,
+	title TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	visibility TEXT NOT NULL DEFAULT 'public'
+);
+CREATE INDEX idx_playlists_user ON playlists(user_id, created_at);
+CREATE TABLE playlist_videos (
+	playlist_id TEXT NOT NULL,
+	video_id TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	PRIMARY KEY(playlist_id, video_id)
+);
+CREATE INDEX idx_playlist_videos_video ON playlist_videos(video_id);
+
+-- +migrate down
+DROP TABLE IF EXISTS playlist_videos;
+DROP TABLE IF EXISTS playlists;
@@ -0,0 +1,13 @@
+-- Objects being uploaded for a video that it doesn't point at yet, so ones
+-- whose upload never finished can be deleted
+
+-- +migrate up
+CREATE TABLE pending_objects (
+	object_key TEXT PRIMARY KEY,
+	video_id TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_pending_objects_created_at ON pending_objects(created_at);
+
+-- +migrate down
+DROP TABLE IF EXISTS pending_objects;
@@ -0,0 +1,19 @@
+-- Background jobs, kept until they succeed or run out of attempts
+
+-- +migrate up
+CREATE TABLE jobs (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL,
+	kind TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	run_at TIMESTAMPTZ NOT NULL,
+	last_error TEXT
+);
+CREATE INDEX idx_jobs_status_run_at ON jobs(status, run_at);
+
+-- +migrate down
+DROP TABLE IF EXISTS jobs;
@@ -0,0 +1,9 @@
+-- What content moderation made of a video's thumbnail and frames
+
+-- +migrate up
+ALTER TABLE videos ADD COLUMN moderation_status TEXT NOT NULL DEFAULT '';
+ALTER TABLE videos ADD COLUMN moderation TEXT;
+
+-- +migrate down
+ALTER TABLE videos DROP COLUMN moderation;
+ALTER TABLE videos DROP COLUMN moderation_status;
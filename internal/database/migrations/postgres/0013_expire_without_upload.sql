@@ -0,0 +1,9 @@
+-- Marks videos created along with an upload session, which are the only
+-- ones deleted if their file never arrives. Drafts from before it, or made
+-- without a session, are kept however long they wait for one.
+
+-- +migrate up
+ALTER TABLE videos ADD COLUMN expire_without_upload INTEGER NOT NULL DEFAULT 0;
+
+-- +migrate down
+ALTER TABLE videos DROP COLUMN expire_without_upload;
@@ -0,0 +1,134 @@
+-- The schema as it stood before migrations. Every statement is guarded, as
+-- databases from then already have most of it.
+
+-- +migrate up
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL,
+	role TEXT NOT NULL DEFAULT 'user',
+	stored_bytes INTEGER NOT NULL DEFAULT 0,
+	quota_bytes INTEGER,
+	plan TEXT NOT NULL DEFAULT 'free'
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMP,
+	user_id TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	hashed INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS videos (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT TEXT,
+	video_checksum TEXT,
+	captions_url TEXT,
+	media_info TEXT,
+	perceptual_hash TEXT,
+	pending_upload_key TEXT,
+	renditions TEXT,
+	hls_url TEXT,
+	source_checksum TEXT,
+	deleted_at TIMESTAMP,
+	status TEXT NOT NULL DEFAULT '',
+	visibility TEXT NOT NULL DEFAULT 'public',
+	original_filename TEXT,
+	thumbnail_small_url TEXT,
+	preview_url TEXT,
+	sprites_vtt_url TEXT,
+	video_encryption TEXT,
+	user_id INTEGER,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+-- Listings of other users' videos only include public ones
+CREATE INDEX IF NOT EXISTS idx_videos_visibility ON videos(visibility, created_at);
+
+CREATE TABLE IF NOT EXISTS upload_sessions (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	upload_offset INTEGER NOT NULL DEFAULT 0,
+	content_type TEXT NOT NULL,
+	file_path TEXT NOT NULL,
+	filename TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY(video_id) REFERENCES videos(id),
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	last_used_at TIMESTAMP,
+	revoked_at TIMESTAMP,
+	key_hash TEXT NOT NULL UNIQUE,
+	user_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	user_id TEXT NOT NULL,
+	idempotency_key TEXT NOT NULL,
+	request TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	status_code INTEGER,
+	content_type TEXT NOT NULL DEFAULT '',
+	body BLOB,
+	PRIMARY KEY(user_id, idempotency_key),
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
+
+CREATE TABLE IF NOT EXISTS scan_incidents (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	user_id TEXT NOT NULL,
+	video_id TEXT NOT NULL,
+	signature TEXT NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	secret TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	url TEXT NOT NULL,
+	events TEXT NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS video_uploads (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at TIMESTAMP NOT NULL,
+	user_id TEXT NOT NULL,
+	video_id TEXT NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE INDEX IF NOT EXISTS idx_video_uploads_user ON video_uploads(user_id, created_at);
+
+-- +migrate down
+DROP TABLE IF EXISTS video_uploads;
+DROP TABLE IF EXISTS webhooks;
+DROP TABLE IF EXISTS scan_incidents;
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS upload_sessions;
+DROP TABLE IF EXISTS videos;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,18 @@
+-- +migrate up
+CREATE TABLE IF NOT EXISTS exports (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	user_id TEXT NOT NULL,
+	format TEXT NOT NULL,
+	status TEXT NOT NULL,
+	objects INTEGER NOT NULL DEFAULT 0,
+	bytes INTEGER NOT NULL DEFAULT 0,
+	error TEXT,
+	completed_at TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE INDEX IF NOT EXISTS idx_exports_user ON exports(user_id, created_at);
+
+-- +migrate down
+DROP TABLE IF EXISTS exports;
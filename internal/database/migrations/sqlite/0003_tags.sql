@@ -0,0 +1,18 @@
+-- +migrate up
+CREATE TABLE IF NOT EXISTS tags (
+	id TEXT PRIMARY KEY,
+	name TEXT UNIQUE NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+CREATE TABLE IF NOT EXISTS video_tags (
+	video_id TEXT NOT NULL,
+	tag_id TEXT NOT NULL,
+	PRIMARY KEY(video_id, tag_id),
+	FOREIGN KEY(video_id) REFERENCES videos(id),
+	FOREIGN KEY(tag_id) REFERENCES tags(id)
+);
+CREATE INDEX IF NOT EXISTS idx_video_tags_tag ON video_tags(tag_id);
+
+-- +migrate down
+DROP TABLE IF EXISTS video_tags;
+DROP TABLE IF EXISTS tags;
@@ -0,0 +1,13 @@
+-- Views are kept per day rather than one row per view
+
+-- +migrate up
+CREATE TABLE IF NOT EXISTS video_views (
+	video_id TEXT NOT NULL,
+	day TEXT NOT NULL,
+	views INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY(video_id, day),
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
+
+-- +migrate down
+DROP TABLE IF EXISTS video_views;
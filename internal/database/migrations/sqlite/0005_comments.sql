@@ -0,0 +1,14 @@
+-- +migrate up
+CREATE TABLE IF NOT EXISTS comments (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	video_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	body TEXT NOT NULL,
+	FOREIGN KEY(video_id) REFERENCES videos(id),
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE INDEX IF NOT EXISTS idx_comments_video ON comments(video_id, created_at);
+
+-- +migrate down
+DROP TABLE IF EXISTS comments;
@@ -0,0 +1,25 @@
+-- +migrate up
+CREATE TABLE IF NOT EXISTS playlists (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	user_id TEXT NOT NULL,
+	title TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	visibility TEXT NOT NULL DEFAULT 'public',
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE INDEX IF NOT EXISTS idx_playlists_user ON playlists(user_id, created_at);
+CREATE TABLE IF NOT EXISTS playlist_videos (
+	playlist_id TEXT NOT NULL,
+	video_id TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	PRIMARY KEY(playlist_id, video_id),
+	FOREIGN KEY(playlist_id) REFERENCES playlists(id),
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
+CREATE INDEX IF NOT EXISTS idx_playlist_videos_video ON playlist_videos(video_id);
+
+-- +migrate down
+DROP TABLE IF EXISTS playlist_videos;
+DROP TABLE IF EXISTS playlists;
@@ -0,0 +1,11 @@
+-- Files in the local assets dir, with the content type each was stored with
+
+-- +migrate up
+CREATE TABLE IF NOT EXISTS assets (
+	name TEXT PRIMARY KEY,
+	content_type TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+-- +migrate down
+DROP TABLE IF EXISTS assets;
@@ -0,0 +1,7 @@
+-- Which of a video's caption tracks were made by speech-to-text
+
+-- +migrate up
+ALTER TABLE videos ADD COLUMN auto_captions TEXT;
+
+-- +migrate down
+ALTER TABLE videos DROP COLUMN auto_captions;
@@ -0,0 +1,10 @@
+-- Gives videos from before processing statuses were recorded the one they
+-- would have had: ready with a file, pending without. Pending ones are
+-- drafts made without an upload session, which the upload expirer leaves
+-- alone (see 0013_expire_without_upload).
+
+-- +migrate up
+UPDATE videos SET status = CASE WHEN video_url IS NULL THEN 'pending' ELSE 'ready' END WHERE status = '';
+
+-- +migrate down
+-- Older releases read these statuses the same way, so they're kept
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Moderation statuses. Flagged videos stay up until someone has looked at
+// them, and blocked ones are hidden from everyone but their owner and
+// admins. Videos moderation hasn't seen have no status.
+const (
+	ModerationStatusClear   = "clear"
+	ModerationStatusFlagged = "flagged"
+	ModerationStatusBlocked = "blocked"
+)
+
+// Moderation is what content moderation found in a video's thumbnail and in
+// frames sampled from its file, each replaced when that image is. It's
+// stored as JSON.
+type Moderation struct {
+	Thumbnail          []ModerationLabel `json:"thumbnail,omitempty"`
+	ThumbnailCheckedAt *time.Time        `json:"thumbnail_checked_at,omitempty"`
+	Frames             []ModerationLabel `json:"frames,omitempty"`
+	FramesCheckedAt    *time.Time        `json:"frames_checked_at,omitempty"`
+}
+
+// ModerationLabel is something found in an image, like "Explicit Nudity",
+// with the category it comes under and how confident the finding is, out
+// of 100. Labels from frames say how far into the video the frame was.
+type ModerationLabel struct {
+	Name       string   `json:"name"`
+	Parent     string   `json:"parent,omitempty"`
+	Confidence float64  `json:"confidence"`
+	Timestamp  *float64 `json:"timestamp,omitempty"`
+}
+
+func (m Moderation) Value() (driver.Value, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+type nullModeration struct {
+	Moderation Moderation
+	Valid      bool
+}
+
+func (n *nullModeration) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*n = nullModeration{}
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("unsupported moderation type %T", src)
+	}
+	n.Valid = false
+	if err := json.Unmarshal(data, &n.Moderation); err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PendingObject is an object being uploaded for a video that doesn't point
+// at it yet. It's recorded before the upload starts, so one whose upload is
+// never finalized can be found and deleted.
+type PendingObject struct {
+	Key       string
+	VideoID   uuid.UUID
+	CreatedAt time.Time
+}
+
+func (c Client) CreatePendingObject(key string, videoID uuid.UUID) error {
+	query := `
+	INSERT INTO pending_objects (object_key, video_id, created_at)
+	VALUES (?, ?, ?)
+	`
+	_, err := c.db.Exec(query, key, videoID, time.Now().UTC())
+	return err
+}
+
+func (c Client) DeletePendingObject(key string) error {
+	_, err := c.db.Exec(`DELETE FROM pending_objects WHERE object_key = ?`, key)
+	return err
+}
+
+// GetPendingObjectsBefore lists objects still pending that were recorded
+// before cutoff, oldest first.
+func (c Client) GetPendingObjectsBefore(cutoff time.Time) ([]PendingObject, error) {
+	query := `
+	SELECT object_key, video_id, created_at
+	FROM pending_objects
+	WHERE created_at < ?
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, cutoff.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	objects := []PendingObject{}
+	for rows.Next() {
+		var object PendingObject
+		if err := rows.Scan(&object.Key, &object.VideoID, &object.CreatedAt); err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	return objects, rows.Err()
+}
+
+// FinalizeVideoUpload saves a video whose file was just uploaded, clearing
+// the upload's pending object in the same transaction, so the object is
+// only forgotten once the video points at it. pendingKey is empty when the
+// video reuses an object that was already stored.
+func (c Client) FinalizeVideoUpload(video Video, pendingKey string) error {
+	defer c.invalidateVideo(video.ID)
+	err := c.inTransaction(func(tx sqlTx) error {
+		err := updateVideo(tx, video)
+		if err != nil || pendingKey == "" {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM pending_objects WHERE object_key = ?`, pendingKey)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return c.updateStoredBytes(video.UserID)
+}
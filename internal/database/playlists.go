@@ -0,0 +1,242 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Playlist struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	VideoCount int       `json:"video_count"`
+	CreatePlaylistParams
+}
+
+type CreatePlaylistParams struct {
+	UserID      uuid.UUID `json:"user_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	// Visibility is one of the VideoVisibility constants
+	Visibility string `json:"visibility"`
+}
+
+const playlistColumns = `
+		id,
+		created_at,
+		updated_at,
+		(SELECT COUNT(*) FROM playlist_videos WHERE playlist_videos.playlist_id = playlists.id),
+		user_id,
+		title,
+		description,
+		visibility`
+
+func scanPlaylist(row rowScanner) (Playlist, error) {
+	var playlist Playlist
+	err := row.Scan(
+		&playlist.ID,
+		&playlist.CreatedAt,
+		&playlist.UpdatedAt,
+		&playlist.VideoCount,
+		&playlist.UserID,
+		&playlist.Title,
+		&playlist.Description,
+		&playlist.Visibility,
+	)
+	return playlist, err
+}
+
+func (c Client) CreatePlaylist(params CreatePlaylistParams) (Playlist, error) {
+	id := uuid.New()
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO playlists (
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		title,
+		description,
+		visibility
+	) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, now, now, params.UserID, params.Title, params.Description, params.Visibility)
+	if err != nil {
+		return Playlist{}, err
+	}
+	return c.GetPlaylist(id)
+}
+
+// GetPlaylist returns a playlist, with a nil ID if there's no such
+// playlist.
+func (c Client) GetPlaylist(id uuid.UUID) (Playlist, error) {
+	query := `
+	SELECT` + playlistColumns + `
+	FROM playlists
+	WHERE id = ?
+	`
+	playlist, err := scanPlaylist(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Playlist{}, nil
+	}
+	return playlist, err
+}
+
+// GetPlaylists lists a user's playlists, newest first.
+func (c Client) GetPlaylists(userID uuid.UUID) ([]Playlist, error) {
+	query := `
+	SELECT` + playlistColumns + `
+	FROM playlists
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []Playlist{}
+	for rows.Next() {
+		playlist, err := scanPlaylist(rows)
+		if err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+	return playlists, rows.Err()
+}
+
+// UpdatePlaylist sets a playlist's title, description and visibility.
+func (c Client) UpdatePlaylist(playlist Playlist) error {
+	query := `
+	UPDATE playlists
+	SET
+		updated_at = ?,
+		title = ?,
+		description = ?,
+		visibility = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), playlist.Title, playlist.Description, playlist.Visibility, playlist.ID)
+	return err
+}
+
+func (c Client) DeletePlaylist(id uuid.UUID) error {
+	_, err := c.db.Exec(`DELETE FROM playlist_videos WHERE playlist_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(`DELETE FROM playlists WHERE id = ?`, id)
+	return err
+}
+
+// GetPlaylistVideos returns a playlist's videos in order, leaving out any
+// in the trash.
+func (c Client) GetPlaylistVideos(playlistID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	JOIN playlist_videos ON playlist_videos.video_id = videos.id
+	WHERE playlist_videos.playlist_id = ? AND videos.deleted_at IS NULL
+	ORDER BY playlist_videos.position
+	`
+	rows, err := c.db.Query(query, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// GetPlaylistVideoIDs returns the IDs of every video in a playlist in
+// order, trashed ones included.
+func (c Client) GetPlaylistVideoIDs(playlistID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := c.db.Query(`SELECT video_id FROM playlist_videos WHERE playlist_id = ? ORDER BY position`, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// AddPlaylistVideo appends a video to a playlist, reporting false if it's
+// already in it.
+func (c Client) AddPlaylistVideo(playlistID, videoID uuid.UUID) (bool, error) {
+	query := `
+	INSERT INTO playlist_videos (playlist_id, video_id, position)
+	SELECT ?, ?, COALESCE(MAX(position) + 1, 0) FROM playlist_videos WHERE playlist_id = ?
+	ON CONFLICT DO NOTHING
+	`
+	result, err := c.db.Exec(query, playlistID, videoID, playlistID)
+	if err != nil {
+		return false, err
+	}
+	added, err := result.RowsAffected()
+	if err != nil || added == 0 {
+		return false, err
+	}
+	return true, c.touchPlaylist(playlistID)
+}
+
+// RemovePlaylistVideo takes a video out of a playlist, reporting false if it
+// wasn't in it.
+func (c Client) RemovePlaylistVideo(playlistID, videoID uuid.UUID) (bool, error) {
+	result, err := c.db.Exec(`DELETE FROM playlist_videos WHERE playlist_id = ? AND video_id = ?`, playlistID, videoID)
+	if err != nil {
+		return false, err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil || removed == 0 {
+		return false, err
+	}
+	return true, c.touchPlaylist(playlistID)
+}
+
+// SetPlaylistOrder puts a playlist's videos in the order of videoIDs, which
+// must be exactly the videos already in it.
+func (c Client) SetPlaylistOrder(playlistID uuid.UUID, videoIDs []uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, videoID := range videoIDs {
+		_, err := tx.Exec(`UPDATE playlist_videos SET position = ? WHERE playlist_id = ? AND video_id = ?`, i, playlistID, videoID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`UPDATE playlists SET updated_at = ? WHERE id = ?`, time.Now().UTC(), playlistID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) touchPlaylist(id uuid.UUID) error {
+	_, err := c.db.Exec(`UPDATE playlists SET updated_at = ? WHERE id = ?`, time.Now().UTC(), id)
+	return err
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// storedBytesQuery totals the size of a user's video files. Videos sharing a
+// deduplicated file count it once.
+func (c Client) storedBytesQuery() string {
+	return `
+	SELECT COALESCE(SUM(size), 0) FROM (
+		SELECT MAX(` + c.db.dialect.jsonInt("media_info", "size") + `) AS size
+		FROM videos
+		WHERE user_id = users.id AND video_url IS NOT NULL
+		GROUP BY video_url
+	) AS files
+`
+}
+
+// StorageUsage is how much of their quota a user has used. A nil QuotaBytes
+// means the user has no override and gets the server's default.
+type StorageUsage struct {
+	UsedBytes  int64
+	QuotaBytes *int64
+}
+
+// GetStorageUsage returns a user's stored bytes and quota override. It
+// returns sql.ErrNoRows if there's no such user.
+func (c Client) GetStorageUsage(userID uuid.UUID) (StorageUsage, error) {
+	var usage StorageUsage
+	var quota sql.NullInt64
+	err := c.db.QueryRow(`SELECT stored_bytes, quota_bytes FROM users WHERE id = ?`, userID.String()).Scan(&usage.UsedBytes, &quota)
+	if err != nil {
+		return StorageUsage{}, err
+	}
+	if quota.Valid {
+		usage.QuotaBytes = &quota.Int64
+	}
+	return usage, nil
+}
+
+// SetUserQuota overrides a user's quota, or clears the override if quota is
+// nil. It returns false if there's no such user.
+func (c Client) SetUserQuota(userID uuid.UUID, quota *int64) (bool, error) {
+	query := `
+		UPDATE users
+		SET quota_bytes = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	result, err := c.db.Exec(query, quota, userID.String())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// updateStoredBytes recounts a user's stored bytes after their videos
+// change.
+func (c Client) updateStoredBytes(userID uuid.UUID) error {
+	_, err := c.db.Exec(`UPDATE users SET stored_bytes = (`+c.storedBytesQuery()+`) WHERE id = ?`, userID.String())
+	return err
+}
+
+// recountStoredBytes recounts every user's stored bytes, filling in the
+// column for databases created before it existed.
+func (c *Client) recountStoredBytes() error {
+	_, err := c.db.Exec(`UPDATE users SET stored_bytes = (` + c.storedBytesQuery() + `)`)
+	return err
+}
+
+// videoOwner returns the ID of a video's owner, or uuid.Nil if there's no
+// such video.
+func (c Client) videoOwner(videoID uuid.UUID) (uuid.UUID, error) {
+	var owner string
+	err := c.db.QueryRow(`SELECT user_id FROM videos WHERE id = ?`, videoID).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, nil
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.Parse(owner)
+}
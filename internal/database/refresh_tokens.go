@@ -14,6 +14,8 @@ type RefreshToken struct {
 	RevokedAt *time.Time `json:"revoked_at"`
 }
 
+// CreateRefreshTokenParams holds the token as returned by
+// auth.HashRefreshToken; the raw token is never stored.
 type CreateRefreshTokenParams struct {
 	Token     string    `json:"token"`
 	UserID    uuid.UUID `json:"user_id"`
@@ -27,8 +29,9 @@ func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToke
 			created_at,
 			updated_at,
 			user_id,
-			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+			expires_at,
+			hashed
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, 1)
 	`
 	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
 	if err != nil {
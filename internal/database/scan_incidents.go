@@ -0,0 +1,68 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ScanIncident records an upload that was rejected for carrying malware.
+type ScanIncident struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateScanIncidentParams
+}
+
+type CreateScanIncidentParams struct {
+	UserID    uuid.UUID `json:"user_id"`
+	VideoID   uuid.UUID `json:"video_id"`
+	Signature string    `json:"signature"`
+}
+
+func (c Client) CreateScanIncident(params CreateScanIncidentParams) (ScanIncident, error) {
+	incident := ScanIncident{
+		ID:                       uuid.New(),
+		CreatedAt:                time.Now().UTC(),
+		CreateScanIncidentParams: params,
+	}
+	query := `
+	INSERT INTO scan_incidents (
+		id,
+		created_at,
+		user_id,
+		video_id,
+		signature
+	) VALUES (?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, incident.ID, incident.CreatedAt, params.UserID, params.VideoID, params.Signature)
+	if err != nil {
+		return ScanIncident{}, err
+	}
+	return incident, nil
+}
+
+// GetScanIncidents lists a user's rejected uploads, newest first.
+func (c Client) GetScanIncidents(userID uuid.UUID) ([]ScanIncident, error) {
+	query := `
+	SELECT id, created_at, user_id, video_id, signature
+	FROM scan_incidents
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	incidents := []ScanIncident{}
+	for rows.Next() {
+		var incident ScanIncident
+		err := rows.Scan(&incident.ID, &incident.CreatedAt, &incident.UserID, &incident.VideoID, &incident.Signature)
+		if err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, incident)
+	}
+	return incidents, rows.Err()
+}
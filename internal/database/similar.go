@@ -0,0 +1,57 @@
+package database
+
+import (
+	"encoding/hex"
+	"math/bits"
+)
+
+// FindSimilarVideos returns videos whose perceptual hash is within threshold
+// bits of hash. SQLite can't compute Hamming distances, so the comparison
+// happens here.
+func (c Client) FindSimilarVideos(hash string, threshold int) ([]Video, error) {
+	target, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE perceptual_hash IS NOT NULL AND deleted_at IS NULL
+	ORDER BY created_at DESC
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		if video.PerceptualHash == nil {
+			continue
+		}
+		candidate, err := hex.DecodeString(*video.PerceptualHash)
+		if err != nil || len(candidate) != len(target) {
+			continue
+		}
+		if hammingDistance(target, candidate) <= threshold {
+			videos = append(videos, video)
+		}
+	}
+
+	return videos, rows.Err()
+}
+
+func hammingDistance(a, b []byte) int {
+	distance := 0
+	for i := range a {
+		distance += bits.OnesCount8(a[i] ^ b[i])
+	}
+	return distance
+}
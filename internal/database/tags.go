@@ -0,0 +1,145 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TagList is a video's tag names in order. It's read alongside the rest of
+// the row as the names joined with commas, which tags can't contain.
+type TagList []string
+
+func (t *TagList) Scan(src interface{}) error {
+	var joined string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		joined = v
+	case []byte:
+		joined = string(v)
+	default:
+		return fmt.Errorf("unsupported tag list type %T", src)
+	}
+	tags := TagList{}
+	if joined != "" {
+		tags = strings.Split(joined, ",")
+	}
+	*t = tags
+	return nil
+}
+
+// TagCount is a tag and how many videos have it.
+type TagCount struct {
+	Name   string `json:"name"`
+	Videos int    `json:"videos"`
+}
+
+// AddVideoTags tags a video, creating any tags that don't exist yet. Tags
+// it already has are left as they are.
+func (c Client) AddVideoTags(videoID uuid.UUID, names []string) error {
+	defer c.invalidateVideo(videoID)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, name := range names {
+		_, err := tx.Exec(`INSERT INTO tags (id, name, created_at) VALUES (?, ?, ?) ON CONFLICT(name) DO NOTHING`, uuid.New(), name, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+		INSERT INTO video_tags (video_id, tag_id)
+		SELECT ?, id FROM tags WHERE name = ?
+		ON CONFLICT DO NOTHING
+		`, videoID, name)
+		if err != nil {
+			return err
+		}
+	}
+	// Listings cache on updated_at, so a change of tags has to move it
+	_, err = tx.Exec(`UPDATE videos SET updated_at = ? WHERE id = ?`, time.Now().UTC(), videoID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveVideoTag takes a tag off a video, reporting whether the video had
+// it. Tags no video has any more are deleted.
+func (c Client) RemoveVideoTag(videoID uuid.UUID, name string) (bool, error) {
+	defer c.invalidateVideo(videoID)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+	DELETE FROM video_tags
+	WHERE video_id = ? AND tag_id = (SELECT id FROM tags WHERE name = ?)
+	`, videoID, name)
+	if err != nil {
+		return false, err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil || removed == 0 {
+		return false, err
+	}
+	_, err = tx.Exec(`UPDATE videos SET updated_at = ? WHERE id = ?`, time.Now().UTC(), videoID)
+	if err != nil {
+		return false, err
+	}
+	err = deleteUnusedTags(tx)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// deleteUnusedTags removes tags no video has, so they stop being suggested.
+func deleteUnusedTags(db execer) error {
+	_, err := db.Exec(`DELETE FROM tags WHERE id NOT IN (SELECT tag_id FROM video_tags)`)
+	return err
+}
+
+// SearchTags lists the tags starting with prefix, most used first, counting
+// only videos outside the trash that viewerID can see: their own and
+// everyone's public ones. A nil viewerID counts every video.
+func (c Client) SearchTags(prefix string, viewerID *uuid.UUID, limit int) ([]TagCount, error) {
+	conditions := []string{"tags.name " + c.db.dialect.caseInsensitiveLike() + " ? ESCAPE '\\'", "videos.deleted_at IS NULL"}
+	args := []interface{}{escapeLike(prefix) + "%"}
+	if viewerID != nil {
+		conditions = append(conditions, "(videos.user_id = ? OR videos.visibility = ?)")
+		args = append(args, *viewerID, VideoVisibilityPublic)
+	}
+	query := `
+	SELECT tags.name, COUNT(*)
+	FROM tags
+	JOIN video_tags ON video_tags.tag_id = tags.id
+	JOIN videos ON videos.id = video_tags.video_id
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	GROUP BY tags.id
+	ORDER BY COUNT(*) DESC, tags.name
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []TagCount{}
+	for rows.Next() {
+		var tag TagCount
+		if err := rows.Scan(&tag.Name, &tag.Videos); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TrashVideo moves a video to the trash. It returns false if there's no
+// such video or it's already there.
+func (c Client) TrashVideo(id uuid.UUID) (bool, error) {
+	defer c.invalidateVideo(id)
+	query := `
+	UPDATE videos
+	SET deleted_at = ?
+	WHERE id = ? AND deleted_at IS NULL
+	`
+	return c.execAffectsRow(query, time.Now().UTC(), id)
+}
+
+// RestoreVideo takes a video out of the trash. It returns false if it isn't
+// in the trash.
+func (c Client) RestoreVideo(id uuid.UUID) (bool, error) {
+	defer c.invalidateVideo(id)
+	query := `
+	UPDATE videos
+	SET deleted_at = NULL
+	WHERE id = ? AND deleted_at IS NOT NULL
+	`
+	return c.execAffectsRow(query, id)
+}
+
+// GetVideosTrashedBefore returns the videos that went in the trash before
+// cutoff.
+func (c Client) GetVideosTrashedBefore(cutoff time.Time) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE deleted_at IS NOT NULL AND deleted_at < ?
+	`
+	rows, err := c.db.Query(query, cutoff.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+func (c Client) execAffectsRow(query string, args ...interface{}) (bool, error) {
+	result, err := c.db.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
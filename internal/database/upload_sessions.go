@@ -0,0 +1,160 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadSession tracks a resumable upload whose bytes are being appended to
+// FilePath a chunk at a time.
+type UploadSession struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Offset    int64     `json:"offset"`
+	FilePath  string    `json:"-"`
+	CreateUploadSessionParams
+}
+
+type CreateUploadSessionParams struct {
+	VideoID     uuid.UUID `json:"video_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	Filename    string    `json:"filename,omitempty"`
+}
+
+func (c Client) CreateUploadSession(params CreateUploadSessionParams, filePath string) (UploadSession, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO upload_sessions (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		size,
+		upload_offset,
+		content_type,
+		file_path,
+		filename
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, 0, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.VideoID, params.UserID, params.Size, params.ContentType, filePath, params.Filename)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	return c.GetUploadSession(id)
+}
+
+func (c Client) GetUploadSession(id uuid.UUID) (UploadSession, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		size,
+		upload_offset,
+		content_type,
+		file_path,
+		filename
+	FROM upload_sessions
+	WHERE id = ?
+	`
+
+	var session UploadSession
+	err := c.db.QueryRow(query, id).Scan(
+		&session.ID,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+		&session.VideoID,
+		&session.UserID,
+		&session.Size,
+		&session.Offset,
+		&session.ContentType,
+		&session.FilePath,
+		&session.Filename,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UploadSession{}, nil
+		}
+		return UploadSession{}, err
+	}
+
+	return session, nil
+}
+
+func (c Client) UpdateUploadSessionOffset(id uuid.UUID, offset int64) error {
+	query := `
+	UPDATE upload_sessions
+	SET
+		upload_offset = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, offset, id)
+	return err
+}
+
+// GetUploadSessionsIdleSince returns the sessions that haven't received a
+// chunk since cutoff.
+func (c Client) GetUploadSessionsIdleSince(cutoff time.Time) ([]UploadSession, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		size,
+		upload_offset,
+		content_type,
+		file_path,
+		filename
+	FROM upload_sessions
+	WHERE updated_at < ?
+	`
+	rows, err := c.db.Query(query, cutoff.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []UploadSession{}
+	for rows.Next() {
+		var session UploadSession
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.UpdatedAt,
+			&session.VideoID,
+			&session.UserID,
+			&session.Size,
+			&session.Offset,
+			&session.ContentType,
+			&session.FilePath,
+			&session.Filename,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (c Client) DeleteUploadSession(id uuid.UUID) error {
+	query := `
+	DELETE FROM upload_sessions
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
@@ -12,6 +12,8 @@ type User struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	Role      string    `json:"role"`
+	Plan      string    `json:"plan"`
 	CreateUserParams
 }
 
@@ -53,13 +55,13 @@ func (c Client) GetUsers() ([]User, error) {
 
 func (c Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, role, plan
 		FROM users
 		WHERE email = ?
 	`
 	var user User
 	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.Role, &user.Plan)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
@@ -73,31 +75,6 @@ func (c Client) GetUserByEmail(email string) (User, error) {
 	return user, nil
 }
 
-func (c Client) GetUserByRefreshToken(token string) (*User, error) {
-	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
-		FROM users u
-		JOIN refresh_tokens rt ON u.id = rt.user_id
-		WHERE rt.token = ?
-	`
-
-	var user User
-	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
-		}
-		return nil, err
-	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return nil, err
-	}
-
-	return &user, nil
-}
-
 func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 	id := uuid.New()
 
@@ -117,13 +94,13 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, role, plan
 		FROM users
 		WHERE id = ?
 	`
 	var user User
 	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.Role, &user.Plan)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -137,6 +114,95 @@ func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	return &user, nil
 }
 
+// SetUserRole changes a user's role. It returns false if there's no such
+// user.
+func (c Client) SetUserRole(id uuid.UUID, role string) (bool, error) {
+	query := `
+		UPDATE users
+		SET role = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	result, err := c.db.Exec(query, role, id.String())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// SetUserPlan moves a user onto another plan. It returns false if there's no
+// such user.
+func (c Client) SetUserPlan(id uuid.UUID, plan string) (bool, error) {
+	query := `
+		UPDATE users
+		SET plan = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	result, err := c.db.Exec(query, plan, id.String())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// UserStorageUsage is how much video storage a user's uploads take up.
+// QuotaBytes is only set for users with a quota override.
+type UserStorageUsage struct {
+	UserID     uuid.UUID `json:"user_id"`
+	Email      string    `json:"email"`
+	VideoCount int       `json:"video_count"`
+	Bytes      int64     `json:"bytes"`
+	QuotaBytes *int64    `json:"quota_bytes"`
+}
+
+// GetStorageUsageByUser lists every user's stored bytes and quota override,
+// largest first. Users without videos are included with zero usage.
+func (c Client) GetStorageUsageByUser() ([]UserStorageUsage, error) {
+	query := `
+		SELECT
+			users.id,
+			users.email,
+			COUNT(videos.video_url),
+			users.stored_bytes,
+			users.quota_bytes
+		FROM users
+		LEFT JOIN videos ON videos.user_id = users.id
+		GROUP BY users.id
+		ORDER BY users.stored_bytes DESC, users.email
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []UserStorageUsage{}
+	for rows.Next() {
+		var u UserStorageUsage
+		var id string
+		var quota sql.NullInt64
+		if err := rows.Scan(&id, &u.Email, &u.VideoCount, &u.Bytes, &quota); err != nil {
+			return nil, err
+		}
+		u.UserID, err = uuid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		if quota.Valid {
+			u.QuotaBytes = &quota.Int64
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
 func (c Client) DeleteUser(id uuid.UUID) error {
 	query := `
 		DELETE FROM users
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Videos are read far more often than they're written, so with a cache
+// configured rows fetched by ID are kept in it for Config.CacheTTL. Every
+// write to a video deletes its entry, which servers sharing the cache all
+// see. A read racing a write can still cache the old row, which lasts until
+// it expires.
+
+const (
+	defaultCacheTTL = time.Minute
+	// cacheTimeout bounds each cache call, so a slow cache costs little more
+	// than a miss
+	cacheTimeout = 250 * time.Millisecond
+)
+
+// videoCacheEntry is how a video is cached: as JSON, which keeps the
+// difference between empty and missing tags and URL maps that clients see,
+// plus the fields clients aren't shown.
+type videoCacheEntry struct {
+	Video
+	PendingUploadKey *string `json:"pending_upload_key"`
+}
+
+func videoCacheKey(id uuid.UUID) string {
+	return "video:" + id.String()
+}
+
+// cachedVideo returns a video's cached row, trashed or not, reporting false
+// on a miss.
+func (c Client) cachedVideo(id uuid.UUID) (Video, bool) {
+	if c.cache == nil {
+		return Video{}, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cacheTimeout)
+	defer cancel()
+	data, ok, err := c.cache.Get(ctx, videoCacheKey(id))
+	if err != nil || !ok {
+		return Video{}, false
+	}
+	var entry videoCacheEntry
+	if json.Unmarshal(data, &entry) != nil {
+		return Video{}, false
+	}
+	entry.Video.PendingUploadKey = entry.PendingUploadKey
+	return entry.Video, true
+}
+
+func (c Client) cacheVideo(video Video) {
+	if c.cache == nil {
+		return
+	}
+	data, err := json.Marshal(videoCacheEntry{Video: video, PendingUploadKey: video.PendingUploadKey})
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cacheTimeout)
+	defer cancel()
+	c.cache.Set(ctx, videoCacheKey(video.ID), data, c.cacheTTL)
+}
+
+// invalidateVideo drops a video's cached row. Writers defer it, so the entry
+// goes even if the write fails partway.
+func (c Client) invalidateVideo(id uuid.UUID) {
+	if c.cache == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cacheTimeout)
+	defer cancel()
+	c.cache.Delete(ctx, videoCacheKey(id))
+}
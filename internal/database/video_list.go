@@ -0,0 +1,181 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Upload states ListVideos can filter by.
+const (
+	// UploadStateDraft videos have no file yet
+	UploadStateDraft = "draft"
+	// UploadStateUploading videos have a direct upload awaiting confirmation
+	UploadStateUploading = "uploading"
+	// UploadStateReady videos have a playable file
+	UploadStateReady = "ready"
+)
+
+// Orders ListVideos can sort by.
+const (
+	VideoSortCreatedAt = "created_at"
+	VideoSortTitle     = "title"
+	// VideoSortRelevance puts the best matches for Search first, with
+	// title matches counting for more than description ones
+	VideoSortRelevance = "relevance"
+)
+
+type ListVideosParams struct {
+	// UserID limits the list to one owner's videos; nil lists everyone's
+	UserID *uuid.UUID
+	// AspectRatio matches the ratio recorded in the media info, like "16:9"
+	// or "other"
+	AspectRatio string
+	Status      string
+	Visibility  string
+	// HideBlocked leaves out videos moderation blocked
+	HideBlocked bool
+	// Tags limits the list to videos with all of them
+	Tags []string
+	// Search limits the list to videos with every word of it in their
+	// title or description
+	Search string
+	// Trashed lists videos in the trash instead of the ones that aren't
+	Trashed    bool
+	SortBy     string
+	Descending bool
+	Limit      int
+	Offset     int
+}
+
+// ListVideos returns one page of videos matching params, along with how many
+// match in total.
+func (c Client) ListVideos(params ListVideosParams) ([]Video, int, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	if params.UserID != nil {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, *params.UserID)
+	}
+	if params.Trashed {
+		conditions = append(conditions, "deleted_at IS NOT NULL")
+	} else {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+	if params.AspectRatio != "" {
+		conditions = append(conditions, c.db.dialect.jsonText("media_info", "aspect_ratio")+" = ?")
+		args = append(args, params.AspectRatio)
+	}
+	if params.Visibility != "" {
+		conditions = append(conditions, "visibility = ?")
+		args = append(args, params.Visibility)
+	}
+	if params.HideBlocked {
+		conditions = append(conditions, "moderation_status <> ?")
+		args = append(args, ModerationStatusBlocked)
+	}
+	if len(params.Tags) > 0 {
+		conditions = append(conditions, `id IN (
+		SELECT video_tags.video_id
+		FROM video_tags
+		JOIN tags ON tags.id = video_tags.tag_id
+		WHERE tags.name IN (?`+strings.Repeat(", ?", len(params.Tags)-1)+`)
+		GROUP BY video_tags.video_id
+		HAVING COUNT(*) = ?
+	)`)
+		for _, tag := range params.Tags {
+			args = append(args, tag)
+		}
+		args = append(args, len(params.Tags))
+	}
+
+	// Searches join the full-text index's matches when there is one, and
+	// rank by how many words are found in the title otherwise
+	with, from, rank := "", "videos", ""
+	withArgs := []interface{}{}
+	rankArgs := []interface{}{}
+	if terms := searchTerms(params.Search); len(terms) > 0 {
+		if c.fullTextSearch {
+			with = fmt.Sprintf(`WITH matches AS (
+		SELECT rowid AS match_rowid, bm25(videos_fts, %d.0, 1.0) AS match_rank
+		FROM videos_fts
+		WHERE videos_fts MATCH ?
+	)`, titleSearchWeight)
+			withArgs = append(withArgs, ftsMatchQuery(terms))
+			from = "videos JOIN matches ON match_rowid = videos.rowid"
+			// bm25 scores better matches lower
+			rank = "match_rank ASC"
+		} else {
+			like := c.db.dialect.caseInsensitiveLike()
+			scores := []string{}
+			for _, term := range terms {
+				pattern := likePattern(term)
+				conditions = append(conditions, fmt.Sprintf(`(title %[1]s ? ESCAPE '\' OR description %[1]s ? ESCAPE '\')`, like))
+				args = append(args, pattern, pattern)
+				scores = append(scores, fmt.Sprintf(`CASE WHEN title %[1]s ? ESCAPE '\' THEN %[2]d ELSE 0 END + CASE WHEN description %[1]s ? ESCAPE '\' THEN 1 ELSE 0 END`, like, titleSearchWeight))
+				rankArgs = append(rankArgs, pattern, pattern)
+			}
+			rank = "(" + strings.Join(scores, " + ") + ") DESC"
+		}
+	}
+	switch params.Status {
+	case UploadStateDraft:
+		conditions = append(conditions, "video_url IS NULL AND pending_upload_key IS NULL")
+	case UploadStateUploading:
+		conditions = append(conditions, "pending_upload_key IS NOT NULL")
+	case UploadStateReady:
+		conditions = append(conditions, "video_url IS NOT NULL")
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// The ID breaks ties so pages don't overlap
+	direction := "ASC"
+	if params.Descending {
+		direction = "DESC"
+	}
+	orderBy := "created_at " + direction + ", id " + direction
+	if params.SortBy == VideoSortTitle {
+		orderBy = "LOWER(title) " + direction + ", id " + direction
+	}
+	if params.SortBy == VideoSortRelevance && rank != "" {
+		orderBy = rank + ", " + orderBy
+	} else {
+		rankArgs = nil
+	}
+	args = append(withArgs, args...)
+
+	var total int
+	err := c.db.QueryRow(with+` SELECT COUNT(*) FROM `+from+` `+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := with + `
+	SELECT` + videoColumns + `
+	FROM ` + from + `
+	` + where + `
+	ORDER BY ` + orderBy + `
+	LIMIT ? OFFSET ?
+	`
+	args = append(args, rankArgs...)
+	rows, err := c.db.Query(query, append(args, params.Limit, params.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, total, rows.Err()
+}
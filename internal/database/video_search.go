@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// videos_fts indexes video titles and descriptions for ListVideos searches.
+// Triggers keep it in step with the videos table, however a row is written.
+// It needs SQLite built with FTS5, which go-sqlite3 only includes with the
+// sqlite_fts5 build tag; without it searches fall back to LIKE.
+const videoSearchTriggers = `
+CREATE TRIGGER IF NOT EXISTS videos_fts_insert AFTER INSERT ON videos BEGIN
+	INSERT INTO videos_fts(rowid, title, description) VALUES (new.rowid, new.title, new.description);
+END;
+CREATE TRIGGER IF NOT EXISTS videos_fts_delete AFTER DELETE ON videos BEGIN
+	INSERT INTO videos_fts(videos_fts, rowid, title, description) VALUES ('delete', old.rowid, old.title, old.description);
+END;
+CREATE TRIGGER IF NOT EXISTS videos_fts_update AFTER UPDATE OF title, description ON videos BEGIN
+	INSERT INTO videos_fts(videos_fts, rowid, title, description) VALUES ('delete', old.rowid, old.title, old.description);
+	INSERT INTO videos_fts(rowid, title, description) VALUES (new.rowid, new.title, new.description);
+END;
+`
+
+var videoSearchTriggerNames = []string{"videos_fts_insert", "videos_fts_delete", "videos_fts_update"}
+
+// titleSearchWeight is how much more a match in the title counts towards a
+// result's rank than one in the description
+const titleSearchWeight = 10
+
+// migrateVideoSearch creates the search index if SQLite supports it,
+// rebuilding it from the videos table whenever its triggers are new, since
+// anything written before then is missing. Without FTS5 the triggers are
+// dropped, as they'd fail every write to videos.
+func (c *Client) migrateVideoSearch() error {
+	_, err := c.db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS videos_fts USING fts5(title, description, content='videos', content_rowid='rowid')`)
+	if err != nil && strings.Contains(err.Error(), "no such module") {
+		for _, name := range videoSearchTriggerNames {
+			if _, err := c.db.Exec(`DROP TRIGGER IF EXISTS ` + name); err != nil {
+				return err
+			}
+		}
+		c.fullTextSearch = false
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var existing string
+	err = c.db.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'trigger' AND name = ?`, videoSearchTriggerNames[0]).Scan(&existing)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	_, err = c.db.Exec(videoSearchTriggers)
+	if err != nil {
+		return err
+	}
+	if existing == "" {
+		_, err = c.db.Exec(`INSERT INTO videos_fts(videos_fts) VALUES ('rebuild')`)
+		if err != nil {
+			return err
+		}
+	}
+	c.fullTextSearch = true
+	return nil
+}
+
+// searchTerms splits a search into the words every result must contain.
+func searchTerms(search string) []string {
+	return strings.Fields(search)
+}
+
+// ftsMatchQuery turns search terms into an FTS5 query matching all of them,
+// each as a prefix so partly typed words still match. Quoting every term
+// keeps FTS5's own syntax out of users' hands.
+func ftsMatchQuery(terms []string) string {
+	quoted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		quoted = append(quoted, `"`+strings.ReplaceAll(term, `"`, `""`)+`"*`)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// likePattern matches term anywhere in a column.
+func likePattern(term string) string {
+	return "%" + escapeLike(term) + "%"
+}
+
+// escapeLike makes LIKE take the wildcards in s literally, given ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
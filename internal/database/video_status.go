@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Processing statuses, recorded on a video as its latest upload moves
+// through the pipeline. A video whose upload was stored is ready even if
+// transcoding it later fails, since the original still plays.
+const (
+	// VideoStatusPending videos have been created but their file hasn't
+	// started arriving
+	VideoStatusPending     = "pending"
+	VideoStatusReceived    = "received"
+	VideoStatusProbing     = "probing"
+	VideoStatusUploading   = "uploading"
+	VideoStatusTranscoding = "transcoding"
+	VideoStatusReady       = "ready"
+	VideoStatusFailed      = "failed"
+)
+
+// ErrVideoStatusTransition is returned by SetVideoStatus for a status the
+// video can't move to from the one it has.
+var ErrVideoStatusTransition = errors.New("invalid video status transition")
+
+// videoStatusTransitions are the statuses each status can move on to.
+// Besides these, any video can go back to received, since a new upload can
+// replace whatever came before it, including one a restart cut short.
+var videoStatusTransitions = map[string][]string{
+	VideoStatusPending:     {VideoStatusReady},
+	VideoStatusReceived:    {VideoStatusProbing, VideoStatusUploading, VideoStatusFailed},
+	VideoStatusProbing:     {VideoStatusUploading, VideoStatusReady, VideoStatusFailed},
+	VideoStatusUploading:   {VideoStatusTranscoding, VideoStatusReady, VideoStatusFailed},
+	VideoStatusTranscoding: {VideoStatusReady},
+	VideoStatusReady:       {},
+	VideoStatusFailed:      {},
+}
+
+// ValidVideoStatusTransition reports whether a video can move from one
+// processing status to another.
+func ValidVideoStatusTransition(from, to string) bool {
+	next, ok := videoStatusTransitions[from]
+	if !ok {
+		return false
+	}
+	return to == VideoStatusReceived || slices.Contains(next, to)
+}
+
+// SetVideoStatus moves a video to another processing status, returning
+// ErrVideoStatusTransition if it can't get there from its current one.
+// Like SetTranscodedOutputs it touches nothing else but updated_at, since
+// the status changes while handlers hold older copies of the row.
+func (c Client) SetVideoStatus(id uuid.UUID, status string) error {
+	from := []interface{}{}
+	for current := range videoStatusTransitions {
+		if ValidVideoStatusTransition(current, status) {
+			from = append(from, current)
+		}
+	}
+	if len(from) == 0 {
+		return fmt.Errorf("%w: unknown status %q", ErrVideoStatusTransition, status)
+	}
+
+	defer c.invalidateVideo(id)
+	query := `UPDATE videos SET status = ?, updated_at = ? WHERE id = ? AND status IN (?` + strings.Repeat(", ?", len(from)-1) + `)`
+	args := append([]interface{}{status, time.Now().UTC(), id}, from...)
+	result, err := c.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+
+	// Either the video is gone, which isn't worth failing over, or it's in
+	// a status that can't lead to this one
+	var current string
+	err = c.db.QueryRow(`SELECT status FROM videos WHERE id = ?`, id).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s to %s", ErrVideoStatusTransition, current, status)
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RecordVideoUpload notes that a user stored a video file, for limits on
+// how many they may upload in a period. Replacing a file counts again.
+func (c Client) RecordVideoUpload(userID, videoID uuid.UUID) error {
+	query := `
+	INSERT INTO video_uploads (created_at, user_id, video_id)
+	VALUES (?, ?, ?)
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), userID, videoID)
+	return err
+}
+
+// CountVideoUploadsSince returns how many video files a user has stored
+// since the given time.
+func (c Client) CountVideoUploadsSince(userID uuid.UUID, since time.Time) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM video_uploads
+	WHERE user_id = ? AND created_at >= ?
+	`
+	var count int
+	err := c.db.QueryRow(query, userID, since.UTC()).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// viewDayLayout is how days are keyed in video_views, in UTC.
+const viewDayLayout = "2006-01-02"
+
+// DailyViews is how many times a video was viewed on one UTC day.
+type DailyViews struct {
+	Date  string `json:"date"`
+	Views int64  `json:"views"`
+}
+
+// RecordVideoView counts a view of a video on the day at is in.
+func (c Client) RecordVideoView(videoID uuid.UUID, at time.Time) error {
+	query := `
+	INSERT INTO video_views (video_id, day, views) VALUES (?, ?, 1)
+	ON CONFLICT(video_id, day) DO UPDATE SET views = views + 1
+	`
+	_, err := c.db.Exec(query, videoID, at.UTC().Format(viewDayLayout))
+	return err
+}
+
+// GetVideoViews returns a video's views per day from since onwards, oldest
+// first, along with its views of all time. Days without views are left
+// out.
+func (c Client) GetVideoViews(videoID uuid.UUID, since time.Time) ([]DailyViews, int64, error) {
+	var total sql.NullInt64
+	err := c.db.QueryRow(`SELECT SUM(views) FROM video_views WHERE video_id = ?`, videoID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+	SELECT day, views
+	FROM video_views
+	WHERE video_id = ? AND day >= ?
+	ORDER BY day
+	`
+	rows, err := c.db.Query(query, videoID, since.UTC().Format(viewDayLayout))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	days := []DailyViews{}
+	for rows.Next() {
+		var day DailyViews
+		if err := rows.Scan(&day.Date, &day.Views); err != nil {
+			return nil, 0, err
+		}
+		days = append(days, day)
+	}
+	return days, total.Int64, rows.Err()
+}
@@ -2,30 +2,194 @@ package database
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID                uuid.UUID   `json:"id"`
+	CreatedAt         time.Time   `json:"created_at"`
+	UpdatedAt         time.Time   `json:"updated_at"`
+	ThumbnailURL      *string     `json:"thumbnail_url"`
+	ThumbnailSmallURL *string     `json:"thumbnail_small_url"`
+	VideoURL          *string     `json:"video_url"`
+	VideoChecksum     *string     `json:"video_checksum"`
+	CaptionsURL       URLMap      `json:"captions_url"`
+	AutoCaptions      LangList    `json:"auto_captions"`
+	MediaInfo         *MediaInfo  `json:"media_info"`
+	PerceptualHash    *string     `json:"perceptual_hash"`
+	PendingUploadKey  *string     `json:"-"`
+	Renditions        URLMap      `json:"renditions"`
+	HLSURL            *string     `json:"hls_url"`
+	SourceChecksum    *string     `json:"source_checksum"`
+	DeletedAt         *time.Time  `json:"deleted_at,omitempty"`
+	Status            string      `json:"status"`
+	OriginalFilename  *string     `json:"original_filename"`
+	PreviewURL        *string     `json:"preview_url"`
+	SpritesVTTURL     *string     `json:"sprites_vtt_url"`
+	VideoEncryption   *string     `json:"video_encryption"`
+	Tags              TagList     `json:"tags"`
+	ModerationStatus  string      `json:"moderation_status,omitempty"`
+	Moderation        *Moderation `json:"moderation,omitempty"`
 	CreateVideoParams
 }
 
 type CreateVideoParams struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	UserID      uuid.UUID `json:"user_id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// Visibility is one of the VideoVisibility constants, public if empty
+	Visibility string    `json:"visibility"`
+	UserID     uuid.UUID `json:"user_id"`
+	// ExpireWithoutUpload lets GetAbandonedVideos return the video if its
+	// file never arrives. It's only written on creation, never read back.
+	ExpireWithoutUpload bool `json:"-"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+// Who can see a video. Unlisted videos can be fetched by anyone who has the
+// ID but are left out of other users' listings, and private ones are only
+// shown to their owner.
+const (
+	VideoVisibilityPublic   = "public"
+	VideoVisibilityUnlisted = "unlisted"
+	VideoVisibilityPrivate  = "private"
+)
+
+// ValidVideoVisibility reports whether visibility is one of the
+// VideoVisibility constants.
+func ValidVideoVisibility(visibility string) bool {
+	switch visibility {
+	case VideoVisibilityPublic, VideoVisibilityUnlisted, VideoVisibilityPrivate:
+		return true
+	}
+	return false
+}
+
+// URLMap maps names to object URLs, such as caption tracks by language or
+// renditions by resolution. It's stored as a JSON object in a single column.
+type URLMap map[string]string
+
+func (m *URLMap) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*m = URLMap{}
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("unsupported URL map type %T", src)
+	}
+	urls := URLMap{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &urls); err != nil {
+			return err
+		}
+	}
+	*m = urls
+	return nil
+}
+
+func (m URLMap) Value() (driver.Value, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// LangList is a set of language tags, such as the caption tracks that were
+// generated rather than uploaded. It's stored comma separated in a single
+// column, since language tags can't contain commas.
+type LangList []string
+
+func (l *LangList) Scan(src interface{}) error {
+	var joined string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		joined = v
+	case []byte:
+		joined = string(v)
+	default:
+		return fmt.Errorf("unsupported language list type %T", src)
+	}
+	langs := LangList{}
+	if joined != "" {
+		langs = strings.Split(joined, ",")
+	}
+	*l = langs
+	return nil
+}
+
+func (l LangList) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+	return strings.Join(l, ","), nil
+}
+
+// MediaInfo is what ffprobe reported about an uploaded video. It's stored as
+// JSON so probing a stored video again isn't needed. Bitrate is the overall
+// rate in bits per second.
+type MediaInfo struct {
+	Codec       string  `json:"codec"`
+	Container   string  `json:"container,omitempty"`
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	Duration    float64 `json:"duration"`
+	AspectRatio string  `json:"aspect_ratio"`
+	Size        int64   `json:"size"`
+	AudioCodec  string  `json:"audio_codec,omitempty"`
+	Bitrate     int64   `json:"bitrate,omitempty"`
+	FrameRate   float64 `json:"frame_rate,omitempty"`
+}
+
+func (m MediaInfo) Value() (driver.Value, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+type nullMediaInfo struct {
+	MediaInfo MediaInfo
+	Valid     bool
+}
+
+func (n *nullMediaInfo) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*n = nullMediaInfo{}
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("unsupported media info type %T", src)
+	}
+	n.Valid = false
+	if err := json.Unmarshal(data, &n.MediaInfo); err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
+}
+
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
@@ -33,7 +197,88 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
+		video_checksum,
+		captions_url,
+		auto_captions,
+		media_info,
+		perceptual_hash,
+		pending_upload_key,
+		renditions,
+		hls_url,
+		source_checksum,
+		deleted_at,
+		status,
+		visibility,
+		original_filename,
+		thumbnail_small_url,
+		preview_url,
+		sprites_vtt_url,
+		video_encryption,
+		moderation_status,
+		moderation,
+		(
+			SELECT string_agg(tags.name, ',' ORDER BY tags.name)
+			FROM video_tags
+			JOIN tags ON tags.id = video_tags.tag_id
+			WHERE video_tags.video_id = videos.id
+		),
+		user_id`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// execer is the database or a transaction.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	var mediaInfo nullMediaInfo
+	var moderation nullModeration
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.VideoChecksum,
+		&video.CaptionsURL,
+		&video.AutoCaptions,
+		&mediaInfo,
+		&video.PerceptualHash,
+		&video.PendingUploadKey,
+		&video.Renditions,
+		&video.HLSURL,
+		&video.SourceChecksum,
+		&video.DeletedAt,
+		&video.Status,
+		&video.Visibility,
+		&video.OriginalFilename,
+		&video.ThumbnailSmallURL,
+		&video.PreviewURL,
+		&video.SpritesVTTURL,
+		&video.VideoEncryption,
+		&video.ModerationStatus,
+		&moderation,
+		&video.Tags,
+		&video.UserID,
+	)
+	if mediaInfo.Valid {
+		video.MediaInfo = &mediaInfo.MediaInfo
+	}
+	if moderation.Valid {
+		video.Moderation = &moderation.Moderation
+	}
+	return video, err
+}
+
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE user_id = ?
 	ORDER BY created_at DESC
@@ -47,17 +292,34 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, nil
+}
+
+// GetAllVideos returns every video, regardless of owner.
+func (c Client) GetAllVideos() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	ORDER BY created_at
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
@@ -66,8 +328,69 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 	return videos, nil
 }
 
-func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
+// FindVideoBySourceChecksum returns the newest of the user's other videos
+// whose uploaded file had this checksum and is still stored. The returned
+// video's ID is uuid.Nil when there's none.
+func (c Client) FindVideoBySourceChecksum(userID, excludeID uuid.UUID, checksum string) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND id != ? AND source_checksum = ? AND video_url IS NOT NULL
+	ORDER BY created_at DESC
+	LIMIT 1
+	`
+	video, err := scanVideo(c.db.QueryRow(query, userID, excludeID, checksum))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Video{}, nil
+	}
+	return video, err
+}
+
+// VideoURLInUse reports whether any video other than excludeID points at
+// videoURL, as deduplicated uploads share one object.
+func (c Client) VideoURLInUse(videoURL string, excludeID uuid.UUID) (bool, error) {
+	query := `
+	SELECT EXISTS (SELECT 1 FROM videos WHERE video_url = ? AND id != ?)
+	`
+	var inUse bool
+	err := c.db.QueryRow(query, videoURL, excludeID).Scan(&inUse)
+	return inUse, err
+}
+
+// GetVideosByThumbnailPrefix returns every video whose thumbnail URL starts
+// with prefix, regardless of owner.
+func (c Client) GetVideosByThumbnailPrefix(prefix string) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE substr(thumbnail_url, 1, length(?)) = ?
+	ORDER BY created_at
+	`
+
+	rows, err := c.db.Query(query, prefix, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, nil
+}
+
+// CreateVideo adds a video with no file yet, starting in the given status.
+func (c Client) CreateVideo(params CreateVideoParams, status string) (Video, error) {
 	id := uuid.New()
+	if params.Visibility == "" {
+		params.Visibility = VideoVisibilityPublic
+	}
 	query := `
 	INSERT INTO videos (
 		id,
@@ -75,10 +398,17 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		visibility,
+		status,
+		user_id,
+		expire_without_upload
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	expireWithoutUpload := 0
+	if params.ExpireWithoutUpload {
+		expireWithoutUpload = 1
+	}
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.Visibility, status, params.UserID, expireWithoutUpload)
 	if err != nil {
 		return Video{}, err
 	}
@@ -86,70 +416,233 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 	return c.GetVideo(id)
 }
 
+// GetVideo returns a video that isn't in the trash, or an empty Video if
+// there's none with that ID.
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
+	return c.getVideo(id, false)
+}
+
+// GetVideoWithDeleted is GetVideo for callers that also need videos in the
+// trash.
+func (c Client) GetVideoWithDeleted(id uuid.UUID) (Video, error) {
+	return c.getVideo(id, true)
+}
+
+func (c Client) getVideo(id uuid.UUID, withDeleted bool) (Video, error) {
+	if video, ok := c.cachedVideo(id); ok {
+		if video.DeletedAt != nil && !withDeleted {
+			return Video{}, nil
+		}
+		return video, nil
+	}
+
+	// The row is cached trashed or not, so it's fetched either way
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
-
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
 		}
 		return Video{}, err
 	}
+	c.cacheVideo(video)
 
+	if video.DeletedAt != nil && !withDeleted {
+		return Video{}, nil
+	}
 	return video, nil
 }
 
 func (c Client) UpdateVideo(video Video) error {
+	defer c.invalidateVideo(video.ID)
+	err := updateVideo(c.db, video)
+	if err != nil {
+		return err
+	}
+	return c.updateStoredBytes(video.UserID)
+}
+
+func updateVideo(db execer, video Video) error {
 	query := `
 	UPDATE videos
 	SET
+		updated_at = ?,
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
 		video_url = ?,
+		video_checksum = ?,
+		captions_url = ?,
+		auto_captions = ?,
+		media_info = ?,
+		perceptual_hash = ?,
+		pending_upload_key = ?,
+		renditions = ?,
+		hls_url = ?,
+		source_checksum = ?,
+		original_filename = ?,
+		thumbnail_small_url = ?,
+		preview_url = ?,
+		sprites_vtt_url = ?,
+		video_encryption = ?,
 		user_id = ?
 	WHERE id = ?
 	`
 
-	_, err := c.db.Exec(
+	_, err := db.Exec(
 		query,
+		time.Now().UTC(),
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
 		&video.VideoURL,
+		video.VideoChecksum,
+		video.CaptionsURL,
+		video.AutoCaptions,
+		video.MediaInfo,
+		video.PerceptualHash,
+		video.PendingUploadKey,
+		video.Renditions,
+		video.HLSURL,
+		video.SourceChecksum,
+		video.OriginalFilename,
+		video.ThumbnailSmallURL,
+		video.PreviewURL,
+		video.SpritesVTTURL,
+		video.VideoEncryption,
 		video.UserID,
 		video.ID,
 	)
 	return err
 }
 
+// SetTranscodedOutputs records renditions, the HLS playlist and the sprite
+// sheet WebVTT without touching the rest of the row, so a background job
+// can't clobber edits made meanwhile.
+func (c Client) SetTranscodedOutputs(id uuid.UUID, renditions URLMap, hlsURL, spritesVTTURL *string) error {
+	defer c.invalidateVideo(id)
+	query := `
+	UPDATE videos
+	SET
+		updated_at = ?,
+		renditions = ?,
+		hls_url = ?,
+		sprites_vtt_url = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), renditions, hlsURL, spritesVTTURL, id)
+	return err
+}
+
+// SetVideoModeration records what moderation found and the status it led
+// to, without touching the rest of the row, so a background job can't undo
+// edits made while it ran.
+func (c Client) SetVideoModeration(id uuid.UUID, status string, moderation Moderation) error {
+	defer c.invalidateVideo(id)
+	query := `
+	UPDATE videos
+	SET
+		updated_at = ?,
+		moderation_status = ?,
+		moderation = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), status, moderation, id)
+	return err
+}
+
+// GetAbandonedVideos returns videos created with ExpireWithoutUpload that
+// are still in one of statuses without ever getting a file, haven't changed
+// since cutoff, and have no upload session left that could deliver one.
+func (c Client) GetAbandonedVideos(cutoff time.Time, statuses ...string) ([]Video, error) {
+	if len(statuses) == 0 {
+		return []Video{}, nil
+	}
+	args := []interface{}{cutoff.UTC()}
+	for _, status := range statuses {
+		args = append(args, status)
+	}
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE expire_without_upload = 1
+		AND updated_at < ?
+		AND status IN (?` + strings.Repeat(", ?", len(statuses)-1) + `)
+		AND video_url IS NULL
+		AND deleted_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM upload_sessions WHERE upload_sessions.video_id = videos.id)
+	`
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// UpdateVideoDetails sets a video's title, description and visibility,
+// leaving the fields uploads and background jobs write alone.
+func (c Client) UpdateVideoDetails(id uuid.UUID, title, description, visibility string) error {
+	defer c.invalidateVideo(id)
+	query := `
+	UPDATE videos
+	SET
+		updated_at = ?,
+		title = ?,
+		description = ?,
+		visibility = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), title, description, visibility, id)
+	return err
+}
+
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	defer c.invalidateVideo(id)
+	owner, err := c.videoOwner(id)
+	if err != nil {
+		return err
+	}
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
-	return err
+	_, err = c.db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(`DELETE FROM video_tags WHERE video_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(`DELETE FROM video_views WHERE video_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(`DELETE FROM comments WHERE video_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(`DELETE FROM playlist_videos WHERE video_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	err = deleteUnusedTags(c.db)
+	if err != nil {
+		return err
+	}
+	return c.updateStoredBytes(owner)
 }
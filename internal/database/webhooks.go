@@ -0,0 +1,128 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook is a URL a user wants events POSTed to. Secret signs each event so
+// the receiver can tell it came from this server.
+type Webhook struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Secret    string    `json:"-"`
+	CreateWebhookParams
+}
+
+type CreateWebhookParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	URL    string    `json:"url"`
+	Events []string  `json:"events"`
+}
+
+const webhookColumns = `
+		id,
+		created_at,
+		secret,
+		user_id,
+		url,
+		events`
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var webhook Webhook
+	var events string
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.Secret,
+		&webhook.UserID,
+		&webhook.URL,
+		&events,
+	)
+	webhook.Events = strings.Split(events, ",")
+	return webhook, err
+}
+
+func (c Client) CreateWebhook(params CreateWebhookParams, secret string) (Webhook, error) {
+	webhook := Webhook{
+		ID:                  uuid.New(),
+		CreatedAt:           time.Now().UTC(),
+		Secret:              secret,
+		CreateWebhookParams: params,
+	}
+	query := `
+	INSERT INTO webhooks (
+		id,
+		created_at,
+		secret,
+		user_id,
+		url,
+		events
+	) VALUES (?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, webhook.ID, webhook.CreatedAt, secret, params.UserID, params.URL, strings.Join(params.Events, ","))
+	if err != nil {
+		return Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func (c Client) GetWebhooks(userID uuid.UUID) ([]Webhook, error) {
+	query := `
+	SELECT` + webhookColumns + `
+	FROM webhooks
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// GetWebhook returns a webhook, with a nil ID if there's no such webhook.
+func (c Client) GetWebhook(id uuid.UUID) (Webhook, error) {
+	query := `
+	SELECT` + webhookColumns + `
+	FROM webhooks
+	WHERE id = ?
+	`
+	webhook, err := scanWebhook(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Webhook{}, nil
+	}
+	return webhook, err
+}
+
+// GetWebhooksForEvent lists a user's webhooks subscribed to event.
+func (c Client) GetWebhooksForEvent(userID uuid.UUID, event string) ([]Webhook, error) {
+	webhooks, err := c.GetWebhooks(userID)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(webhooks, func(webhook Webhook) bool {
+		return !slices.Contains(webhook.Events, event)
+	}), nil
+}
+
+// DeleteWebhook deletes one of a user's webhooks, reporting whether it
+// existed.
+func (c Client) DeleteWebhook(id, userID uuid.UUID) (bool, error) {
+	return c.execAffectsRow(`DELETE FROM webhooks WHERE id = ? AND user_id = ?`, id, userID)
+}
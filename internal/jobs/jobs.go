@@ -0,0 +1,377 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+var ErrQueueClosed = errors.New("job queue is closed")
+
+const (
+	// DefaultMaxAttempts is how many times a job is tried unless its kind
+	// says otherwise
+	DefaultMaxAttempts = 5
+	defaultBackoff     = 10 * time.Second
+	defaultMaxBackoff  = time.Hour
+	// pollInterval is how often idle workers look for due jobs, which
+	// catches retries coming due and jobs queued by other servers
+	pollInterval = 2 * time.Second
+	// Running jobs are touched every heartbeatInterval, and ones left
+	// untouched for staleAfter are taken to belong to a server that stopped
+	heartbeatInterval = 30 * time.Second
+	staleAfter        = 5 * time.Minute
+)
+
+// Handler runs one attempt at a job. An error retries the job after a
+// backoff, unless it was the last attempt or the error is Permanent, when
+// the job is dead.
+type Handler func(ctx context.Context, job database.Job) error
+
+// Kind is how jobs of one kind are run.
+type Kind struct {
+	Handler Handler
+	// MaxAttempts is how many times a job is tried, DefaultMaxAttempts if 0
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubling after each one up
+	// to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout limits each attempt, 0 for none
+	Timeout time.Duration
+}
+
+// Store is where jobs are kept. *database.Client is one.
+type Store interface {
+	CreateJob(params database.CreateJobParams) (database.Job, error)
+	ClaimJob() (database.Job, error)
+	TouchJob(id uuid.UUID) error
+	CompleteJob(id uuid.UUID) error
+	RetryJob(id uuid.UUID, runAt time.Time, lastError string) error
+	ReleaseJob(id uuid.UUID) error
+	KillJob(id uuid.UUID, lastError string) error
+	ResetStaleJobs(staleBefore time.Time) (int64, error)
+}
+
+// DeadFunc hears about jobs that ran out of attempts or failed permanently.
+type DeadFunc func(job database.Job, err error)
+
+// ErrorFunc hears about errors reading or writing the store, after which
+// the queue carries on.
+type ErrorFunc func(err error)
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a handler's error as one retrying won't fix.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// Queue runs jobs kept in a Store on a pool of workers. Jobs outlive the
+// process, so ones queued or interrupted before a restart run after it, and
+// several servers sharing a store share its jobs.
+type Queue struct {
+	store   Store
+	kinds   map[string]Kind
+	workers int
+	onDead  DeadFunc
+	onError ErrorFunc
+	wake    chan struct{}
+
+	// stopping stops workers claiming jobs, and ctx is cancelled once
+	// running ones have had their chance to finish
+	stopping chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	mu      sync.RWMutex
+	started bool
+	closed  bool
+}
+
+// NewQueue returns a queue that runs jobs on workers goroutines once it's
+// started. Kinds must be registered before then.
+func NewQueue(store Store, workers int, onDead DeadFunc, onError ErrorFunc) *Queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Queue{
+		store:    store,
+		kinds:    map[string]Kind{},
+		workers:  workers,
+		onDead:   onDead,
+		onError:  onError,
+		wake:     make(chan struct{}, 1),
+		stopping: make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Register sets how jobs of a kind are run.
+func (q *Queue) Register(name string, kind Kind) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.started {
+		panic("jobs: Register called after Start")
+	}
+	if kind.MaxAttempts <= 0 {
+		kind.MaxAttempts = DefaultMaxAttempts
+	}
+	if kind.Backoff <= 0 {
+		kind.Backoff = defaultBackoff
+	}
+	if kind.MaxBackoff <= 0 {
+		kind.MaxBackoff = defaultMaxBackoff
+	}
+	q.kinds[name] = kind
+}
+
+// Start starts the workers, and puts back jobs left running by a server
+// that stopped.
+func (q *Queue) Start() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.started || q.closed {
+		return
+	}
+	q.started = true
+	for range q.workers {
+		q.wg.Add(1)
+		go q.work()
+	}
+	q.wg.Add(1)
+	go q.resetStale()
+}
+
+// Enqueue stores a job of a registered kind, with payload encoded as JSON,
+// to run as soon as a worker is free.
+func (q *Queue) Enqueue(kind string, payload any) (database.Job, error) {
+	return q.EnqueueAt(kind, payload, time.Now())
+}
+
+// EnqueueAt stores a job that isn't to run before runAt.
+func (q *Queue) EnqueueAt(kind string, payload any, runAt time.Time) (database.Job, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return database.Job{}, ErrQueueClosed
+	}
+	k, ok := q.kinds[kind]
+	if !ok {
+		return database.Job{}, fmt.Errorf("unknown job kind %q", kind)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return database.Job{}, err
+	}
+	job, err := q.store.CreateJob(database.CreateJobParams{
+		Kind:        kind,
+		Payload:     data,
+		MaxAttempts: k.MaxAttempts,
+		RunAt:       runAt,
+	})
+	if err != nil {
+		return database.Job{}, err
+	}
+	q.Wake()
+	return job, nil
+}
+
+// Wake has an idle worker look for due jobs now rather than at its next
+// poll, for jobs stored by something other than Enqueue, like a requeue.
+func (q *Queue) Wake() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops claiming jobs and waits for running ones to finish, or until
+// ctx is done, when they're cancelled and queued again for the next start.
+func (q *Queue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.stopping)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.stopping:
+			return
+		default:
+		}
+
+		job, err := q.store.ClaimJob()
+		if err != nil {
+			q.reportError(fmt.Errorf("claiming job: %w", err))
+		}
+		if err != nil || job.ID == uuid.Nil {
+			select {
+			case <-q.stopping:
+				return
+			case <-q.wake:
+			case <-ticker.C:
+			}
+			continue
+		}
+		q.run(job)
+		// Others may be due too
+		q.Wake()
+	}
+}
+
+func (q *Queue) run(job database.Job) {
+	kind, ok := q.kinds[job.Kind]
+	if !ok {
+		q.kill(job, fmt.Errorf("no handler for job kind %q", job.Kind))
+		return
+	}
+
+	ctx := q.ctx
+	if kind.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, kind.Timeout)
+		defer cancel()
+	}
+	stopHeartbeat := q.heartbeat(job.ID)
+	err := callHandler(ctx, kind.Handler, job)
+	stopHeartbeat()
+
+	switch {
+	case err == nil:
+		err = q.store.CompleteJob(job.ID)
+		if err != nil {
+			q.reportError(fmt.Errorf("completing job %s: %w", job.ID, err))
+		}
+	case q.ctx.Err() != nil:
+		// Shutdown interrupted it, which isn't the job's fault
+		err = q.store.ReleaseJob(job.ID)
+		if err != nil {
+			q.reportError(fmt.Errorf("releasing job %s: %w", job.ID, err))
+		}
+	case errors.As(err, &permanentError{}) || job.Attempts >= job.MaxAttempts:
+		q.kill(job, err)
+	default:
+		runAt := time.Now().Add(backoff(kind, job.Attempts))
+		err = q.store.RetryJob(job.ID, runAt, err.Error())
+		if err != nil {
+			q.reportError(fmt.Errorf("retrying job %s: %w", job.ID, err))
+		}
+	}
+}
+
+// callHandler runs a handler, turning a panic into an error so one bad job
+// doesn't take the worker down with it.
+func callHandler(ctx context.Context, handler Handler, job database.Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+func (q *Queue) kill(job database.Job, err error) {
+	storeErr := q.store.KillJob(job.ID, err.Error())
+	if storeErr != nil {
+		q.reportError(fmt.Errorf("killing job %s: %w", job.ID, storeErr))
+	}
+	if q.onDead != nil {
+		q.onDead(job, err)
+	}
+}
+
+// heartbeat touches a running job until the returned func is called.
+func (q *Queue) heartbeat(id uuid.UUID) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := q.store.TouchJob(id); err != nil {
+					q.reportError(fmt.Errorf("touching job %s: %w", id, err))
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// resetStale puts back jobs whose server stopped while running them, when
+// the queue starts and then every staleAfter.
+func (q *Queue) resetStale() {
+	defer q.wg.Done()
+	ticker := time.NewTicker(staleAfter)
+	defer ticker.Stop()
+	for {
+		n, err := q.store.ResetStaleJobs(time.Now().Add(-staleAfter))
+		if err != nil {
+			q.reportError(fmt.Errorf("resetting stale jobs: %w", err))
+		}
+		if n > 0 {
+			q.Wake()
+		}
+		select {
+		case <-q.stopping:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *Queue) reportError(err error) {
+	if q.onError != nil {
+		q.onError(err)
+	}
+}
+
+// backoff is how long to wait before retrying a job that has failed
+// attempts times.
+func backoff(kind Kind, attempts int) time.Duration {
+	delay := kind.Backoff
+	for i := 1; i < attempts && delay < kind.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, kind.MaxBackoff)
+}
@@ -0,0 +1,225 @@
+// Package metrics implements the handful of Prometheus metric types the
+// server exports, written out in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets suit latencies in seconds, from 5ms to 10s.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metrics and serves them to Prometheus.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// family is a metric and its series, one per combination of label values.
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	// value is the counter or gauge value, or the histogram's sum
+	value float64
+	// counts are the histogram's per-bucket counts, not cumulative
+	counts []uint64
+	count  uint64
+}
+
+func (r *Registry) register(name, help, kind string, buckets []float64, labels []string) *family {
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, f)
+	return f
+}
+
+func (f *family) with(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.kind == "histogram" {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct{ f *family }
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, "counter", nil, labels)}
+}
+
+// Add increases the counter for labelValues by v, which must not be
+// negative.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counters can't decrease")
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.with(labelValues).value += v
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct{ f *family }
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, "gauge", nil, labels)}
+}
+
+func (g *GaugeVec) Add(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.with(labelValues).value += v
+}
+
+func (g *GaugeVec) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *GaugeVec) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.with(labelValues).value = v
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct{ f *family }
+
+// NewHistogramVec creates a histogram with the given upper bucket bounds,
+// which must be sorted. The +Inf bucket is added automatically.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{r.register(name, help, "histogram", buckets, labels)}
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.with(labelValues)
+	s.value += v
+	s.count++
+	if i := sort.SearchFloat64s(h.f.buckets, v); i < len(h.f.buckets) {
+		s.counts[i]++
+	}
+}
+
+// ServeHTTP writes every metric in the text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	r.mu.Lock()
+	metrics := append([]*family(nil), r.metrics...)
+	r.mu.Unlock()
+	for _, f := range metrics {
+		f.write(out)
+	}
+}
+
+func (f *family) write(out *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(out, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(out, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != "histogram" {
+			fmt.Fprintf(out, "%s%s %s\n", f.name, f.labelString(s.labelValues, "", ""), formatFloat(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(out, "%s_bucket%s %d\n", f.name, f.labelString(s.labelValues, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(out, "%s_bucket%s %d\n", f.name, f.labelString(s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(out, "%s_sum%s %s\n", f.name, f.labelString(s.labelValues, "", ""), formatFloat(s.value))
+		fmt.Fprintf(out, "%s_count%s %d\n", f.name, f.labelString(s.labelValues, "", ""), s.count)
+	}
+}
+
+// labelString renders a series' labels, plus an extra one if extraName is
+// set.
+func (f *family) labelString(values []string, extraName, extraValue string) string {
+	pairs := []string{}
+	for i, name := range f.labels {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
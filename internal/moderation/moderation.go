@@ -0,0 +1,27 @@
+package moderation
+
+import "context"
+
+// Label is something a moderator found in an image, like "Explicit
+// Nudity", under a Parent category unless it's one itself, with a
+// Confidence out of 100.
+type Label struct {
+	Name       string
+	Parent     string
+	Confidence float64
+}
+
+// Moderator checks images for content that shouldn't be shown to everyone.
+type Moderator interface {
+	// ModerateImage returns the labels found in a JPEG image, none if it's
+	// fine.
+	ModerateImage(ctx context.Context, jpeg []byte) ([]Label, error)
+}
+
+// Noop finds nothing in any image, for trying out the moderation flow in
+// development without a backend.
+type Noop struct{}
+
+func (Noop) ModerateImage(ctx context.Context, jpeg []byte) ([]Label, error) {
+	return nil, nil
+}
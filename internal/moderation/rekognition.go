@@ -0,0 +1,47 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rekognition"
+	"github.com/aws/aws-sdk-go-v2/service/rekognition/types"
+)
+
+// maxRekognitionImageBytes is the most Rekognition takes in a request,
+// rather than read from S3.
+const maxRekognitionImageBytes = 5 << 20
+
+// Rekognition moderates images with Amazon Rekognition's
+// DetectModerationLabels. Labels below MinConfidence aren't returned.
+type Rekognition struct {
+	Client        *rekognition.Client
+	MinConfidence float64
+}
+
+func NewRekognition(client *rekognition.Client, minConfidence float64) *Rekognition {
+	return &Rekognition{Client: client, MinConfidence: minConfidence}
+}
+
+func (r *Rekognition) ModerateImage(ctx context.Context, jpeg []byte) ([]Label, error) {
+	if len(jpeg) > maxRekognitionImageBytes {
+		return nil, fmt.Errorf("image is %d bytes, Rekognition takes at most %d", len(jpeg), maxRekognitionImageBytes)
+	}
+	out, err := r.Client.DetectModerationLabels(ctx, &rekognition.DetectModerationLabelsInput{
+		Image:         &types.Image{Bytes: jpeg},
+		MinConfidence: aws.Float32(float32(r.MinConfidence)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("rekognition: %w", err)
+	}
+	labels := make([]Label, 0, len(out.ModerationLabels))
+	for _, label := range out.ModerationLabels {
+		labels = append(labels, Label{
+			Name:       aws.ToString(label.Name),
+			Parent:     aws.ToString(label.ParentName),
+			Confidence: float64(aws.ToFloat32(label.Confidence)),
+		})
+	}
+	return labels, nil
+}
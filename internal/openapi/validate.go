@@ -0,0 +1,155 @@
+package openapi
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// ValidationError is one way a request doesn't match its operation.
+type ValidationError struct {
+	In      string `json:"in"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s %s %s", e.In, e.Name, e.Message)
+}
+
+// Validate checks a request's path, query and header parameters against
+// op, and that a multipart body is sent as one. pathValues are the path
+// wildcards it matched. The parts of a multipart body aren't read, since
+// handlers stream uploads rather than have them buffered here, and JSON
+// bodies are left to their handlers to decode.
+func Validate(op *Operation, r *http.Request, pathValues map[string]string) []ValidationError {
+	errs := []ValidationError{}
+	query := r.URL.Query()
+	for _, param := range op.Parameters {
+		var values []string
+		switch param.In {
+		case InPath:
+			if value, ok := pathValues[param.Name]; ok {
+				values = []string{value}
+			}
+		case InQuery:
+			values = query[param.Name]
+		case InHeader:
+			values = r.Header.Values(param.Name)
+		}
+		if len(values) == 0 {
+			if param.Required {
+				errs = append(errs, ValidationError{In: param.In, Name: param.Name, Message: "is required"})
+			}
+			continue
+		}
+		if param.Schema == nil {
+			continue
+		}
+		schema := param.Schema
+		if schema.Type == "array" {
+			schema = schema.Items
+		} else if len(values) > 1 {
+			errs = append(errs, ValidationError{In: param.In, Name: param.Name, Message: "can only be given once"})
+			continue
+		}
+		for _, value := range values {
+			if message := checkValue(schema, value); message != "" {
+				errs = append(errs, ValidationError{In: param.In, Name: param.Name, Message: message})
+				break
+			}
+		}
+	}
+
+	if body := op.RequestBody; body != nil && len(body.Content) == 1 {
+		if _, ok := body.Content["multipart/form-data"]; ok {
+			mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+				errs = append(errs, ValidationError{In: InHeader, Name: "Content-Type", Message: "must be multipart/form-data with a boundary"})
+			}
+		}
+	}
+	return errs
+}
+
+// checkValue returns what's wrong with a parameter's value, or "" if
+// nothing is.
+func checkValue(schema *Schema, value string) string {
+	if schema == nil {
+		return ""
+	}
+	var number float64
+	switch schema.Type {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "must be an integer"
+		}
+		number = float64(n)
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "must be a number"
+		}
+		number = n
+	case "boolean":
+		if value != "true" && value != "false" {
+			return `must be "true" or "false"`
+		}
+	case "string":
+		if schema.Format == "uuid" {
+			if _, err := uuid.Parse(value); err != nil {
+				return "must be a UUID"
+			}
+		}
+		if schema.MaxLength != nil && utf8.RuneCountInString(value) > *schema.MaxLength {
+			return fmt.Sprintf("can be at most %d characters", *schema.MaxLength)
+		}
+	}
+	if schema.Type == "integer" || schema.Type == "number" {
+		if schema.Minimum != nil && number < *schema.Minimum {
+			return fmt.Sprintf("must be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && number > *schema.Maximum {
+			return fmt.Sprintf("must be at most %v", *schema.Maximum)
+		}
+	}
+	if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, value) {
+		return "must be one of " + strings.Join(schema.Enum, ", ")
+	}
+	return ""
+}
+
+// PathValues matches an escaped request path against a path with {name}
+// wildcards, like those in the document and http.ServeMux patterns,
+// returning the wildcards' unescaped values. It reports false if the path
+// doesn't match.
+func PathValues(pattern, escapedPath string) (map[string]string, bool) {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(escapedPath, "/"), "/")
+	if len(patternSegments) != len(pathSegments) {
+		return nil, false
+	}
+	values := map[string]string{}
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			value, err := url.PathUnescape(pathSegments[i])
+			if err != nil {
+				return nil, false
+			}
+			values[strings.TrimSuffix(strings.TrimPrefix(segment, "{"), "}")] = value
+			continue
+		}
+		if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+	return values, true
+}
@@ -0,0 +1,103 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is how much of the file goes in each INSTREAM chunk.
+const clamdChunkSize = 64 << 10
+
+// Clamd scans files with a ClamAV daemon over its INSTREAM command, so the
+// daemon doesn't need access to the server's files. clamd rejects streams
+// over its StreamMaxLength, which has to be raised to fit large videos.
+type Clamd struct {
+	// Network is "unix" or "tcp"
+	Network string
+	Address string
+}
+
+// NewClamd parses an address like "unix:/run/clamav/clamd.ctl" or
+// "tcp:localhost:3310".
+func NewClamd(address string) (*Clamd, error) {
+	network, addr, ok := strings.Cut(address, ":")
+	if !ok || addr == "" || (network != "unix" && network != "tcp") {
+		return nil, fmt.Errorf("clamd address %q must look like unix:/path or tcp:host:port", address)
+	}
+	return &Clamd{Network: network, Address: addr}, nil
+}
+
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return Result{}, fmt.Errorf("connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Unblock the connection if ctx is cancelled mid-scan
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	_, err = conn.Write([]byte("zINSTREAM\x00"))
+	if err != nil {
+		return Result{}, fmt.Errorf("clamd: %w", err)
+	}
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return Result{}, fmt.Errorf("clamd: %w", err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return Result{}, fmt.Errorf("clamd: %w", err)
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return Result{}, readErr
+		}
+	}
+	// A zero length chunk ends the stream
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return Result{}, fmt.Errorf("clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		if ctx.Err() != nil {
+			return Result{}, ctx.Err()
+		}
+		return Result{}, fmt.Errorf("clamd: %w", err)
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply reads replies like "stream: OK" and
+// "stream: Eicar-Signature FOUND".
+func parseClamdReply(reply string) (Result, error) {
+	_, verdict, _ := strings.Cut(reply, ": ")
+	switch {
+	case verdict == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	}
+	return Result{}, fmt.Errorf("clamd: %s", reply)
+}
@@ -0,0 +1,46 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Command scans files with an external program that reads the file on stdin
+// and, like clamscan, exits 0 if it's clean and 1 if it's infected, printing
+// what it found.
+type Command struct {
+	Name string
+	Args []string
+}
+
+// NewCommand splits a command line like "clamscan --no-summary -" on spaces.
+func NewCommand(commandLine string) (*Command, error) {
+	fields := strings.Fields(commandLine)
+	if len(fields) == 0 {
+		return nil, errors.New("scan command is empty")
+	}
+	return &Command{Name: fields[0], Args: fields[1:]}, nil
+}
+
+func (c *Command) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Stdin = r
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil {
+		return Result{}, nil
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return Result{Infected: true, Signature: strings.TrimSpace(stdout.String())}, nil
+	}
+	return Result{}, fmt.Errorf("%s: %w: %s", c.Name, err, strings.TrimSpace(stderr.String()))
+}
@@ -0,0 +1,18 @@
+package scan
+
+import (
+	"context"
+	"io"
+)
+
+// Result is a scanner's verdict on a file. Signature names what was found
+// when it's infected.
+type Result struct {
+	Infected  bool
+	Signature string
+}
+
+// Scanner checks files for malware before they're accepted.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+)
+
+// AzureConfig configures the Azure Blob Storage driver. ServiceURL defaults
+// to the account's public endpoint; set it to reach Azurite or another
+// cloud. A shared key is needed so URLs can be presigned.
+type AzureConfig struct {
+	AccountName string
+	AccountKey  string
+	Container   string
+	ServiceURL  string
+	// Uploads are sent in blocks of BlockSize with up to Concurrency in
+	// flight
+	BlockSize   int64
+	Concurrency int
+}
+
+func (c AzureConfig) serviceURL() string {
+	if c.ServiceURL != "" {
+		return strings.TrimSuffix(c.ServiceURL, "/") + "/"
+	}
+	return fmt.Sprintf("https://%s.blob.core.windows.net/", c.AccountName)
+}
+
+// BaseURL is where the container's objects can be read when it allows
+// public access.
+func (c AzureConfig) BaseURL() (string, error) {
+	u, err := url.Parse(c.serviceURL() + c.Container)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("%q is not an absolute URL", c.ServiceURL)
+	}
+	return u.String(), nil
+}
+
+// Azure stores objects as block blobs in an Azure Storage container.
+type Azure struct {
+	container   *container.Client
+	blockSize   int64
+	concurrency int
+}
+
+func NewAzure(cfg AzureConfig) (*Azure, error) {
+	if cfg.AccountName == "" || cfg.AccountKey == "" || cfg.Container == "" {
+		return nil, errors.New("account name, account key and container must all be set")
+	}
+	cred, err := azblob.NewSharedKeyCredential(cfg.AccountName, cfg.AccountKey)
+	if err != nil {
+		return nil, err
+	}
+	client, err := azblob.NewClientWithSharedKeyCredential(cfg.serviceURL(), cred, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Azure{
+		container:   client.ServiceClient().NewContainerClient(cfg.Container),
+		blockSize:   cfg.BlockSize,
+		concurrency: cfg.Concurrency,
+	}, nil
+}
+
+// Put uploads body in blocks, each with a CRC64 Azure checks on arrival.
+func (a *Azure) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (ObjectInfo, error) {
+	sha := sha256.New()
+	counter := &countingReader{r: io.TeeReader(body, sha)}
+	_, err := a.container.NewBlockBlobClient(key).UploadStream(ctx, counter, &azblob.UploadStreamOptions{
+		BlockSize:               a.blockSize,
+		Concurrency:             a.concurrency,
+		TransactionalValidation: blob.TransferValidationTypeComputeCRC64(),
+		HTTPHeaders: &blob.HTTPHeaders{
+			BlobContentType:        &opts.ContentType,
+			BlobCacheControl:       optionalString(opts.CacheControl),
+			BlobContentDisposition: optionalString(opts.ContentDisposition),
+		},
+	})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	// Azure happily stores an empty blob, so it has to be removed here
+	if opts.Size < 0 && counter.n == 0 {
+		if err := a.Delete(ctx, key); err != nil {
+			return ObjectInfo{}, fmt.Errorf("%w (and couldn't delete it: %v)", ErrEmptyObject, err)
+		}
+		return ObjectInfo{}, ErrEmptyObject
+	}
+	return ObjectInfo{
+		Key:            key,
+		Size:           counter.n,
+		LastModified:   time.Now(),
+		ChecksumSHA256: base64.StdEncoding.EncodeToString(sha.Sum(nil)),
+	}, nil
+}
+
+func (a *Azure) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return a.GetRange(ctx, key, 0, 0)
+}
+
+// GetRange reads to the end of the object when length is 0.
+func (a *Azure) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	resp, err := a.container.NewBlobClient(key).DownloadStream(ctx, &blob.DownloadStreamOptions{
+		Range: blob.HTTPRange{Offset: offset, Count: length},
+	})
+	if err != nil {
+		return nil, convertAzureError(err)
+	}
+	return resp.Body, nil
+}
+
+func (a *Azure) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	props, err := a.container.NewBlobClient(key).GetProperties(ctx, nil)
+	if err != nil {
+		return ObjectInfo{}, convertAzureError(err)
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         deref(props.ContentLength),
+		LastModified: deref(props.LastModified),
+	}, nil
+}
+
+func (a *Azure) Delete(ctx context.Context, key string) error {
+	_, err := a.container.NewBlobClient(key).Delete(ctx, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (a *Azure) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return a.container.NewBlobClient(key).GetSASURL(sas.BlobPermissions{Read: true}, time.Now().Add(expiry), nil)
+}
+
+// PresignPut can't sign the content type or size, so both have to be
+// checked once the upload is confirmed.
+func (a *Azure) PresignPut(ctx context.Context, key string, opts PutOptions, expiry time.Duration) (PresignedPut, error) {
+	signed, err := a.container.NewBlobClient(key).GetSASURL(sas.BlobPermissions{Create: true, Write: true}, time.Now().Add(expiry), nil)
+	if err != nil {
+		return PresignedPut{}, err
+	}
+	headers := map[string]string{
+		"Content-Type":   opts.ContentType,
+		"x-ms-blob-type": "BlockBlob",
+	}
+	if opts.CacheControl != "" {
+		headers["x-ms-blob-cache-control"] = opts.CacheControl
+	}
+	if opts.ContentDisposition != "" {
+		headers["x-ms-blob-content-disposition"] = opts.ContentDisposition
+	}
+	return PresignedPut{
+		URL:     signed,
+		Method:  http.MethodPut,
+		Headers: headers,
+	}, nil
+}
+
+func (a *Azure) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	pager := a.container.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: &prefix})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Segment.BlobItems {
+			object := ObjectInfo{Key: deref(item.Name)}
+			if item.Properties != nil {
+				object.Size = deref(item.Properties.ContentLength)
+				object.LastModified = deref(item.Properties.LastModified)
+				object.StorageClass = string(deref(item.Properties.AccessTier))
+			}
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
+
+func convertAzureError(err error) error {
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	return err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
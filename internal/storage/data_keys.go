@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+const (
+	dataKeySize = 32
+	// dataKeyPurpose is bound to every wrapped key, so keys wrapped for
+	// something else can't be passed off as ours
+	dataKeyPurpose = "tubely-object"
+)
+
+var dataKeyContext = map[string]string{"purpose": dataKeyPurpose}
+
+// LocalKeyWrapper wraps data keys with AES-256-GCM under a master key the
+// server holds itself.
+type LocalKeyWrapper struct {
+	aead cipher.AEAD
+}
+
+func NewLocalKeyWrapper(masterKey []byte) (*LocalKeyWrapper, error) {
+	if len(masterKey) != dataKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", dataKeySize, len(masterKey))
+	}
+	aead, err := newSegmentAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalKeyWrapper{aead: aead}, nil
+}
+
+// NewDataKey returns the key wrapped as a random nonce followed by the
+// sealed key.
+func (w *LocalKeyWrapper) NewDataKey(ctx context.Context) ([]byte, []byte, error) {
+	key := make([]byte, dataKeySize)
+	nonce := make([]byte, w.aead.NonceSize())
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return key, w.aead.Seal(nonce, nonce, key, []byte(dataKeyPurpose)), nil
+}
+
+func (w *LocalKeyWrapper) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < w.aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is too short")
+	}
+	nonce, sealed := wrapped[:w.aead.NonceSize()], wrapped[w.aead.NonceSize():]
+	return w.aead.Open(nil, nonce, sealed, []byte(dataKeyPurpose))
+}
+
+// KMSKeyWrapper has AWS KMS generate data keys under keyID and decrypt them
+// again, so the master key never leaves KMS.
+type KMSKeyWrapper struct {
+	client *kms.Client
+	keyID  string
+}
+
+func NewKMSKeyWrapper(client *kms.Client, keyID string) *KMSKeyWrapper {
+	return &KMSKeyWrapper{client: client, keyID: keyID}
+}
+
+func (w *KMSKeyWrapper) NewDataKey(ctx context.Context) ([]byte, []byte, error) {
+	out, err := w.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             &w.keyID,
+		KeySpec:           kmstypes.DataKeySpecAes256,
+		EncryptionContext: dataKeyContext,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return out.Plaintext, out.CiphertextBlob, nil
+}
+
+func (w *KMSKeyWrapper) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	out, err := w.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:             &w.keyID,
+		CiphertextBlob:    wrapped,
+		EncryptionContext: dataKeyContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
@@ -0,0 +1,431 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	encryptedMagic = "TBLYENC1"
+	// Plaintext is sealed in segments this big, each with its own GCM tag,
+	// so a range can be decrypted without reading from the start
+	encryptedSegmentSize  = 64 << 10
+	encryptedTagSize      = 16
+	encryptedSealedSize   = encryptedSegmentSize + encryptedTagSize
+	encryptedHeaderPrefix = len(encryptedMagic) + 2
+	// maxWrappedKeySize covers KMS ciphertext blobs with room to spare
+	maxWrappedKeySize = 1024
+	// maxCachedDataKeys bounds how many unwrapped keys are kept so seeking
+	// through a video doesn't unwrap its key on every range request
+	maxCachedDataKeys = 256
+)
+
+var ErrDecryption = errors.New("couldn't decrypt object")
+
+// KeyWrapper protects the data keys objects are encrypted with.
+type KeyWrapper interface {
+	// NewDataKey returns a new 256-bit key along with the wrapped form of
+	// it that's stored with the object.
+	NewDataKey(ctx context.Context) (key, wrapped []byte, err error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// Encrypted envelope-encrypts videos before they reach the store it wraps,
+// for deployments that can't rely on the bucket's own encryption. Each
+// object with a video/ content type gets a fresh AES-256-GCM data key,
+// stored wrapped by keys in a header ahead of the sealed segments. Get,
+// GetRange and Head decrypt them again, while other objects and ones
+// stored before encryption was turned on pass through untouched.
+//
+// Encrypted objects can only be read through the store, so URLs from
+// PresignGet don't work for them, and List reports their encrypted size.
+// Encrypted doesn't support presigned uploads, which would bypass it.
+type Encrypted struct {
+	store ObjectStore
+	keys  KeyWrapper
+
+	mu       sync.Mutex
+	dataKeys map[string][]byte
+}
+
+func NewEncrypted(store ObjectStore, keys KeyWrapper) *Encrypted {
+	return &Encrypted{store: store, keys: keys, dataKeys: map[string][]byte{}}
+}
+
+// encryptedSize is how big an object of plainSize bytes becomes. Even an
+// empty one has a segment, so its tag is checked.
+func encryptedSize(headerSize int, plainSize int64) int64 {
+	segments := max((plainSize+encryptedSegmentSize-1)/encryptedSegmentSize, 1)
+	return int64(headerSize) + plainSize + segments*encryptedTagSize
+}
+
+// encryptedSegments returns how many segments an encrypted object of size
+// bytes holds and the size of its plaintext.
+func encryptedSegments(headerSize int, size int64) (int64, int64, error) {
+	body := size - int64(headerSize)
+	if body < encryptedTagSize {
+		return 0, 0, fmt.Errorf("%w: object is truncated", ErrDecryption)
+	}
+	segments := (body + encryptedSealedSize - 1) / encryptedSealedSize
+	if body-(segments-1)*encryptedSealedSize < encryptedTagSize {
+		return 0, 0, fmt.Errorf("%w: object is truncated", ErrDecryption)
+	}
+	return segments, body - segments*encryptedTagSize, nil
+}
+
+// segmentNonce numbers segments and marks the last one, so they can't be
+// reordered, dropped or cut off without failing to open. Data keys are never
+// reused, so the nonces don't have to be random.
+func segmentNonce(index int64, final bool) []byte {
+	nonce := make([]byte, 12)
+	if final {
+		nonce[0] = 1
+	}
+	binary.BigEndian.PutUint64(nonce[4:], uint64(index))
+	return nonce
+}
+
+func newSegmentAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (e *Encrypted) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (ObjectInfo, error) {
+	if !strings.HasPrefix(opts.ContentType, "video/") {
+		return e.store.Put(ctx, key, body, opts)
+	}
+
+	dataKey, wrapped, err := e.keys.NewDataKey(ctx)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("creating data key: %w", err)
+	}
+	if len(wrapped) > maxWrappedKeySize {
+		return ObjectInfo{}, fmt.Errorf("wrapped data key is %d bytes, more than the %d allowed", len(wrapped), maxWrappedKeySize)
+	}
+	aead, err := newSegmentAEAD(dataKey)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	header := make([]byte, 0, encryptedHeaderPrefix+len(wrapped))
+	header = append(header, encryptedMagic...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+	reader := &encryptingReader{
+		aead:    aead,
+		src:     bufio.NewReaderSize(body, encryptedSegmentSize),
+		plain:   make([]byte, encryptedSegmentSize),
+		sealed:  make([]byte, 0, encryptedSealedSize),
+		pending: header,
+	}
+	if opts.Size >= 0 {
+		opts.Size = encryptedSize(len(header), opts.Size)
+	}
+
+	info, err := e.store.Put(ctx, key, reader, opts)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	// The header and an empty segment still made an object
+	if reader.plainSize == 0 {
+		if err := e.store.Delete(ctx, key); err != nil {
+			return ObjectInfo{}, fmt.Errorf("%w (and couldn't delete it: %v)", ErrEmptyObject, err)
+		}
+		return ObjectInfo{}, ErrEmptyObject
+	}
+	info.Size = reader.plainSize
+	return info, nil
+}
+
+// encryptingReader reads a header followed by src sealed segment by segment.
+type encryptingReader struct {
+	aead      cipher.AEAD
+	src       *bufio.Reader
+	plain     []byte
+	sealed    []byte
+	pending   []byte
+	index     int64
+	done      bool
+	plainSize int64
+}
+
+func (r *encryptingReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		err := r.sealNext()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *encryptingReader) sealNext() error {
+	n, err := io.ReadFull(r.src, r.plain)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	// A full segment is only the last one if nothing follows it
+	final := n < len(r.plain)
+	if !final {
+		_, err := r.src.Peek(1)
+		if errors.Is(err, io.EOF) {
+			final = true
+		} else if err != nil {
+			return err
+		}
+	}
+	r.pending = r.aead.Seal(r.sealed[:0], segmentNonce(r.index, final), r.plain[:n], nil)
+	r.plainSize += int64(n)
+	r.index++
+	r.done = final
+	return nil
+}
+
+// encryptedObject is what's needed to read an object that may be encrypted.
+// aead is nil for objects stored in the clear.
+type encryptedObject struct {
+	info       ObjectInfo
+	aead       cipher.AEAD
+	headerSize int
+	segments   int64
+}
+
+// open reads an object's header, if it has one, and unwraps its data key.
+// The returned info has the plaintext size.
+func (e *Encrypted) open(ctx context.Context, key string) (encryptedObject, error) {
+	info, err := e.store.Head(ctx, key)
+	if err != nil {
+		return encryptedObject{}, err
+	}
+	if info.Size < int64(encryptedHeaderPrefix) {
+		return encryptedObject{info: info}, nil
+	}
+	body, err := e.store.GetRange(ctx, key, 0, min(info.Size, int64(encryptedHeaderPrefix+maxWrappedKeySize)))
+	if err != nil {
+		return encryptedObject{}, err
+	}
+	defer body.Close()
+	header, err := io.ReadAll(body)
+	if err != nil {
+		return encryptedObject{}, err
+	}
+	if !bytes.HasPrefix(header, []byte(encryptedMagic)) {
+		return encryptedObject{info: info}, nil
+	}
+
+	headerSize := encryptedHeaderPrefix + int(binary.BigEndian.Uint16(header[len(encryptedMagic):]))
+	if len(header) < headerSize {
+		return encryptedObject{}, fmt.Errorf("%w: header is truncated", ErrDecryption)
+	}
+	segments, plainSize, err := encryptedSegments(headerSize, info.Size)
+	if err != nil {
+		return encryptedObject{}, err
+	}
+	dataKey, err := e.unwrapKey(ctx, header[encryptedHeaderPrefix:headerSize])
+	if err != nil {
+		return encryptedObject{}, err
+	}
+	aead, err := newSegmentAEAD(dataKey)
+	if err != nil {
+		return encryptedObject{}, err
+	}
+	info.Size = plainSize
+	return encryptedObject{info: info, aead: aead, headerSize: headerSize, segments: segments}, nil
+}
+
+func (e *Encrypted) unwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	e.mu.Lock()
+	dataKey, ok := e.dataKeys[string(wrapped)]
+	e.mu.Unlock()
+	if ok {
+		return dataKey, nil
+	}
+
+	dataKey, err := e.keys.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unwrapping data key: %w", ErrDecryption, err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.dataKeys) >= maxCachedDataKeys {
+		clear(e.dataKeys)
+	}
+	e.dataKeys[string(wrapped)] = dataKey
+	return dataKey, nil
+}
+
+func (e *Encrypted) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := e.open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if object.aead == nil {
+		return e.store.Get(ctx, key)
+	}
+	return e.readRange(ctx, key, object, 0, object.info.Size)
+}
+
+func (e *Encrypted) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	object, err := e.open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if object.aead == nil {
+		return e.store.GetRange(ctx, key, offset, length)
+	}
+	length = min(length, object.info.Size-offset)
+	if offset < 0 || length < 0 {
+		return nil, fmt.Errorf("range %d-%d is outside the %d byte object", offset, offset+length, object.info.Size)
+	}
+	return e.readRange(ctx, key, object, offset, length)
+}
+
+// readRange fetches and opens the segments holding length bytes from
+// offset.
+func (e *Encrypted) readRange(ctx context.Context, key string, object encryptedObject, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	first := offset / encryptedSegmentSize
+	last := (offset + length - 1) / encryptedSegmentSize
+	start := int64(object.headerSize) + first*encryptedSealedSize
+	end := min(int64(object.headerSize)+(last+1)*encryptedSealedSize, encryptedSize(object.headerSize, object.info.Size))
+	body, err := e.store.GetRange(ctx, key, start, end-start)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{
+		aead:      object.aead,
+		src:       body,
+		sealed:    make([]byte, encryptedSealedSize),
+		plain:     make([]byte, 0, encryptedSegmentSize),
+		index:     first,
+		final:     object.segments - 1,
+		skip:      offset - first*encryptedSegmentSize,
+		remaining: length,
+	}, nil
+}
+
+// decryptingReader opens the sealed segments read from src, starting with
+// segment index, and returns remaining bytes of them after skipping skip.
+type decryptingReader struct {
+	aead      cipher.AEAD
+	src       io.ReadCloser
+	sealed    []byte
+	plain     []byte
+	pending   []byte
+	index     int64
+	final     int64
+	skip      int64
+	remaining int64
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	for len(r.pending) == 0 {
+		err := r.openNext()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending[:min(int64(len(r.pending)), r.remaining)])
+	r.pending = r.pending[n:]
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+func (r *decryptingReader) openNext() error {
+	final := r.index == r.final
+	n, err := io.ReadFull(r.src, r.sealed)
+	if errors.Is(err, io.ErrUnexpectedEOF) && final {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: reading segment %d: %w", ErrDecryption, r.index, err)
+	}
+	plain, err := r.aead.Open(r.plain[:0], segmentNonce(r.index, final), r.sealed[:n], nil)
+	if err != nil {
+		return fmt.Errorf("%w: segment %d: %w", ErrDecryption, r.index, err)
+	}
+	r.pending = plain[r.skip:]
+	r.skip = 0
+	r.index++
+	return nil
+}
+
+func (r *decryptingReader) Close() error {
+	return r.src.Close()
+}
+
+func (e *Encrypted) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	object, err := e.open(ctx, key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return object.info, nil
+}
+
+func (e *Encrypted) Delete(ctx context.Context, key string) error {
+	return e.store.Delete(ctx, key)
+}
+
+func (e *Encrypted) DeleteMany(ctx context.Context, keys []string) (map[string]error, error) {
+	if batch, ok := e.store.(BatchDeleter); ok {
+		return batch.DeleteMany(ctx, keys)
+	}
+	failed := map[string]error{}
+	for _, key := range keys {
+		if err := e.store.Delete(ctx, key); err != nil {
+			failed[key] = err
+		}
+	}
+	return failed, nil
+}
+
+// Copy copies an object as it's stored, which needs no keys since data keys
+// aren't tied to the key an object is stored under. It's done in the store
+// itself where that's supported.
+func (e *Encrypted) Copy(ctx context.Context, srcKey, dstKey string, opts PutOptions) (ObjectInfo, error) {
+	if copier, ok := e.store.(Copier); ok {
+		return copier.Copy(ctx, srcKey, dstKey, opts)
+	}
+	info, err := e.store.Head(ctx, srcKey)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	body, err := e.store.Get(ctx, srcKey)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer body.Close()
+	opts.Size = info.Size
+	return e.store.Put(ctx, dstKey, body, opts)
+}
+
+func (e *Encrypted) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return e.store.PresignGet(ctx, key, expiry)
+}
+
+func (e *Encrypted) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	return e.store.List(ctx, prefix)
+}
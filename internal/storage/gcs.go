@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// GCSConfig configures the Google Cloud Storage driver. Without a
+// CredentialsFile the usual application default credentials are used.
+// Presigning needs credentials that can sign, such as a service account key.
+type GCSConfig struct {
+	Bucket          string
+	CredentialsFile string
+	// ChunkSize is how much of an upload is buffered per request. Zero
+	// uses the client's default.
+	ChunkSize int
+}
+
+// BaseURL is where the bucket's objects can be read when it's public.
+func (c GCSConfig) BaseURL() string {
+	return "https://storage.googleapis.com/" + c.Bucket
+}
+
+// GCS stores objects in a Google Cloud Storage bucket.
+type GCS struct {
+	bucket    *gcs.BucketHandle
+	chunkSize int
+}
+
+func NewGCS(ctx context.Context, cfg GCSConfig) (*GCS, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("no bucket set")
+	}
+	opts := []option.ClientOption{}
+	if cfg.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(cfg.CredentialsFile))
+	}
+	client, err := gcs.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &GCS{bucket: client.Bucket(cfg.Bucket), chunkSize: cfg.ChunkSize}, nil
+}
+
+// Put uploads body and compares the CRC32C GCS computed with our own,
+// deleting the object if they differ.
+func (g *GCS) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (ObjectInfo, error) {
+	// Cancelling the context is the only way to abandon a GCS upload
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := g.bucket.Object(key).NewWriter(ctx)
+	w.ContentType = opts.ContentType
+	w.CacheControl = opts.CacheControl
+	w.ContentDisposition = opts.ContentDisposition
+	if g.chunkSize > 0 {
+		w.ChunkSize = g.chunkSize
+	}
+
+	sha := sha256.New()
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	size, err := io.Copy(io.MultiWriter(w, sha, crc), body)
+	if err != nil {
+		cancel()
+		w.Close()
+		return ObjectInfo{}, err
+	}
+	if opts.Size < 0 && size == 0 {
+		cancel()
+		w.Close()
+		return ObjectInfo{}, ErrEmptyObject
+	}
+	err = w.Close()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	attrs := w.Attrs()
+	if attrs.CRC32C != crc.Sum32() {
+		if err := g.Delete(ctx, key); err != nil {
+			return ObjectInfo{}, fmt.Errorf("%w (and couldn't delete it: %v)", ErrChecksumMismatch, err)
+		}
+		return ObjectInfo{}, ErrChecksumMismatch
+	}
+	return ObjectInfo{
+		Key:            key,
+		Size:           attrs.Size,
+		LastModified:   attrs.Updated,
+		ChecksumSHA256: base64.StdEncoding.EncodeToString(sha.Sum(nil)),
+	}, nil
+}
+
+func (g *GCS) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return g.GetRange(ctx, key, 0, -1)
+}
+
+func (g *GCS) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	r, err := g.bucket.Object(key).NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, convertGCSError(err)
+	}
+	return r, nil
+}
+
+func (g *GCS) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	attrs, err := g.bucket.Object(key).Attrs(ctx)
+	if err != nil {
+		return ObjectInfo{}, convertGCSError(err)
+	}
+	return ObjectInfo{Key: key, Size: attrs.Size, LastModified: attrs.Updated}, nil
+}
+
+func (g *GCS) Delete(ctx context.Context, key string) error {
+	err := g.bucket.Object(key).Delete(ctx)
+	if errors.Is(err, gcs.ErrObjectNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (g *GCS) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return g.bucket.SignedURL(key, &gcs.SignedURLOptions{
+		Scheme:  gcs.SigningSchemeV4,
+		Method:  http.MethodGet,
+		Expires: time.Now().Add(expiry),
+	})
+}
+
+// PresignPut signs the content type and, through x-goog-content-length-range,
+// the exact size.
+func (g *GCS) PresignPut(ctx context.Context, key string, opts PutOptions, expiry time.Duration) (PresignedPut, error) {
+	lengthRange := fmt.Sprintf("%d,%d", opts.Size, opts.Size)
+	headers := map[string]string{
+		"Content-Type":                opts.ContentType,
+		"x-goog-content-length-range": lengthRange,
+	}
+	signedHeaders := []string{"x-goog-content-length-range:" + lengthRange}
+	if opts.CacheControl != "" {
+		headers["Cache-Control"] = opts.CacheControl
+		signedHeaders = append(signedHeaders, "Cache-Control:"+opts.CacheControl)
+	}
+	if opts.ContentDisposition != "" {
+		headers["Content-Disposition"] = opts.ContentDisposition
+		signedHeaders = append(signedHeaders, "Content-Disposition:"+opts.ContentDisposition)
+	}
+	signed, err := g.bucket.SignedURL(key, &gcs.SignedURLOptions{
+		Scheme:      gcs.SigningSchemeV4,
+		Method:      http.MethodPut,
+		Expires:     time.Now().Add(expiry),
+		ContentType: opts.ContentType,
+		Headers:     signedHeaders,
+	})
+	if err != nil {
+		return PresignedPut{}, err
+	}
+	return PresignedPut{
+		URL:     signed,
+		Method:  http.MethodPut,
+		Headers: headers,
+	}, nil
+}
+
+func (g *GCS) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	it := g.bucket.Objects(ctx, &gcs.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, ObjectInfo{Key: attrs.Name, Size: attrs.Size, LastModified: attrs.Updated, StorageClass: attrs.StorageClass})
+	}
+}
+
+func convertGCSError(err error) error {
+	if errors.Is(err, gcs.ErrObjectNotExist) {
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	return err
+}
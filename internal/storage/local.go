@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// localTempPrefix marks files still being written, which List and ServeHTTP
+// skip.
+const localTempPrefix = ".tubely-tmp-"
+
+// Local keeps objects as files under a directory, for running without S3.
+// It serves them itself: mount it so that baseURL + "/" + key reaches
+// ServeHTTP with the key as the path. Presigned URLs are signed with secret,
+// and when private is set they're the only way to read an object.
+type Local struct {
+	root    string
+	baseURL string
+	secret  []byte
+	private bool
+}
+
+func NewLocal(root, baseURL string, secret []byte, private bool) (*Local, error) {
+	err := os.MkdirAll(root, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &Local{
+		root:    root,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  secret,
+		private: private,
+	}, nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	if !filepath.IsLocal(key) || strings.HasPrefix(path.Base(key), localTempPrefix) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes body to a temporary file and moves it into place, so readers
+// never see part of an object.
+func (l *Local) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (ObjectInfo, error) {
+	dest, err := l.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	err = os.MkdirAll(filepath.Dir(dest), 0o755)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	file, err := os.CreateTemp(filepath.Dir(dest), localTempPrefix+"*")
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, h), NewContextReader(ctx, body))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if opts.Size >= 0 && size != opts.Size {
+		return ObjectInfo{}, fmt.Errorf("got %d bytes, expected %d", size, opts.Size)
+	}
+	if opts.Size < 0 && size == 0 {
+		return ObjectInfo{}, ErrEmptyObject
+	}
+	err = file.Close()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	err = os.Rename(file.Name(), dest)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{
+		Key:            key,
+		Size:           size,
+		LastModified:   time.Now(),
+		ChecksumSHA256: base64.StdEncoding.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+func (l *Local) open(key string) (*os.File, error) {
+	filePath, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return file, err
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return l.open(key)
+}
+
+func (l *Local) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	file, err := l.open(key)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(file, offset, length), file}, nil
+}
+
+// Head leaves ChecksumSHA256 empty, since it would mean reading the whole
+// file.
+func (l *Local) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	filePath, err := l.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := os.Stat(filePath)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()}, nil
+}
+
+// Delete also removes the directories the object leaves empty.
+func (l *Local) Delete(ctx context.Context, key string) error {
+	filePath, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for dir := filepath.Dir(filePath); dir != filepath.Clean(l.root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (l *Local) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if _, err := l.path(key); err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("signature", l.sign(key, expires))
+	return l.baseURL + "/" + key + "?" + query.Encode(), nil
+}
+
+func (l *Local) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify reports whether a request carries an unexpired signature for key.
+func (l *Local) verify(key string, query url.Values) bool {
+	expires := query.Get("expires")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	return hmac.Equal([]byte(query.Get("signature")), []byte(l.sign(key, expires)))
+}
+
+func (l *Local) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	// Only walk the directory the prefix is in, not the whole store
+	start := filepath.Join(l.root, filepath.FromSlash(path.Dir("/"+prefix+"x")))
+	err := filepath.WalkDir(start, func(filePath string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), localTempPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(l.root, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// ServeHTTP serves the object named by the request path, with range
+// requests so videos can be seeked. The content type comes from the key's
+// extension.
+func (l *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	signed := r.URL.Query().Has("signature")
+	if signed && !l.verify(key, r.URL.Query()) {
+		http.Error(w, "Invalid or expired signature", http.StatusForbidden)
+		return
+	}
+	if l.private && !signed {
+		http.Error(w, "Object requires a signed URL", http.StatusForbidden)
+		return
+	}
+
+	file, err := l.open(key)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, path.Base(key), info.ModTime(), file)
+}
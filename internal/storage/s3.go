@@ -0,0 +1,384 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	DefaultPartSize          = 16 << 20
+	MinPartSize              = 5 << 20
+	DefaultUploadConcurrency = 4
+
+	// maxDeleteObjectsKeys is the most keys S3 accepts in one DeleteObjects
+	// call.
+	maxDeleteObjectsKeys = 1000
+)
+
+// S3 stores objects in an S3 bucket, or one on an S3-compatible service.
+// Objects at least PartSize big, or of unknown size, are sent as multipart
+// uploads with up to Concurrency parts in flight.
+type S3 struct {
+	client      *s3.Client
+	bucket      string
+	partSize    int64
+	concurrency int
+	encryption  S3Encryption
+}
+
+// S3Encryption is the server-side encryption requested for stored objects.
+// With an empty Algorithm the bucket's default applies. KMSKeyID and
+// BucketKey only apply to the "aws:kms" algorithms, and an empty KMSKeyID
+// means the AWS managed key.
+type S3Encryption struct {
+	Algorithm types.ServerSideEncryption
+	KMSKeyID  string
+	// BucketKey has S3 use a bucket-level key, cutting down on KMS calls
+	BucketKey bool
+}
+
+func (e S3Encryption) kmsKeyID() *string {
+	if e.KMSKeyID == "" {
+		return nil
+	}
+	return &e.KMSKeyID
+}
+
+func (e S3Encryption) bucketKeyEnabled() *bool {
+	if !e.BucketKey {
+		return nil
+	}
+	return aws.Bool(true)
+}
+
+func NewS3(client *s3.Client, bucket string, partSize int64, concurrency int, encryption S3Encryption) *S3 {
+	return &S3{
+		client:      client,
+		bucket:      bucket,
+		partSize:    partSize,
+		concurrency: concurrency,
+		encryption:  encryption,
+	}
+}
+
+// Put uploads body with its SHA-256 attached and reads the checksum S3
+// recorded back to confirm nothing was corrupted in transit. A mismatched
+// object is deleted.
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (ObjectInfo, error) {
+	var (
+		checksum string
+		size     = opts.Size
+		err      error
+	)
+	readerAt, seekable := body.(io.ReaderAt)
+	switch {
+	case size >= s.partSize && seekable:
+		checksum, err = s.putMultipartObject(ctx, key, opts, readerAt, size)
+	case size >= 0 && size < s.partSize:
+		if seeker, ok := body.(io.ReadSeeker); ok {
+			checksum, err = s.putSingleObject(ctx, key, opts, seeker)
+			break
+		}
+		fallthrough
+	default:
+		checksum, size, err = s.putMultipartStream(ctx, key, opts, body)
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	encryption, err := s.verifyChecksum(ctx, key, checksum)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Key:            key,
+		Size:           size,
+		LastModified:   time.Now(),
+		ChecksumSHA256: checksum,
+		Encryption:     encryption,
+	}, nil
+}
+
+func (s *S3) putSingleObject(ctx context.Context, key string, opts PutOptions, body io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	_, err := io.Copy(h, body)
+	if err != nil {
+		return "", err
+	}
+	checksum := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	_, err = body.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:               &s.bucket,
+		Key:                  &key,
+		Body:                 body,
+		ContentType:          &opts.ContentType,
+		CacheControl:         optionalString(opts.CacheControl),
+		ContentDisposition:   optionalString(opts.ContentDisposition),
+		ChecksumSHA256:       &checksum,
+		StorageClass:         types.StorageClass(opts.StorageClass),
+		Tagging:              encodeTags(opts.Tags),
+		ServerSideEncryption: s.encryption.Algorithm,
+		SSEKMSKeyId:          s.encryption.kmsKeyID(),
+		BucketKeyEnabled:     s.encryption.bucketKeyEnabled(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return checksum, nil
+}
+
+// checksumsEqual compares base64 checksums, ignoring the "-<parts>" suffix
+// S3 may or may not add to composite multipart checksums.
+func checksumsEqual(a, b string) bool {
+	a, _, _ = strings.Cut(a, "-")
+	b, _, _ = strings.Cut(b, "-")
+	return a == b
+}
+
+// verifyChecksum compares the checksum S3 holds for key with the one we
+// computed, deleting the object if they differ. It returns the encryption S3
+// applied to the object.
+func (s *S3) verifyChecksum(ctx context.Context, key, checksum string) (string, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       &s.bucket,
+		Key:          &key,
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return "", err
+	}
+	if head.ChecksumSHA256 == nil || !checksumsEqual(*head.ChecksumSHA256, checksum) {
+		if err := s.Delete(ctx, key); err != nil {
+			return "", fmt.Errorf("%w (and couldn't delete it: %v)", ErrChecksumMismatch, err)
+		}
+		return "", ErrChecksumMismatch
+	}
+	return string(head.ServerSideEncryption), nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, convertS3Error(err)
+	}
+	return out.Body, nil
+}
+
+func (s *S3) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	byteRange := fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+		Range:  &byteRange,
+	})
+	if err != nil {
+		return nil, convertS3Error(err)
+	}
+	return out.Body, nil
+}
+
+func (s *S3) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       &s.bucket,
+		Key:          &key,
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return ObjectInfo{}, convertS3Error(err)
+	}
+	return ObjectInfo{
+		Key:            key,
+		Size:           aws.ToInt64(head.ContentLength),
+		LastModified:   aws.ToTime(head.LastModified),
+		ChecksumSHA256: aws.ToString(head.ChecksumSHA256),
+		Encryption:     string(head.ServerSideEncryption),
+	}, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	})
+	return err
+}
+
+// DeleteMany removes keys in as few DeleteObjects calls as possible.
+func (s *S3) DeleteMany(ctx context.Context, keys []string) (map[string]error, error) {
+	failed := map[string]error{}
+	for start := 0; start < len(keys); start += maxDeleteObjectsKeys {
+		end := min(start+maxDeleteObjectsKeys, len(keys))
+
+		objects := make([]types.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		out, err := s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &s.bucket,
+			Delete: &types.Delete{
+				Objects: objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, objErr := range out.Errors {
+			failed[aws.ToString(objErr.Key)] = errors.New(aws.ToString(objErr.Message))
+		}
+	}
+	return failed, nil
+}
+
+func (s *S3) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	presigned, err := s3.NewPresignClient(s.client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", err
+	}
+	return presigned.URL, nil
+}
+
+func (s *S3) PresignPut(ctx context.Context, key string, opts PutOptions, expiry time.Duration) (PresignedPut, error) {
+	presigned, err := s3.NewPresignClient(s.client).PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:               &s.bucket,
+		Key:                  &key,
+		ContentType:          &opts.ContentType,
+		CacheControl:         optionalString(opts.CacheControl),
+		ContentDisposition:   optionalString(opts.ContentDisposition),
+		ContentLength:        &opts.Size,
+		StorageClass:         types.StorageClass(opts.StorageClass),
+		Tagging:              encodeTags(opts.Tags),
+		ServerSideEncryption: s.encryption.Algorithm,
+		SSEKMSKeyId:          s.encryption.kmsKeyID(),
+		BucketKeyEnabled:     s.encryption.bucketKeyEnabled(),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return PresignedPut{}, err
+	}
+
+	// Signed headers have to be sent exactly as they were signed
+	headers := map[string]string{"Content-Type": opts.ContentType}
+	if opts.CacheControl != "" {
+		headers["Cache-Control"] = opts.CacheControl
+	}
+	if opts.ContentDisposition != "" {
+		headers["Content-Disposition"] = opts.ContentDisposition
+	}
+	if opts.StorageClass != "" {
+		headers["x-amz-storage-class"] = opts.StorageClass
+	}
+	if tagging := encodeTags(opts.Tags); tagging != nil {
+		headers["x-amz-tagging"] = *tagging
+	}
+	if s.encryption.Algorithm != "" {
+		headers["x-amz-server-side-encryption"] = string(s.encryption.Algorithm)
+	}
+	if s.encryption.KMSKeyID != "" {
+		headers["x-amz-server-side-encryption-aws-kms-key-id"] = s.encryption.KMSKeyID
+	}
+	if s.encryption.BucketKey {
+		headers["x-amz-server-side-encryption-bucket-key-enabled"] = "true"
+	}
+	return PresignedPut{
+		URL:     presigned.URL,
+		Method:  presigned.Method,
+		Headers: headers,
+	}, nil
+}
+
+// SetTags replaces every tag on an object.
+func (s *S3) SetTags(ctx context.Context, key string, tags map[string]string) error {
+	tagSet := []types.Tag{}
+	for _, name := range slices.Sorted(maps.Keys(tags)) {
+		tagSet = append(tagSet, types.Tag{Key: aws.String(name), Value: aws.String(tags[name])})
+	}
+	_, err := s.client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  &s.bucket,
+		Key:     &key,
+		Tagging: &types.Tagging{TagSet: tagSet},
+	})
+	return convertS3Error(err)
+}
+
+// encodeTags formats tags as the query string S3 expects on uploads, nil
+// when there are none.
+func encodeTags(tags map[string]string) *string {
+	if len(tags) == 0 {
+		return nil
+	}
+	values := url.Values{}
+	for name, value := range tags {
+		values.Set(name, value)
+	}
+	return aws.String(values.Encode())
+}
+
+// optionalString leaves a header out of a request when it's empty.
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// List follows pagination until every key under prefix is read.
+func (s *S3) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(object.Key),
+				Size:         aws.ToInt64(object.Size),
+				LastModified: aws.ToTime(object.LastModified),
+				StorageClass: string(object.StorageClass),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func convertS3Error(err error) error {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	// Operations without a modeled NoSuchKey error only carry its code
+	var apiErr smithy.APIError
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) || (errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchKey") {
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// maxCopyObjectSize is the biggest object CopyObject copies in one call
+	maxCopyObjectSize = 5 << 30
+	// copyPartSize is how much of the source each part of a multipart copy
+	// takes. Nothing passes through us, so parts can be far bigger than
+	// uploaded ones.
+	copyPartSize = 512 << 20
+)
+
+// Copy copies an object inside the bucket with CopyObject, or part by part
+// with UploadPartCopy once it's too big for that.
+func (s *S3) Copy(ctx context.Context, srcKey, dstKey string, opts PutOptions) (ObjectInfo, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &s.bucket,
+		Key:    &srcKey,
+	})
+	if err != nil {
+		return ObjectInfo{}, convertS3Error(err)
+	}
+	size := aws.ToInt64(head.ContentLength)
+	if size > maxCopyObjectSize {
+		return s.copyMultipart(ctx, srcKey, dstKey, opts, size)
+	}
+
+	out, err := s.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:               &s.bucket,
+		Key:                  &dstKey,
+		CopySource:           aws.String(s.copySource(srcKey)),
+		MetadataDirective:    types.MetadataDirectiveReplace,
+		ContentType:          &opts.ContentType,
+		CacheControl:         optionalString(opts.CacheControl),
+		ContentDisposition:   optionalString(opts.ContentDisposition),
+		StorageClass:         types.StorageClass(opts.StorageClass),
+		Tagging:              encodeTags(opts.Tags),
+		TaggingDirective:     types.TaggingDirectiveReplace,
+		ChecksumAlgorithm:    types.ChecksumAlgorithmSha256,
+		ServerSideEncryption: s.encryption.Algorithm,
+		SSEKMSKeyId:          s.encryption.kmsKeyID(),
+		BucketKeyEnabled:     s.encryption.bucketKeyEnabled(),
+	})
+	if err != nil {
+		return ObjectInfo{}, convertS3Error(err)
+	}
+	info := ObjectInfo{
+		Key:          dstKey,
+		Size:         size,
+		LastModified: time.Now(),
+		Encryption:   string(out.ServerSideEncryption),
+	}
+	if out.CopyObjectResult != nil {
+		info.ChecksumSHA256 = aws.ToString(out.CopyObjectResult.ChecksumSHA256)
+	}
+	return info, nil
+}
+
+// copySource is how CopyObject and UploadPartCopy want a key in this bucket
+// named.
+func (s *S3) copySource(key string) string {
+	return (&url.URL{Path: s.bucket + "/" + key}).EscapedPath()
+}
+
+// copyMultipart copies size bytes of srcKey in copyPartSize ranges, with up
+// to s.concurrency in flight.
+func (s *S3) copyMultipart(ctx context.Context, srcKey, dstKey string, opts PutOptions, size int64) (ObjectInfo, error) {
+	partSize := max(int64(copyPartSize), (size+maxS3Parts-1)/maxS3Parts)
+	partCount := int((size + partSize - 1) / partSize)
+
+	uploadID, err := s.createMultipartUpload(ctx, dstKey, opts)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parts := make([]types.CompletedPart, partCount)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	inFlight := make(chan struct{}, s.concurrency)
+	for i := 0; i < partCount && ctx.Err() == nil; i++ {
+		inFlight <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+			offset := int64(i) * partSize
+			end := min(offset+partSize, size) - 1
+			out, err := s.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+				Bucket:          &s.bucket,
+				Key:             &dstKey,
+				UploadId:        uploadID,
+				PartNumber:      aws.Int32(int32(i + 1)),
+				CopySource:      aws.String(s.copySource(srcKey)),
+				CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, end)),
+			})
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf("copy part %d: %w", i+1, err)
+					cancel()
+				})
+				return
+			}
+			parts[i] = types.CompletedPart{
+				ETag:           out.CopyPartResult.ETag,
+				PartNumber:     aws.Int32(int32(i + 1)),
+				ChecksumSHA256: out.CopyPartResult.ChecksumSHA256,
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return ObjectInfo{}, s.abortMultipartUpload(ctx, dstKey, uploadID, firstErr)
+	}
+
+	out, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &s.bucket,
+		Key:             &dstKey,
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return ObjectInfo{}, s.abortMultipartUpload(ctx, dstKey, uploadID, err)
+	}
+	return ObjectInfo{
+		Key:            dstKey,
+		Size:           size,
+		LastModified:   time.Now(),
+		ChecksumSHA256: aws.ToString(out.ChecksumSHA256),
+		Encryption:     string(out.ServerSideEncryption),
+	}, nil
+}
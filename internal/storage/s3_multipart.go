@@ -0,0 +1,297 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	maxS3Parts      = 10000
+	maxPartAttempts = 3
+)
+
+// putMultipartObject uploads a file in parts of s.partSize, with up to
+// s.concurrency parts in flight. Each part carries its own SHA-256
+// and is retried on failure, so a blip doesn't restart the whole upload. The
+// returned checksum is the composite one S3 computes for multipart objects.
+func (s *S3) putMultipartObject(ctx context.Context, key string, opts PutOptions, file io.ReaderAt, size int64) (string, error) {
+	partSize := s.partSize
+	// S3 caps uploads at 10,000 parts, so grow the parts for huge files
+	if (size+partSize-1)/partSize > maxS3Parts {
+		partSize = (size + maxS3Parts - 1) / maxS3Parts
+	}
+	partCount := int((size + partSize - 1) / partSize)
+
+	uploadID, err := s.createMultipartUpload(ctx, key, opts)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parts := make([]types.CompletedPart, partCount)
+	digests := make([][]byte, partCount)
+	partNumbers := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	concurrency := min(s.concurrency, partCount)
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range partNumbers {
+				// Drain the remaining parts without uploading once one fails
+				if ctx.Err() != nil {
+					continue
+				}
+				offset := int64(i) * partSize
+				length := min(partSize, size-offset)
+				part, digest, err := s.uploadPart(ctx, key, uploadID, int32(i+1), io.NewSectionReader(file, offset, length), length)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					continue
+				}
+				parts[i] = part
+				digests[i] = digest
+			}
+		}()
+	}
+
+	for i := 0; i < partCount; i++ {
+		if ctx.Err() != nil {
+			break
+		}
+		partNumbers <- i
+	}
+	close(partNumbers)
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return "", s.abortMultipartUpload(ctx, key, uploadID, firstErr)
+	}
+	return s.completeMultipartUpload(ctx, key, uploadID, parts, digests)
+}
+
+// putMultipartStream uploads body as it's read, in parts of s.partSize with
+// up to s.concurrency in flight, so nothing is written to
+// disk. At most that many parts are held in memory at once. It returns the
+// composite checksum S3 computes and how many bytes were uploaded.
+func (s *S3) putMultipartStream(ctx context.Context, key string, opts PutOptions, body io.Reader) (string, int64, error) {
+	uploadID, err := s.createMultipartUpload(ctx, key, opts)
+	if err != nil {
+		return "", 0, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		parts    []types.CompletedPart
+		digests  [][]byte
+		errOnce  sync.Once
+		firstErr error
+		size     int64
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	// Each buffer is reused once its part is uploaded
+	buffers := make(chan []byte, s.concurrency)
+	for range s.concurrency {
+		buffers <- make([]byte, s.partSize)
+	}
+
+	for i := 0; ; i++ {
+		var buf []byte
+		select {
+		case buf = <-buffers:
+		case <-ctx.Done():
+		}
+		if buf == nil {
+			break
+		}
+		n, err := io.ReadFull(body, buf)
+		if n == 0 {
+			buffers <- buf
+		} else if i == maxS3Parts {
+			fail(fmt.Errorf("upload is larger than %d parts", maxS3Parts))
+			break
+		} else {
+			size += int64(n)
+			mu.Lock()
+			parts = append(parts, types.CompletedPart{})
+			digests = append(digests, nil)
+			mu.Unlock()
+
+			wg.Add(1)
+			go func(i int, buf []byte, n int) {
+				defer wg.Done()
+				defer func() { buffers <- buf }()
+				body := io.NewSectionReader(bytes.NewReader(buf[:n]), 0, int64(n))
+				part, digest, err := s.uploadPart(ctx, key, uploadID, int32(i+1), body, int64(n))
+				if err != nil {
+					fail(err)
+					return
+				}
+				mu.Lock()
+				parts[i] = part
+				digests[i] = digest
+				mu.Unlock()
+			}(i, buf, n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			fail(err)
+			break
+		}
+	}
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr == nil && size == 0 {
+		firstErr = ErrEmptyObject
+	}
+	if firstErr != nil {
+		return "", 0, s.abortMultipartUpload(ctx, key, uploadID, firstErr)
+	}
+
+	checksum, err := s.completeMultipartUpload(ctx, key, uploadID, parts, digests)
+	if err != nil {
+		return "", 0, err
+	}
+	return checksum, size, nil
+}
+
+// completeMultipartUpload assembles uploaded parts into the object, aborting
+// the upload if that fails, and returns the composite checksum S3 reports
+// for it.
+func (s *S3) completeMultipartUpload(ctx context.Context, key string, uploadID *string, parts []types.CompletedPart, digests [][]byte) (string, error) {
+	_, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &s.bucket,
+		Key:             &key,
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return "", s.abortMultipartUpload(ctx, key, uploadID, err)
+	}
+
+	// S3 reports multipart checksums as the hash of the part hashes
+	h := sha256.New()
+	for _, digest := range digests {
+		h.Write(digest)
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(h.Sum(nil)), len(parts)), nil
+}
+
+func (s *S3) uploadPart(ctx context.Context, key string, uploadID *string, partNumber int32, body *io.SectionReader, length int64) (types.CompletedPart, []byte, error) {
+	h := sha256.New()
+	_, err := io.Copy(h, body)
+	if err != nil {
+		return types.CompletedPart{}, nil, err
+	}
+	digest := h.Sum(nil)
+	checksum := base64.StdEncoding.EncodeToString(digest)
+
+	for attempt := 1; ; attempt++ {
+		_, err = body.Seek(0, io.SeekStart)
+		if err != nil {
+			return types.CompletedPart{}, nil, err
+		}
+
+		out, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:         &s.bucket,
+			Key:            &key,
+			UploadId:       uploadID,
+			PartNumber:     aws.Int32(partNumber),
+			Body:           body,
+			ContentLength:  aws.Int64(length),
+			ChecksumSHA256: &checksum,
+		})
+		if err == nil {
+			return types.CompletedPart{
+				ETag:           out.ETag,
+				PartNumber:     aws.Int32(partNumber),
+				ChecksumSHA256: out.ChecksumSHA256,
+			}, digest, nil
+		}
+		if attempt == maxPartAttempts || ctx.Err() != nil {
+			return types.CompletedPart{}, nil, fmt.Errorf("upload part %d: %w", partNumber, err)
+		}
+
+		select {
+		case <-time.After(time.Duration(attempt) * time.Second):
+		case <-ctx.Done():
+			return types.CompletedPart{}, nil, ctx.Err()
+		}
+	}
+}
+
+func (s *S3) createMultipartUpload(ctx context.Context, key string, opts PutOptions) (*string, error) {
+	created, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               &s.bucket,
+		Key:                  &key,
+		ContentType:          &opts.ContentType,
+		CacheControl:         optionalString(opts.CacheControl),
+		ContentDisposition:   optionalString(opts.ContentDisposition),
+		StorageClass:         types.StorageClass(opts.StorageClass),
+		Tagging:              encodeTags(opts.Tags),
+		ChecksumAlgorithm:    types.ChecksumAlgorithmSha256,
+		ServerSideEncryption: s.encryption.Algorithm,
+		SSEKMSKeyId:          s.encryption.kmsKeyID(),
+		BucketKeyEnabled:     s.encryption.bucketKeyEnabled(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created.UploadId, nil
+}
+
+// abortMultipartUpload releases the parts of an upload that failed with
+// cause, returning cause along with any error aborting it. It runs on a
+// context that outlives ctx being cancelled, as the caller's may already be.
+func (s *S3) abortMultipartUpload(ctx context.Context, key string, uploadID *string, cause error) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   &s.bucket,
+		Key:      &key,
+		UploadId: uploadID,
+	})
+	if err != nil {
+		return fmt.Errorf("%w (and couldn't abort multipart upload: %v)", cause, err)
+	}
+	return cause
+}
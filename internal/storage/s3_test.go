@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeS3 is just enough of the S3 API for single-part Puts. HeadObject
+// reports headChecksum, or the checksum of what was put if it's empty.
+type fakeS3 struct {
+	headChecksum string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	puts    []http.Header
+	deletes []string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = body
+		f.puts = append(f.puts, r.Header.Clone())
+	case http.MethodHead:
+		body, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		checksum := f.headChecksum
+		if checksum == "" {
+			sum := sha256.Sum256(body)
+			checksum = base64.StdEncoding.EncodeToString(sum[:])
+		}
+		w.Header().Set("x-amz-checksum-sha256", checksum)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		f.deletes = append(f.deletes, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func newFakeS3(t *testing.T, headChecksum string) (*S3, *fakeS3) {
+	t.Helper()
+	fake := &fakeS3{headChecksum: headChecksum, objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	return NewS3(client, "bucket", DefaultPartSize, DefaultUploadConcurrency, S3Encryption{}), fake
+}
+
+func TestS3PutVerifiesChecksum(t *testing.T) {
+	store, fake := newFakeS3(t, "")
+	data := []byte("video data")
+	info, err := store.Put(context.Background(), "landscape/a.mp4", bytes.NewReader(data), PutOptions{ContentType: "video/mp4", Size: int64(len(data))})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	if want := base64.StdEncoding.EncodeToString(sum[:]); info.ChecksumSHA256 != want {
+		t.Errorf("checksum = %q, want %q", info.ChecksumSHA256, want)
+	}
+	if len(fake.deletes) != 0 {
+		t.Errorf("deleted %v, want nothing deleted", fake.deletes)
+	}
+}
+
+func TestS3PutDeletesMismatchedObject(t *testing.T) {
+	store, fake := newFakeS3(t, base64.StdEncoding.EncodeToString(make([]byte, sha256.Size)))
+	data := []byte("video data")
+	_, err := store.Put(context.Background(), "landscape/a.mp4", bytes.NewReader(data), PutOptions{ContentType: "video/mp4", Size: int64(len(data))})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Put error = %v, want ErrChecksumMismatch", err)
+	}
+	if len(fake.deletes) != 1 || fake.deletes[0] != "/bucket/landscape/a.mp4" {
+		t.Errorf("deleted %v, want the uploaded object", fake.deletes)
+	}
+	if len(fake.objects) != 0 {
+		t.Errorf("%d objects left in the bucket, want none", len(fake.objects))
+	}
+}
+
+func TestS3PutSetsStorageClass(t *testing.T) {
+	tests := []struct {
+		class string
+		want  string
+	}{
+		{"STANDARD_IA", "STANDARD_IA"},
+		{"INTELLIGENT_TIERING", "INTELLIGENT_TIERING"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.class, func(t *testing.T) {
+			store, fake := newFakeS3(t, "")
+			data := []byte("video data")
+			_, err := store.Put(context.Background(), "landscape/a.mp4", bytes.NewReader(data), PutOptions{ContentType: "video/mp4", StorageClass: tt.class, Size: int64(len(data))})
+			if err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			if len(fake.puts) != 1 {
+				t.Fatalf("%d PutObject calls, want 1", len(fake.puts))
+			}
+			if got := fake.puts[0].Get("x-amz-storage-class"); got != tt.want {
+				t.Errorf("storage class = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
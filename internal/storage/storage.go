@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrNotFound         = errors.New("object not found")
+	ErrEmptyObject      = errors.New("object is empty")
+	ErrChecksumMismatch = errors.New("stored object checksum does not match upload")
+	ErrInvalidKey       = errors.New("invalid object key")
+)
+
+// ObjectInfo describes a stored object. ChecksumSHA256 is base64 encoded
+// and, for objects S3 assembled from parts, the composite checksum S3
+// reports. It's empty when the store doesn't keep one. Encryption is the
+// server-side encryption S3 reports for the object, like "AES256" or
+// "aws:kms", and empty for other stores. StorageClass is only filled in by
+// List, with the S3 or GCS storage class or Azure access tier.
+type ObjectInfo struct {
+	Key            string
+	Size           int64
+	LastModified   time.Time
+	ChecksumSHA256 string
+	Encryption     string
+	StorageClass   string
+}
+
+// PutOptions describe an object being stored. A negative Size means it isn't
+// known up front. StorageClass is an S3 storage class and Tags are S3 object
+// tags, both of which other stores ignore. CacheControl and
+// ContentDisposition are stored with the object and sent back when it's
+// fetched, except by the local store, which keeps no metadata.
+type PutOptions struct {
+	ContentType        string
+	CacheControl       string
+	ContentDisposition string
+	StorageClass       string
+	Tags               map[string]string
+	Size               int64
+}
+
+// ObjectStore is where videos, thumbnails and everything derived from them
+// are kept.
+type ObjectStore interface {
+	// Put stores body under key and returns once it's confirmed to have
+	// arrived intact.
+	Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (ObjectInfo, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange reads length bytes of an object starting at offset.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Head(ctx context.Context, key string) (ObjectInfo, error)
+	// Delete removes an object. Deleting one that doesn't exist isn't an
+	// error.
+	Delete(ctx context.Context, key string) error
+	// PresignGet returns a URL anyone can read the object from until expiry
+	// passes.
+	PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error)
+	// List returns every object whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// BatchDeleter is implemented by stores that can delete many objects in one
+// call. It returns the keys that couldn't be deleted, mapped to the reason.
+type BatchDeleter interface {
+	DeleteMany(ctx context.Context, keys []string) (map[string]error, error)
+}
+
+// Copier is implemented by stores that can copy an object without it
+// passing through the server. Like a Put, the copy gets the content type,
+// caching headers, storage class and tags in opts; Size is ignored.
+type Copier interface {
+	Copy(ctx context.Context, srcKey, dstKey string, opts PutOptions) (ObjectInfo, error)
+}
+
+// Tagger is implemented by stores that can replace the tags on an object
+// that's already stored.
+type Tagger interface {
+	SetTags(ctx context.Context, key string, tags map[string]string) error
+}
+
+// PresignedPut is a request a client can send to store an object directly.
+type PresignedPut struct {
+	URL     string
+	Method  string
+	Headers map[string]string
+}
+
+// PutPresigner is implemented by stores clients can upload to without going
+// through the server. Where the store supports it, the content type, size
+// and storage class are part of the signature, so an upload that doesn't
+// match them is rejected. Callers should still check what arrived.
+type PutPresigner interface {
+	PresignPut(ctx context.Context, key string, opts PutOptions, expiry time.Duration) (PresignedPut, error)
+}
+
+// NewContextReader returns a reader that reads from r until ctx is done, so
+// a copy from it stops once a request is abandoned.
+func NewContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return contextReader{ctx: ctx, r: r}
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
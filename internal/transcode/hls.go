@@ -0,0 +1,123 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	HLSMasterPlaylist  = "master.m3u8"
+	HLSVariantPlaylist = "index.m3u8"
+	HLSSubtitlesDir    = "subtitles"
+	hlsSegmentSeconds  = 6
+	hlsSubtitlesGroup  = "subs"
+)
+
+// HLSVariant is one entry of a master playlist.
+type HLSVariant struct {
+	Name      string
+	Bandwidth int
+	Width     int
+	Height    int
+}
+
+// PackageHLS splits an H.264/AAC mp4 into MPEG-TS segments plus a VOD
+// playlist inside outputDir, without re-encoding.
+func PackageHLS(ctx context.Context, inputPath, outputDir string) error {
+	err := os.MkdirAll(outputDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y", "-v", "error",
+		"-i", inputPath,
+		"-c", "copy",
+		"-bsf:v", "h264_mp4toannexb",
+		"-f", "hls",
+		"-hls_time", fmt.Sprint(hlsSegmentSeconds),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(outputDir, "segment_%05d.ts"),
+		filepath.Join(outputDir, HLSVariantPlaylist),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg hls: %w: %s", err, out)
+	}
+	return nil
+}
+
+// MasterPlaylist lists each variant's playlist, found at
+// <variant name>/index.m3u8 relative to the master.
+func MasterPlaylist(variants []HLSVariant) []byte {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, v := range variants {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d", v.Bandwidth)
+		if v.Width > 0 && v.Height > 0 {
+			fmt.Fprintf(&b, ",RESOLUTION=%dx%d", v.Width, v.Height)
+		}
+		fmt.Fprintf(&b, "\n%s/%s\n", v.Name, HLSVariantPlaylist)
+	}
+	return []byte(b.String())
+}
+
+// WithSubtitles rewrites a master playlist to offer a subtitle track per
+// language, each at subtitles/<lang>.m3u8 relative to the master, in place
+// of any it offered before. No languages takes them all out.
+func WithSubtitles(master []byte, langs []string) []byte {
+	var b strings.Builder
+	wroteMedia := false
+	for _, line := range strings.Split(strings.TrimRight(string(master), "\n"), "\n") {
+		if strings.HasPrefix(line, "#EXT-X-MEDIA:TYPE=SUBTITLES,") {
+			continue
+		}
+		if strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
+			if !wroteMedia {
+				for _, lang := range langs {
+					fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=%q,NAME=%q,LANGUAGE=%q,DEFAULT=NO,AUTOSELECT=YES,URI=\"%s/%s.m3u8\"\n",
+						hlsSubtitlesGroup, lang, lang, HLSSubtitlesDir, lang)
+				}
+				wroteMedia = true
+			}
+			line = strings.Replace(line, fmt.Sprintf(",SUBTITLES=%q", hlsSubtitlesGroup), "", 1)
+			if len(langs) > 0 {
+				line += fmt.Sprintf(",SUBTITLES=%q", hlsSubtitlesGroup)
+			}
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return []byte(b.String())
+}
+
+// SubtitlePlaylist is the playlist for a subtitle track, which is the
+// whole WebVTT file at vttURL as a single segment as long as the video.
+func SubtitlePlaylist(vttURL string, duration float64) []byte {
+	target := max(int(math.Ceil(duration)), 1)
+	return []byte(fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXTINF:%.3f,\n%s\n#EXT-X-ENDLIST\n",
+		target, max(duration, 1), vttURL))
+}
+
+// estimateBandwidth derives a variant's peak bits per second from its size,
+// padded since HLS BANDWIDTH is meant to be an upper bound.
+func estimateBandwidth(filePath string, duration float64) int {
+	info, err := os.Stat(filePath)
+	if err != nil || duration <= 0 {
+		return 0
+	}
+	return int(float64(info.Size()*8) / duration * 1.2)
+}
+
+// scaledWidth mirrors ffmpeg's scale=-2:height, which keeps the width even.
+func scaledWidth(sourceWidth, sourceHeight, height int) int {
+	if sourceHeight == 0 {
+		return 0
+	}
+	w := sourceWidth * height / sourceHeight
+	return w - w%2
+}
@@ -0,0 +1,233 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrQueueFull = errors.New("transcode queue is full")
+var ErrQueueClosed = errors.New("transcode queue is closed")
+
+// Job asks for a source file to be transcoded. The queue owns SourcePath once
+// the job is accepted and removes it when the job is done.
+type Job struct {
+	VideoID        uuid.UUID
+	SourceKey      string
+	SourcePath     string
+	SourceWidth    int
+	SourceHeight   int
+	SourceDuration float64
+	// MaxRenditions keeps only the lowest resolution renditions, 0 for all
+	MaxRenditions int
+}
+
+// Output is one finished rendition.
+type Output struct {
+	Rendition Rendition
+	Path      string
+}
+
+// Result is everything a job produced. Files stay on disk until the
+// ResultFunc returns.
+type Result struct {
+	Renditions []Output
+	// HLSDir holds master.m3u8 and a directory of segments per variant, when
+	// HLS packaging is on.
+	HLSDir string
+	// SpritesDir holds the sprite sheets and thumbnails.vtt, when sprite
+	// generation is on.
+	SpritesDir string
+	// Elapsed is how long ffmpeg spent on the job, including when it failed
+	Elapsed time.Duration
+}
+
+// ResultFunc receives a job's output, or the error that stopped it.
+type ResultFunc func(ctx context.Context, job Job, result Result, err error)
+
+type Queue struct {
+	jobs       chan Job
+	renditions []Rendition
+	packageHLS bool
+	sprites    bool
+	tempDir    string
+	onResult   ResultFunc
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewQueue starts workers goroutines that transcode jobs one at a time each.
+// Up to buffer jobs wait for a free worker before Enqueue starts refusing.
+// With packageHLS set, the source and every rendition are also packaged as
+// HLS variants, and with sprites set the source gets seek bar sprite sheets.
+func NewQueue(workers, buffer int, tempDir string, renditions []Rendition, packageHLS, sprites bool, onResult ResultFunc) *Queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		jobs:       make(chan Job, buffer),
+		renditions: renditions,
+		packageHLS: packageHLS,
+		sprites:    sprites,
+		tempDir:    tempDir,
+		onResult:   onResult,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	for range workers {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Enqueue adds a job without blocking.
+func (q *Queue) Enqueue(job Job) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting jobs and waits for queued ones to finish, or until
+// ctx is done, at which point running ffmpeg processes are killed.
+func (q *Queue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for job := range q.jobs {
+		q.run(job)
+	}
+}
+
+func (q *Queue) run(job Job) {
+	defer os.Remove(job.SourcePath)
+
+	start := time.Now()
+	result := Result{}
+	defer func() {
+		for _, output := range result.Renditions {
+			os.Remove(output.Path)
+		}
+		if result.HLSDir != "" {
+			os.RemoveAll(result.HLSDir)
+		}
+		if result.SpritesDir != "" {
+			os.RemoveAll(result.SpritesDir)
+		}
+	}()
+
+	renditions := RenditionsFor(job.SourceHeight, q.renditions)
+	if job.MaxRenditions > 0 && len(renditions) > job.MaxRenditions {
+		renditions = renditions[:job.MaxRenditions]
+	}
+	for _, rendition := range renditions {
+		path, err := Transcode(q.ctx, job.SourcePath, q.tempDir, rendition)
+		if err != nil {
+			q.onResult(q.ctx, job, Result{Elapsed: time.Since(start)}, err)
+			return
+		}
+		result.Renditions = append(result.Renditions, Output{Rendition: rendition, Path: path})
+	}
+
+	if q.packageHLS {
+		dir, err := q.packageVariants(job, result.Renditions)
+		result.HLSDir = dir
+		if err != nil {
+			q.onResult(q.ctx, job, Result{Elapsed: time.Since(start)}, err)
+			return
+		}
+	}
+
+	if q.sprites {
+		dir, err := os.MkdirTemp(q.tempDir, "tubely-sprites-*")
+		if err == nil {
+			result.SpritesDir = dir
+			err = GenerateSprites(q.ctx, job.SourcePath, dir, job.SourceWidth, job.SourceHeight, job.SourceDuration)
+		}
+		if err != nil {
+			q.onResult(q.ctx, job, Result{Elapsed: time.Since(start)}, err)
+			return
+		}
+	}
+
+	result.Elapsed = time.Since(start)
+	q.onResult(q.ctx, job, result, nil)
+}
+
+// packageVariants packages the source as the "source" variant alongside the
+// renditions, and writes the master playlist tying them together.
+func (q *Queue) packageVariants(job Job, renditions []Output) (string, error) {
+	dir, err := os.MkdirTemp(q.tempDir, "tubely-hls-*")
+	if err != nil {
+		return "", err
+	}
+
+	err = PackageHLS(q.ctx, job.SourcePath, filepath.Join(dir, "source"))
+	if err != nil {
+		return dir, err
+	}
+	variants := []HLSVariant{{
+		Name:      "source",
+		Bandwidth: estimateBandwidth(job.SourcePath, job.SourceDuration),
+		Width:     job.SourceWidth,
+		Height:    job.SourceHeight,
+	}}
+
+	for _, output := range renditions {
+		err = PackageHLS(q.ctx, output.Path, filepath.Join(dir, output.Rendition.Name))
+		if err != nil {
+			return dir, err
+		}
+		variants = append(variants, HLSVariant{
+			Name:      output.Rendition.Name,
+			Bandwidth: estimateBandwidth(output.Path, job.SourceDuration),
+			Width:     scaledWidth(job.SourceWidth, job.SourceHeight, output.Rendition.Height),
+			Height:    output.Rendition.Height,
+		})
+	}
+
+	err = os.WriteFile(filepath.Join(dir, HLSMasterPlaylist), MasterPlaylist(variants), 0644)
+	if err != nil {
+		return dir, err
+	}
+	return dir, nil
+}
@@ -0,0 +1,139 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	SpritesVTT = "thumbnails.vtt"
+
+	spriteTileWidth = 160
+	spriteColumns   = 10
+	spriteRows      = 10
+	// Frames are this far apart, closer for videos too short to fill a
+	// seek bar with them
+	maxSpriteInterval = 10.0
+	minSpriteInterval = 1.0
+	targetSpriteCount = 100
+)
+
+// spriteInterval is how many seconds apart the frames of a video are taken.
+func spriteInterval(duration float64) float64 {
+	return min(max(duration/targetSpriteCount, minSpriteInterval), maxSpriteInterval)
+}
+
+// spriteTileHeight keeps the source's aspect ratio in a tile
+// spriteTileWidth wide, falling back to 16:9 when it isn't known.
+func spriteTileHeight(sourceWidth, sourceHeight int) int {
+	if sourceWidth == 0 || sourceHeight == 0 {
+		return spriteTileWidth * 9 / 16
+	}
+	h := spriteTileWidth * sourceHeight / sourceWidth
+	return max(h-h%2, 2)
+}
+
+// GenerateSprites takes a frame of inputPath every few seconds, tiles them
+// into JPEG sprite sheets inside outputDir and writes a WebVTT file pointing
+// each stretch of the video at its tile, for seek bar previews.
+func GenerateSprites(ctx context.Context, inputPath, outputDir string, sourceWidth, sourceHeight int, duration float64) error {
+	framesDir := filepath.Join(outputDir, "frames")
+	err := os.MkdirAll(framesDir, 0755)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(framesDir)
+
+	interval := spriteInterval(duration)
+	tileHeight := spriteTileHeight(sourceWidth, sourceHeight)
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y", "-v", "error",
+		"-i", inputPath,
+		"-an",
+		"-vf", fmt.Sprintf("fps=1/%g,scale=%d:%d", interval, spriteTileWidth, tileHeight),
+		"-q:v", "5",
+		filepath.Join(framesDir, "frame_%05d.jpg"),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg sprites: %w: %s", err, out)
+	}
+
+	frames, err := filepath.Glob(filepath.Join(framesDir, "frame_*.jpg"))
+	if err != nil {
+		return err
+	}
+	if len(frames) == 0 {
+		return fmt.Errorf("ffmpeg sprites: no frames extracted")
+	}
+
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n")
+	perSheet := spriteColumns * spriteRows
+	for sheet := 0; sheet*perSheet < len(frames); sheet++ {
+		sheetFrames := frames[sheet*perSheet : min((sheet+1)*perSheet, len(frames))]
+		name := fmt.Sprintf("sprite_%03d.jpg", sheet)
+		err = writeSpriteSheet(filepath.Join(outputDir, name), sheetFrames, tileHeight)
+		if err != nil {
+			return err
+		}
+		for i := range sheetFrames {
+			n := sheet*perSheet + i
+			start := float64(n) * interval
+			end := start + interval
+			// The last tile covers whatever is left
+			if n == len(frames)-1 && duration > start {
+				end = duration
+			}
+			x, y := (i%spriteColumns)*spriteTileWidth, (i/spriteColumns)*tileHeight
+			fmt.Fprintf(&vtt, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n", vttTimestamp(start), vttTimestamp(end), name, x, y, spriteTileWidth, tileHeight)
+		}
+	}
+	return os.WriteFile(filepath.Join(outputDir, SpritesVTT), []byte(vtt.String()), 0644)
+}
+
+// writeSpriteSheet tiles frames left to right, top to bottom, into a sheet
+// only as tall as the rows it uses.
+func writeSpriteSheet(path string, frames []string, tileHeight int) error {
+	columns := min(len(frames), spriteColumns)
+	rows := (len(frames) + spriteColumns - 1) / spriteColumns
+	sheet := image.NewRGBA(image.Rect(0, 0, columns*spriteTileWidth, rows*tileHeight))
+	for i, frame := range frames {
+		data, err := os.Open(frame)
+		if err != nil {
+			return err
+		}
+		img, err := jpeg.Decode(data)
+		data.Close()
+		if err != nil {
+			return fmt.Errorf("decoding %s: %w", filepath.Base(frame), err)
+		}
+		at := image.Pt((i%spriteColumns)*spriteTileWidth, (i/spriteColumns)*tileHeight)
+		draw.Draw(sheet, image.Rectangle{Min: at, Max: at.Add(image.Pt(spriteTileWidth, tileHeight))}, img, img.Bounds().Min, draw.Src)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = jpeg.Encode(out, sheet, &jpeg.Options{Quality: 75})
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// vttTimestamp formats seconds as a WebVTT hh:mm:ss.ttt timestamp.
+func vttTimestamp(seconds float64) string {
+	d := time.Duration(math.Round(seconds*1000)) * time.Millisecond
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60, d.Milliseconds()%1000)
+}
@@ -0,0 +1,61 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+type Rendition struct {
+	Name         string
+	Height       int
+	VideoBitrate string
+	AudioBitrate string
+}
+
+var DefaultRenditions = []Rendition{
+	{Name: "480p", Height: 480, VideoBitrate: "1400k", AudioBitrate: "128k"},
+	{Name: "720p", Height: 720, VideoBitrate: "2800k", AudioBitrate: "128k"},
+	{Name: "1080p", Height: 1080, VideoBitrate: "5000k", AudioBitrate: "192k"},
+}
+
+// RenditionsFor drops the renditions taller than the source, since
+// upscaling only makes files bigger.
+func RenditionsFor(sourceHeight int, renditions []Rendition) []Rendition {
+	fitting := []Rendition{}
+	for _, r := range renditions {
+		if r.Height <= sourceHeight {
+			fitting = append(fitting, r)
+		}
+	}
+	return fitting
+}
+
+// Transcode encodes inputPath as an H.264/AAC fast-start mp4 scaled to the
+// rendition's height and returns the path of the new file in outputDir.
+func Transcode(ctx context.Context, inputPath, outputDir string, r Rendition) (string, error) {
+	output, err := os.CreateTemp(outputDir, fmt.Sprintf("tubely-%s-*.mp4", r.Name))
+	if err != nil {
+		return "", err
+	}
+	output.Close()
+	outputPath := output.Name()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y", "-v", "error",
+		"-i", inputPath,
+		// -2 keeps the aspect ratio with an even width, which libx264 needs
+		"-vf", "scale=-2:"+strconv.Itoa(r.Height),
+		"-c:v", "libx264", "-preset", "veryfast", "-b:v", r.VideoBitrate,
+		"-c:a", "aac", "-b:a", r.AudioBitrate,
+		"-movflags", "faststart",
+		"-f", "mp4", outputPath,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg %s: %w: %s", r.Name, err, out)
+	}
+	return outputPath, nil
+}
@@ -0,0 +1,125 @@
+package transcribe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awstranscribe "github.com/aws/aws-sdk-go-v2/service/transcribe"
+	"github.com/aws/aws-sdk-go-v2/service/transcribe/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	// stagingPrefix is where audio waits in the bucket for Transcribe to
+	// read it
+	stagingPrefix       = "transcribe/"
+	defaultPollInterval = 10 * time.Second
+	maxSubtitleBytes    = 10 << 20
+)
+
+// AWS transcribes with Amazon Transcribe, which only reads media from S3.
+// The audio is staged in the bucket Store writes to for the length of the
+// job, and the captions are fetched from the temporary link Transcribe
+// gives for its own bucket, so it needs no access to write to ours.
+type AWS struct {
+	Client       *awstranscribe.Client
+	Store        storage.ObjectStore
+	Bucket       string
+	PollInterval time.Duration
+	HTTPClient   *http.Client
+}
+
+func NewAWS(client *awstranscribe.Client, store storage.ObjectStore, bucket string) *AWS {
+	return &AWS{
+		Client:       client,
+		Store:        store,
+		Bucket:       bucket,
+		PollInterval: defaultPollInterval,
+		HTTPClient:   &http.Client{Timeout: time.Minute},
+	}
+}
+
+func (a *AWS) Transcribe(ctx context.Context, audioPath, lang string) ([]byte, error) {
+	name := "tubely-" + uuid.NewString()
+	key := stagingPrefix + name + ".wav"
+	err := a.stage(ctx, key, audioPath)
+	// Cleaning up is worth doing even once ctx is done
+	cleanupCtx := context.WithoutCancel(ctx)
+	defer a.Store.Delete(cleanupCtx, key)
+	if err != nil {
+		return nil, fmt.Errorf("staging audio: %w", err)
+	}
+
+	_, err = a.Client.StartTranscriptionJob(ctx, &awstranscribe.StartTranscriptionJobInput{
+		TranscriptionJobName: aws.String(name),
+		LanguageCode:         types.LanguageCode(lang),
+		Media:                &types.Media{MediaFileUri: aws.String(fmt.Sprintf("s3://%s/%s", a.Bucket, key))},
+		MediaFormat:          types.MediaFormatWav,
+		Subtitles:            &types.Subtitles{Formats: []types.SubtitleFormat{types.SubtitleFormatVtt}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("starting transcription job: %w", err)
+	}
+	defer a.Client.DeleteTranscriptionJob(cleanupCtx, &awstranscribe.DeleteTranscriptionJobInput{TranscriptionJobName: aws.String(name)})
+
+	ticker := time.NewTicker(a.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+		out, err := a.Client.GetTranscriptionJob(ctx, &awstranscribe.GetTranscriptionJobInput{TranscriptionJobName: aws.String(name)})
+		if err != nil {
+			return nil, fmt.Errorf("checking transcription job: %w", err)
+		}
+		job := out.TranscriptionJob
+		switch job.TranscriptionJobStatus {
+		case types.TranscriptionJobStatusCompleted:
+			if job.Subtitles == nil || len(job.Subtitles.SubtitleFileUris) == 0 {
+				return nil, errors.New("transcription job made no subtitles")
+			}
+			return a.fetch(ctx, job.Subtitles.SubtitleFileUris[0])
+		case types.TranscriptionJobStatusFailed:
+			return nil, fmt.Errorf("transcription job failed: %s", aws.ToString(job.FailureReason))
+		}
+	}
+}
+
+func (a *AWS) stage(ctx context.Context, key, audioPath string) error {
+	file, err := os.Open(audioPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = a.Store.Put(ctx, key, file, storage.PutOptions{ContentType: "audio/wav", Size: info.Size()})
+	return err
+}
+
+func (a *AWS) fetch(ctx context.Context, subtitlesURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, subtitlesURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching subtitles: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching subtitles: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxSubtitleBytes))
+}
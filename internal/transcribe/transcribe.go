@@ -0,0 +1,10 @@
+package transcribe
+
+import "context"
+
+// Transcriber turns the speech in an audio file into WebVTT captions.
+type Transcriber interface {
+	// Transcribe reads audioPath, a 16 kHz mono WAV file, expecting speech
+	// in lang, a language tag like "en-US".
+	Transcribe(ctx context.Context, audioPath, lang string) ([]byte, error)
+}
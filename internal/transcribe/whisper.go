@@ -0,0 +1,51 @@
+package transcribe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Whisper transcribes on this machine with whisper.cpp's command line
+// program, which writes its WebVTT output next to a prefix it's given.
+type Whisper struct {
+	Command string
+	Model   string
+}
+
+// NewWhisper checks the model file exists, since whisper.cpp only finds
+// out once it's given a file to transcribe.
+func NewWhisper(command, model string) (*Whisper, error) {
+	if model == "" {
+		return nil, errors.New("a whisper.cpp model file is required")
+	}
+	if _, err := os.Stat(model); err != nil {
+		return nil, fmt.Errorf("whisper.cpp model: %w", err)
+	}
+	if command == "" {
+		command = "whisper-cli"
+	}
+	return &Whisper{Command: command, Model: model}, nil
+}
+
+func (w *Whisper) Transcribe(ctx context.Context, audioPath, lang string) ([]byte, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(audioPath), "tubely-whisper-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	outputPrefix := filepath.Join(dir, "captions")
+
+	// whisper.cpp takes a bare language, "en" rather than "en-US"
+	language, _, _ := strings.Cut(lang, "-")
+	args := []string{"-m", w.Model, "-f", audioPath, "-l", strings.ToLower(language), "-ovtt", "-of", outputPrefix, "-np"}
+	cmd := exec.CommandContext(ctx, w.Command, args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", w.Command, err, strings.TrimSpace(string(out)))
+	}
+	return os.ReadFile(outputPrefix + ".vtt")
+}
@@ -0,0 +1,78 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// MaxAttempts is how many times a delivery is tried before it's given
+	// up on, with the wait between attempts doubling from FirstRetryDelay
+	MaxAttempts     = 5
+	FirstRetryDelay = time.Second
+	requestTimeout  = 10 * time.Second
+	// Receivers' responses aren't used, so only this much is read to let
+	// the connection be reused
+	maxResponseBytes = 4 << 10
+)
+
+// Delivery is one event on its way to one endpoint. Body is the JSON event.
+type Delivery struct {
+	ID        uuid.UUID
+	WebhookID uuid.UUID
+	URL       string
+	Secret    string
+	Event     string
+	Body      []byte
+}
+
+// Send POSTs one attempt at a delivery, failing unless the endpoint
+// responds with a 2xx status. Retrying is up to the caller.
+func Send(ctx context.Context, client *http.Client, delivery Delivery, attempt int) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Tubely-Webhooks/1")
+	req.Header.Set("X-Tubely-Event", delivery.Event)
+	req.Header.Set("X-Tubely-Delivery", delivery.ID.String())
+	req.Header.Set("X-Tubely-Attempt", strconv.Itoa(attempt))
+	req.Header.Set("X-Tubely-Signature", Sign(delivery.Secret, timestamp, delivery.Body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBytes))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign builds the X-Tubely-Signature header: the send time and an HMAC-SHA256
+// of "<time>.<body>" keyed with the webhook's secret. Receivers recompute the
+// HMAC to check the event came from this server, and can reject old
+// timestamps to stop replays.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
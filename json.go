@@ -2,30 +2,45 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
-	if err != nil {
-		log.Println(err)
-	}
-	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
-	}
-	type errorResponse struct {
-		Error string `json:"error"`
+	respondWithAPIError(w, apiErrorFor(code, msg, err), err)
+}
+
+// respondWithAPIError reports apiErr to the client, logging err as what
+// caused it.
+func respondWithAPIError(w http.ResponseWriter, apiErr *apiError, err error) {
+	// The request ID middleware has already set this on the response
+	requestID := w.Header().Get(requestIDHeader)
+	logger := loggerWithRequestID(requestID)
+	if apiErr.status > 499 {
+		logger.Error("Responding with 5XX error", "status", apiErr.status, "code", apiErr.code, "message", apiErr.message, "error", err)
+	} else if err != nil {
+		logger.Info("Responding with error", "status", apiErr.status, "code", apiErr.code, "message", apiErr.message, "error", err)
 	}
-	respondWithJSON(w, code, errorResponse{
-		Error: msg,
-	})
+	respondWithJSON(w, apiErr.status, newErrorResponse(w, apiErr.code, apiErr.message))
+}
+
+// errorResponse is the body of every error response. Some add fields of
+// their own, like the limits a plan or quota refusal was based on.
+type errorResponse struct {
+	Error     string    `json:"error"`
+	Code      errorCode `json:"code"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+func newErrorResponse(w http.ResponseWriter, code errorCode, msg string) errorResponse {
+	return errorResponse{Error: msg, Code: code, RequestID: w.Header().Get(requestIDHeader)}
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshalling JSON: %s", err)
+		slog.Error("Couldn't marshal JSON", "error", err)
 		w.WriteHeader(500)
 		return
 	}
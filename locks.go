@@ -0,0 +1,30 @@
+package main
+
+import "sync"
+
+// keyedLocker hands out non-blocking locks by key, for refusing a second
+// request that would work on the same resource at once.
+type keyedLocker struct {
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+func newKeyedLocker() *keyedLocker {
+	return &keyedLocker{held: map[string]bool{}}
+}
+
+func (l *keyedLocker) TryLock(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[key] {
+		return false
+	}
+	l.held[key] = true
+	return true
+}
+
+func (l *keyedLocker) Unlock(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.held, key)
+}
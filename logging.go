@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+type loggerKey struct{}
+
+type requestUserKey struct{}
+
+// newLogger builds the server's logger. format is "text" (the default) or
+// "json", and level is a slog level name like "debug" or "warn".
+func newLogger(format, level string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{}
+	if level != "" {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q", level)
+		}
+		opts.Level = l
+	}
+
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q, must be text or json", format)
+}
+
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFromContext returns the request's logger, which tags every line with
+// its request ID, or the default logger outside of a request.
+func loggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// requestUser is who the access log attributes a request to.
+type requestUser struct {
+	id uuid.UUID
+}
+
+// setRequestUser records who made a request authenticated by something other
+// than a bearer token, which the access log can't see by itself.
+func setRequestUser(ctx context.Context, userID uuid.UUID) {
+	if user, ok := ctx.Value(requestUserKey{}).(*requestUser); ok {
+		user.id = userID
+	}
+}
+
+// statusRecorder captures the status and size of a response for the access
+// log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(p)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps progress streams working through the recorder.
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// accessLogMiddleware logs a line for every request once it's been served.
+// It must run inside requestIDMiddleware so the line carries the request ID.
+func (cfg *apiConfig) accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		user := &requestUser{}
+		if token, err := auth.GetBearerToken(r.Header); err == nil {
+			if userID, err := auth.ValidateJWT(token, cfg.jwtSecret); err == nil {
+				user.id = userID
+			}
+		}
+		ctx := context.WithValue(r.Context(), requestUserKey{}, user)
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(start),
+			"bytes", rec.bytes,
+		}
+		if user.id != uuid.Nil {
+			attrs = append(attrs, "user_id", user.id)
+		}
+		loggerFromContext(ctx).Info("Request served", attrs...)
+	})
+}
+
+// s3Logging logs every S3 call at debug level, or at warn level if it fails,
+// through the logger of the request that made it.
+func s3Logging(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("TubelyLogging", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		start := time.Now()
+		out, metadata, err := next.HandleInitialize(ctx, in)
+
+		attrs := []any{
+			"operation", awsmiddleware.GetOperationName(ctx),
+			"duration", time.Since(start),
+		}
+		if key := s3InputKey(in.Parameters); key != "" {
+			attrs = append(attrs, "key", key)
+		}
+		logger := loggerFromContext(ctx)
+		if err != nil {
+			logger.Warn("S3 call failed", append(attrs, "error", err)...)
+		} else {
+			logger.Debug("S3 call", attrs...)
+		}
+		return out, metadata, err
+	}), middleware.After)
+}
+
+// s3InputKey returns the object key of the S3 calls this server makes on
+// single objects.
+func s3InputKey(params interface{}) string {
+	var key *string
+	switch input := params.(type) {
+	case *s3.PutObjectInput:
+		key = input.Key
+	case *s3.GetObjectInput:
+		key = input.Key
+	case *s3.HeadObjectInput:
+		key = input.Key
+	case *s3.DeleteObjectInput:
+		key = input.Key
+	case *s3.CreateMultipartUploadInput:
+		key = input.Key
+	case *s3.UploadPartInput:
+		key = input.Key
+	case *s3.CompleteMultipartUploadInput:
+		key = input.Key
+	case *s3.AbortMultipartUploadInput:
+		key = input.Key
+	}
+	if key == nil {
+		return ""
+	}
+	return *key
+}
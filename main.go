@@ -2,40 +2,134 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	awstranscribe "github.com/aws/aws-sdk-go-v2/service/transcribe"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cache"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/scan"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcribe"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	db               database.Client
-	jwtSecret        string
-	platform         string
-	filepathRoot     string
-	assetsRoot       string
-	s3Bucket         string
-	s3Region         string
-	s3CfDistribution string
-	port             string
-	s3Client         *s3.Client
+	db                   database.Client
+	jwtSecret            string
+	platform             string
+	filepathRoot         string
+	assetsRoot           string
+	s3Bucket             string
+	s3Region             string
+	s3ObjectBaseURL      string
+	s3Private            bool
+	s3PresignExpiry      time.Duration
+	cfSigner             *cloudFrontSigner
+	thumbnailsInS3       bool
+	maxVideoUploadBytes  int64
+	batchUploadWorkers   int
+	uploadSessionTTL     time.Duration
+	defaultQuotaBytes    int64
+	maxThumbnailBytes    int64
+	stripImageMetadata   bool
+	allowedMediaTypes    map[string]bool
+	port                 string
+	store                storage.ObjectStore
+	objectTagger         storage.Tagger
+	encryptVideos        bool
+	tempDir              string
+	workDir              string
+	tempSpace            *tempSpaceGuard
+	cors                 corsPolicy
+	enablePerceptualHash bool
+	processVideos        bool
+	previewFormat        string
+	enableDedupe         bool
+	s3StorageClass       types.StorageClass
+	objectCache          objectCachePolicy
+	uploadLocks          *keyedLocker
+	uploadProgress       *uploadProgressTracker
+	uploadRateLimiter    *rateLimiter
+	commentRateLimiter   *rateLimiter
+	exportWake           chan struct{}
+	viewTracker          *viewTracker
+	transcodeQueue       *transcode.Queue
+	scanner              scan.Scanner
+	transcriber          transcribe.Transcriber
+	transcribeLanguage   string
+	moderation           moderationPolicy
+	jobQueue             *jobs.Queue
+	cache                cache.Cache
+	webhookClient        *http.Client
+	videoStatus          *statusBroker
 }
 
 func main() {
+	migrateCommand := flag.String("migrate", "", "manage the database schema and exit: up, down or status")
+	migrateSteps := flag.Int("migrate-steps", 1, "how many migrations -migrate down rolls back")
+	flag.Parse()
+
 	godotenv.Load(".env")
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
-		log.Fatal("DB_URL must be set")
+	logger, err := newLogger(os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		log.Fatal(err)
 	}
+	slog.SetDefault(logger)
 
-	db, err := database.NewClient(pathToDB)
+	dbConfig, err := loadDatabaseConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *migrateCommand != "" {
+		err := runMigrateCommand(dbConfig, *migrateCommand, *migrateSteps)
+		if err != nil {
+			log.Fatalf("Couldn't migrate database: %v", err)
+		}
+		return
+	}
+
+	// Video rows and signed URLs are cached in Redis when it's configured
+	var redisCache *cache.Redis
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		redisCache, err = cache.NewRedis(ctx, redisURL, redisKeyPrefix)
+		cancel()
+		if err != nil {
+			log.Fatalf("Couldn't connect to Redis: %v", err)
+		}
+		dbConfig.Cache = redisCache
+		if value := os.Getenv("CACHE_TTL"); value != "" {
+			dbConfig.CacheTTL, err = time.ParseDuration(value)
+			if err != nil || dbConfig.CacheTTL <= 0 {
+				log.Fatal("CACHE_TTL must be a positive duration")
+			}
+		}
+	}
+
+	db, err := database.NewClient(dbConfig)
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
@@ -60,44 +154,567 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	port := os.Getenv("PORT")
+	if port == "" {
+		log.Fatal("PORT environment variable is not set")
+	}
+
+	// Objects live in S3 unless STORAGE_BACKEND picks another store. "local"
+	// keeps them on disk so development needs no cloud credentials.
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = "s3"
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	var (
+		s3Bucket, s3Region, s3Endpoint, s3BucketURL string
+		s3UsePathStyle                              bool
+		gcsConfig                                   storage.GCSConfig
+		azureConfig                                 storage.AzureConfig
+	)
+	switch storageBackend {
+	case "s3":
+		s3Bucket = os.Getenv("S3_BUCKET")
+		if s3Bucket == "" {
+			log.Fatal("S3_BUCKET environment variable is not set")
+		}
+
+		// Non-AWS services like MinIO and R2 are reached through their own
+		// endpoint, which is usually addressed path-style
+		s3Endpoint = os.Getenv("S3_ENDPOINT")
+		s3UsePathStyle = s3Endpoint != ""
+		if value := os.Getenv("S3_USE_PATH_STYLE"); value != "" {
+			s3UsePathStyle = value == "true"
+		}
+
+		s3Region = os.Getenv("S3_REGION")
+		if s3Region == "" && s3Endpoint != "" {
+			// Accepted by MinIO, and R2 treats it as "auto"
+			s3Region = "us-east-1"
+		}
+		if s3Region == "" {
+			log.Fatal("S3_REGION environment variable is not set")
+		}
+		s3BucketURL, err = bucketBaseURL(s3Endpoint, s3Bucket, s3Region, s3UsePathStyle)
+		if err != nil {
+			log.Fatalf("Invalid S3_ENDPOINT: %v", err)
+		}
+	case "local":
+		// Only used to name private object references
+		s3Bucket = "local"
+		s3BucketURL = fmt.Sprintf("http://localhost:%s%s", port, localObjectsPath)
+	case "gcs":
+		gcsConfig = storage.GCSConfig{
+			Bucket:          os.Getenv("GCS_BUCKET"),
+			CredentialsFile: os.Getenv("GCS_CREDENTIALS_FILE"),
+		}
+		if gcsConfig.Bucket == "" {
+			log.Fatal("GCS_BUCKET environment variable is not set")
+		}
+		s3Bucket = gcsConfig.Bucket
+		s3BucketURL = gcsConfig.BaseURL()
+	case "azure":
+		azureConfig = storage.AzureConfig{
+			AccountName: os.Getenv("AZURE_STORAGE_ACCOUNT"),
+			AccountKey:  os.Getenv("AZURE_STORAGE_KEY"),
+			Container:   os.Getenv("AZURE_STORAGE_CONTAINER"),
+			ServiceURL:  os.Getenv("AZURE_STORAGE_URL"),
+		}
+		if azureConfig.AccountName == "" || azureConfig.AccountKey == "" || azureConfig.Container == "" {
+			log.Fatal("AZURE_STORAGE_ACCOUNT, AZURE_STORAGE_KEY and AZURE_STORAGE_CONTAINER must all be set")
+		}
+		s3Bucket = azureConfig.Container
+		s3BucketURL, err = azureConfig.BaseURL()
+		if err != nil {
+			log.Fatalf("Invalid AZURE_STORAGE_URL: %v", err)
+		}
+	default:
+		log.Fatalf("STORAGE_BACKEND must be \"s3\", \"local\", \"gcs\" or \"azure\", got %q", storageBackend)
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
+	// Object URLs point at the CDN or public URL when one is configured, and
+	// the bucket's own endpoint otherwise. S3_CF_DISTRO is the older name.
+	s3ObjectBaseURL := strings.TrimSuffix(os.Getenv("S3_PUBLIC_URL"), "/")
+	s3CfDistribution := os.Getenv("S3_CF_DISTRIBUTION")
 	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+		s3CfDistribution = os.Getenv("S3_CF_DISTRO")
+	}
+	switch {
+	case s3ObjectBaseURL != "" && s3CfDistribution != "":
+		log.Fatal("Set only one of S3_PUBLIC_URL and S3_CF_DISTRIBUTION")
+	case s3CfDistribution != "":
+		s3ObjectBaseURL = "https://" + s3CfDistribution
+	case s3ObjectBaseURL == "":
+		s3ObjectBaseURL = s3BucketURL
+	}
+	if u, err := url.Parse(s3ObjectBaseURL); err != nil || u.Host == "" {
+		log.Fatal("S3_PUBLIC_URL must be an absolute URL")
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	// Distributions restricted to a trusted key group need signed URLs
+	var cfSigner *cloudFrontSigner
+	if keyPairID := os.Getenv("CF_KEY_PAIR_ID"); keyPairID != "" {
+		cfSigner, err = loadCloudFrontSigner(keyPairID, os.Getenv("CF_PRIVATE_KEY_PATH"))
+		if err != nil {
+			log.Fatalf("Couldn't load CF_PRIVATE_KEY_PATH: %v", err)
+		}
+	}
+
+	// Upload limits, in megabytes
+	maxVideoUploadBytes := int64(defaultMaxVideoUploadBytes)
+	if value := os.Getenv("MAX_VIDEO_UPLOAD_MB"); value != "" {
+		mb, err := strconv.Atoi(value)
+		if err != nil || mb < 1 {
+			log.Fatal("MAX_VIDEO_UPLOAD_MB must be a positive number")
+		}
+		maxVideoUploadBytes = int64(mb) << 20
+	}
+	batchUploadWorkers := defaultBatchUploadWorkers
+	if value := os.Getenv("BATCH_UPLOAD_WORKERS"); value != "" {
+		batchUploadWorkers, err = strconv.Atoi(value)
+		if err != nil || batchUploadWorkers < 1 {
+			log.Fatal("BATCH_UPLOAD_WORKERS must be a positive number")
+		}
+	}
+	viewDebounce := defaultViewDebounce
+	if value := os.Getenv("VIEW_DEBOUNCE"); value != "" {
+		viewDebounce, err = time.ParseDuration(value)
+		if err != nil || viewDebounce <= 0 {
+			log.Fatal("VIEW_DEBOUNCE must be a positive duration")
+		}
+	}
+	// How long an upload session may sit idle before it, and the pending
+	// video it was created with, are thrown away
+	uploadSessionTTL := defaultUploadSessionTTL
+	if value := os.Getenv("UPLOAD_SESSION_TTL"); value != "" {
+		uploadSessionTTL, err = time.ParseDuration(value)
+		if err != nil || uploadSessionTTL <= 0 {
+			log.Fatal("UPLOAD_SESSION_TTL must be a positive duration")
+		}
+	}
+	maxThumbnailBytes := int64(defaultMaxThumbnailUploadBytes)
+	if value := os.Getenv("MAX_THUMBNAIL_UPLOAD_MB"); value != "" {
+		mb, err := strconv.Atoi(value)
+		if err != nil || mb < 1 {
+			log.Fatal("MAX_THUMBNAIL_UPLOAD_MB must be a positive number")
+		}
+		maxThumbnailBytes = int64(mb) << 20
+	}
+	stripImageMetadata := os.Getenv("STRIP_IMAGE_METADATA") != "false"
+
+	// Uploads each client and user can start per second, 0 to disable
+	uploadRateLimit := defaultUploadRateLimit
+	if value := os.Getenv("UPLOAD_RATE_LIMIT"); value != "" {
+		uploadRateLimit, err = strconv.ParseFloat(value, 64)
+		if err != nil || uploadRateLimit < 0 {
+			log.Fatal("UPLOAD_RATE_LIMIT must be a non-negative number")
+		}
+	}
+	uploadBurst := defaultUploadBurst
+	if value := os.Getenv("UPLOAD_RATE_BURST"); value != "" {
+		uploadBurst, err = strconv.Atoi(value)
+		if err != nil || uploadBurst < 1 {
+			log.Fatal("UPLOAD_RATE_BURST must be a positive number")
+		}
+	}
+	var uploadRateLimiter *rateLimiter
+	if uploadRateLimit > 0 {
+		uploadRateLimiter = newRateLimiter(uploadRateLimit, uploadBurst)
+	}
+
+	// Comments each user can post per second, 0 to disable
+	commentRate := defaultCommentRate
+	if value := os.Getenv("COMMENT_RATE_LIMIT"); value != "" {
+		commentRate, err = strconv.ParseFloat(value, 64)
+		if err != nil || commentRate < 0 {
+			log.Fatal("COMMENT_RATE_LIMIT must be a non-negative number")
+		}
+	}
+	commentBurst := defaultCommentBurst
+	if value := os.Getenv("COMMENT_RATE_BURST"); value != "" {
+		commentBurst, err = strconv.Atoi(value)
+		if err != nil || commentBurst < 1 {
+			log.Fatal("COMMENT_RATE_BURST must be a positive number")
+		}
+	}
+	var commentRateLimiter *rateLimiter
+	if commentRate > 0 {
+		commentRateLimiter = newRateLimiter(commentRate, commentBurst)
+	}
+
+	// Storage each user gets unless an admin overrides it; 0 is unlimited
+	var defaultQuotaBytes int64
+	if value := os.Getenv("DEFAULT_QUOTA_MB"); value != "" {
+		mb, err := strconv.Atoi(value)
+		if err != nil || mb < 0 {
+			log.Fatal("DEFAULT_QUOTA_MB must be a non-negative number")
+		}
+		defaultQuotaBytes = int64(mb) << 20
+	}
+
+	// Directories videos are sorted into by shape
+	if value := os.Getenv("ASPECT_CLASSES"); value != "" {
+		aspectClasses, err = parseAspectClasses(value)
+		if err != nil {
+			log.Fatalf("Invalid ASPECT_CLASSES: %v", err)
+		}
+	}
+
+	// Media types accepted for videos and thumbnails, comma separated
+	allowedMediaTypesValue := os.Getenv("ALLOWED_MEDIA_TYPES")
+	if allowedMediaTypesValue == "" {
+		allowedMediaTypesValue = defaultAllowedMediaTypes
+	}
+	allowedMediaTypes, err := parseAllowedMediaTypes(allowedMediaTypesValue)
+	if err != nil {
+		log.Fatalf("Invalid ALLOWED_MEDIA_TYPES: %v", err)
+	}
+
+	// Uploads are staged here before processing, so it needs room for at
+	// least maxVideoUploadBytes (twice that while fast-start runs), times
+	// BATCH_UPLOAD_WORKERS for batch uploads
+	tempDir := os.Getenv("TEMP_DIR")
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	// Uploads that would leave less than this free in TEMP_DIR are refused
+	minTempFreeBytes := int64(defaultMinTempFreeBytes)
+	if value := os.Getenv("TEMP_DIR_MIN_FREE_MB"); value != "" {
+		mb, err := strconv.Atoi(value)
+		if err != nil || mb < 0 {
+			log.Fatal("TEMP_DIR_MIN_FREE_MB must be a number, or 0 for no minimum")
+		}
+		minTempFreeBytes = int64(mb) << 20
+	}
+	tempFileMaxAge := defaultTempFileMaxAge
+	if value := os.Getenv("TEMP_FILE_MAX_AGE"); value != "" {
+		tempFileMaxAge, err = time.ParseDuration(value)
+		if err != nil || tempFileMaxAge < time.Hour {
+			log.Fatal("TEMP_FILE_MAX_AGE must be a duration of at least 1h")
+		}
+	}
+
+	// Other origins allowed to call the API from a browser
+	cors, err := loadCORSPolicy(platform)
+	if err != nil {
+		log.Fatalf("Invalid CORS settings: %v", err)
+	}
+
+	enablePerceptualHash := os.Getenv("ENABLE_PERCEPTUAL_HASH") == "true"
+	enableDedupe := os.Getenv("ENABLE_DEDUPE") == "true"
+
+	// With processing off, multipart uploads are streamed to S3 as they
+	// arrive instead of being probed and remuxed for fast start
+	processVideos := os.Getenv("PROCESS_VIDEOS") != "false"
+	if !processVideos && enablePerceptualHash {
+		log.Fatal("ENABLE_PERCEPTUAL_HASH needs PROCESS_VIDEOS")
+	}
+
+	// Animated hover previews are opt-in, since they cost another ffmpeg run
+	previewFormat := os.Getenv("PREVIEW_FORMAT")
+	if _, ok := previewMediaTypes[previewFormat]; previewFormat != "" && !ok {
+		log.Fatal("PREVIEW_FORMAT must be gif or webp")
+	}
+	if previewFormat != "" && !processVideos {
+		log.Fatal("PREVIEW_FORMAT needs PROCESS_VIDEOS")
+	}
+
+	// Optional malware scanning of uploads, through clamd or a command
+	var scanner scan.Scanner
+	clamdAddress := os.Getenv("CLAMD_ADDRESS")
+	scanCommand := os.Getenv("SCAN_COMMAND")
+	switch {
+	case clamdAddress != "" && scanCommand != "":
+		log.Fatal("Set only one of CLAMD_ADDRESS and SCAN_COMMAND")
+	case clamdAddress != "":
+		scanner, err = scan.NewClamd(clamdAddress)
+	case scanCommand != "":
+		scanner, err = scan.NewCommand(scanCommand)
+	}
+	if err != nil {
+		log.Fatalf("Invalid malware scanner: %v", err)
+	}
+	if scanner != nil && !processVideos {
+		// Streamed uploads are never on disk to scan
+		log.Fatal("Malware scanning needs PROCESS_VIDEOS")
+	}
+
+	// Optional default storage class for uploaded videos, e.g. STANDARD_IA
+	var s3StorageClass types.StorageClass
+	if value := os.Getenv("S3_STORAGE_CLASS"); value != "" {
+		s3StorageClass, err = parseStorageClass(value)
+		if err != nil {
+			log.Fatalf("Invalid S3_STORAGE_CLASS: %v", err)
+		}
+	}
+
+	// Optional Cache-Control for each type of stored object and local asset
+	objectCache, err := loadObjectCachePolicy()
+	if err != nil {
+		log.Fatalf("Invalid cache control: %v", err)
+	}
+
+	// Optional server-side encryption of everything stored in S3, instead of
+	// the bucket's default
+	var s3Encryption storage.S3Encryption
+	switch value := os.Getenv("S3_SSE"); value {
+	case "":
+	case string(types.ServerSideEncryptionAes256), string(types.ServerSideEncryptionAwsKms):
+		s3Encryption.Algorithm = types.ServerSideEncryption(value)
+	default:
+		log.Fatal("S3_SSE must be AES256 or aws:kms")
+	}
+	s3Encryption.KMSKeyID = os.Getenv("S3_SSE_KMS_KEY_ID")
+	s3Encryption.BucketKey = os.Getenv("S3_SSE_BUCKET_KEY") == "true"
+	if (s3Encryption.KMSKeyID != "" || s3Encryption.BucketKey) && s3Encryption.Algorithm != types.ServerSideEncryptionAwsKms {
+		log.Fatal("S3_SSE_KMS_KEY_ID and S3_SSE_BUCKET_KEY need S3_SSE set to aws:kms")
+	}
+
+	// Optional envelope encryption of video files by the server before they
+	// reach storage, with data keys wrapped by KMS or a local master key
+	var videoKeys storage.KeyWrapper
+	switch mode := os.Getenv("VIDEO_ENCRYPTION"); mode {
+	case "":
+	case "local":
+		masterKey, err := base64.StdEncoding.DecodeString(os.Getenv("VIDEO_ENCRYPTION_KEY"))
+		if err != nil {
+			log.Fatal("VIDEO_ENCRYPTION_KEY must be base64 encoded")
+		}
+		videoKeys, err = storage.NewLocalKeyWrapper(masterKey)
+		if err != nil {
+			log.Fatalf("Invalid VIDEO_ENCRYPTION_KEY: %v", err)
+		}
+	case "kms":
+		keyID := os.Getenv("VIDEO_ENCRYPTION_KMS_KEY_ID")
+		if keyID == "" {
+			log.Fatal("VIDEO_ENCRYPTION_KMS_KEY_ID must be set when VIDEO_ENCRYPTION is kms")
+		}
+		c, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(s3Region))
+		if err != nil {
+			log.Fatal("Unable to load config")
+		}
+		videoKeys = storage.NewKMSKeyWrapper(kms.NewFromConfig(c), keyID)
+	default:
+		log.Fatal("VIDEO_ENCRYPTION must be local or kms")
+	}
+
+	// Videos at least this big are sent to S3 as multipart uploads
+	s3PartSize := int64(storage.DefaultPartSize)
+	if value := os.Getenv("S3_PART_SIZE_MB"); value != "" {
+		mb, err := strconv.Atoi(value)
+		if err != nil || int64(mb)<<20 < storage.MinPartSize {
+			log.Fatalf("S3_PART_SIZE_MB must be a whole number of at least %d", storage.MinPartSize>>20)
+		}
+		s3PartSize = int64(mb) << 20
+	}
+
+	s3UploadConcurrency := storage.DefaultUploadConcurrency
+	if value := os.Getenv("S3_UPLOAD_CONCURRENCY"); value != "" {
+		s3UploadConcurrency, err = strconv.Atoi(value)
+		if err != nil || s3UploadConcurrency < 1 {
+			log.Fatal("S3_UPLOAD_CONCURRENCY must be a positive number")
+		}
+	}
+
+	// How hard S3 calls are retried, on top of which the circuit breaker
+	// fails them fast after S3_BREAKER_THRESHOLD in a row have failed. A
+	// threshold of 0 turns the breaker off.
+	s3MaxAttempts := retry.DefaultMaxAttempts
+	if value := os.Getenv("S3_MAX_ATTEMPTS"); value != "" {
+		s3MaxAttempts, err = strconv.Atoi(value)
+		if err != nil || s3MaxAttempts < 1 {
+			log.Fatal("S3_MAX_ATTEMPTS must be a positive number")
+		}
+	}
+	s3MaxBackoff := retry.DefaultMaxBackoff
+	if value := os.Getenv("S3_MAX_BACKOFF"); value != "" {
+		s3MaxBackoff, err = time.ParseDuration(value)
+		if err != nil || s3MaxBackoff <= 0 {
+			log.Fatal("S3_MAX_BACKOFF must be a positive duration")
+		}
+	}
+	s3BreakerThreshold := defaultS3BreakerThreshold
+	if value := os.Getenv("S3_BREAKER_THRESHOLD"); value != "" {
+		s3BreakerThreshold, err = strconv.Atoi(value)
+		if err != nil || s3BreakerThreshold < 0 {
+			log.Fatal("S3_BREAKER_THRESHOLD must be a whole number")
+		}
+	}
+	s3BreakerCooldown := defaultS3BreakerCooldown
+	if value := os.Getenv("S3_BREAKER_COOLDOWN"); value != "" {
+		s3BreakerCooldown, err = time.ParseDuration(value)
+		if err != nil || s3BreakerCooldown <= 0 {
+			log.Fatal("S3_BREAKER_COOLDOWN must be a positive duration")
+		}
+	}
+
+	// Thumbnails live in the assets dir unless THUMBNAIL_STORAGE is "s3"
+	thumbnailsInS3 := false
+	switch value := os.Getenv("THUMBNAIL_STORAGE"); value {
+	case "", "local":
+	case "s3":
+		thumbnailsInS3 = true
+	default:
+		log.Fatalf("THUMBNAIL_STORAGE must be \"local\" or \"s3\", got %q", value)
+	}
+
+	// Private buckets get presigned GET URLs instead of public ones
+	s3Private := os.Getenv("S3_PRIVATE") == "true"
+	s3PresignExpiry := defaultS3PresignExpiry
+	if value := os.Getenv("S3_PRESIGN_EXPIRY"); value != "" {
+		s3PresignExpiry, err = time.ParseDuration(value)
+		if err != nil || s3PresignExpiry <= 0 || s3PresignExpiry > 7*24*time.Hour {
+			log.Fatal("S3_PRESIGN_EXPIRY must be a duration between 1s and 168h")
+		}
 	}
 
-	c, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(s3Region))
+	var store storage.ObjectStore
+	switch storageBackend {
+	case "local":
+		root := os.Getenv("STORAGE_LOCAL_ROOT")
+		if root == "" {
+			root = defaultLocalStorageRoot
+		}
+		store, err = storage.NewLocal(root, s3ObjectBaseURL, []byte(jwtSecret), s3Private)
+		if err != nil {
+			log.Fatalf("Couldn't create STORAGE_LOCAL_ROOT: %v", err)
+		}
+	case "gcs":
+		gcsConfig.ChunkSize = int(s3PartSize)
+		store, err = storage.NewGCS(context.Background(), gcsConfig)
+		if err != nil {
+			log.Fatalf("Couldn't create GCS client: %v", err)
+		}
+	case "azure":
+		azureConfig.BlockSize = s3PartSize
+		azureConfig.Concurrency = s3UploadConcurrency
+		store, err = storage.NewAzure(azureConfig)
+		if err != nil {
+			log.Fatalf("Couldn't create Azure client: %v", err)
+		}
+	default:
+		c, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(s3Region))
+		if err != nil {
+			log.Fatal("Unable to load config")
+		}
+
+		s3Client := s3.NewFromConfig(c, func(o *s3.Options) {
+			if s3Endpoint != "" {
+				o.BaseEndpoint = aws.String(s3Endpoint)
+			}
+			o.UsePathStyle = s3UsePathStyle
+			// Exponential backoff with full jitter, capped at s3MaxBackoff
+			o.Retryer = retry.NewStandard(func(so *retry.StandardOptions) {
+				so.MaxAttempts = s3MaxAttempts
+				so.MaxBackoff = s3MaxBackoff
+			})
+			o.APIOptions = append(o.APIOptions, s3Logging, s3Metrics)
+			if s3BreakerThreshold > 0 {
+				o.APIOptions = append(o.APIOptions, newCircuitBreaker(s3BreakerThreshold, s3BreakerCooldown).s3Middleware)
+			}
+		})
+		store = storage.NewS3(s3Client, s3Bucket, s3PartSize, s3UploadConcurrency, s3Encryption)
+	}
+
+	// Optional speech-to-text captions for uploads, through AWS Transcribe or
+	// whisper.cpp. Transcribe reads the audio from the bucket, so it's
+	// staged there unencrypted for the length of the job.
+	var transcriber transcribe.Transcriber
+	switch os.Getenv("TRANSCRIBE_BACKEND") {
+	case "":
+	case "whisper":
+		transcriber, err = transcribe.NewWhisper(os.Getenv("WHISPER_COMMAND"), os.Getenv("WHISPER_MODEL"))
+		if err != nil {
+			log.Fatalf("Invalid whisper.cpp settings: %v", err)
+		}
+	case "aws":
+		if _, ok := store.(*storage.S3); !ok {
+			log.Fatal("TRANSCRIBE_BACKEND=aws needs S3 storage")
+		}
+		c, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(s3Region))
+		if err != nil {
+			log.Fatal("Unable to load config")
+		}
+		transcriber = transcribe.NewAWS(awstranscribe.NewFromConfig(c), store, s3Bucket)
+	default:
+		log.Fatal("TRANSCRIBE_BACKEND must be aws or whisper")
+	}
+	if transcriber != nil && !processVideos {
+		// Only probed uploads are known to have sound
+		log.Fatal("Transcription needs PROCESS_VIDEOS")
+	}
+	transcribeLanguage := os.Getenv("TRANSCRIBE_LANGUAGE")
+	if transcribeLanguage == "" {
+		transcribeLanguage = defaultTranscribeLanguage
+	}
+	if !captionLangPattern.MatchString(transcribeLanguage) {
+		log.Fatal("TRANSCRIBE_LANGUAGE must be a language tag like en-US")
+	}
+
+	// Optional checks of thumbnails and sampled frames for explicit content
+	moderation, err := loadModerationPolicy(s3Region)
 	if err != nil {
-		log.Fatal("Unable to load config")
+		log.Fatalf("Invalid moderation settings: %v", err)
+	}
+	if moderation.moderator != nil && moderation.frames > 0 && !processVideos {
+		// Only probed uploads are known to have frames, and where they are
+		log.Fatal("Moderating frames needs PROCESS_VIDEOS, or MODERATION_FRAMES=0")
 	}
 
-	s3Client := s3.NewFromConfig(c)
+	// The local store serves its own files and S3 tags its own objects,
+	// encrypted or not
+	localStore, _ := store.(*storage.Local)
+	objectTagger, _ := store.(storage.Tagger)
+	if videoKeys != nil {
+		store = storage.NewEncrypted(store, videoKeys)
+	}
 
 	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
-		s3Client:         s3Client,
+		db:                   db,
+		jwtSecret:            jwtSecret,
+		platform:             platform,
+		filepathRoot:         filepathRoot,
+		assetsRoot:           assetsRoot,
+		s3Bucket:             s3Bucket,
+		s3Region:             s3Region,
+		s3ObjectBaseURL:      s3ObjectBaseURL,
+		s3Private:            s3Private,
+		s3PresignExpiry:      s3PresignExpiry,
+		cfSigner:             cfSigner,
+		thumbnailsInS3:       thumbnailsInS3,
+		maxVideoUploadBytes:  maxVideoUploadBytes,
+		batchUploadWorkers:   batchUploadWorkers,
+		uploadSessionTTL:     uploadSessionTTL,
+		defaultQuotaBytes:    defaultQuotaBytes,
+		maxThumbnailBytes:    maxThumbnailBytes,
+		stripImageMetadata:   stripImageMetadata,
+		allowedMediaTypes:    allowedMediaTypes,
+		port:                 port,
+		store:                store,
+		objectTagger:         objectTagger,
+		encryptVideos:        videoKeys != nil,
+		tempDir:              tempDir,
+		tempSpace:            &tempSpaceGuard{dir: tempDir, minFree: minTempFreeBytes},
+		cors:                 cors,
+		enablePerceptualHash: enablePerceptualHash,
+		processVideos:        processVideos,
+		previewFormat:        previewFormat,
+		scanner:              scanner,
+		transcriber:          transcriber,
+		transcribeLanguage:   transcribeLanguage,
+		moderation:           moderation,
+		enableDedupe:         enableDedupe,
+		s3StorageClass:       s3StorageClass,
+		objectCache:          objectCache,
+		uploadLocks:          newKeyedLocker(),
+		uploadProgress:       newUploadProgressTracker(),
+		uploadRateLimiter:    uploadRateLimiter,
+		commentRateLimiter:   commentRateLimiter,
+		exportWake:           make(chan struct{}, 1),
+		viewTracker:          newViewTracker(viewDebounce),
+	}
+	if redisCache != nil {
+		cfg.cache = redisCache
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -105,33 +722,283 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	err = cfg.ensureTempDirWritable()
+	if err != nil {
+		log.Fatalf("TEMP_DIR %q is not a writable directory: %v", tempDir, err)
+	}
+	// Files that only live as long as a request or transcode job go in a
+	// directory of their own, which is removed on shutdown. Resumable
+	// uploads outlive restarts, so they stay in TEMP_DIR.
+	cfg.workDir, err = os.MkdirTemp(tempDir, "tubely-work-*")
+	if err != nil {
+		log.Fatalf("Couldn't create work directory: %v", err)
+	}
+	// Before serving, so what a crash left behind doesn't count against
+	// the first uploads
+	cfg.sweepTempFiles(tempFileMaxAge)
+
+	// Limits on single ffprobe and ffmpeg runs, so malformed files can't
+	// hang a request
+	if value := os.Getenv("FFPROBE_TIMEOUT"); value != "" {
+		ffprobeTimeout, err = time.ParseDuration(value)
+		if err != nil || ffprobeTimeout <= 0 {
+			log.Fatal("FFPROBE_TIMEOUT must be a positive duration")
+		}
+	}
+	if value := os.Getenv("FFMPEG_TIMEOUT"); value != "" {
+		ffmpegTimeout, err = time.ParseDuration(value)
+		if err != nil || ffmpegTimeout <= 0 {
+			log.Fatal("FFMPEG_TIMEOUT must be a positive duration")
+		}
+	}
+
+	// How long shutdown waits for in-flight uploads and background jobs
+	shutdownTimeout := defaultShutdownTimeout
+	if value := os.Getenv("SHUTDOWN_TIMEOUT"); value != "" {
+		shutdownTimeout, err = time.ParseDuration(value)
+		if err != nil || shutdownTimeout <= 0 {
+			log.Fatal("SHUTDOWN_TIMEOUT must be a positive duration")
+		}
+	}
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	jobs := &sync.WaitGroup{}
+
+	// Work that has to outlive the request that asked for it, like webhook
+	// deliveries, is kept in the database until it's done
+	jobWorkers := defaultJobWorkers
+	if value := os.Getenv("JOB_WORKERS"); value != "" {
+		jobWorkers, err = strconv.Atoi(value)
+		if err != nil || jobWorkers < 1 {
+			log.Fatal("JOB_WORKERS must be a positive number")
+		}
+	}
+	cfg.webhookClient = newWebhookHTTPClient(platform == "dev")
+	cfg.startJobQueue(jobWorkers)
+	cfg.videoStatus = newStatusBroker()
+
+	// Background transcoding into lower resolution renditions, HLS packaging
+	// and seek bar sprite sheets are all opt-in
+	enableTranscoding := os.Getenv("ENABLE_TRANSCODING") == "true"
+	enableHLS := os.Getenv("ENABLE_HLS") == "true"
+	enableSprites := os.Getenv("ENABLE_SPRITES") == "true"
+	if enableHLS && cfg.signsObjectURLs() {
+		// Segments are fetched relative to their playlist, which can't be
+		// signed per object
+		log.Fatal("ENABLE_HLS isn't supported with S3_PRIVATE or CF_KEY_PAIR_ID")
+	}
+	if enableSprites && cfg.signsObjectURLs() {
+		// Likewise sprite sheets are named relative to their WebVTT file
+		log.Fatal("ENABLE_SPRITES isn't supported with S3_PRIVATE or CF_KEY_PAIR_ID")
+	}
+	// Segments are fetched by players straight from storage
+	if enableHLS && cfg.encryptVideos {
+		log.Fatal("ENABLE_HLS isn't supported with VIDEO_ENCRYPTION")
+	}
+	if (enableTranscoding || enableHLS || enableSprites) && !processVideos {
+		log.Fatal("ENABLE_TRANSCODING, ENABLE_HLS and ENABLE_SPRITES need PROCESS_VIDEOS")
+	}
+	if enableTranscoding || enableHLS || enableSprites {
+		workers := 2
+		if value := os.Getenv("TRANSCODE_WORKERS"); value != "" {
+			workers, err = strconv.Atoi(value)
+			if err != nil || workers < 1 {
+				log.Fatal("TRANSCODE_WORKERS must be a positive number")
+			}
+		}
+		var renditions []transcode.Rendition
+		if enableTranscoding {
+			renditions = transcode.DefaultRenditions
+		}
+		cfg.transcodeQueue = transcode.NewQueue(workers, 100, cfg.workDir, renditions, enableHLS, enableSprites, cfg.handleTranscodeResult)
+	}
+
+	// Periodically remove S3 objects nothing refers to any more. Off unless
+	// an interval is set.
+	if value := os.Getenv("ORPHAN_SWEEP_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < time.Minute {
+			log.Fatal("ORPHAN_SWEEP_INTERVAL must be a duration of at least 1m")
+		}
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			cfg.runOrphanSweeper(jobsCtx, interval)
+		}()
+	}
+
+	// How long deleted videos stay in the trash before they're purged
+	trashRetention := defaultTrashRetention
+	if value := os.Getenv("TRASH_RETENTION"); value != "" {
+		trashRetention, err = time.ParseDuration(value)
+		if err != nil || trashRetention <= 0 {
+			log.Fatal("TRASH_RETENTION must be a positive duration")
+		}
+	}
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		cfg.runTrashPurger(jobsCtx, trashRetention)
+	}()
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		cfg.runUploadExpirer(jobsCtx)
+	}()
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		cfg.runTempSweeper(jobsCtx, tempFileMaxAge)
+	}()
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		cfg.runExporter(jobsCtx)
+	}()
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	mux.HandleFunc("GET /assets/{name}", cfg.handlerAsset)
+	if localStore != nil {
+		mux.Handle("GET "+localObjectsPath+"/", http.StripPrefix(localObjectsPath, cfg.objectCacheMiddleware(localStore)))
+	}
+
+	// Upload routes also take an API key, for scripts and CI, and an
+	// Idempotency-Key so POSTs can be retried safely
+	withAPIKey := func(handler http.HandlerFunc) http.Handler {
+		return cfg.apiKeyMiddleware(cfg.idempotencyMiddleware(handler))
+	}
+	// File uploads are rate limited as well, though replayed responses
+	// aren't counted
+	limitedUpload := func(handler http.HandlerFunc) http.Handler {
+		return cfg.apiKeyMiddleware(cfg.idempotencyMiddleware(cfg.rateLimitMiddleware(handler)))
+	}
+
+	requireUser := func(handler http.HandlerFunc) http.Handler {
+		return cfg.requireRole()(handler)
+	}
+	requireModerator := func(handler http.HandlerFunc) http.Handler {
+		return cfg.requireRole(auth.RoleModerator, auth.RoleAdmin)(handler)
+	}
+	requireAdmin := func(handler http.HandlerFunc) http.Handler {
+		return cfg.requireRole(auth.RoleAdmin)(handler)
+	}
+
+	mux.Handle("GET /metrics", metricsRegistry)
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
 
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("POST /api/api_keys", cfg.handlerAPIKeysCreate)
+	mux.HandleFunc("GET /api/api_keys", cfg.handlerAPIKeysList)
+	mux.HandleFunc("DELETE /api/api_keys/{keyID}", cfg.handlerAPIKeysRevoke)
+	mux.Handle("POST /api/webhooks", requireUser(cfg.handlerWebhooksCreate))
+	mux.Handle("GET /api/webhooks", requireUser(cfg.handlerWebhooksList))
+	mux.Handle("DELETE /api/webhooks/{webhookID}", requireUser(cfg.handlerWebhooksDelete))
+	mux.Handle("POST /api/exports", requireUser(cfg.handlerExportsCreate))
+	mux.Handle("GET /api/exports", requireUser(cfg.handlerExportsList))
+	mux.Handle("GET /api/exports/{exportID}", requireUser(cfg.handlerExportGet))
+	mux.Handle("DELETE /api/exports/{exportID}", requireUser(cfg.handlerExportDelete))
+	mux.Handle("GET /api/users/me/usage", requireUser(cfg.handlerUserUsage))
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.Handle("POST /api/videos", withAPIKey(cfg.handlerVideoMetaCreate))
+	mux.Handle("POST /api/thumbnail_upload/{videoID}", limitedUpload(cfg.handlerUploadThumbnail))
+	mux.Handle("POST /api/videos/{videoID}/thumbnail/generate", limitedUpload(cfg.handlerGenerateThumbnail))
+	mux.Handle("POST /api/video_upload/{videoID}", limitedUpload(cfg.handlerUploadVideo))
+	mux.Handle("POST /api/video_upload/{videoID}/chunks", limitedUpload(cfg.handlerUploadVideoChunk))
+	mux.Handle("GET /api/video_upload/{videoID}/chunks", cfg.requireOwnerOrAdmin(http.HandlerFunc(cfg.handlerVideoChunks)))
+	mux.Handle("POST /api/videos/batch", limitedUpload(cfg.handlerBatchUploadVideos))
+	mux.Handle("POST /api/audio_upload/{videoID}", limitedUpload(cfg.handlerUploadAudio))
+	mux.Handle("POST /api/videos/{videoID}/captions", withAPIKey(cfg.handlerUploadCaptions))
+	mux.HandleFunc("GET /api/videos/{videoID}/captions", cfg.handlerCaptionsList)
+	mux.Handle("DELETE /api/videos/{videoID}/captions/{lang}", withAPIKey(cfg.handlerCaptionsDelete))
+	mux.Handle("POST /api/videos/{videoID}/import", limitedUpload(cfg.handlerImportVideo))
+	mux.Handle("POST /api/videos/{videoID}/upload_url", withAPIKey(cfg.handlerRequestUploadURL))
+	mux.Handle("POST /api/videos/{videoID}/upload_confirm", withAPIKey(cfg.handlerConfirmUpload))
+	mux.Handle("POST /api/videos/{videoID}/upload-url", withAPIKey(cfg.handlerRequestUploadURL))
+	mux.Handle("POST /api/videos/{videoID}/upload-url/complete", withAPIKey(cfg.handlerConfirmUpload))
+	mux.Handle("POST /api/videos/{videoID}/uploads", withAPIKey(cfg.handlerCreateResumableUpload))
+	mux.Handle("GET /api/uploads/{uploadID}", withAPIKey(cfg.handlerGetResumableUpload))
+	mux.Handle("PATCH /api/uploads/{uploadID}", withAPIKey(cfg.handlerPatchResumableUpload))
+	mux.Handle("DELETE /api/uploads/{uploadID}", withAPIKey(cfg.handlerDeleteResumableUpload))
+	mux.Handle("GET /api/uploads/{uploadID}/progress", withAPIKey(cfg.handlerUploadProgress))
+	mux.Handle("POST /api/videos/{videoID}/probe", withAPIKey(cfg.handlerProbeVideo))
+	mux.HandleFunc("GET /api/videos/{videoID}/similar", cfg.handlerSimilarVideos)
+	mux.Handle("GET /api/videos", requireUser(cfg.handlerVideosRetrieve))
+	mux.Handle("GET /api/videos/trash", requireUser(cfg.handlerVideosTrash))
+	mux.Handle("GET /api/videos/search", requireUser(cfg.handlerVideosSearch))
+	mux.Handle("GET /api/tags", requireUser(cfg.handlerTagsList))
+	mux.Handle("POST /api/playlists", requireUser(cfg.handlerPlaylistsCreate))
+	mux.Handle("GET /api/playlists", requireUser(cfg.handlerPlaylistsList))
+	mux.HandleFunc("GET /api/playlists/{playlistID}", cfg.handlerPlaylistGet)
+	mux.Handle("PATCH /api/playlists/{playlistID}", requireUser(cfg.handlerPlaylistUpdate))
+	mux.Handle("DELETE /api/playlists/{playlistID}", requireUser(cfg.handlerPlaylistDelete))
+	mux.Handle("POST /api/playlists/{playlistID}/videos", requireUser(cfg.handlerPlaylistVideoAdd))
+	mux.Handle("PUT /api/playlists/{playlistID}/videos", requireUser(cfg.handlerPlaylistReorder))
+	mux.Handle("DELETE /api/playlists/{playlistID}/videos/{videoID}", requireUser(cfg.handlerPlaylistVideoRemove))
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("GET /api/videos/{videoID}/stream", cfg.handlerVideoStream)
+	mux.HandleFunc("GET /api/videos/{videoID}/download", cfg.handlerVideoDownload)
+	mux.HandleFunc("GET /api/videos/{videoID}/playlist.m3u8", cfg.handlerVideoPlaylist)
+	mux.Handle("GET /api/videos/{videoID}/stats", cfg.requireOwnerOrAdmin(http.HandlerFunc(cfg.handlerVideoStats)))
+	mux.Handle("POST /api/videos/{videoID}/comments", requireUser(cfg.handlerCommentsCreate))
+	mux.HandleFunc("GET /api/videos/{videoID}/comments", cfg.handlerCommentsList)
+	mux.Handle("DELETE /api/videos/{videoID}/comments/{commentID}", requireUser(cfg.handlerCommentDelete))
+	mux.Handle("GET /api/videos/{videoID}/events", cfg.requireOwnerOrAdmin(http.HandlerFunc(cfg.handlerVideoEvents)))
+	mux.HandleFunc("DELETE /api/videos", cfg.handlerBatchDeleteVideos)
+	// For clients and proxies that drop DELETE bodies
+	mux.HandleFunc("POST /api/videos/batch-delete", cfg.handlerBatchDeleteVideos)
+	mux.Handle("POST /api/videos/{videoID}/share", cfg.requireOwnerOrAdmin(http.HandlerFunc(cfg.handlerCreateShareLink)))
+	mux.HandleFunc("GET /share/{token}", cfg.handlerShareLink)
+	mux.Handle("PATCH /api/videos/{videoID}", cfg.requireOwnerOrAdmin(http.HandlerFunc(cfg.handlerVideoMetaUpdate)))
+	mux.Handle("DELETE /api/videos/{videoID}", cfg.requireOwnerOrAdminWithTrash(http.HandlerFunc(cfg.handlerVideoMetaDelete)))
+	mux.Handle("POST /api/videos/{videoID}/restore", cfg.requireOwnerOrAdminWithTrash(http.HandlerFunc(cfg.handlerVideoRestore)))
+	mux.Handle("POST /api/videos/{videoID}/tags", cfg.requireOwnerOrAdmin(http.HandlerFunc(cfg.handlerVideoTagsAdd)))
+	mux.Handle("DELETE /api/videos/{videoID}/tags/{tag}", cfg.requireOwnerOrAdmin(http.HandlerFunc(cfg.handlerVideoTagDelete)))
+	mux.Handle("POST /api/videos/{videoID}/duplicate", cfg.requireOwnerOrAdmin(http.HandlerFunc(cfg.handlerDuplicateVideo)))
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.Handle("POST /admin/migrate_thumbnails", requireAdmin(cfg.handlerMigrateThumbnails))
+	mux.Handle("GET /admin/videos", requireModerator(cfg.handlerAdminVideosList))
+	mux.Handle("DELETE /admin/videos/{videoID}", requireModerator(cfg.handlerAdminVideoDelete))
+	mux.Handle("GET /admin/usage", requireAdmin(cfg.handlerAdminStorageUsage))
+	mux.Handle("GET /api/admin/storage-report", requireAdmin(cfg.handlerAdminStorageReport))
+	mux.Handle("GET /admin/storage_report", requireAdmin(cfg.handlerAdminStorageReport))
+	mux.Handle("POST /admin/retag_objects", requireAdmin(cfg.handlerAdminRetagObjects))
+	mux.Handle("PUT /admin/users/{userID}/role", requireAdmin(cfg.handlerAdminSetUserRole))
+	mux.Handle("PUT /admin/users/{userID}/quota", requireAdmin(cfg.handlerAdminSetUserQuota))
+	mux.Handle("PUT /admin/users/{userID}/plan", requireAdmin(cfg.handlerAdminSetUserPlan))
+	mux.Handle("GET /admin/users/{userID}/scan_incidents", requireAdmin(cfg.handlerAdminScanIncidents))
+	mux.Handle("GET /admin/jobs", requireAdmin(cfg.handlerAdminJobsList))
+	mux.Handle("POST /admin/jobs/{jobID}/requeue", requireAdmin(cfg.handlerAdminJobRequeue))
+
+	// Requests are checked against the API's OpenAPI document, which
+	// clients can fetch to generate their own code from
+	apiDoc := apiSpec()
+	openAPIHandler, err := handlerOpenAPI(apiDoc)
+	if err != nil {
+		log.Fatalf("Couldn't encode OpenAPI document: %v", err)
+	}
+	mux.HandleFunc("GET /api/openapi.json", openAPIHandler)
+	err = checkAPISpec(apiDoc, mux)
+	if err != nil {
+		log.Fatalf("OpenAPI document is out of date: %v", err)
+	}
 
+	inFlight := &sync.WaitGroup{}
 	srv := &http.Server{
 		Addr:    ":" + port,
-		Handler: mux,
+		Handler: inFlightMiddleware(inFlight, requestIDMiddleware(cfg.accessLogMiddleware(cfg.corsMiddleware(metricsMiddleware(openAPIValidationMiddleware(apiDoc, mux)))))),
 	}
+	// Status streams never end on their own, so Shutdown would wait on them
+	srv.RegisterOnShutdown(cfg.videoStatus.close)
 
-	log.Printf("Serving on: http://localhost:%s/app/\n", port)
-	log.Fatal(srv.ListenAndServe())
+	slog.Info("Serving", "url", fmt.Sprintf("http://localhost:%s/app/", port))
+	err = cfg.serveUntilSignalled(srv, inFlight, stopJobs, jobs, shutdownTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFFprobeTimeout = 30 * time.Second
+	defaultFFmpegTimeout  = 10 * time.Minute
+)
+
+// How long a single ffprobe or ffmpeg run may take before it's killed, so a
+// malformed file can't hold a request forever. Set from FFPROBE_TIMEOUT and
+// FFMPEG_TIMEOUT.
+var (
+	ffprobeTimeout = defaultFFprobeTimeout
+	ffmpegTimeout  = defaultFFmpegTimeout
+)
+
+var errMediaCommandTimeout = errors.New("timed out")
+
+// mediaCommandError is a failed ffprobe or ffmpeg run, carrying what it
+// printed to stderr.
+type mediaCommandError struct {
+	Command string
+	Err     error
+	Stderr  string
+}
+
+func (e *mediaCommandError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("%s: %v", e.Command, e.Err)
+	}
+	return fmt.Sprintf("%s: %v: %s", e.Command, e.Err, e.Stderr)
+}
+
+func (e *mediaCommandError) Unwrap() error {
+	return e.Err
+}
+
+// runMediaCommand runs ffprobe or ffmpeg, killing it if ctx is done or it
+// runs longer than timeout, and records its duration under metric. Output
+// goes to stdout, which may be nil.
+func runMediaCommand(ctx context.Context, metric string, timeout time.Duration, stdout io.Writer, name string, args ...string) error {
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(cmdCtx, name, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	defer observeCommand(metric, time.Now())
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == nil && cmdCtx.Err() != nil:
+		err = fmt.Errorf("%w after %v", errMediaCommandTimeout, timeout)
+	case !errors.As(err, &exitErr):
+		// ffmpeg couldn't be started at all, which isn't the file's fault
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return &mediaCommandError{
+		Command: name,
+		Err:     err,
+		Stderr:  strings.TrimSpace(stderr.String()),
+	}
+}
+
+// respondWithMediaError responds 422 when ffprobe or ffmpeg rejected or
+// choked on the file, and 500 for anything else.
+func respondWithMediaError(w http.ResponseWriter, msg string, err error) {
+	var cmdErr *mediaCommandError
+	if errors.As(err, &cmdErr) {
+		if errors.Is(err, errMediaCommandTimeout) {
+			msg = "Timed out processing video, it may be corrupt"
+		}
+		respondWithError(w, http.StatusUnprocessableEntity, msg, err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, msg, err)
+}
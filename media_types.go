@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultMaxVideoUploadBytes     = 1 << 30
+	defaultMaxThumbnailUploadBytes = 10 << 20
+)
+
+// supportedMediaTypes are the types the pipeline knows how to handle. The
+// ALLOWED_MEDIA_TYPES setting picks from these.
+var supportedMediaTypes = []string{
+	"video/mp4",
+	"video/webm",
+	"audio/mpeg",
+	"audio/mp4",
+	"image/jpeg",
+	"image/png",
+	"image/webp",
+}
+
+const defaultAllowedMediaTypes = "video/mp4,audio/mpeg,audio/mp4,image/jpeg,image/png"
+
+// parseAllowedMediaTypes reads a comma separated list of media types,
+// rejecting any the pipeline doesn't support.
+func parseAllowedMediaTypes(value string) (map[string]bool, error) {
+	allowed := map[string]bool{}
+	for _, mediaType := range strings.Split(value, ",") {
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		if mediaType == "" {
+			continue
+		}
+		supported := false
+		for _, candidate := range supportedMediaTypes {
+			if mediaType == candidate {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return nil, fmt.Errorf("unsupported media type %q", mediaType)
+		}
+		allowed[mediaType] = true
+	}
+	return allowed, nil
+}
+
+func (cfg *apiConfig) isAllowedVideoType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "video/") && cfg.allowedMediaTypes[mediaType]
+}
+
+func (cfg *apiConfig) isAllowedAudioType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "audio/") && cfg.allowedMediaTypes[mediaType]
+}
+
+func (cfg *apiConfig) isAllowedImageType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "image/") && cfg.allowedMediaTypes[mediaType]
+}
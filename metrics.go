@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+)
+
+// Upload types the upload metrics are labelled with.
+const (
+	uploadTypeVideo     = "video"
+	uploadTypeThumbnail = "thumbnail"
+	uploadTypeAudio     = "audio"
+)
+
+var (
+	metricsRegistry = metrics.NewRegistry()
+
+	uploadsTotal = metricsRegistry.NewCounterVec("tubely_uploads_total",
+		"Uploads received, by type and whether they were stored.", "type", "result")
+	uploadSizeBytes = metricsRegistry.NewHistogramVec("tubely_upload_size_bytes",
+		"Size of stored uploads.", []float64{1 << 16, 1 << 20, 1 << 22, 1 << 24, 1 << 26, 1 << 28, 1 << 30, 1 << 32}, "type")
+	uploadDurationSeconds = metricsRegistry.NewHistogramVec("tubely_upload_duration_seconds",
+		"Time taken to receive, process and store uploads.", []float64{.1, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}, "type")
+	activeUploads = metricsRegistry.NewGaugeVec("tubely_active_uploads",
+		"Uploads in progress.", "type")
+	s3RequestDurationSeconds = metricsRegistry.NewHistogramVec("tubely_s3_request_duration_seconds",
+		"Latency of S3 calls, by operation.", metrics.DefaultBuckets, "operation")
+	s3RequestErrorsTotal = metricsRegistry.NewCounterVec("tubely_s3_request_errors_total",
+		"S3 calls that failed, by operation.", "operation")
+	s3CircuitState = metricsRegistry.NewGaugeVec("tubely_s3_circuit_state",
+		"State of the S3 circuit breaker: 0 closed, 1 half-open, 2 open.")
+	s3CircuitRejectionsTotal = metricsRegistry.NewCounterVec("tubely_s3_circuit_rejections_total",
+		"S3 calls failed fast by the open circuit breaker, by operation.", "operation")
+	commandDurationSeconds = metricsRegistry.NewHistogramVec("tubely_command_duration_seconds",
+		"Time spent running ffprobe and ffmpeg, by what they were run for.", []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300, 1800}, "command")
+	httpRequestsTotal = metricsRegistry.NewCounterVec("tubely_http_requests_total",
+		"HTTP requests served, by route and status code.", "route", "status")
+)
+
+// startUpload counts an upload of the given type as active. The returned
+// function records its outcome and must be called once it's done; size is
+// only recorded for stored uploads.
+func startUpload(uploadType string) func(stored bool, size int64) {
+	start := time.Now()
+	activeUploads.Inc(uploadType)
+	return func(stored bool, size int64) {
+		activeUploads.Dec(uploadType)
+		result := "failure"
+		if stored {
+			result = "success"
+			uploadSizeBytes.Observe(float64(size), uploadType)
+		}
+		uploadsTotal.Inc(uploadType, result)
+		uploadDurationSeconds.Observe(time.Since(start).Seconds(), uploadType)
+	}
+}
+
+// observeCommand records how long an ffprobe or ffmpeg run that began at
+// start took. It's meant to be deferred.
+func observeCommand(command string, start time.Time) {
+	commandDurationSeconds.Observe(time.Since(start).Seconds(), command)
+}
+
+// metricsMiddleware counts responses by the route pattern that served them.
+// It must wrap the mux directly, since the mux records the pattern on the
+// request it's given.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestsTotal.Inc(route, strconv.Itoa(rec.status))
+	})
+}
+
+// s3Metrics times every S3 call and counts the ones that fail.
+func s3Metrics(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("TubelyMetrics", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		start := time.Now()
+		out, metadata, err := next.HandleInitialize(ctx, in)
+
+		operation := awsmiddleware.GetOperationName(ctx)
+		s3RequestDurationSeconds.Observe(time.Since(start).Seconds(), operation)
+		if err != nil {
+			s3RequestErrorsTotal.Inc(operation)
+		}
+		return out, metadata, err
+	}), middleware.After)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// runMigrateCommand handles -migrate, which manages the schema by hand
+// instead of starting the server: up applies every pending migration, down
+// rolls back the latest steps of them, and status lists them all. The
+// server applies pending migrations itself, so down is for going back to an
+// older release.
+func runMigrateCommand(dbConfig database.Config, command string, steps int) error {
+	db, err := database.Open(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var names []string
+	switch command {
+	case "up":
+		names, err = db.MigrateUp()
+		for _, name := range names {
+			fmt.Println("Applied", name)
+		}
+	case "down":
+		if steps < 1 {
+			return fmt.Errorf("-migrate-steps must be at least 1")
+		}
+		names, err = db.MigrateDown(steps)
+		for _, name := range names {
+			fmt.Println("Rolled back", name)
+		}
+	case "status":
+		statuses, err := db.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.AppliedAt != nil {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%-24s %s\n", status.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("-migrate must be up, down or status")
+	}
+	if err == nil && len(names) == 0 {
+		fmt.Println("Nothing to migrate")
+	}
+	return err
+}
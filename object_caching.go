@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// objectCachePolicy is the Cache-Control stored objects and local assets are
+// served with, by asset type. Video files are stored under random keys that
+// are never reused, so they can be cached for good. Renditions, HLS output,
+// sprite sheets and captions are written over in place when they're made
+// again, and thumbnails are swapped out and removed with their video, so
+// those are only cached briefly.
+type objectCachePolicy struct {
+	videos     string
+	thumbnails string
+	captions   string
+	transcoded string
+	exports    string
+}
+
+func defaultObjectCachePolicy() objectCachePolicy {
+	return objectCachePolicy{
+		videos:     "public, max-age=31536000, immutable",
+		thumbnails: "public, max-age=3600",
+		captions:   "public, max-age=300",
+		transcoded: "public, max-age=300",
+		exports:    "private, no-cache",
+	}
+}
+
+// loadObjectCachePolicy applies the CACHE_CONTROL_* overrides to the
+// defaults.
+func loadObjectCachePolicy() (objectCachePolicy, error) {
+	policy := defaultObjectCachePolicy()
+	for name, value := range map[string]*string{
+		"CACHE_CONTROL_VIDEOS":     &policy.videos,
+		"CACHE_CONTROL_THUMBNAILS": &policy.thumbnails,
+		"CACHE_CONTROL_CAPTIONS":   &policy.captions,
+		"CACHE_CONTROL_TRANSCODED": &policy.transcoded,
+		"CACHE_CONTROL_EXPORTS":    &policy.exports,
+	} {
+		env := os.Getenv(name)
+		if env == "" {
+			continue
+		}
+		if strings.ContainsAny(env, "\r\n") {
+			return objectCachePolicy{}, fmt.Errorf("%s can't contain line breaks", name)
+		}
+		*value = env
+	}
+	return policy, nil
+}
+
+// forKey picks the Cache-Control for an object from the prefix or extension
+// its key was given.
+func (p objectCachePolicy) forKey(key string) string {
+	switch {
+	case strings.HasPrefix(key, exportPrefix):
+		return p.exports
+	case strings.HasPrefix(key, thumbnailKeyPrefix):
+		return p.thumbnails
+	case strings.HasPrefix(key, "renditions/"), strings.HasPrefix(key, "hls/"), strings.HasPrefix(key, "sprites/"):
+		return p.transcoded
+	case path.Ext(key) == ".vtt":
+		return p.captions
+	default:
+		return p.videos
+	}
+}
+
+// objectPutOptions fills in the caching headers for an object stored under
+// key, leaving any opts already has.
+func (cfg *apiConfig) objectPutOptions(key string, opts storage.PutOptions) storage.PutOptions {
+	if opts.CacheControl == "" {
+		opts.CacheControl = cfg.objectCache.forKey(key)
+	}
+	if opts.ContentDisposition == "" {
+		opts.ContentDisposition = objectContentDisposition(key)
+	}
+	return opts
+}
+
+// objectContentDisposition has export archives saved rather than opened.
+func objectContentDisposition(key string) string {
+	if strings.HasPrefix(key, exportPrefix) && path.Ext(key) == ".zip" {
+		return mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(key)})
+	}
+	return ""
+}
+
+// objectCacheMiddleware sets the headers the local store would have stored
+// with each object, if it stored any metadata.
+func (cfg *apiConfig) objectCacheMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		headers := http.Header{}
+		headers.Set("Cache-Control", cfg.objectCache.forKey(key))
+		if disposition := objectContentDisposition(key); disposition != "" {
+			headers.Set("Content-Disposition", disposition)
+		}
+		next.ServeHTTP(&successHeaderWriter{ResponseWriter: w, headers: headers}, r)
+	})
+}
+
+// successHeaderWriter adds headers to a response unless it's an error, so
+// a missing or forbidden file isn't cached as if it were the file.
+type successHeaderWriter struct {
+	http.ResponseWriter
+	headers     http.Header
+	wroteHeader bool
+}
+
+func (sw *successHeaderWriter) WriteHeader(code int) {
+	if !sw.wroteHeader && code < http.StatusBadRequest {
+		for name, values := range sw.headers {
+			sw.Header()[name] = values
+		}
+	}
+	sw.wroteHeader = true
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *successHeaderWriter) Write(p []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *successHeaderWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
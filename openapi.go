@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/openapi"
+)
+
+// handlerOpenAPI serves the API's OpenAPI document.
+func handlerOpenAPI(doc *openapi.Document) (http.HandlerFunc, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}, nil
+}
+
+// openAPIValidationMiddleware checks requests to routes the document
+// describes against it before they reach their handler, answering 400 with
+// everything that's wrong. Routes it doesn't describe, like the static
+// files, are passed straight through.
+func openAPIValidationMiddleware(doc *openapi.Document, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		method, path, ok := strings.Cut(pattern, " ")
+		if !ok {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		op := doc.Operation(method, path)
+		if op == nil {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		pathValues, ok := openapi.PathValues(path, r.URL.EscapedPath())
+		if !ok {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		if errs := openapi.Validate(op, r, pathValues); len(errs) > 0 {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// respondWithValidationErrors is respondWithError for requests that don't
+// match the OpenAPI document, listing each problem.
+func respondWithValidationErrors(w http.ResponseWriter, errs []openapi.ValidationError) {
+	loggerWithRequestID(w.Header().Get(requestIDHeader)).Info("Responding with error", "status", http.StatusBadRequest, "code", codeValidationFailed, "message", "Invalid request", "error", errs[0])
+	type response struct {
+		errorResponse
+		Errors []openapi.ValidationError `json:"errors"`
+	}
+	respondWithJSON(w, http.StatusBadRequest, response{
+		errorResponse: newErrorResponse(w, codeValidationFailed, fmt.Sprintf("Invalid request: %s", errs[0])),
+		Errors:        errs,
+	})
+}
+
+var pathWildcard = regexp.MustCompile(`\{[^}]+\}`)
+
+// checkAPISpec makes sure every operation in the document is a route the
+// mux serves, so the document can't describe routes that were renamed or
+// removed.
+func checkAPISpec(doc *openapi.Document, mux *http.ServeMux) error {
+	for path, item := range doc.Paths {
+		for method := range item {
+			method = strings.ToUpper(method)
+			r, err := http.NewRequest(method, pathWildcard.ReplaceAllString(path, "x"), nil)
+			if err != nil {
+				return err
+			}
+			if _, pattern := mux.Handler(r); pattern != method+" "+path {
+				return fmt.Errorf("%s %s isn't a route", method, path)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,861 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/openapi"
+)
+
+// Ways a request can be authorized, as OpenAPI security requirements.
+// Routes anyone can call have none.
+var (
+	// optionalAuth routes serve more to a signed-in owner or admin
+	optionalAuth = []openapi.SecurityRequirement{{}, {"bearerAuth": {}}}
+	userAuth     = []openapi.SecurityRequirement{{"bearerAuth": {}}}
+	refreshAuth  = []openapi.SecurityRequirement{{"refreshToken": {}}}
+	// apiKeyAuth routes take an API key as well as an access token
+	apiKeyAuth = []openapi.SecurityRequirement{{"bearerAuth": {}}, {"apiKey": {}}}
+)
+
+// apiSpec describes every route under /api and /admin. It's served at
+// GET /api/openapi.json, and openAPIValidationMiddleware checks requests
+// against it, so a route's parameters have to be described here to be
+// accepted.
+func apiSpec() *openapi.Document {
+	doc := openapi.NewDocument(openapi.Info{
+		Title:       "Tubely",
+		Description: "Upload, process and share videos.",
+		Version:     "1.0.0",
+	})
+	doc.Components.SecuritySchemes = map[string]openapi.SecurityScheme{
+		"bearerAuth": {
+			Type:         "http",
+			Scheme:       "bearer",
+			BearerFormat: "JWT",
+			Description:  "An access token from POST /api/login or POST /api/refresh",
+		},
+		"refreshToken": {
+			Type:        "http",
+			Scheme:      "bearer",
+			Description: "A refresh token from POST /api/login",
+		},
+		"apiKey": {
+			Type:        "apiKey",
+			In:          openapi.InHeader,
+			Name:        "Authorization",
+			Description: `An API key from POST /api/api_keys, sent as "ApiKey <key>"`,
+		},
+	}
+	doc.Components.Schemas = map[string]*openapi.Schema{
+		"Error": {
+			Type:     "object",
+			Required: []string{"error", "code"},
+			Properties: map[string]*openapi.Schema{
+				"error": {Type: "string"},
+				"code": {
+					Type:        "string",
+					Description: "Why the request failed, like VIDEO_NOT_FOUND or NOT_OWNER, which stays the same if the error message is reworded",
+				},
+				"request_id": {Type: "string"},
+				"errors": {
+					Type:        "array",
+					Description: "Each parameter that didn't match this document",
+					Items: &openapi.Schema{
+						Type: "object",
+						Properties: map[string]*openapi.Schema{
+							"in":      {Type: "string", Enum: []string{openapi.InPath, openapi.InQuery, openapi.InHeader}},
+							"name":    {Type: "string"},
+							"message": {Type: "string"},
+						},
+					},
+				},
+			},
+		},
+		"Video": {
+			Type: "object",
+			Properties: map[string]*openapi.Schema{
+				"id":                  uuidSchema(),
+				"created_at":          dateTimeSchema(),
+				"updated_at":          dateTimeSchema(),
+				"user_id":             uuidSchema(),
+				"title":               {Type: "string"},
+				"description":         {Type: "string"},
+				"visibility":          visibilitySchema(),
+				"status":              {Type: "string", Enum: []string{database.VideoStatusPending, database.VideoStatusReceived, database.VideoStatusProbing, database.VideoStatusUploading, database.VideoStatusTranscoding, database.VideoStatusReady, database.VideoStatusFailed}, Description: "Where the latest upload is in processing. Failed videos keep any file an earlier upload stored"},
+				"tags":                {Type: "array", Items: &openapi.Schema{Type: "string"}},
+				"video_url":           nullableString(),
+				"video_checksum":      nullableString(),
+				"source_checksum":     nullableString(),
+				"original_filename":   nullableString(),
+				"thumbnail_url":       nullableString(),
+				"thumbnail_small_url": nullableString(),
+				"preview_url":         nullableString(),
+				"hls_url":             nullableString(),
+				"sprites_vtt_url":     nullableString(),
+				"perceptual_hash":     nullableString(),
+				"video_encryption":    nullableString(),
+				"captions_url":        {Type: "object", Description: "Captions URLs by language", Nullable: true},
+				"auto_captions":       {Type: "array", Items: &openapi.Schema{Type: "string"}, Description: "Languages whose captions were generated by speech-to-text", Nullable: true},
+				"renditions":          {Type: "object", Description: "Rendition URLs by name", Nullable: true},
+				"media_info":          {Type: "object", Nullable: true},
+				"moderation_status":   {Type: "string", Enum: []string{database.ModerationStatusClear, database.ModerationStatusFlagged, database.ModerationStatusBlocked}, Description: "Set once moderation has checked the thumbnail or frames. Only the owner and admins can see blocked videos"},
+				"moderation":          {Type: "object", Description: "Moderation labels found in the thumbnail and frames, and when each was checked"},
+				"deleted_at":          {Type: "string", Format: "date-time", Description: "Set while the video is in the trash"},
+			},
+		},
+	}
+
+	video := refSchema("Video")
+	videos := &openapi.Schema{Type: "array", Items: video}
+	object := &openapi.Schema{Type: "object"}
+	objects := &openapi.Schema{Type: "array", Items: object}
+	videoID := pathUUID("videoID")
+
+	add := func(pattern string, op *openapi.Operation) {
+		method, path, _ := strings.Cut(pattern, " ")
+		if op.Responses == nil {
+			op.Responses = map[string]openapi.Response{}
+		}
+		op.Responses["default"] = openapi.Response{
+			Description: "An error",
+			Content:     map[string]openapi.MediaType{"application/json": {Schema: refSchema("Error")}},
+		}
+		doc.Add(method, path, op)
+	}
+
+	// Accounts and authentication
+	add("POST /api/login", &openapi.Operation{
+		OperationID: "login", Summary: "Log in with an email and password", Tags: []string{"auth"},
+		RequestBody: jsonBody(objectSchema([]string{"email", "password"}, map[string]*openapi.Schema{
+			"email":    {Type: "string", Format: "email"},
+			"password": {Type: "string", Format: "password"},
+		})),
+		Responses: jsonResponse(http.StatusOK, "The user, with an access and a refresh token", object),
+	})
+	add("POST /api/refresh", &openapi.Operation{
+		OperationID: "refresh", Summary: "Get a new access token", Tags: []string{"auth"}, Security: refreshAuth,
+		Responses: jsonResponse(http.StatusOK, "A new access token", object),
+	})
+	add("POST /api/revoke", &openapi.Operation{
+		OperationID: "revoke", Summary: "Revoke a refresh token", Tags: []string{"auth"}, Security: refreshAuth,
+		Responses: emptyResponse(http.StatusNoContent, "Revoked"),
+	})
+	add("POST /api/users", &openapi.Operation{
+		OperationID: "createUser", Summary: "Sign up", Tags: []string{"users"},
+		RequestBody: jsonBody(objectSchema([]string{"email", "password"}, map[string]*openapi.Schema{
+			"email":    {Type: "string", Format: "email"},
+			"password": {Type: "string", Format: "password"},
+		})),
+		Responses: jsonResponse(http.StatusCreated, "The new user", object),
+	})
+	add("GET /api/users/me/usage", &openapi.Operation{
+		OperationID: "getUsage", Summary: "Get your storage use, quota and plan", Tags: []string{"users"}, Security: userAuth,
+		Responses: jsonResponse(http.StatusOK, "Usage", object),
+	})
+	add("POST /api/api_keys", &openapi.Operation{
+		OperationID: "createAPIKey", Summary: "Create an API key", Tags: []string{"auth"}, Security: userAuth,
+		RequestBody: jsonBody(objectSchema([]string{"name"}, map[string]*openapi.Schema{"name": {Type: "string"}})),
+		Responses:   jsonResponse(http.StatusCreated, "The key, which is only ever shown here", object),
+	})
+	add("GET /api/api_keys", &openapi.Operation{
+		OperationID: "listAPIKeys", Summary: "List your API keys", Tags: []string{"auth"}, Security: userAuth,
+		Responses: jsonResponse(http.StatusOK, "API keys", objects),
+	})
+	add("DELETE /api/api_keys/{keyID}", &openapi.Operation{
+		OperationID: "revokeAPIKey", Summary: "Revoke an API key", Tags: []string{"auth"}, Security: userAuth,
+		Parameters: []openapi.Parameter{pathUUID("keyID")},
+		Responses:  emptyResponse(http.StatusNoContent, "Revoked"),
+	})
+
+	// Webhooks and exports
+	add("POST /api/webhooks", &openapi.Operation{
+		OperationID: "createWebhook", Summary: "Register a webhook", Tags: []string{"webhooks"}, Security: userAuth,
+		RequestBody: jsonBody(objectSchema([]string{"url", "events"}, map[string]*openapi.Schema{
+			"url":    {Type: "string", Format: "uri"},
+			"events": {Type: "array", Items: &openapi.Schema{Type: "string", Enum: webhookEvents}},
+		})),
+		Responses: jsonResponse(http.StatusCreated, "The webhook, with the secret deliveries are signed with", object),
+	})
+	add("GET /api/webhooks", &openapi.Operation{
+		OperationID: "listWebhooks", Summary: "List your webhooks", Tags: []string{"webhooks"}, Security: userAuth,
+		Responses: jsonResponse(http.StatusOK, "Webhooks", objects),
+	})
+	add("DELETE /api/webhooks/{webhookID}", &openapi.Operation{
+		OperationID: "deleteWebhook", Summary: "Delete a webhook", Tags: []string{"webhooks"}, Security: userAuth,
+		Parameters: []openapi.Parameter{pathUUID("webhookID")},
+		Responses:  emptyResponse(http.StatusNoContent, "Deleted"),
+	})
+	add("POST /api/exports", &openapi.Operation{
+		OperationID: "createExport", Summary: "Export all your videos", Tags: []string{"exports"}, Security: userAuth,
+		RequestBody: optionalJSONBody(objectSchema(nil, map[string]*openapi.Schema{
+			"format": {Type: "string", Enum: []string{exportFormatZIP, exportFormatCopy}},
+		})),
+		Responses: jsonResponse(http.StatusAccepted, "The queued export", object),
+	})
+	add("GET /api/exports", &openapi.Operation{
+		OperationID: "listExports", Summary: "List your exports", Tags: []string{"exports"}, Security: userAuth,
+		Responses: jsonResponse(http.StatusOK, "Exports", objects),
+	})
+	add("GET /api/exports/{exportID}", &openapi.Operation{
+		OperationID: "getExport", Summary: "Get an export, with its download link once it's done", Tags: []string{"exports"}, Security: userAuth,
+		Parameters: []openapi.Parameter{pathUUID("exportID")},
+		Responses:  jsonResponse(http.StatusOK, "The export", object),
+	})
+	add("DELETE /api/exports/{exportID}", &openapi.Operation{
+		OperationID: "deleteExport", Summary: "Delete an export and what it stored", Tags: []string{"exports"}, Security: userAuth,
+		Parameters: []openapi.Parameter{pathUUID("exportID")},
+		Responses:  emptyResponse(http.StatusNoContent, "Deleted"),
+	})
+
+	// Videos
+	add("POST /api/videos", &openapi.Operation{
+		OperationID: "createVideo", Summary: "Create a video, optionally opening an upload session for its file", Tags: []string{"videos"}, Security: apiKeyAuth,
+		Parameters: []openapi.Parameter{idempotencyKeyHeader()},
+		RequestBody: jsonBody(objectSchema([]string{"title"}, map[string]*openapi.Schema{
+			"title":       {Type: "string"},
+			"description": {Type: "string"},
+			"visibility":  visibilitySchema(),
+			"upload":      uploadSessionSchema(),
+		})),
+		Responses: jsonResponse(http.StatusCreated, "The new video", video),
+	})
+	add("GET /api/videos", &openapi.Operation{
+		OperationID: "listVideos", Summary: "List videos, your own unless owner says otherwise", Tags: []string{"videos"}, Security: userAuth,
+		Parameters: append([]openapi.Parameter{ownerParam()}, videoListParams()...),
+		Responses:  jsonResponse(http.StatusOK, "A page of videos, with the total in X-Total-Count and the next page in Link", videos),
+	})
+	add("DELETE /api/videos", &openapi.Operation{
+		OperationID: "deleteVideos", Summary: "Delete several of your videos at once", Tags: []string{"videos"}, Security: userAuth,
+		Parameters:  []openapi.Parameter{queryBool("permanent", "Delete outright rather than moving to the trash")},
+		RequestBody: jsonBody(&openapi.Schema{Type: "array", Items: uuidSchema()}),
+		Responses:   jsonResponse(http.StatusOK, "Whether each video was deleted", object),
+	})
+	add("POST /api/videos/batch-delete", &openapi.Operation{
+		OperationID: "deleteVideosAlias", Summary: "Same as DELETE /api/videos, for clients that can't send a DELETE body", Tags: []string{"videos"}, Security: userAuth,
+		Parameters:  []openapi.Parameter{queryBool("permanent", "Delete outright rather than moving to the trash")},
+		RequestBody: jsonBody(&openapi.Schema{Type: "array", Items: uuidSchema()}),
+		Responses:   jsonResponse(http.StatusOK, "Whether each video was deleted", object),
+	})
+	add("GET /api/videos/trash", &openapi.Operation{
+		OperationID: "listTrash", Summary: "List your videos in the trash", Tags: []string{"videos"}, Security: userAuth,
+		Parameters: videoListParams(),
+		Responses:  jsonResponse(http.StatusOK, "A page of videos", videos),
+	})
+	add("GET /api/videos/search", &openapi.Operation{
+		OperationID: "searchVideos", Summary: "Search video titles and descriptions", Tags: []string{"videos"}, Security: userAuth,
+		Parameters: append([]openapi.Parameter{
+			{Name: "q", In: openapi.InQuery, Required: true, Description: "Words every match must have", Schema: &openapi.Schema{Type: "string", MaxLength: intPtr(maxSearchLength)}},
+			ownerParam(),
+		}, videoListParams()...),
+		Responses: jsonResponse(http.StatusOK, "A page of videos, best matches first", videos),
+	})
+	add("GET /api/videos/{videoID}", &openapi.Operation{
+		OperationID: "getVideo", Summary: "Get a video", Tags: []string{"videos"}, Security: optionalAuth,
+		Parameters: []openapi.Parameter{videoID},
+		Responses:  jsonResponse(http.StatusOK, "The video", video),
+	})
+	add("PATCH /api/videos/{videoID}", &openapi.Operation{
+		OperationID: "updateVideo", Summary: "Edit a video's details", Tags: []string{"videos"}, Security: userAuth,
+		Parameters: []openapi.Parameter{videoID},
+		RequestBody: jsonBody(objectSchema(nil, map[string]*openapi.Schema{
+			"title":       {Type: "string"},
+			"description": {Type: "string"},
+			"visibility":  visibilitySchema(),
+		})),
+		Responses: jsonResponse(http.StatusOK, "The video", video),
+	})
+	add("DELETE /api/videos/{videoID}", &openapi.Operation{
+		OperationID: "deleteVideo", Summary: "Move a video to the trash, or delete it outright", Tags: []string{"videos"}, Security: userAuth,
+		Parameters: []openapi.Parameter{videoID, queryBool("permanent", "Delete outright rather than moving to the trash")},
+		Responses:  emptyResponse(http.StatusNoContent, "Deleted"),
+	})
+	add("POST /api/videos/{videoID}/restore", &openapi.Operation{
+		OperationID: "restoreVideo", Summary: "Restore a video from the trash", Tags: []string{"videos"}, Security: userAuth,
+		Parameters: []openapi.Parameter{videoID},
+		Responses:  jsonResponse(http.StatusOK, "The video", video),
+	})
+	add("POST /api/videos/{videoID}/duplicate", &openapi.Operation{
+		OperationID: "duplicateVideo", Summary: "Copy a video and everything stored for it", Tags: []string{"videos"}, Security: userAuth,
+		Parameters: []openapi.Parameter{videoID},
+		Responses:  jsonResponse(http.StatusCreated, "The copy", video),
+	})
+	add("POST /api/videos/{videoID}/tags", &openapi.Operation{
+		OperationID: "addVideoTags", Summary: "Tag a video", Tags: []string{"videos"}, Security: userAuth,
+		Parameters: []openapi.Parameter{videoID},
+		RequestBody: jsonBody(objectSchema([]string{"tags"}, map[string]*openapi.Schema{
+			"tags": {Type: "array", Items: &openapi.Schema{Type: "string", MaxLength: intPtr(maxTagLength)}},
+		})),
+		Responses: jsonResponse(http.StatusOK, "The video", video),
+	})
+	add("DELETE /api/videos/{videoID}/tags/{tag}", &openapi.Operation{
+		OperationID: "removeVideoTag", Summary: "Remove a tag from a video", Tags: []string{"videos"}, Security: userAuth,
+		Parameters: []openapi.Parameter{videoID, {Name: "tag", In: openapi.InPath, Required: true, Schema: &openapi.Schema{Type: "string"}}},
+		Responses:  jsonResponse(http.StatusOK, "The video", video),
+	})
+	add("GET /api/tags", &openapi.Operation{
+		OperationID: "listTags", Summary: "Suggest tags from those on videos you can see", Tags: []string{"videos"}, Security: userAuth,
+		Parameters: []openapi.Parameter{
+			queryString("q", "What the tags start with"),
+			queryInt("limit", "How many tags to return", 1, maxTagsLimit),
+		},
+		Responses: jsonResponse(http.StatusOK, "Tags, most used first", objects),
+	})
+	add("GET /api/videos/{videoID}/similar", &openapi.Operation{
+		OperationID: "listSimilarVideos", Summary: "Find videos that look like this one", Tags: []string{"videos"}, Security: optionalAuth,
+		Parameters: []openapi.Parameter{videoID, queryInt("threshold", "How different matches' perceptual hashes can be", 0, 0)},
+		Responses:  jsonResponse(http.StatusOK, "Similar videos", videos),
+	})
+	add("GET /api/videos/{videoID}/stats", &openapi.Operation{
+		OperationID: "getVideoStats", Summary: "Get a video's daily views", Tags: []string{"videos"}, Security: userAuth,
+		Parameters: []openapi.Parameter{videoID, queryInt("days", "How many days back to count", 1, maxStatsDays)},
+		Responses:  jsonResponse(http.StatusOK, "View counts", object),
+	})
+	add("GET /api/videos/{videoID}/events", &openapi.Operation{
+		OperationID: "streamVideoEvents", Summary: "Follow a video's processing status as server-sent events", Tags: []string{"videos"}, Security: userAuth,
+		Parameters: []openapi.Parameter{videoID},
+		Responses:  streamResponse("text/event-stream", "Status events"),
+	})
+	add("POST /api/videos/{videoID}/share", &openapi.Operation{
+		OperationID: "shareVideo", Summary: "Create a link anyone can watch a video with", Tags: []string{"videos"}, Security: userAuth,
+		Parameters: []openapi.Parameter{videoID},
+		RequestBody: optionalJSONBody(objectSchema(nil, map[string]*openapi.Schema{
+			"expires_in": {Type: "string", Description: `How long the link works for, like "24h"`},
+		})),
+		Responses: jsonResponse(http.StatusCreated, "The link", object),
+	})
+
+	// Playback
+	add("GET /api/videos/{videoID}/stream", &openapi.Operation{
+		OperationID: "streamVideo", Summary: "Stream a video's file, with range requests", Tags: []string{"playback"}, Security: optionalAuth,
+		Parameters: []openapi.Parameter{
+			videoID,
+			queryString("rendition", "A rendition to stream instead of the original"),
+			shareParam(),
+		},
+		Responses: streamResponse("video/mp4", "The video"),
+	})
+	add("GET /api/videos/{videoID}/download", &openapi.Operation{
+		OperationID: "downloadVideo", Summary: "Download a video's file", Tags: []string{"playback"}, Security: optionalAuth,
+		Parameters: []openapi.Parameter{videoID, shareParam()},
+		Responses:  streamResponse("video/mp4", "The video, as an attachment"),
+	})
+	add("GET /api/videos/{videoID}/playlist.m3u8", &openapi.Operation{
+		OperationID: "getVideoPlaylist", Summary: "Redirect to a video's HLS playlist", Tags: []string{"playback"}, Security: optionalAuth,
+		Parameters: []openapi.Parameter{videoID},
+		Responses:  emptyResponse(http.StatusFound, "Redirects to the playlist"),
+	})
+	add("GET /share/{token}", &openapi.Operation{
+		OperationID: "openShareLink", Summary: "Open a share link", Tags: []string{"playback"},
+		Parameters: []openapi.Parameter{{Name: "token", In: openapi.InPath, Required: true, Schema: &openapi.Schema{Type: "string"}}},
+		Responses:  emptyResponse(http.StatusFound, "Redirects to the video's file"),
+	})
+
+	// Uploads
+	uploadParams := []openapi.Parameter{
+		videoID,
+		queryBool("validate", "Only check the file, without storing it"),
+		queryBool("overwrite", "Replace a file the video already has"),
+		idempotencyKeyHeader(),
+	}
+	add("POST /api/video_upload/{videoID}", &openapi.Operation{
+		OperationID: "uploadVideo", Summary: "Upload a video's file", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters: append(uploadParams,
+			queryString("storage_class", "S3 storage class, if not given in the form"),
+			queryUUID("upload_id", "An ID to follow the upload's progress by"),
+		),
+		RequestBody: multipartBody([]string{"video"}, map[string]*openapi.Schema{
+			"video":         {Type: "string", Format: "binary"},
+			"storage_class": {Type: "string"},
+		}),
+		Responses: jsonResponse(http.StatusOK, "The video", video),
+	})
+	chunks := objectSchema([]string{"file_id", "received"}, map[string]*openapi.Schema{
+		"file_id":      {Type: "string"},
+		"total_chunks": {Type: "integer"},
+		"received":     {Type: "array", Items: &openapi.Schema{Type: "integer"}, Description: "Indexes of the chunks received so far"},
+	})
+	chunkResponses := jsonResponse(http.StatusOK, "The video, once the last chunk is in", video)
+	chunkResponses[statusKey(http.StatusAccepted)] = openapi.Response{Description: "Stored, with the chunks received so far", Content: map[string]openapi.MediaType{"application/json": {Schema: chunks}}}
+	add("POST /api/video_upload/{videoID}/chunks", &openapi.Operation{
+		OperationID: "uploadVideoChunk", Summary: "Upload one chunk of a video's file, storing it once all have arrived", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters: []openapi.Parameter{
+			videoID,
+			queryBool("overwrite", "Replace a file the video already has"),
+			idempotencyKeyHeader(),
+		},
+		RequestBody: multipartBody([]string{"fileID", "chunkIndex", "totalChunks", "video"}, map[string]*openapi.Schema{
+			"fileID":        {Type: "string", Description: "Identifies the file the chunk is part of"},
+			"chunkIndex":    {Type: "integer", Minimum: floatPtr(0)},
+			"totalChunks":   {Type: "integer", Minimum: floatPtr(1), Maximum: floatPtr(maxUploadChunks)},
+			"video":         {Type: "string", Format: "binary"},
+			"storage_class": {Type: "string"},
+		}),
+		Responses: chunkResponses,
+	})
+	add("GET /api/video_upload/{videoID}/chunks", &openapi.Operation{
+		OperationID: "listVideoChunks", Summary: "List the chunks of an upload received so far", Tags: []string{"uploads"}, Security: userAuth,
+		Parameters: []openapi.Parameter{videoID, {Name: "fileID", In: openapi.InQuery, Required: true, Schema: &openapi.Schema{Type: "string"}}},
+		Responses:  jsonResponse(http.StatusOK, "The chunks received", chunks),
+	})
+	add("POST /api/videos/batch", &openapi.Operation{
+		OperationID: "batchUploadVideos", Summary: "Create and upload several videos at once, titled after their filenames", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		RequestBody: multipartBody([]string{"video"}, map[string]*openapi.Schema{
+			"video":         {Type: "array", Items: &openapi.Schema{Type: "string", Format: "binary"}},
+			"visibility":    visibilitySchema(),
+			"storage_class": {Type: "string"},
+		}),
+		Responses: jsonResponse(http.StatusOK, "How each file went, in the order they were sent", &openapi.Schema{
+			Type: "object",
+			Properties: map[string]*openapi.Schema{
+				"results": {Type: "array", Items: &openapi.Schema{
+					Type: "object",
+					Properties: map[string]*openapi.Schema{
+						"filename": {Type: "string"},
+						"status":   {Type: "integer", Description: "The status a single upload of the file would have had"},
+						"video":    video,
+						"error":    refSchema("Error"),
+					},
+				}},
+			},
+		}),
+	})
+	add("POST /api/audio_upload/{videoID}", &openapi.Operation{
+		OperationID: "uploadAudio", Summary: "Upload an MP3 or M4A file, such as a podcast episode, as a video's file", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters: append(uploadParams,
+			queryString("storage_class", "S3 storage class, if not given in the form"),
+			queryUUID("upload_id", "An ID to follow the upload's progress by"),
+		),
+		RequestBody: multipartBody([]string{"audio"}, map[string]*openapi.Schema{
+			"audio":         {Type: "string", Format: "binary"},
+			"storage_class": {Type: "string"},
+		}),
+		Responses: jsonResponse(http.StatusOK, "The video, with a waveform for its preview", video),
+	})
+	add("POST /api/videos/{videoID}/upload_url", &openapi.Operation{
+		OperationID: "requestUploadURL", Summary: "Get a URL to upload a video's file to storage directly", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters:  uploadParams,
+		RequestBody: jsonBody(directUploadSchema()),
+		Responses:   jsonResponse(http.StatusOK, "The URL, with the method and headers to send", object),
+	})
+	add("POST /api/videos/{videoID}/upload-url", &openapi.Operation{
+		OperationID: "requestUploadURLAlias", Summary: "Same as POST /api/videos/{videoID}/upload_url", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters:  uploadParams,
+		RequestBody: jsonBody(directUploadSchema()),
+		Responses:   jsonResponse(http.StatusOK, "The URL, with the method and headers to send", object),
+	})
+	add("POST /api/videos/{videoID}/upload_confirm", &openapi.Operation{
+		OperationID: "confirmUpload", Summary: "Finish a direct upload", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters:  []openapi.Parameter{videoID, idempotencyKeyHeader()},
+		RequestBody: optionalJSONBody(objectSchema(nil, map[string]*openapi.Schema{"filename": {Type: "string"}})),
+		Responses:   jsonResponse(http.StatusOK, "The video", video),
+	})
+	add("POST /api/videos/{videoID}/upload-url/complete", &openapi.Operation{
+		OperationID: "confirmUploadAlias", Summary: "Same as POST /api/videos/{videoID}/upload_confirm", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters:  []openapi.Parameter{videoID, idempotencyKeyHeader()},
+		RequestBody: optionalJSONBody(objectSchema(nil, map[string]*openapi.Schema{"filename": {Type: "string"}})),
+		Responses:   jsonResponse(http.StatusOK, "The video", video),
+	})
+	add("POST /api/videos/{videoID}/import", &openapi.Operation{
+		OperationID: "importVideo", Summary: "Fetch a video's file from a URL", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters: uploadParams,
+		RequestBody: jsonBody(objectSchema([]string{"source_url"}, map[string]*openapi.Schema{
+			"source_url":    {Type: "string", Format: "uri"},
+			"storage_class": {Type: "string"},
+		})),
+		Responses: jsonResponse(http.StatusOK, "The video", video),
+	})
+	add("POST /api/videos/{videoID}/uploads", &openapi.Operation{
+		OperationID: "createUploadSession", Summary: "Start a resumable upload of a video's file", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters:  uploadParams,
+		RequestBody: jsonBody(uploadSessionSchema()),
+		Responses:   jsonResponse(http.StatusCreated, "The upload session", object),
+	})
+	uploadID := pathUUID("uploadID")
+	add("GET /api/uploads/{uploadID}", &openapi.Operation{
+		OperationID: "getUploadSession", Summary: "Get how much of a resumable upload has arrived", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters: []openapi.Parameter{uploadID},
+		Responses:  jsonResponse(http.StatusOK, "The upload session, with its offset also in Upload-Offset", object),
+	})
+	add("PATCH /api/uploads/{uploadID}", &openapi.Operation{
+		OperationID: "appendUploadChunk", Summary: "Send the next chunk of a resumable upload", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters: []openapi.Parameter{
+			uploadID,
+			{Name: uploadOffsetHeader, In: openapi.InHeader, Required: true, Description: "Where the chunk starts, which must be the session's offset", Schema: &openapi.Schema{Type: "integer", Minimum: floatPtr(0)}},
+			idempotencyKeyHeader(),
+		},
+		RequestBody: &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{"application/octet-stream": {Schema: &openapi.Schema{Type: "string", Format: "binary"}}}},
+		Responses: withResponse(jsonResponse(http.StatusOK, "The video, once the last chunk is in", video),
+			http.StatusNoContent, "Stored, with the new offset in Upload-Offset"),
+	})
+	add("DELETE /api/uploads/{uploadID}", &openapi.Operation{
+		OperationID: "deleteUploadSession", Summary: "Abandon a resumable upload", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters: []openapi.Parameter{uploadID, idempotencyKeyHeader()},
+		Responses:  emptyResponse(http.StatusNoContent, "Abandoned"),
+	})
+	add("GET /api/uploads/{uploadID}/progress", &openapi.Operation{
+		OperationID: "getUploadProgress", Summary: "Follow an upload's progress, as server-sent events if asked for", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters: []openapi.Parameter{uploadID},
+		Responses:  jsonResponse(http.StatusOK, "Progress", object),
+	})
+	add("POST /api/videos/{videoID}/probe", &openapi.Operation{
+		OperationID: "probeVideo", Summary: "Get what ffprobe makes of a video's file", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters: []openapi.Parameter{videoID, queryBool("refresh", "Probe again rather than returning what was recorded"), idempotencyKeyHeader()},
+		Responses:  jsonResponse(http.StatusOK, "Media info", object),
+	})
+	add("POST /api/thumbnail_upload/{videoID}", &openapi.Operation{
+		OperationID: "uploadThumbnail", Summary: "Upload a video's thumbnail", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters: []openapi.Parameter{videoID, idempotencyKeyHeader()},
+		RequestBody: multipartBody([]string{"thumbnail"}, map[string]*openapi.Schema{
+			"thumbnail": {Type: "string", Format: "binary"},
+		}),
+		Responses: jsonResponse(http.StatusOK, "The video", video),
+	})
+	add("POST /api/videos/{videoID}/thumbnail/generate", &openapi.Operation{
+		OperationID: "generateThumbnail", Summary: "Make a video's thumbnail from a frame of it", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters: []openapi.Parameter{videoID, idempotencyKeyHeader()},
+		RequestBody: optionalJSONBody(objectSchema(nil, map[string]*openapi.Schema{
+			"timestamp": {Type: "number", Minimum: floatPtr(0), Description: "Seconds into the video, a tenth of the way in by default"},
+		})),
+		Responses: jsonResponse(http.StatusOK, "The video", video),
+	})
+	add("POST /api/videos/{videoID}/captions", &openapi.Operation{
+		OperationID: "uploadCaptions", Summary: "Upload captions for a video, as WebVTT or SRT", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters: []openapi.Parameter{videoID, idempotencyKeyHeader()},
+		RequestBody: multipartBody([]string{"lang", "captions"}, map[string]*openapi.Schema{
+			"lang":     {Type: "string", Description: "A BCP 47 language tag"},
+			"captions": {Type: "string", Format: "binary"},
+		}),
+		Responses: jsonResponse(http.StatusOK, "The video", video),
+	})
+	add("GET /api/videos/{videoID}/captions", &openapi.Operation{
+		OperationID: "listCaptions", Summary: "List a video's caption tracks by language", Tags: []string{"videos"}, Security: optionalAuth,
+		Parameters: []openapi.Parameter{videoID},
+		Responses: jsonResponse(http.StatusOK, "Caption tracks", &openapi.Schema{Type: "array", Items: objectSchema([]string{"lang", "url", "auto_generated"}, map[string]*openapi.Schema{
+			"lang":           {Type: "string"},
+			"url":            {Type: "string"},
+			"auto_generated": {Type: "boolean", Description: "Whether the track was made by speech-to-text"},
+		})}),
+	})
+	add("DELETE /api/videos/{videoID}/captions/{lang}", &openapi.Operation{
+		OperationID: "deleteCaptions", Summary: "Delete a video's captions in one language", Tags: []string{"uploads"}, Security: apiKeyAuth,
+		Parameters: []openapi.Parameter{videoID, {Name: "lang", In: openapi.InPath, Required: true, Schema: &openapi.Schema{Type: "string"}}},
+		Responses:  emptyResponse(http.StatusNoContent, "Deleted"),
+	})
+
+	// Comments
+	add("POST /api/videos/{videoID}/comments", &openapi.Operation{
+		OperationID: "createComment", Summary: "Comment on a video", Tags: []string{"comments"}, Security: userAuth,
+		Parameters:  []openapi.Parameter{videoID},
+		RequestBody: jsonBody(objectSchema([]string{"body"}, map[string]*openapi.Schema{"body": {Type: "string"}})),
+		Responses:   jsonResponse(http.StatusCreated, "The comment", object),
+	})
+	add("GET /api/videos/{videoID}/comments", &openapi.Operation{
+		OperationID: "listComments", Summary: "List a video's comments, oldest first", Tags: []string{"comments"}, Security: optionalAuth,
+		Parameters: []openapi.Parameter{videoID, queryInt("limit", "Page size", 1, maxCommentPageSize), queryInt("offset", "Comments to skip", 0, 0)},
+		Responses:  jsonResponse(http.StatusOK, "A page of comments, with the next page in Link", objects),
+	})
+	add("DELETE /api/videos/{videoID}/comments/{commentID}", &openapi.Operation{
+		OperationID: "deleteComment", Summary: "Delete a comment", Tags: []string{"comments"}, Security: userAuth,
+		Parameters: []openapi.Parameter{videoID, pathUUID("commentID")},
+		Responses:  emptyResponse(http.StatusNoContent, "Deleted"),
+	})
+
+	// Playlists
+	playlistID := pathUUID("playlistID")
+	playlistFields := map[string]*openapi.Schema{
+		"title":       {Type: "string"},
+		"description": {Type: "string"},
+		"visibility":  visibilitySchema(),
+	}
+	add("POST /api/playlists", &openapi.Operation{
+		OperationID: "createPlaylist", Summary: "Create a playlist", Tags: []string{"playlists"}, Security: userAuth,
+		RequestBody: jsonBody(objectSchema([]string{"title"}, playlistFields)),
+		Responses:   jsonResponse(http.StatusCreated, "The playlist", object),
+	})
+	add("GET /api/playlists", &openapi.Operation{
+		OperationID: "listPlaylists", Summary: "List your playlists, or another user's", Tags: []string{"playlists"}, Security: userAuth,
+		Parameters: []openapi.Parameter{queryUUID("owner", "The user whose playlists to list")},
+		Responses:  jsonResponse(http.StatusOK, "Playlists", objects),
+	})
+	add("GET /api/playlists/{playlistID}", &openapi.Operation{
+		OperationID: "getPlaylist", Summary: "Get a playlist and its videos", Tags: []string{"playlists"}, Security: optionalAuth,
+		Parameters: []openapi.Parameter{playlistID},
+		Responses:  jsonResponse(http.StatusOK, "The playlist", object),
+	})
+	add("PATCH /api/playlists/{playlistID}", &openapi.Operation{
+		OperationID: "updatePlaylist", Summary: "Edit a playlist's details", Tags: []string{"playlists"}, Security: userAuth,
+		Parameters:  []openapi.Parameter{playlistID},
+		RequestBody: jsonBody(objectSchema(nil, playlistFields)),
+		Responses:   jsonResponse(http.StatusOK, "The playlist", object),
+	})
+	add("DELETE /api/playlists/{playlistID}", &openapi.Operation{
+		OperationID: "deletePlaylist", Summary: "Delete a playlist", Tags: []string{"playlists"}, Security: userAuth,
+		Parameters: []openapi.Parameter{playlistID},
+		Responses:  emptyResponse(http.StatusNoContent, "Deleted"),
+	})
+	add("POST /api/playlists/{playlistID}/videos", &openapi.Operation{
+		OperationID: "addPlaylistVideo", Summary: "Add a video to the end of a playlist", Tags: []string{"playlists"}, Security: userAuth,
+		Parameters:  []openapi.Parameter{playlistID},
+		RequestBody: jsonBody(objectSchema([]string{"video_id"}, map[string]*openapi.Schema{"video_id": uuidSchema()})),
+		Responses:   jsonResponse(http.StatusOK, "The playlist", object),
+	})
+	add("PUT /api/playlists/{playlistID}/videos", &openapi.Operation{
+		OperationID: "reorderPlaylist", Summary: "Reorder a playlist's videos", Tags: []string{"playlists"}, Security: userAuth,
+		Parameters: []openapi.Parameter{playlistID},
+		RequestBody: jsonBody(objectSchema([]string{"video_ids"}, map[string]*openapi.Schema{
+			"video_ids": {Type: "array", Items: uuidSchema()},
+		})),
+		Responses: jsonResponse(http.StatusOK, "The playlist", object),
+	})
+	add("DELETE /api/playlists/{playlistID}/videos/{videoID}", &openapi.Operation{
+		OperationID: "removePlaylistVideo", Summary: "Remove a video from a playlist", Tags: []string{"playlists"}, Security: userAuth,
+		Parameters: []openapi.Parameter{playlistID, videoID},
+		Responses:  jsonResponse(http.StatusOK, "The playlist", object),
+	})
+
+	// Admin
+	userID := pathUUID("userID")
+	add("POST /admin/reset", &openapi.Operation{
+		OperationID: "reset", Summary: "Delete everything, on the dev platform only", Tags: []string{"admin"},
+		Responses: emptyResponse(http.StatusOK, "Reset"),
+	})
+	add("POST /admin/migrate_thumbnails", &openapi.Operation{
+		OperationID: "migrateThumbnails", Summary: "Move local thumbnails to storage", Tags: []string{"admin"}, Security: userAuth,
+		Responses: jsonResponse(http.StatusOK, "What was moved", object),
+	})
+	add("GET /admin/videos", &openapi.Operation{
+		OperationID: "adminListVideos", Summary: "List everyone's videos", Tags: []string{"admin"}, Security: userAuth,
+		Parameters: append([]openapi.Parameter{ownerParam(), queryBool("trashed", "List the trash instead")}, videoListParams()...),
+		Responses:  jsonResponse(http.StatusOK, "A page of videos", videos),
+	})
+	add("DELETE /admin/videos/{videoID}", &openapi.Operation{
+		OperationID: "adminDeleteVideo", Summary: "Take down a video", Tags: []string{"admin"}, Security: userAuth,
+		Parameters: []openapi.Parameter{videoID},
+		Responses:  emptyResponse(http.StatusNoContent, "Deleted"),
+	})
+	add("GET /admin/usage", &openapi.Operation{
+		OperationID: "adminStorageUsage", Summary: "Get every user's storage use", Tags: []string{"admin"}, Security: userAuth,
+		Responses: jsonResponse(http.StatusOK, "Usage by user", objects),
+	})
+	add("GET /api/admin/storage-report", &openapi.Operation{
+		OperationID: "adminStorageReport", Summary: "Add up what's actually stored", Tags: []string{"admin"}, Security: userAuth,
+		Parameters: []openapi.Parameter{queryEnum("format", "json by default", "json", "csv")},
+		Responses:  jsonResponse(http.StatusOK, "The report", object),
+	})
+	add("GET /admin/storage_report", &openapi.Operation{
+		OperationID: "adminStorageReportAlias", Summary: "Same as GET /api/admin/storage-report", Tags: []string{"admin"}, Security: userAuth,
+		Parameters: []openapi.Parameter{queryEnum("format", "json by default", "json", "csv")},
+		Responses:  jsonResponse(http.StatusOK, "The report", object),
+	})
+	add("POST /admin/retag_objects", &openapi.Operation{
+		OperationID: "adminRetagObjects", Summary: "Tag objects stored before objects were tagged", Tags: []string{"admin"}, Security: userAuth,
+		Responses: jsonResponse(http.StatusOK, "How many objects were tagged", object),
+	})
+	add("PUT /admin/users/{userID}/role", &openapi.Operation{
+		OperationID: "adminSetUserRole", Summary: "Set a user's role", Tags: []string{"admin"}, Security: userAuth,
+		Parameters: []openapi.Parameter{userID},
+		RequestBody: jsonBody(objectSchema([]string{"role"}, map[string]*openapi.Schema{
+			"role": {Type: "string", Enum: []string{string(auth.RoleUser), string(auth.RoleModerator), string(auth.RoleAdmin)}},
+		})),
+		Responses: emptyResponse(http.StatusNoContent, "Set"),
+	})
+	add("PUT /admin/users/{userID}/quota", &openapi.Operation{
+		OperationID: "adminSetUserQuota", Summary: "Set a user's storage quota", Tags: []string{"admin"}, Security: userAuth,
+		Parameters: []openapi.Parameter{userID},
+		RequestBody: jsonBody(objectSchema([]string{"quota_bytes"}, map[string]*openapi.Schema{
+			"quota_bytes": {Type: "integer", Nullable: true, Description: "null for the default quota"},
+		})),
+		Responses: emptyResponse(http.StatusNoContent, "Set"),
+	})
+	add("PUT /admin/users/{userID}/plan", &openapi.Operation{
+		OperationID: "adminSetUserPlan", Summary: "Move a user to another plan", Tags: []string{"admin"}, Security: userAuth,
+		Parameters:  []openapi.Parameter{userID},
+		RequestBody: jsonBody(objectSchema([]string{"plan"}, map[string]*openapi.Schema{"plan": {Type: "string"}})),
+		Responses:   emptyResponse(http.StatusNoContent, "Set"),
+	})
+	add("GET /admin/users/{userID}/scan_incidents", &openapi.Operation{
+		OperationID: "adminListScanIncidents", Summary: "List a user's rejected uploads", Tags: []string{"admin"}, Security: userAuth,
+		Parameters: []openapi.Parameter{userID},
+		Responses:  jsonResponse(http.StatusOK, "Scan incidents", objects),
+	})
+	add("GET /admin/jobs", &openapi.Operation{
+		OperationID: "adminListJobs", Summary: "List background jobs, dead ones by default", Tags: []string{"admin"}, Security: userAuth,
+		Parameters: []openapi.Parameter{
+			queryEnum("status", "", database.JobStatusQueued, database.JobStatusRunning, database.JobStatusDead),
+			queryInt("limit", "Page size", 1, maxJobPageSize),
+		},
+		Responses: jsonResponse(http.StatusOK, "Jobs, most recently updated first", objects),
+	})
+	add("POST /admin/jobs/{jobID}/requeue", &openapi.Operation{
+		OperationID: "adminRequeueJob", Summary: "Give a dead job a fresh set of attempts", Tags: []string{"admin"}, Security: userAuth,
+		Parameters: []openapi.Parameter{pathUUID("jobID")},
+		Responses:  jsonResponse(http.StatusOK, "The job", object),
+	})
+
+	add("GET /api/openapi.json", &openapi.Operation{
+		OperationID: "getOpenAPI", Summary: "Get this document", Tags: []string{"meta"},
+		Responses: jsonResponse(http.StatusOK, "An OpenAPI 3 document", object),
+	})
+	return doc
+}
+
+// videoListParams filter, sort and page a list of videos.
+func videoListParams() []openapi.Parameter {
+	return []openapi.Parameter{
+		queryEnum("aspect", "Aspect ratio", append(aspectDirectories(), "other")...),
+		{Name: "tag", In: openapi.InQuery, Description: "Tags every video must have, repeated or comma separated", Schema: &openapi.Schema{Type: "array", Items: &openapi.Schema{Type: "string"}}},
+		queryEnum("visibility", "", database.VideoVisibilityPublic, database.VideoVisibilityUnlisted, database.VideoVisibilityPrivate),
+		queryEnum("status", "", database.UploadStateDraft, database.UploadStateUploading, database.UploadStateReady),
+		queryString("q", "Words every video must have in its title or description"),
+		queryEnum("sort", "created_at by default, or relevance for searches", database.VideoSortCreatedAt, database.VideoSortTitle, database.VideoSortRelevance),
+		queryEnum("order", "", "asc", "desc"),
+		queryInt("limit", "Page size", 1, maxVideoPageSize),
+		queryInt("offset", "Videos to skip", 0, 0),
+	}
+}
+
+func aspectDirectories() []string {
+	directories := make([]string, len(aspectClasses))
+	for i, class := range aspectClasses {
+		directories[i] = class.Directory
+	}
+	return directories
+}
+
+func ownerParam() openapi.Parameter {
+	return queryString("owner", `A user ID, or "all" for everyone's`)
+}
+
+func shareParam() openapi.Parameter {
+	return queryString("share", "A share link token, for private videos")
+}
+
+func idempotencyKeyHeader() openapi.Parameter {
+	return openapi.Parameter{
+		Name:        "Idempotency-Key",
+		In:          openapi.InHeader,
+		Description: "Replays the first response to a retried request with the same key",
+		Schema:      &openapi.Schema{Type: "string"},
+	}
+}
+
+func uploadSessionSchema() *openapi.Schema {
+	return objectSchema([]string{"size", "content_type"}, map[string]*openapi.Schema{
+		"size":         {Type: "integer", Minimum: floatPtr(1)},
+		"content_type": {Type: "string"},
+		"filename":     {Type: "string"},
+	})
+}
+
+func directUploadSchema() *openapi.Schema {
+	return objectSchema([]string{"content_type", "size"}, map[string]*openapi.Schema{
+		"content_type":  {Type: "string"},
+		"size":          {Type: "integer", Minimum: floatPtr(1)},
+		"storage_class": {Type: "string"},
+	})
+}
+
+func pathUUID(name string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: openapi.InPath, Required: true, Schema: uuidSchema()}
+}
+
+func queryUUID(name, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: openapi.InQuery, Description: description, Schema: uuidSchema()}
+}
+
+func queryString(name, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: openapi.InQuery, Description: description, Schema: &openapi.Schema{Type: "string"}}
+}
+
+func queryBool(name, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: openapi.InQuery, Description: description, Schema: &openapi.Schema{Type: "boolean"}}
+}
+
+// queryInt is an integer query parameter. A max of 0 means there's none.
+func queryInt(name, description string, min, max int) openapi.Parameter {
+	schema := &openapi.Schema{Type: "integer", Minimum: floatPtr(float64(min))}
+	if max > 0 {
+		schema.Maximum = floatPtr(float64(max))
+	}
+	return openapi.Parameter{Name: name, In: openapi.InQuery, Description: description, Schema: schema}
+}
+
+func queryEnum(name, description string, values ...string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: openapi.InQuery, Description: description, Schema: &openapi.Schema{Type: "string", Enum: values}}
+}
+
+func jsonBody(schema *openapi.Schema) *openapi.RequestBody {
+	return &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{"application/json": {Schema: schema}}}
+}
+
+func optionalJSONBody(schema *openapi.Schema) *openapi.RequestBody {
+	body := jsonBody(schema)
+	body.Required = false
+	return body
+}
+
+func multipartBody(required []string, properties map[string]*openapi.Schema) *openapi.RequestBody {
+	return &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{"multipart/form-data": {Schema: objectSchema(required, properties)}}}
+}
+
+func jsonResponse(code int, description string, schema *openapi.Schema) map[string]openapi.Response {
+	return map[string]openapi.Response{
+		statusKey(code): {Description: description, Content: map[string]openapi.MediaType{"application/json": {Schema: schema}}},
+	}
+}
+
+func streamResponse(contentType, description string) map[string]openapi.Response {
+	return map[string]openapi.Response{
+		statusKey(http.StatusOK): {Description: description, Content: map[string]openapi.MediaType{contentType: {Schema: &openapi.Schema{Type: "string", Format: "binary"}}}},
+	}
+}
+
+func emptyResponse(code int, description string) map[string]openapi.Response {
+	return map[string]openapi.Response{statusKey(code): {Description: description}}
+}
+
+// withResponse adds another status to responses.
+func withResponse(responses map[string]openapi.Response, code int, description string) map[string]openapi.Response {
+	responses[statusKey(code)] = openapi.Response{Description: description}
+	return responses
+}
+
+func statusKey(code int) string {
+	return strconv.Itoa(code)
+}
+
+func objectSchema(required []string, properties map[string]*openapi.Schema) *openapi.Schema {
+	return &openapi.Schema{Type: "object", Required: required, Properties: properties}
+}
+
+func refSchema(name string) *openapi.Schema {
+	return &openapi.Schema{Ref: "#/components/schemas/" + name}
+}
+
+func uuidSchema() *openapi.Schema {
+	return &openapi.Schema{Type: "string", Format: "uuid"}
+}
+
+func dateTimeSchema() *openapi.Schema {
+	return &openapi.Schema{Type: "string", Format: "date-time"}
+}
+
+func nullableString() *openapi.Schema {
+	return &openapi.Schema{Type: "string", Nullable: true}
+}
+
+func visibilitySchema() *openapi.Schema {
+	return &openapi.Schema{Type: "string", Enum: []string{database.VideoVisibilityPublic, database.VideoVisibilityUnlisted, database.VideoVisibilityPrivate}}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func floatPtr(n float64) *float64 {
+	return &n
+}
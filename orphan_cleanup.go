@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// orphanGracePeriod protects objects that were written recently but aren't
+// referenced yet, like direct uploads awaiting confirmation or renditions
+// about to be recorded.
+const orphanGracePeriod = 24 * time.Hour
+
+// managedPrefixes are the parts of the bucket this server writes to. The
+// sweep never touches anything outside them.
+func managedPrefixes() []string {
+	prefixes := []string{"other/", "uploads/", "renditions/", "hls/", "sprites/", thumbnailKeyPrefix, audioKeyPrefix + "/"}
+	for _, class := range aspectClasses {
+		prefixes = append(prefixes, class.Directory+"/")
+	}
+	return prefixes
+}
+
+// runOrphanSweeper reconciles the bucket against the database every
+// interval until ctx is cancelled.
+func (cfg *apiConfig) runOrphanSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		deleted, err := cfg.deleteOrphanedObjects(ctx)
+		if err != nil {
+			slog.Error("Orphan sweep failed", "error", err)
+			continue
+		}
+		if deleted > 0 {
+			slog.Info("Orphan sweep deleted objects", "count", deleted)
+		}
+	}
+}
+
+// deleteOrphanedObjects removes objects under the managed prefixes that no
+// video refers to and that are older than orphanGracePeriod.
+func (cfg *apiConfig) deleteOrphanedObjects(ctx context.Context) (int, error) {
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return 0, err
+	}
+	referenced := map[string]bool{}
+	// HLS segments and sprite sheets are kept by directory
+	outputDirs := map[string]bool{}
+	for _, video := range videos {
+		for _, key := range cfg.videoReferencedKeys(video) {
+			referenced[key] = true
+		}
+		if video.HLSURL != nil {
+			outputDirs[hlsPrefix(video)] = true
+		}
+		if video.SpritesVTTURL != nil {
+			outputDirs[spritesPrefix(video)] = true
+		}
+	}
+
+	cutoff := time.Now().Add(-orphanGracePeriod)
+	orphans := []string{}
+	for _, prefix := range managedPrefixes() {
+		objects, err := cfg.store.List(ctx, prefix)
+		if err != nil {
+			return 0, err
+		}
+		for _, object := range objects {
+			if referenced[object.Key] || object.LastModified.After(cutoff) {
+				continue
+			}
+			if outputDirs[outputDirOfKey(object.Key)] {
+				continue
+			}
+			orphans = append(orphans, object.Key)
+		}
+	}
+
+	failed, err := cfg.deleteObjects(ctx, orphans)
+	if err != nil {
+		return 0, err
+	}
+	for key, keyErr := range failed {
+		slog.Error("Couldn't delete orphaned object", "key", key, "error", keyErr)
+	}
+	return len(orphans) - len(failed), nil
+}
+
+// outputDirOfKey returns the "<kind>/<video id>/" prefix a key lives under,
+// such as "hls/<video id>/".
+func outputDirOfKey(key string) string {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 3 {
+		return key
+	}
+	return parts[0] + "/" + parts[1] + "/"
+}
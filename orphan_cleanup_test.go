@@ -0,0 +1,15 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestManagedPrefixes(t *testing.T) {
+	prefixes := managedPrefixes()
+	for _, want := range []string{"other/", "uploads/", audioKeyPrefix + "/", thumbnailKeyPrefix, "landscape/", "portrait/"} {
+		if !slices.Contains(prefixes, want) {
+			t.Errorf("managedPrefixes() = %v, missing %q", prefixes, want)
+		}
+	}
+}
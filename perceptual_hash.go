@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+)
+
+const (
+	// perceptualHashFrames frames are sampled evenly across the video, each
+	// contributing a 64 bit average hash.
+	perceptualHashFrames = 4
+	hashFrameSide        = 8
+
+	// defaultSimilarityThreshold is the largest Hamming distance, out of
+	// perceptualHashFrames*64 bits, still reported as a likely duplicate.
+	defaultSimilarityThreshold = 40
+)
+
+// perceptualHash computes an average hash over a few frames of a video. Re-encodes
+// of the same footage produce hashes a small Hamming distance apart, which
+// exact checksums can't detect.
+func perceptualHash(ctx context.Context, filePath string, duration float64) (string, error) {
+	if duration <= 0 {
+		return "", fmt.Errorf("can't sample frames from a video of duration %v", duration)
+	}
+
+	// Downscale each sampled frame to 8x8 grayscale and read the raw pixels
+	filter := fmt.Sprintf("fps=%d/%f,scale=%d:%d,format=gray", perceptualHashFrames, duration, hashFrameSide, hashFrameSide)
+	var out bytes.Buffer
+	err := runMediaCommand(ctx, "ffmpeg_perceptual_hash", ffmpegTimeout, &out, "ffmpeg", "-v", "error", "-i", filePath,
+		"-vf", filter, "-frames:v", fmt.Sprint(perceptualHashFrames), "-f", "rawvideo", "pipe:1")
+	if err != nil {
+		return "", err
+	}
+
+	frameSize := hashFrameSide * hashFrameSide
+	pixels := out.Bytes()
+	if len(pixels) < frameSize {
+		return "", fmt.Errorf("ffmpeg returned %d bytes, want at least one frame", len(pixels))
+	}
+
+	hash := []byte{}
+	for offset := 0; offset+frameSize <= len(pixels); offset += frameSize {
+		hash = append(hash, averageHash(pixels[offset:offset+frameSize])...)
+	}
+	return hex.EncodeToString(hash), nil
+}
+
+// averageHash sets one bit per pixel that is brighter than the frame's mean.
+func averageHash(frame []byte) []byte {
+	total := 0
+	for _, p := range frame {
+		total += int(p)
+	}
+	mean := total / len(frame)
+
+	hash := make([]byte, len(frame)/8)
+	for i, p := range frame {
+		if int(p) > mean {
+			hash[i/8] |= 1 << (7 - uint(i%8))
+		}
+	}
+	return hash
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// plan is what a tier of users may upload. A zero limit means unlimited.
+type plan struct {
+	Name               string  `json:"name"`
+	MaxDurationSeconds float64 `json:"max_duration_seconds"`
+	MaxFileBytes       int64   `json:"max_file_bytes"`
+	// MaxRenditions keeps the lowest resolutions when transcoding
+	MaxRenditions  int `json:"max_renditions"`
+	MonthlyUploads int `json:"monthly_uploads"`
+}
+
+const defaultPlan = "free"
+
+var plans = map[string]plan{
+	"free": {
+		Name:               "free",
+		MaxDurationSeconds: 10 * 60,
+		MaxFileBytes:       500 << 20,
+		MaxRenditions:      1,
+		MonthlyUploads:     20,
+	},
+	"pro": {
+		Name:               "pro",
+		MaxDurationSeconds: 4 * 60 * 60,
+		MaxFileBytes:       10 << 30,
+		MonthlyUploads:     1000,
+	},
+}
+
+// userPlan returns the plan a user is on, falling back to the default for
+// users on one that no longer exists.
+func (cfg *apiConfig) userPlan(userID uuid.UUID) (plan, error) {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return plan{}, err
+	}
+	if user != nil {
+		if p, ok := plans[user.Plan]; ok {
+			return p, nil
+		}
+	}
+	return plans[defaultPlan], nil
+}
+
+// respondWithPlanLimit reports an upload refused by the user's plan, along
+// with the plan's limits so clients can explain them.
+func respondWithPlanLimit(w http.ResponseWriter, code int, msg string, p plan) {
+	type response struct {
+		errorResponse
+		Plan plan `json:"plan"`
+	}
+	respondWithJSON(w, code, response{errorResponse: newErrorResponse(w, codePlanLimitExceeded, msg), Plan: p})
+}
+
+// startOfMonth is when monthly upload counts reset, at midnight UTC on the
+// first.
+func startOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// checkMonthlyUploads makes sure a user hasn't used up their plan's uploads
+// for the month. It responds itself with a 402 and returns false if they
+// have.
+func (cfg *apiConfig) checkMonthlyUploads(w http.ResponseWriter, userID uuid.UUID) bool {
+	p, err := cfg.userPlan(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return false
+	}
+	if p.MonthlyUploads == 0 {
+		return true
+	}
+	count, err := cfg.db.CountVideoUploadsSince(userID, startOfMonth(time.Now()))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count uploads", err)
+		return false
+	}
+	if count < p.MonthlyUploads {
+		return true
+	}
+	respondWithPlanLimit(w, http.StatusPaymentRequired, fmt.Sprintf("You've used all %d uploads the %s plan allows this month", p.MonthlyUploads, p.Name), p)
+	return false
+}
+
+// checkPlanDuration makes sure a video is no longer than its owner's plan
+// allows. It responds itself with a 402 and returns false if it is.
+func (cfg *apiConfig) checkPlanDuration(w http.ResponseWriter, userID uuid.UUID, duration float64) bool {
+	p, err := cfg.userPlan(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return false
+	}
+	if p.MaxDurationSeconds == 0 || duration <= p.MaxDurationSeconds {
+		return true
+	}
+	limit := time.Duration(p.MaxDurationSeconds) * time.Second
+	respondWithPlanLimit(w, http.StatusPaymentRequired, fmt.Sprintf("Videos on the %s plan can be at most %v long", p.Name, limit), p)
+	return false
+}
+
+// recordVideoUpload counts a stored upload against its owner's monthly
+// limit. The upload has already succeeded, so failures are only logged.
+func (cfg *apiConfig) recordVideoUpload(ctx context.Context, video database.Video) {
+	err := cfg.db.RecordVideoUpload(video.UserID, video.ID)
+	if err != nil {
+		loggerFromContext(ctx).Error("Couldn't record upload", "video_id", video.ID, "error", err)
+	}
+}
+
+// handlerAdminSetUserPlan moves a user onto another plan. It's served
+// behind requireRole(auth.RoleAdmin).
+func (cfg *apiConfig) handlerAdminSetUserPlan(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Plan string `json:"plan"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidID, err)
+		return
+	}
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if _, ok := plans[params.Plan]; !ok {
+		respondWithError(w, http.StatusBadRequest, "plan must be free or pro", nil)
+		return
+	}
+
+	found, err := cfg.db.SetUserPlan(userID, params.Plan)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update plan", err)
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxPlaylistVideos = 500
+
+// requestViewer returns whoever the request's access token belongs to, if
+// it has a valid one. Endpoints that anyone can call use it to show owners
+// and admins more.
+func (cfg *apiConfig) requestViewer(r *http.Request) (authUser, bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return authUser{}, false
+	}
+	userID, role, err := cfg.validateAccessToken(token)
+	if err != nil {
+		return authUser{}, false
+	}
+	return authUser{ID: userID, Role: role}, true
+}
+
+// playlistDetails checks and cleans up a playlist's title, description and
+// visibility, returning a message for the client if they're unacceptable.
+func playlistDetails(playlist *database.Playlist) string {
+	playlist.Title = sanitizeVideoText(playlist.Title, false)
+	playlist.Description = sanitizeVideoText(playlist.Description, true)
+	switch {
+	case playlist.Title == "":
+		return "Title can't be empty"
+	case utf8.RuneCountInString(playlist.Title) > maxVideoTitleLength:
+		return fmt.Sprintf("Title can be at most %d characters", maxVideoTitleLength)
+	case utf8.RuneCountInString(playlist.Description) > maxVideoDescriptionLength:
+		return fmt.Sprintf("Description can be at most %d characters", maxVideoDescriptionLength)
+	case !database.ValidVideoVisibility(playlist.Visibility):
+		return "visibility must be public, unlisted or private"
+	}
+	return ""
+}
+
+// handlerPlaylistsCreate creates an empty playlist for the caller. Playlists
+// are public unless visibility says otherwise, with the same meanings as
+// for videos.
+func (cfg *apiConfig) handlerPlaylistsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Visibility  string `json:"visibility"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Visibility == "" {
+		params.Visibility = database.VideoVisibilityPublic
+	}
+	playlist := database.Playlist{CreatePlaylistParams: database.CreatePlaylistParams{
+		UserID:      authUserFromContext(r.Context()).ID,
+		Title:       params.Title,
+		Description: params.Description,
+		Visibility:  params.Visibility,
+	}}
+	if msg := playlistDetails(&playlist); msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg, nil)
+		return
+	}
+
+	playlist, err = cfg.db.CreatePlaylist(playlist.CreatePlaylistParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create playlist", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, playlist)
+}
+
+// handlerPlaylistsList lists the caller's playlists, or another user's with
+// owner set to their ID, in which case only admins see more than the public
+// ones.
+func (cfg *apiConfig) handlerPlaylistsList(w http.ResponseWriter, r *http.Request) {
+	user := authUserFromContext(r.Context())
+	ownerID := user.ID
+	if owner := r.URL.Query().Get("owner"); owner != "" {
+		id, err := uuid.Parse(owner)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "owner must be a user ID", err)
+			return
+		}
+		ownerID = id
+	}
+
+	playlists, err := cfg.db.GetPlaylists(ownerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlists", err)
+		return
+	}
+	if ownerID != user.ID && user.Role != auth.RoleAdmin {
+		public := []database.Playlist{}
+		for _, playlist := range playlists {
+			if playlist.Visibility == database.VideoVisibilityPublic {
+				public = append(public, playlist)
+			}
+		}
+		playlists = public
+	}
+	respondWithJSON(w, http.StatusOK, playlists)
+}
+
+// getPlaylist loads the playlist in the path if the request may see it,
+// which only private playlists restrict. It responds itself and returns
+// false otherwise.
+func (cfg *apiConfig) getPlaylist(w http.ResponseWriter, r *http.Request) (database.Playlist, bool) {
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		respondWithAPIError(w, &apiError{http.StatusBadRequest, codeInvalidID, "Invalid playlist ID"}, err)
+		return database.Playlist{}, false
+	}
+	playlist, err := cfg.db.GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return database.Playlist{}, false
+	}
+	if playlist.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+		return database.Playlist{}, false
+	}
+	if playlist.Visibility == database.VideoVisibilityPrivate {
+		viewer, ok := cfg.requestViewer(r)
+		if !ok || (viewer.ID != playlist.UserID && viewer.Role != auth.RoleAdmin) {
+			respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+			return database.Playlist{}, false
+		}
+	}
+	return playlist, true
+}
+
+// getOwnedPlaylist loads the playlist in the path if the caller owns it or
+// is an admin. It responds itself and returns false otherwise.
+func (cfg *apiConfig) getOwnedPlaylist(w http.ResponseWriter, r *http.Request) (database.Playlist, bool) {
+	playlist, ok := cfg.getPlaylist(w, r)
+	if !ok {
+		return database.Playlist{}, false
+	}
+	user := authUserFromContext(r.Context())
+	if playlist.UserID != user.ID && user.Role != auth.RoleAdmin {
+		respondWithError(w, http.StatusForbidden, "You can't change this playlist", nil)
+		return database.Playlist{}, false
+	}
+	return playlist, true
+}
+
+// handlerPlaylistGet responds with a playlist and its videos in order.
+// Videos the viewer couldn't see on their own, like other users' private
+// ones, are left out.
+func (cfg *apiConfig) handlerPlaylistGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		database.Playlist
+		Videos []database.Video `json:"videos"`
+	}
+
+	playlist, ok := cfg.getPlaylist(w, r)
+	if !ok {
+		return
+	}
+	videos, err := cfg.db.GetPlaylistVideos(playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist videos", err)
+		return
+	}
+
+	viewer, _ := cfg.requestViewer(r)
+	visible := []database.Video{}
+	for _, video := range videos {
+		hidden := video.Visibility == database.VideoVisibilityPrivate || video.ModerationStatus == database.ModerationStatusBlocked
+		if hidden && video.UserID != viewer.ID && viewer.Role != auth.RoleAdmin {
+			continue
+		}
+		visible = append(visible, video)
+	}
+	err = cfg.dbVideosToSignedVideos(r.Context(), visible)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{Playlist: playlist, Videos: visible})
+}
+
+// handlerPlaylistUpdate changes a playlist's title, description and
+// visibility. Fields left out of the body are kept.
+func (cfg *apiConfig) handlerPlaylistUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		Visibility  *string `json:"visibility"`
+	}
+
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Title == nil && params.Description == nil && params.Visibility == nil {
+		respondWithError(w, http.StatusBadRequest, "Nothing to update", nil)
+		return
+	}
+	if params.Title != nil {
+		playlist.Title = *params.Title
+	}
+	if params.Description != nil {
+		playlist.Description = *params.Description
+	}
+	if params.Visibility != nil {
+		playlist.Visibility = *params.Visibility
+	}
+	if msg := playlistDetails(&playlist); msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg, nil)
+		return
+	}
+
+	err = cfg.db.UpdatePlaylist(playlist)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update playlist", err)
+		return
+	}
+	cfg.respondWithPlaylist(w, playlist.ID)
+}
+
+func (cfg *apiConfig) handlerPlaylistDelete(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+	err := cfg.db.DeletePlaylist(playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete playlist", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerPlaylistVideoAdd appends a video to a playlist. The playlist's
+// owner has to be able to see the video, so other users' private videos
+// can't be added.
+func (cfg *apiConfig) handlerPlaylistVideoAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoID uuid.UUID `json:"video_id"`
+	}
+
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(params.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || (video.Visibility == database.VideoVisibilityPrivate && video.UserID != playlist.UserID) {
+		respondWithAPIError(w, errVideoNotFound, nil)
+		return
+	}
+	if playlist.VideoCount >= maxPlaylistVideos {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Playlists can have at most %d videos", maxPlaylistVideos), nil)
+		return
+	}
+
+	added, err := cfg.db.AddPlaylistVideo(playlist.ID, video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add video to playlist", err)
+		return
+	}
+	if !added {
+		respondWithError(w, http.StatusConflict, "Video is already in the playlist", nil)
+		return
+	}
+	cfg.respondWithPlaylist(w, playlist.ID)
+}
+
+func (cfg *apiConfig) handlerPlaylistVideoRemove(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidVideoID, err)
+		return
+	}
+	removed, err := cfg.db.RemovePlaylistVideo(playlist.ID, videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove video from playlist", err)
+		return
+	}
+	if !removed {
+		respondWithError(w, http.StatusNotFound, "Video isn't in the playlist", nil)
+		return
+	}
+	cfg.respondWithPlaylist(w, playlist.ID)
+}
+
+// handlerPlaylistReorder puts a playlist's videos in the order given, which
+// has to list every video in it exactly once.
+func (cfg *apiConfig) handlerPlaylistReorder(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoIDs []uuid.UUID `json:"video_ids"`
+	}
+
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	current, err := cfg.db.GetPlaylistVideoIDs(playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist videos", err)
+		return
+	}
+	inPlaylist := map[uuid.UUID]bool{}
+	for _, id := range current {
+		inPlaylist[id] = true
+	}
+	listed := map[uuid.UUID]bool{}
+	for _, id := range params.VideoIDs {
+		if !inPlaylist[id] || listed[id] {
+			respondWithError(w, http.StatusBadRequest, "video_ids must list every video in the playlist once", nil)
+			return
+		}
+		listed[id] = true
+	}
+	if len(listed) != len(current) {
+		respondWithError(w, http.StatusBadRequest, "video_ids must list every video in the playlist once", nil)
+		return
+	}
+
+	err = cfg.db.SetPlaylistOrder(playlist.ID, params.VideoIDs)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reorder playlist", err)
+		return
+	}
+	cfg.respondWithPlaylist(w, playlist.ID)
+}
+
+// respondWithPlaylist responds with a playlist as it is after a change.
+func (cfg *apiConfig) respondWithPlaylist(w http.ResponseWriter, playlistID uuid.UUID) {
+	playlist, err := cfg.db.GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, playlist)
+}
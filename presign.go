@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const defaultS3PresignExpiry = 15 * time.Minute
+
+// signsObjectURLs reports whether stored object URLs have to be signed
+// before they're handed out.
+func (cfg *apiConfig) signsObjectURLs() bool {
+	return cfg.s3Private || cfg.cfSigner != nil
+}
+
+// signObjectURL turns a stored "bucket,key" reference into a short-lived
+// presigned GET URL, and signs CDN URLs when CloudFront restricts access.
+// Anything else is returned unchanged.
+func (cfg *apiConfig) signObjectURL(ctx context.Context, objectURL string) (string, error) {
+	if bucket, key, ok := strings.Cut(objectURL, ","); ok && bucket != "" && key != "" {
+		return cfg.cachedSignature(ctx, objectURL, func() (string, error) {
+			return cfg.store.PresignGet(ctx, key, cfg.s3PresignExpiry)
+		})
+	}
+	if cfg.cfSigner != nil {
+		if strings.HasPrefix(objectURL, cfg.s3ObjectBaseURL+"/") {
+			return cfg.cachedSignature(ctx, objectURL, func() (string, error) {
+				return cfg.cfSigner.Sign(objectURL, time.Now().Add(cfg.s3PresignExpiry))
+			})
+		}
+	}
+	return objectURL, nil
+}
+
+// dbVideoToSignedVideo presigns every S3 reference on a video so it can be
+// handed to clients when the bucket is private. The stored row is untouched.
+func (cfg *apiConfig) dbVideoToSignedVideo(ctx context.Context, video database.Video) (database.Video, error) {
+	if cfg.encryptVideos {
+		video = videoWithStreamURLs(video)
+	}
+	if !cfg.signsObjectURLs() {
+		return video, nil
+	}
+
+	if video.ThumbnailURL != nil {
+		signed, err := cfg.signObjectURL(ctx, *video.ThumbnailURL)
+		if err != nil {
+			return video, err
+		}
+		video.ThumbnailURL = &signed
+	}
+	if video.ThumbnailSmallURL != nil {
+		signed, err := cfg.signObjectURL(ctx, *video.ThumbnailSmallURL)
+		if err != nil {
+			return video, err
+		}
+		video.ThumbnailSmallURL = &signed
+	}
+	if video.PreviewURL != nil {
+		signed, err := cfg.signObjectURL(ctx, *video.PreviewURL)
+		if err != nil {
+			return video, err
+		}
+		video.PreviewURL = &signed
+	}
+
+	if video.VideoURL != nil {
+		presigned, err := cfg.signObjectURL(ctx, *video.VideoURL)
+		if err != nil {
+			return video, err
+		}
+		video.VideoURL = &presigned
+	}
+
+	// Copy the maps so the caller's video keeps its stored references
+	signMap := func(urls database.URLMap) (database.URLMap, error) {
+		if urls == nil {
+			return nil, nil
+		}
+		signed := make(database.URLMap, len(urls))
+		for name, objectURL := range urls {
+			presigned, err := cfg.signObjectURL(ctx, objectURL)
+			if err != nil {
+				return nil, err
+			}
+			signed[name] = presigned
+		}
+		return signed, nil
+	}
+	var err error
+	video.Renditions, err = signMap(video.Renditions)
+	if err != nil {
+		return video, err
+	}
+	video.CaptionsURL, err = signMap(video.CaptionsURL)
+	if err != nil {
+		return video, err
+	}
+	return video, nil
+}
+
+// videoWithStreamURLs points a video's file and renditions at the stream
+// endpoint, which decrypts them, since their storage URLs only serve
+// ciphertext.
+func videoWithStreamURLs(video database.Video) database.Video {
+	streamURL := fmt.Sprintf("/api/videos/%s/stream", video.ID)
+	if video.VideoURL != nil {
+		video.VideoURL = &streamURL
+	}
+	if video.Renditions != nil {
+		renditions := make(database.URLMap, len(video.Renditions))
+		for name := range video.Renditions {
+			renditions[name] = streamURL + "?rendition=" + url.QueryEscape(name)
+		}
+		video.Renditions = renditions
+	}
+	return video
+}
+
+// dbVideosToSignedVideos presigns a list of videos in place.
+func (cfg *apiConfig) dbVideosToSignedVideos(ctx context.Context, videos []database.Video) error {
+	for i, video := range videos {
+		signed, err := cfg.dbVideoToSignedVideo(ctx, video)
+		if err != nil {
+			return err
+		}
+		videos[i] = signed
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	// Previews are short, small and choppy, for showing on hover
+	previewDuration = 3.0
+	previewFPS      = 10
+)
+
+// previewMediaTypes are the PREVIEW_FORMAT values and what they're stored as.
+var previewMediaTypes = map[string]string{
+	"gif":  "image/gif",
+	"webp": "image/webp",
+}
+
+// extractPreview cuts previewDuration seconds of input, starting at the given
+// offset, into an animated GIF or WebP the width of a small thumbnail.
+func extractPreview(ctx context.Context, input string, at float64, format string) ([]byte, error) {
+	filter := fmt.Sprintf("fps=%d,scale=%d:-2:flags=lanczos", previewFPS, smallThumbnailWidth)
+	var codecArgs []string
+	switch format {
+	case "gif":
+		// A palette made from the clip itself looks far better than the
+		// default one
+		filter += ",split[a][b];[a]palettegen[p];[b][p]paletteuse"
+	case "webp":
+		codecArgs = []string{"-c:v", "libwebp", "-quality", "75"}
+	default:
+		return nil, fmt.Errorf("unknown preview format %q", format)
+	}
+
+	args := []string{"-v", "error",
+		"-ss", strconv.FormatFloat(at, 'f', 3, 64), "-t", strconv.FormatFloat(previewDuration, 'f', 3, 64),
+		"-i", input, "-an", "-vf", filter}
+	args = append(args, codecArgs...)
+	args = append(args, "-loop", "0", "-f", format, "pipe:1")
+
+	var out bytes.Buffer
+	err := runMediaCommand(ctx, "ffmpeg_preview", ffmpegTimeout, &out, "ffmpeg", args...)
+	if err != nil {
+		return nil, fmt.Errorf("extracting preview: %w", err)
+	}
+	if out.Len() == 0 {
+		return nil, fmt.Errorf("no frames at %vs", at)
+	}
+	return out.Bytes(), nil
+}
+
+// setPreview stores an animated preview of the video file at input next to
+// its thumbnails and points video at it. It starts where the automatic
+// thumbnail is taken from, moved back so short videos still fill it.
+func (cfg *apiConfig) setPreview(ctx context.Context, video *database.Video, input string, duration float64) error {
+	at := min(duration*autoThumbnailPosition, max(duration-previewDuration, 0))
+	data, err := extractPreview(ctx, input, at, cfg.previewFormat)
+	if err != nil {
+		return err
+	}
+	previewURL, err := cfg.storeThumbnail(ctx, *video, previewMediaTypes[cfg.previewFormat], data)
+	if err != nil {
+		return err
+	}
+	video.PreviewURL = &previewURL
+	return nil
+}
+
+// deletePreview removes the preview of a video whose file is being replaced.
+func (cfg *apiConfig) deletePreview(ctx context.Context, video *database.Video) {
+	if video.PreviewURL == nil {
+		return
+	}
+	err := cfg.deleteThumbnail(ctx, *video.PreviewURL)
+	if err != nil {
+		loggerFromContext(ctx).Error("Couldn't delete previous preview", "video_id", video.ID, "url", *video.PreviewURL, "error", err)
+	}
+	video.PreviewURL = nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// storageQuota is a user's storage usage as reported to clients. QuotaBytes
+// and RemainingBytes are null for users without a limit.
+type storageQuota struct {
+	UsedBytes      int64  `json:"used_bytes"`
+	QuotaBytes     *int64 `json:"quota_bytes"`
+	RemainingBytes *int64 `json:"remaining_bytes"`
+}
+
+// storageQuota returns how much a user has stored and may store. A quota of
+// 0, either the default or a user's override, means unlimited.
+func (cfg *apiConfig) storageQuota(userID uuid.UUID) (storageQuota, error) {
+	usage, err := cfg.db.GetStorageUsage(userID)
+	if err != nil {
+		return storageQuota{}, err
+	}
+	limit := cfg.defaultQuotaBytes
+	if usage.QuotaBytes != nil {
+		limit = *usage.QuotaBytes
+	}
+
+	quota := storageQuota{UsedBytes: usage.UsedBytes}
+	if limit > 0 {
+		remaining := max(limit-usage.UsedBytes, 0)
+		quota.QuotaBytes = &limit
+		quota.RemainingBytes = &remaining
+	}
+	return quota, nil
+}
+
+// checkQuota makes sure replacing video's file with one of size bytes keeps
+// its owner within their quota and their plan's file size limit. It responds
+// itself with a 413 and returns false if it wouldn't.
+func (cfg *apiConfig) checkQuota(w http.ResponseWriter, video database.Video, size int64) bool {
+	type response struct {
+		errorResponse
+		storageQuota
+	}
+
+	p, err := cfg.userPlan(video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return false
+	}
+	if p.MaxFileBytes > 0 && size > p.MaxFileBytes {
+		respondWithPlanLimit(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Videos on the %s plan can be at most %d MB", p.Name, p.MaxFileBytes>>20), p)
+		return false
+	}
+
+	quota, err := cfg.storageQuota(video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return false
+	}
+	if quota.RemainingBytes == nil {
+		return true
+	}
+
+	// The file being replaced no longer counts once the upload succeeds
+	available := *quota.RemainingBytes
+	if video.VideoURL != nil && video.MediaInfo != nil {
+		available += video.MediaInfo.Size
+	}
+	if size <= available {
+		return true
+	}
+	respondWithJSON(w, http.StatusRequestEntityTooLarge, response{
+		errorResponse: newErrorResponse(w, codeQuotaExceeded, "Upload would exceed your storage quota"),
+		storageQuota:  quota,
+	})
+	return false
+}
+
+func (cfg *apiConfig) handlerUserUsage(w http.ResponseWriter, r *http.Request) {
+	quota, err := cfg.storageQuota(authUserFromContext(r.Context()).ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "User not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, quota)
+}
+
+// handlerAdminSetUserQuota overrides a user's quota. A quota_bytes of 0 is
+// unlimited and null reverts them to the default.
+func (cfg *apiConfig) handlerAdminSetUserQuota(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		QuotaBytes *int64 `json:"quota_bytes"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidID, err)
+		return
+	}
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.QuotaBytes != nil && *params.QuotaBytes < 0 {
+		respondWithError(w, http.StatusBadRequest, "quota_bytes can't be negative", nil)
+		return
+	}
+
+	found, err := cfg.db.SetUserQuota(userID, params.QuotaBytes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update quota", err)
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+const (
+	defaultUploadRateLimit = 1.0
+	defaultUploadBurst     = 5
+	// rateLimiterSweepInterval is how often idle buckets are forgotten
+	rateLimiterSweepInterval = time.Minute
+)
+
+// tokenBucket holds up to burst tokens and refills at rate per second.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter keeps a token bucket per key, like a user ID or client IP.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token from key's bucket. If it's empty, it returns false and
+// how long until a token is available.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > rateLimiterSweepInterval {
+		l.sweep(now)
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep forgets buckets that have refilled, since a new bucket starts full
+// anyway.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// rateLimitMiddleware limits requests per client IP and, for authenticated
+// requests, per user, so one client can't hog upload bandwidth and S3
+// requests. It does nothing if rate limiting is disabled.
+func (cfg *apiConfig) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.uploadRateLimiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		keys := []string{"ip:" + clientIP(r)}
+		// Bad tokens are left for the handler to reject
+		if token, err := auth.GetBearerToken(r.Header); err == nil {
+			if userID, err := auth.ValidateJWT(token, cfg.jwtSecret); err == nil {
+				keys = append(keys, "user:"+userID.String())
+			}
+		}
+
+		for _, key := range keys {
+			ok, wait := cfg.uploadRateLimiter.allow(key)
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				respondWithError(w, http.StatusTooManyRequests, "Too many uploads, slow down", nil)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
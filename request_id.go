@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// requestIDMiddleware tags every request with an ID, taken from the client's
+// X-Request-ID if it sent a sane one, so failures reported by users can be
+// matched to log lines. The request's logger adds the ID to everything it
+// logs.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !isValidRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, requestID)
+		ctx = withLogger(ctx, loggerWithRequestID(requestID))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+func loggerWithRequestID(requestID string) *slog.Logger {
+	if requestID == "" {
+		return slog.Default()
+	}
+	return slog.Default().With("request_id", requestID)
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+const (
+	// localObjectsPath is where objects in local storage are served from
+	localObjectsPath        = "/objects"
+	defaultLocalStorageRoot = "./objects"
+)
+
+// allowedStorageClasses are the S3 storage classes videos may be stored in.
+// Archive tiers are left out since their objects can't be streamed directly.
+var allowedStorageClasses = []types.StorageClass{
+	types.StorageClassStandard,
+	types.StorageClassStandardIa,
+	types.StorageClassOnezoneIa,
+	types.StorageClassIntelligentTiering,
+	types.StorageClassGlacierIr,
+}
+
+func parseStorageClass(value string) (types.StorageClass, error) {
+	for _, class := range allowedStorageClasses {
+		if strings.EqualFold(value, string(class)) {
+			return class, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported storage class %q", value)
+}
+
+// resolveStorageClass picks the storage class for an upload: the one the
+// client asked for, or the configured default. An empty result leaves the
+// choice to the bucket.
+func (cfg *apiConfig) resolveStorageClass(requested string) (types.StorageClass, error) {
+	if requested == "" {
+		return cfg.s3StorageClass, nil
+	}
+	return parseStorageClass(requested)
+}
+
+// newObjectKey returns a random, unguessable key under directory.
+func newObjectKey(directory, extension string) (string, error) {
+	name := make([]byte, 32)
+	_, err := rand.Read(name)
+	if err != nil {
+		return "", err
+	}
+	return directory + "/" + base64.RawURLEncoding.EncodeToString(name) + "." + extension, nil
+}
+
+// bucketBaseURL returns the URL a bucket's objects are read from directly,
+// on AWS when endpoint is empty and on the given S3-compatible service
+// otherwise.
+func bucketBaseURL(endpoint, bucket, region string, pathStyle bool) (string, error) {
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("%q is not an absolute URL", endpoint)
+	}
+	if pathStyle {
+		u.Path += "/" + bucket
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	return u.String(), nil
+}
+
+// getObjectURL returns the URL an object is served from.
+func (cfg *apiConfig) getObjectURL(key string) string {
+	// Private buckets can't be read through a plain URL, so store the object
+	// reference and presign it whenever it's handed out
+	if cfg.s3Private {
+		return fmt.Sprintf("%s,%s", cfg.s3Bucket, key)
+	}
+	return cfg.s3ObjectBaseURL + "/" + key
+}
+
+// objectKeyFromURL recovers the object key from a URL we handed out for it.
+func (cfg *apiConfig) objectKeyFromURL(objectURL string) (string, bool) {
+	if bucket, key, ok := strings.Cut(objectURL, ","); ok {
+		return key, bucket == cfg.s3Bucket && key != ""
+	}
+	key, ok := strings.CutPrefix(objectURL, cfg.s3ObjectBaseURL+"/")
+	if !ok || key == "" {
+		return "", false
+	}
+	return key, true
+}
+
+// deleteVideoFile removes a video's file from storage unless another video shares
+// it.
+func (cfg *apiConfig) deleteVideoFile(ctx context.Context, video database.Video) error {
+	if video.VideoURL == nil {
+		return nil
+	}
+	key, ok := cfg.objectKeyFromURL(*video.VideoURL)
+	if !ok {
+		return nil
+	}
+	inUse, err := cfg.db.VideoURLInUse(*video.VideoURL, video.ID)
+	if err != nil || inUse {
+		return err
+	}
+	return cfg.store.Delete(ctx, key)
+}
+
+// putFile stores a file and returns what the store confirmed it holds,
+// including its base64 SHA-256 checksum. The store checks the file arrived
+// intact, as ObjectStore.Put does. On S3, files of at least one part size go
+// through a multipart upload and get a composite checksum.
+func (cfg *apiConfig) putFile(ctx context.Context, key, contentType string, storageClass types.StorageClass, tags map[string]string, filePath string) (storage.ObjectInfo, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+
+	return cfg.store.Put(ctx, key, file, cfg.objectPutOptions(key, storage.PutOptions{
+		ContentType:  contentType,
+		StorageClass: string(storageClass),
+		Tags:         tags,
+		Size:         info.Size(),
+	}))
+}
+
+// objectEncryption is how a stored object's encryption is recorded on its
+// video, nil when the store applied none.
+func objectEncryption(object storage.ObjectInfo) *string {
+	if object.Encryption == "" {
+		return nil
+	}
+	return &object.Encryption
+}
+
+// downloadObject copies an object to a temp file in the work dir, for tools
+// that can't read it from storage themselves. The caller removes the file.
+func (cfg *apiConfig) downloadObject(ctx context.Context, key string) (string, error) {
+	object, err := cfg.store.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer object.Close()
+
+	file, err := os.CreateTemp(cfg.workDir, "tubely-download-*")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	_, err = copyWithContext(ctx, file, object)
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// copyObject copies a stored object to dstKey, inside the store where it
+// supports that and through the server otherwise.
+func (cfg *apiConfig) copyObject(ctx context.Context, srcKey, dstKey string, opts storage.PutOptions) (storage.ObjectInfo, error) {
+	opts = cfg.objectPutOptions(dstKey, opts)
+	if copier, ok := cfg.store.(storage.Copier); ok {
+		return copier.Copy(ctx, srcKey, dstKey, opts)
+	}
+	info, err := cfg.store.Head(ctx, srcKey)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	body, err := cfg.store.Get(ctx, srcKey)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	defer body.Close()
+	opts.Size = info.Size
+	return cfg.store.Put(ctx, dstKey, body, opts)
+}
+
+// putObjectBytes stores a small in-memory object.
+func (cfg *apiConfig) putObjectBytes(ctx context.Context, key, contentType string, tags map[string]string, data []byte) error {
+	_, err := cfg.store.Put(ctx, key, bytes.NewReader(data), cfg.objectPutOptions(key, storage.PutOptions{
+		ContentType: contentType,
+		Tags:        tags,
+		Size:        int64(len(data)),
+	}))
+	return err
+}
+
+// videoObjectTags are the S3 tags on everything stored for a video, so
+// bucket lifecycle rules can pick objects out by owner, video or visibility.
+func videoObjectTags(video database.Video) map[string]string {
+	visibility := video.Visibility
+	if visibility == "" {
+		visibility = database.VideoVisibilityPublic
+	}
+	return map[string]string{
+		"user_id":    video.UserID.String(),
+		"video_id":   video.ID.String(),
+		"visibility": visibility,
+	}
+}
+
+// retagVideoObjects brings the tags on everything stored for a video up to
+// date, such as after its visibility changes. Objects that have already gone
+// are skipped, and nothing happens on stores without tags.
+func (cfg *apiConfig) retagVideoObjects(ctx context.Context, video database.Video) error {
+	if cfg.objectTagger == nil {
+		return nil
+	}
+	keys, err := cfg.videoObjectKeys(ctx, video)
+	if err != nil {
+		return err
+	}
+	tags := videoObjectTags(video)
+	for _, key := range keys {
+		err := cfg.objectTagger.SetTags(ctx, key, tags)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("tagging %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// listObjectKeys returns every key under prefix.
+func (cfg *apiConfig) listObjectKeys(ctx context.Context, prefix string) ([]string, error) {
+	objects, err := cfg.store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(objects))
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	return keys, nil
+}
+
+// videoReferencedKeys lists the objects a video's row points at
+// directly. HLS segments and sprite sheets aren't recorded one by one;
+// they're everything under hlsPrefix and spritesPrefix.
+func (cfg *apiConfig) videoReferencedKeys(video database.Video) []string {
+	keys := []string{}
+	if video.PendingUploadKey != nil {
+		keys = append(keys, *video.PendingUploadKey)
+	}
+	if video.VideoURL != nil {
+		if key, ok := cfg.objectKeyFromURL(*video.VideoURL); ok {
+			keys = append(keys, key)
+		}
+	}
+	for _, thumbnailURL := range videoThumbnailURLs(video) {
+		if key, ok := cfg.objectKeyFromURL(thumbnailURL); ok {
+			keys = append(keys, key)
+		}
+	}
+	for _, renditionURL := range video.Renditions {
+		if key, ok := cfg.objectKeyFromURL(renditionURL); ok {
+			keys = append(keys, key)
+		}
+	}
+	for _, captionsURL := range video.CaptionsURL {
+		if key, ok := cfg.objectKeyFromURL(captionsURL); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// videoObjectKeys lists every object stored for a video, leaving out a
+// video file still shared with a deduplicated upload.
+func (cfg *apiConfig) videoObjectKeys(ctx context.Context, video database.Video) ([]string, error) {
+	keys, err := cfg.videoStoredKeys(ctx, video)
+	if err != nil {
+		return nil, err
+	}
+	if video.VideoURL != nil {
+		inUse, err := cfg.db.VideoURLInUse(*video.VideoURL, video.ID)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := cfg.objectKeyFromURL(*video.VideoURL); ok && inUse {
+			keys = slices.DeleteFunc(keys, func(k string) bool { return k == key })
+		}
+	}
+	return keys, nil
+}
+
+// videoStoredKeys lists every object stored for a video, including a video
+// file it shares with others.
+func (cfg *apiConfig) videoStoredKeys(ctx context.Context, video database.Video) ([]string, error) {
+	keys := cfg.videoReferencedKeys(video)
+	if video.HLSURL != nil {
+		hlsKeys, err := cfg.listObjectKeys(ctx, hlsPrefix(video))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, hlsKeys...)
+	}
+	if video.SpritesVTTURL != nil {
+		spriteKeys, err := cfg.listObjectKeys(ctx, spritesPrefix(video))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, spriteKeys...)
+	}
+	return keys, nil
+}
+
+// deleteObjects removes keys, in batches when the store supports it. It
+// returns the keys that couldn't be deleted, mapped to the reason.
+func (cfg *apiConfig) deleteObjects(ctx context.Context, keys []string) (map[string]error, error) {
+	if batch, ok := cfg.store.(storage.BatchDeleter); ok {
+		return batch.DeleteMany(ctx, keys)
+	}
+	failed := map[string]error{}
+	for _, key := range keys {
+		if err := cfg.store.Delete(ctx, key); err != nil {
+			failed[key] = err
+		}
+	}
+	return failed, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// scanTimeout bounds one malware scan, which can take a while on big videos.
+const scanTimeout = 10 * time.Minute
+
+// scanUpload runs an upload through the malware scanner, if one is set up.
+// Infected files are rejected with a 422 and recorded against the uploader.
+// It writes the response and returns false when the upload can't be
+// accepted, and whether that's because it's infected rather than because
+// the scan couldn't be done.
+func (cfg *apiConfig) scanUpload(w http.ResponseWriter, r *http.Request, video database.Video, body io.Reader) (clean, infected bool) {
+	if cfg.scanner == nil {
+		return true, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), scanTimeout)
+	defer cancel()
+	result, err := cfg.scanner.Scan(ctx, storage.NewContextReader(ctx, body))
+	if r.Context().Err() != nil {
+		return false, false
+	}
+	if err != nil {
+		// Fail closed so an unavailable scanner doesn't wave files through
+		respondWithError(w, http.StatusServiceUnavailable, "Couldn't scan upload, try again later", err)
+		return false, false
+	}
+	if !result.Infected {
+		return true, false
+	}
+
+	logger := loggerFromContext(r.Context())
+	logger.Warn("Rejected infected upload", "user_id", video.UserID, "video_id", video.ID, "signature", result.Signature)
+	_, err = cfg.db.CreateScanIncident(database.CreateScanIncidentParams{
+		UserID:    video.UserID,
+		VideoID:   video.ID,
+		Signature: result.Signature,
+	})
+	if err != nil {
+		logger.Error("Couldn't record scan incident", "video_id", video.ID, "error", err)
+	}
+	respondWithError(w, http.StatusUnprocessableEntity, "Upload was rejected by the malware scanner", nil)
+	return false, true
+}
+
+// scanUploadedFile is scanUpload for a file on disk.
+func (cfg *apiConfig) scanUploadedFile(w http.ResponseWriter, r *http.Request, video database.Video, filePath string) bool {
+	if cfg.scanner == nil {
+		return true
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read uploaded file", err)
+		return false
+	}
+	defer file.Close()
+	clean, _ := cfg.scanUpload(w, r, video, file)
+	return clean
+}
+
+func (cfg *apiConfig) handlerAdminScanIncidents(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidID, err)
+		return
+	}
+	incidents, err := cfg.db.GetScanIncidents(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get scan incidents", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, incidents)
+}
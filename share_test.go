@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// newSharedVideo creates a video with a file for owner, recording
+// moderationStatus unless it's empty, and returns it with a share token.
+func newSharedVideo(t *testing.T, cfg *apiConfig, owner *database.User, visibility, moderationStatus string) (database.Video, string) {
+	t.Helper()
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "t", Visibility: visibility, UserID: owner.ID}, database.VideoStatusReady)
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	videoURL := "https://cdn.example.com/landscape/a.mp4"
+	video.VideoURL = &videoURL
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatalf("UpdateVideo: %v", err)
+	}
+	if moderationStatus != "" {
+		if err := cfg.db.SetVideoModeration(video.ID, moderationStatus, database.Moderation{}); err != nil {
+			t.Fatalf("SetVideoModeration: %v", err)
+		}
+	}
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	return video, auth.MakeShareToken(video.ID, time.Now().Add(time.Hour), cfg.jwtSecret)
+}
+
+func serveShareLink(cfg *apiConfig, token, accessToken string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/share/"+token, nil)
+	req.SetPathValue("token", token)
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	rec := httptest.NewRecorder()
+	cfg.handlerShareLink(rec, req)
+	return rec
+}
+
+func TestShareLinkBlockedVideo(t *testing.T) {
+	cfg := newAuthzTestConfig(t)
+	owner, ownerToken := newTestUser(t, cfg, "owner@example.com", auth.RoleUser)
+	_, adminToken := newTestUser(t, cfg, "admin@example.com", auth.RoleAdmin)
+	_, otherToken := newTestUser(t, cfg, "other@example.com", auth.RoleUser)
+
+	clear, clearShare := newSharedVideo(t, cfg, owner, database.VideoVisibilityPrivate, database.ModerationStatusClear)
+	blocked, blockedShare := newSharedVideo(t, cfg, owner, database.VideoVisibilityPublic, database.ModerationStatusBlocked)
+
+	tests := []struct {
+		name        string
+		share       string
+		accessToken string
+		want        int
+	}{
+		{"clear video", clearShare, "", http.StatusFound},
+		{"blocked video", blockedShare, "", http.StatusNotFound},
+		{"blocked video for another user", blockedShare, otherToken, http.StatusNotFound},
+		{"blocked video for its owner", blockedShare, ownerToken, http.StatusFound},
+		{"blocked video for an admin", blockedShare, adminToken, http.StatusFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serveShareLink(cfg, tt.share, tt.accessToken); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	streams := []struct {
+		name  string
+		video database.Video
+		share string
+		want  bool
+	}{
+		{"clear video", clear, clearShare, true},
+		{"blocked video", blocked, blockedShare, false},
+	}
+	for _, tt := range streams {
+		req := httptest.NewRequest(http.MethodGet, "/api/videos/"+tt.video.ID.String()+"/stream?share="+tt.share, nil)
+		if got := cfg.canStreamVideo(req, tt.video); got != tt.want {
+			t.Errorf("%s: canStreamVideo with a share token = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCreateShareLinkRejectsBlockedVideo(t *testing.T) {
+	cfg := newAuthzTestConfig(t)
+	owner, _ := newTestUser(t, cfg, "owner@example.com", auth.RoleUser)
+	video, _ := newSharedVideo(t, cfg, owner, database.VideoVisibilityPublic, database.ModerationStatusBlocked)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/videos/"+video.ID.String()+"/share", nil)
+	req = req.WithContext(context.WithValue(req.Context(), authVideoKey{}, video))
+	rec := httptest.NewRecorder()
+	cfg.handlerCreateShareLink(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestShareLinkPrivateEncryptedVideo(t *testing.T) {
+	cfg := newAuthzTestConfig(t)
+	cfg.encryptVideos = true
+	owner, _ := newTestUser(t, cfg, "owner@example.com", auth.RoleUser)
+	video, share := newSharedVideo(t, cfg, owner, database.VideoVisibilityPrivate, "")
+
+	rec := serveShareLink(cfg, share, "")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusFound)
+	}
+	location := rec.Header().Get("Location")
+	target, err := url.Parse(location)
+	if err != nil {
+		t.Fatalf("parsing Location %q: %v", location, err)
+	}
+	if want := "/api/videos/" + video.ID.String() + "/stream"; target.Path != want {
+		t.Errorf("redirected to %q, want %q", target.Path, want)
+	}
+	if got := target.Query().Get("share"); got != share {
+		t.Errorf("share = %q, want the link's token", got)
+	}
+
+	// Whoever follows the redirect can stream the private video
+	if !cfg.canStreamVideo(httptest.NewRequest(http.MethodGet, location, nil), video) {
+		t.Error("canStreamVideo rejected the redirect target")
+	}
+}
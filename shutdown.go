@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultShutdownTimeout = 30 * time.Second
+	// shutdownGracePeriod is how long handlers get to clean up after their
+	// connections are forcibly closed
+	shutdownGracePeriod = 5 * time.Second
+)
+
+// inFlightMiddleware counts requests being handled, so shutdown can still
+// wait for their cleanup after it gives up on draining connections.
+func inFlightMiddleware(wg *sync.WaitGroup, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wg.Add(1)
+		defer wg.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveUntilSignalled serves srv until SIGINT or SIGTERM, then stops taking
+// new requests and gives in-flight uploads, transcodes and background jobs
+// up to timeout to finish before cleaning up their temporary files.
+func (cfg *apiConfig) serveUntilSignalled(srv *http.Server, inFlight *sync.WaitGroup, stopJobs context.CancelFunc, jobs *sync.WaitGroup, timeout time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	// A second signal kills the process right away
+	stop()
+	slog.Info("Shutting down", "timeout", timeout)
+
+	deadline, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := srv.Shutdown(deadline)
+	if err != nil {
+		slog.Warn("Timed out draining requests, closing connections", "error", err)
+		srv.Close()
+		if !waitTimeout(inFlight, shutdownGracePeriod) {
+			slog.Warn("Requests still running after connections closed")
+		}
+	}
+
+	if cfg.transcodeQueue != nil {
+		err := cfg.transcodeQueue.Close(deadline)
+		if err != nil {
+			slog.Warn("Cancelled unfinished transcode jobs", "error", err)
+		}
+	}
+
+	stopJobs()
+	if !waitTimeout(jobs, shutdownGracePeriod) {
+		slog.Warn("Background jobs still running")
+	}
+
+	// Last, since everything before it can queue jobs. Ones cut short run
+	// again after a restart.
+	err = cfg.jobQueue.Close(deadline)
+	if err != nil {
+		slog.Warn("Interrupted unfinished background jobs", "error", err)
+	}
+
+	err = os.RemoveAll(cfg.workDir)
+	if err != nil {
+		slog.Error("Couldn't remove temporary files", "dir", cfg.workDir, "error", err)
+	}
+
+	slog.Info("Shut down")
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// waitTimeout waits for wg, returning false if it takes longer than timeout.
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
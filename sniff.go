@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// sniffLen is how much of a file http.DetectContentType looks at.
+const sniffLen = 512
+
+var errContentMismatch = errors.New("file contents don't match the declared media type")
+
+// containerFormats maps video and audio media types to a name ffprobe lists
+// in format_name for that container.
+var containerFormats = map[string]string{
+	"video/mp4":  "mp4",
+	"video/webm": "webm",
+	"audio/mpeg": "mp3",
+	"audio/mp4":  "mp4",
+}
+
+// sniffFile detects the media type of a file from its first bytes.
+func sniffFile(filePath string) (string, error) {
+	header, err := readFileHeader(filePath)
+	if err != nil {
+		return "", err
+	}
+	return sniffedMediaType(header), nil
+}
+
+// readFileHeader returns up to the first sniffLen bytes of a file.
+func readFileHeader(filePath string) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// sniffedMediaType is http.DetectContentType without any parameters.
+func sniffedMediaType(data []byte) string {
+	mediaType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	return strings.TrimSpace(mediaType)
+}
+
+// sniffAudioFile is sniffFile for audio, which http.DetectContentType
+// mostly doesn't know. M4A files are MP4 containers, and only MP3s that
+// start with an ID3 tag are detected, not ones that start with a frame.
+func sniffAudioFile(filePath string) (string, error) {
+	header, err := readFileHeader(filePath)
+	if err != nil {
+		return "", err
+	}
+	switch mediaType := sniffedMediaType(header); {
+	case mediaType == "video/mp4":
+		return "audio/mp4", nil
+	case mediaType == "application/octet-stream" && len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0:
+		return "audio/mpeg", nil
+	default:
+		return mediaType, nil
+	}
+}
+
+// containerMatches reports whether ffprobe's format_name, a comma separated
+// list of demuxer names, covers the container of mediaType.
+func containerMatches(mediaType, formatName string) bool {
+	want, ok := containerFormats[mediaType]
+	if !ok {
+		return false
+	}
+	for _, name := range strings.Split(formatName, ",") {
+		if name == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestResolveStorageClass(t *testing.T) {
+	cfg := &apiConfig{s3StorageClass: types.StorageClassStandardIa}
+	tests := []struct {
+		requested string
+		want      types.StorageClass
+		wantErr   bool
+	}{
+		{"", types.StorageClassStandardIa, false},
+		{"STANDARD", types.StorageClassStandard, false},
+		{"intelligent_tiering", types.StorageClassIntelligentTiering, false},
+		{"GLACIER_BOGUS", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.requested, func(t *testing.T) {
+			got, err := cfg.resolveStorageClass(tt.requested)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("storage class = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadVideoRejectsInvalidStorageClass(t *testing.T) {
+	db, err := database.NewClient(database.Config{DSN: filepath.Join(t.TempDir(), "tubely.db")})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	allowed, err := parseAllowedMediaTypes(defaultAllowedMediaTypes)
+	if err != nil {
+		t.Fatalf("parseAllowedMediaTypes: %v", err)
+	}
+	cfg := &apiConfig{
+		db:                  db,
+		jwtSecret:           "secret",
+		maxVideoUploadBytes: defaultMaxVideoUploadBytes,
+		allowedMediaTypes:   allowed,
+		uploadLocks:         newKeyedLocker(),
+		uploadProgress:      newUploadProgressTracker(),
+	}
+
+	user, err := db.CreateUser(database.CreateUserParams{Email: "a@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	video, err := db.CreateVideo(database.CreateVideoParams{Title: "t", UserID: user.ID}, database.VideoStatusPending)
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	token, err := auth.MakeJWT(user.ID, auth.RoleUser, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("storage_class", "GLACIER_BOGUS")
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="video"; filename="a.mp4"`)
+	header.Set("Content-Type", "video/mp4")
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatalf("CreatePart: %v", err)
+	}
+	part.Write([]byte("not really a video"))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/video_upload/"+video.ID.String(), &body)
+	req.SetPathValue("videoID", video.ID.String())
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	cfg.handlerUploadVideo(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+	}
+	var resp errorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if resp.Error != "Invalid storage class" {
+		t.Errorf("error = %q, want %q", resp.Error, "Invalid storage class")
+	}
+}
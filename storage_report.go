@@ -0,0 +1,171 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// unattributedStorage is what the storage report files objects under when
+// no video refers to them, such as orphans the sweep hasn't reached yet.
+const unattributedStorage = "unattributed"
+
+// storageReportEntry is the number and size of the stored objects in one
+// group of a storage report.
+type storageReportEntry struct {
+	Key     string `json:"key"`
+	Email   string `json:"email,omitempty"`
+	Objects int    `json:"objects"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// storageReport breaks down everything under the managed prefixes by the
+// user whose videos it belongs to, by prefix and by storage class, largest
+// first.
+type storageReport struct {
+	Objects        int                  `json:"objects"`
+	Bytes          int64                `json:"bytes"`
+	ByUser         []storageReportEntry `json:"by_user"`
+	ByPrefix       []storageReportEntry `json:"by_prefix"`
+	ByStorageClass []storageReportEntry `json:"by_storage_class"`
+}
+
+// buildStorageReport lists the managed prefixes and works out who each
+// object belongs to from the videos referring to it.
+func (cfg *apiConfig) buildStorageReport(ctx context.Context) (storageReport, error) {
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return storageReport{}, err
+	}
+	owners := map[string]uuid.UUID{}
+	// HLS segments and sprite sheets belong to a video by directory
+	outputDirOwners := map[string]uuid.UUID{}
+	for _, video := range videos {
+		for _, key := range cfg.videoReferencedKeys(video) {
+			owners[key] = video.UserID
+		}
+		if video.HLSURL != nil {
+			outputDirOwners[hlsPrefix(video)] = video.UserID
+		}
+		if video.SpritesVTTURL != nil {
+			outputDirOwners[spritesPrefix(video)] = video.UserID
+		}
+	}
+
+	usage, err := cfg.db.GetStorageUsageByUser()
+	if err != nil {
+		return storageReport{}, err
+	}
+	emails := map[string]string{}
+	for _, u := range usage {
+		emails[u.UserID.String()] = u.Email
+	}
+
+	report := storageReport{}
+	byUser := map[string]*storageReportEntry{}
+	byPrefix := map[string]*storageReportEntry{}
+	byClass := map[string]*storageReportEntry{}
+	add := func(groups map[string]*storageReportEntry, key string, size int64) {
+		entry, ok := groups[key]
+		if !ok {
+			entry = &storageReportEntry{Key: key}
+			groups[key] = entry
+		}
+		entry.Objects++
+		entry.Bytes += size
+	}
+	for _, prefix := range managedPrefixes() {
+		objects, err := cfg.store.List(ctx, prefix)
+		if err != nil {
+			return storageReport{}, err
+		}
+		for _, object := range objects {
+			owner := unattributedStorage
+			if userID, ok := owners[object.Key]; ok {
+				owner = userID.String()
+			} else if userID, ok := outputDirOwners[outputDirOfKey(object.Key)]; ok {
+				owner = userID.String()
+			}
+			// Stores without storage classes keep everything alike
+			class := object.StorageClass
+			if class == "" {
+				class = "default"
+			}
+
+			report.Objects++
+			report.Bytes += object.Size
+			add(byUser, owner, object.Size)
+			add(byPrefix, prefix, object.Size)
+			add(byClass, class, object.Size)
+		}
+	}
+
+	report.ByUser = sortedReportEntries(byUser)
+	for i := range report.ByUser {
+		report.ByUser[i].Email = emails[report.ByUser[i].Key]
+	}
+	report.ByPrefix = sortedReportEntries(byPrefix)
+	report.ByStorageClass = sortedReportEntries(byClass)
+	return report, nil
+}
+
+func sortedReportEntries(groups map[string]*storageReportEntry) []storageReportEntry {
+	entries := make([]storageReportEntry, 0, len(groups))
+	for _, entry := range groups {
+		entries = append(entries, *entry)
+	}
+	slices.SortFunc(entries, func(a, b storageReportEntry) int {
+		return cmp.Or(cmp.Compare(b.Bytes, a.Bytes), cmp.Compare(a.Key, b.Key))
+	})
+	return entries
+}
+
+// handlerAdminStorageReport reports where the bucket's bytes are going, as
+// JSON or, with format=csv, as one CSV row per group. Unlike /admin/usage,
+// which adds up what uploads recorded, it counts what's actually stored,
+// renditions and thumbnails included. It's served behind
+// requireRole(auth.RoleAdmin).
+func (cfg *apiConfig) handlerAdminStorageReport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		respondWithError(w, http.StatusBadRequest, "format must be json or csv", nil)
+		return
+	}
+
+	report, err := cfg.buildStorageReport(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build storage report", err)
+		return
+	}
+	if format != "csv" {
+		respondWithJSON(w, http.StatusOK, report)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="storage-report.csv"`)
+	out := csv.NewWriter(w)
+	out.Write([]string{"breakdown", "key", "email", "objects", "bytes"})
+	for _, section := range []struct {
+		name    string
+		entries []storageReportEntry
+	}{
+		{"user", report.ByUser},
+		{"prefix", report.ByPrefix},
+		{"storage_class", report.ByStorageClass},
+	} {
+		for _, entry := range section.entries {
+			out.Write([]string{section.name, entry.Key, entry.Email, strconv.Itoa(entry.Objects), strconv.FormatInt(entry.Bytes, 10)})
+		}
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		// The header is already sent, so all that's left is to log it
+		loggerFromContext(r.Context()).Error("Couldn't write storage report", "error", err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	maxTagLength       = 50
+	maxTagsPerVideo    = 20
+	defaultTagsLimit   = 10
+	maxTagsLimit       = 100
+	maxTagFilterLength = 10
+)
+
+// normalizeTag lowercases a tag and collapses its whitespace, so "Cats" and
+// " cats" are the same tag. Commas separate tags in the listing filter, so
+// tags can't contain them.
+func normalizeTag(name string) (string, error) {
+	tag := strings.ToLower(strings.Join(strings.Fields(sanitizeVideoText(name, false)), " "))
+	switch {
+	case tag == "":
+		return "", fmt.Errorf("tags can't be empty")
+	case utf8.RuneCountInString(tag) > maxTagLength:
+		return "", fmt.Errorf("tags can be at most %d characters", maxTagLength)
+	case strings.Contains(tag, ","):
+		return "", fmt.Errorf("tags can't contain commas")
+	}
+	return tag, nil
+}
+
+// parseTagFilter reads the tag query parameter, a comma-separated list of
+// tags a video must all have. It responds itself and returns false if it's
+// malformed.
+func parseTagFilter(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, value := range r.URL.Query()["tag"] {
+		for _, name := range strings.Split(value, ",") {
+			tag, err := normalizeTag(name)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid tag", err)
+				return nil, false
+			}
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	if len(tags) > maxTagFilterLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("At most %d tags can be filtered by", maxTagFilterLength), nil)
+		return nil, false
+	}
+	return tags, true
+}
+
+// handlerVideoTagsAdd tags a video with every tag in the body, keeping the
+// ones it already has. It's served behind requireOwnerOrAdmin.
+func (cfg *apiConfig) handlerVideoTagsAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Tags []string `json:"tags"`
+	}
+
+	video := authVideoFromContext(r.Context())
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.Tags) == 0 {
+		respondWithError(w, http.StatusBadRequest, "No tags to add", nil)
+		return
+	}
+
+	all := map[string]bool{}
+	for _, tag := range video.Tags {
+		all[tag] = true
+	}
+	tags := []string{}
+	for _, name := range params.Tags {
+		tag, err := normalizeTag(name)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid tag", err)
+			return
+		}
+		if !all[tag] {
+			all[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	if len(all) > maxTagsPerVideo {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Videos can have at most %d tags", maxTagsPerVideo), nil)
+		return
+	}
+
+	if len(tags) > 0 {
+		err = cfg.db.AddVideoTags(video.ID, tags)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't add tags", err)
+			return
+		}
+	}
+	cfg.respondWithTaggedVideo(w, r, video)
+}
+
+// handlerVideoTagDelete takes one tag off a video. It's served behind
+// requireOwnerOrAdmin.
+func (cfg *apiConfig) handlerVideoTagDelete(w http.ResponseWriter, r *http.Request) {
+	video := authVideoFromContext(r.Context())
+	tag, err := normalizeTag(r.PathValue("tag"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid tag", err)
+		return
+	}
+	removed, err := cfg.db.RemoveVideoTag(video.ID, tag)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove tag", err)
+		return
+	}
+	if !removed {
+		respondWithError(w, http.StatusNotFound, "Video doesn't have that tag", nil)
+		return
+	}
+	cfg.respondWithTaggedVideo(w, r, video)
+}
+
+// respondWithTaggedVideo responds with video as it is now its tags have
+// changed.
+func (cfg *apiConfig) respondWithTaggedVideo(w http.ResponseWriter, r *http.Request, video database.Video) {
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get pre-signed video link", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerTagsList suggests tags starting with q, most used first, for
+// autocomplete. Usage only counts videos the caller can see, so tags on
+// other users' private videos aren't given away.
+func (cfg *apiConfig) handlerTagsList(w http.ResponseWriter, r *http.Request) {
+	user := authUserFromContext(r.Context())
+	prefix := strings.ToLower(strings.Join(strings.Fields(r.URL.Query().Get("q")), " "))
+	if utf8.RuneCountInString(prefix) > maxTagLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("q can be at most %d characters", maxTagLength), nil)
+		return
+	}
+	limit := defaultTagsLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxTagsLimit {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxTagsLimit), err)
+			return
+		}
+	}
+
+	viewerID := &user.ID
+	if user.Role == auth.RoleAdmin {
+		viewerID = nil
+	}
+	tags, err := cfg.db.SearchTags(prefix, viewerID, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get tags", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, tags)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMinTempFreeBytes = 1 << 30
+	defaultTempFileMaxAge   = 24 * time.Hour
+	tempSweepInterval       = 10 * time.Minute
+	// Running servers touch their work directory every sweep, so one that
+	// hasn't been for this long belongs to a server that's gone
+	staleWorkDirAge = 3 * tempSweepInterval
+	workDirPrefix   = "tubely-work-"
+)
+
+// strayTempPrefixes are files earlier versions left directly in TEMP_DIR
+// rather than in a work directory. Resumable and chunked uploads are
+// expired with their sessions instead.
+var strayTempPrefixes = []string{"tubely-upload", "tubely-import", "tubely-probe", "tubely-check"}
+
+var errTempSpaceLow = errors.New("not enough free space in TEMP_DIR")
+
+var errInsufficientTempSpace = &apiError{http.StatusInsufficientStorage, codeInsufficientStorage, "The server is low on disk space, try again later"}
+
+// tempSpaceGuard keeps uploads from filling the disk TEMP_DIR is on. Free
+// space is checked as each upload starts, so what the ones already running
+// may still write is reserved until they finish.
+type tempSpaceGuard struct {
+	dir      string
+	minFree  int64
+	mu       sync.Mutex
+	reserved int64
+}
+
+// reserve sets size bytes aside, failing with errTempSpaceLow if that would
+// leave less than minFree. The returned available is what could have been
+// reserved. With no minimum, or where free space can't be measured, there's
+// nothing to check.
+func (g *tempSpaceGuard) reserve(size int64) (release func(), available int64, err error) {
+	if g.minFree == 0 {
+		return func() {}, 0, nil
+	}
+	free, err := freeDiskSpace(g.dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return func() {}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	available = free - g.reserved - g.minFree
+	if size > available {
+		return nil, available, errTempSpaceLow
+	}
+	g.reserved += size
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			g.reserved -= size
+			g.mu.Unlock()
+		})
+	}, available, nil
+}
+
+// reserveTempSpace makes sure there's room in TEMP_DIR for size bytes,
+// responding 507 and returning false if there isn't. The caller must call
+// release once it's done with them.
+func (cfg *apiConfig) reserveTempSpace(w http.ResponseWriter, size int64) (release func(), ok bool) {
+	release, available, err := cfg.tempSpace.reserve(size)
+	if errors.Is(err, errTempSpaceLow) {
+		respondWithAPIError(w, errInsufficientTempSpace, fmt.Errorf("%w: needed %d bytes, %d available", err, size, max(available, 0)))
+		return nil, false
+	}
+	// The guard shouldn't be what takes uploads down
+	if err != nil {
+		slog.Warn("Couldn't check free space in TEMP_DIR", "dir", cfg.tempDir, "error", err)
+		return func() {}, true
+	}
+	return release, true
+}
+
+// expectedUploadSize is how much of TEMP_DIR an upload in r's body may
+// take, which is the most allowed if the client didn't say.
+func (cfg *apiConfig) expectedUploadSize(r *http.Request) int64 {
+	if r.ContentLength > 0 {
+		return min(r.ContentLength, cfg.maxVideoUploadBytes)
+	}
+	return cfg.maxVideoUploadBytes
+}
+
+// runTempSweeper removes temp files left behind by crashes and handlers
+// that didn't clean up, checking every tempSweepInterval until ctx is
+// cancelled. sweepTempFiles should already have run once at startup.
+func (cfg *apiConfig) runTempSweeper(ctx context.Context, maxAge time.Duration) {
+	ticker := time.NewTicker(tempSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cfg.sweepTempFiles(maxAge)
+	}
+}
+
+// sweepTempFiles removes the work directories of servers that are no
+// longer running, stray files in TEMP_DIR and anything in this server's
+// work directory older than maxAge, logging what it removed.
+func (cfg *apiConfig) sweepTempFiles(maxAge time.Duration) {
+	now := time.Now()
+	if err := os.Chtimes(cfg.workDir, now, now); err != nil {
+		slog.Error("Couldn't touch work directory", "dir", cfg.workDir, "error", err)
+	}
+
+	entries, err := os.ReadDir(cfg.tempDir)
+	if err != nil {
+		slog.Error("Temp file sweep failed", "dir", cfg.tempDir, "error", err)
+		return
+	}
+	removed := 0
+	for _, entry := range entries {
+		path := filepath.Join(cfg.tempDir, entry.Name())
+		switch {
+		case path == cfg.workDir:
+			continue
+		case entry.IsDir() && strings.HasPrefix(entry.Name(), workDirPrefix):
+			if removeIfOlder(path, now.Add(-staleWorkDirAge)) {
+				removed++
+			}
+		case !entry.IsDir() && hasAnyPrefix(entry.Name(), strayTempPrefixes):
+			if removeIfOlder(path, now.Add(-maxAge)) {
+				removed++
+			}
+		}
+	}
+
+	entries, err = os.ReadDir(cfg.workDir)
+	if err != nil {
+		slog.Error("Temp file sweep failed", "dir", cfg.workDir, "error", err)
+		return
+	}
+	for _, entry := range entries {
+		if removeIfOlder(filepath.Join(cfg.workDir, entry.Name()), now.Add(-maxAge)) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		slog.Info("Temp file sweep removed stale files", "count", removed)
+	}
+}
+
+// removeIfOlder removes path, and everything in it if it's a directory,
+// if it was last modified before cutoff, reporting whether it did.
+func removeIfOlder(path string, cutoff time.Time) bool {
+	info, err := os.Lstat(path)
+	if err != nil || !info.ModTime().Before(cutoff) {
+		return false
+	}
+	if err := os.RemoveAll(path); err != nil {
+		slog.Error("Couldn't remove stale temp file", "path", path, "error", err)
+		return false
+	}
+	return true
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
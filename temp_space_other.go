@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// freeDiskSpace isn't implemented here, so TEMP_DIR_MIN_FREE_MB has no
+// effect.
+func freeDiskSpace(dir string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
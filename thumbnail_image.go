@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Thumbnails are stored at two fixed 16:9 sizes, cropped from the middle of
+// whatever was uploaded.
+const (
+	thumbnailWidth       = 1280
+	thumbnailHeight      = 720
+	smallThumbnailWidth  = 320
+	smallThumbnailHeight = 180
+	thumbnailJPEGQuality = 85
+
+	// Uploads smaller than this would be blown up more than 2x
+	minThumbnailWidth  = 640
+	minThumbnailHeight = 360
+	// maxThumbnailPixels stops a small file that decodes to a huge image
+	// from exhausting memory
+	maxThumbnailPixels = 50_000_000
+)
+
+var errThumbnailTooSmall = fmt.Errorf("thumbnails must be at least %dx%d", minThumbnailWidth, minThumbnailHeight)
+
+// decodeThumbnail decodes a JPEG, PNG or WebP image, turned upright if it's
+// a JPEG whose EXIF data says it was taken rotated.
+func decodeThumbnail(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxThumbnailPixels {
+		return nil, fmt.Errorf("image is %dx%d, which is too large to process", config.Width, config.Height)
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if format == "jpeg" {
+		img = applyEXIFOrientation(img, exifOrientation(jpegEXIF(data)))
+	}
+	return img, nil
+}
+
+// checkThumbnailSize returns errThumbnailTooSmall for images that can't fill
+// a thumbnail without being blown up too far.
+func checkThumbnailSize(img image.Image) error {
+	size := img.Bounds().Size()
+	if size.X < minThumbnailWidth || size.Y < minThumbnailHeight {
+		return fmt.Errorf("%w, got %dx%d", errThumbnailTooSmall, size.X, size.Y)
+	}
+	return nil
+}
+
+// encodeThumbnails renders img at both thumbnail sizes as JPEGs. Encoding
+// from pixels leaves behind any EXIF or other metadata the upload had, so
+// exif, from uprightEXIF, is written into both to keep it. It can be nil.
+func encodeThumbnails(img image.Image, exif []byte) ([]byte, []byte, error) {
+	large := resizeThumbnail(img, thumbnailWidth, thumbnailHeight)
+	small := resizeThumbnail(large, smallThumbnailWidth, smallThumbnailHeight)
+
+	var largeJPEG, smallJPEG bytes.Buffer
+	err := jpeg.Encode(&largeJPEG, large, &jpeg.Options{Quality: thumbnailJPEGQuality})
+	if err != nil {
+		return nil, nil, err
+	}
+	err = jpeg.Encode(&smallJPEG, small, &jpeg.Options{Quality: thumbnailJPEGQuality})
+	if err != nil {
+		return nil, nil, err
+	}
+	return withEXIF(largeJPEG.Bytes(), exif), withEXIF(smallJPEG.Bytes(), exif), nil
+}
+
+// resizeThumbnail crops the middle of img to the aspect ratio of width x
+// height and scales it to exactly that size. Transparency is flattened onto
+// white, since JPEGs have none.
+func resizeThumbnail(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	crop := bounds
+	if bounds.Dx()*height > bounds.Dy()*width {
+		cropWidth := bounds.Dy() * width / height
+		crop.Min.X += (bounds.Dx() - cropWidth) / 2
+		crop.Max.X = crop.Min.X + cropWidth
+	} else {
+		cropHeight := bounds.Dx() * height / width
+		crop.Min.Y += (bounds.Dy() - cropHeight) / 2
+		crop.Max.Y = crop.Min.Y + cropHeight
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Over, nil)
+	return dst
+}
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// jpegEXIF returns the EXIF data of a JPEG, without its header, or nil if
+// it has none or the data can't be read.
+func jpegEXIF(data []byte) []byte {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil
+		}
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		// Metadata segments all come before the start of scan
+		if marker == 0xDA || length < 2 || i+2+length > len(data) {
+			return nil
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, exifHeader) {
+			return segment[len(exifHeader):]
+		}
+		i += 2 + length
+	}
+	return nil
+}
+
+// exifOrientationTag finds the orientation tag in the first IFD of EXIF
+// data, returning the byte order and the offset of its value.
+func exifOrientationTag(tiff []byte) (binary.ByteOrder, int, bool) {
+	if len(tiff) < 8 {
+		return nil, 0, false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, false
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return nil, 0, false
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return nil, 0, false
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return order, entry + 8, true
+		}
+	}
+	return nil, 0, false
+}
+
+// exifOrientation reads the orientation from EXIF data, from 1 (upright) to
+// 8, or 1 if it has none.
+func exifOrientation(tiff []byte) int {
+	order, offset, ok := exifOrientationTag(tiff)
+	if !ok {
+		return 1
+	}
+	orientation := int(order.Uint16(tiff[offset:]))
+	if orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// uprightEXIF returns the EXIF data of a JPEG upload to carry over to its
+// thumbnails, with the orientation reset since decodeThumbnail has already
+// applied it. PNG and WebP metadata isn't kept.
+func uprightEXIF(data []byte) []byte {
+	tiff := jpegEXIF(data)
+	if tiff == nil {
+		return nil
+	}
+	tiff = bytes.Clone(tiff)
+	if order, offset, ok := exifOrientationTag(tiff); ok {
+		order.PutUint16(tiff[offset:], 1)
+	}
+	return tiff
+}
+
+// withEXIF inserts exif into a JPEG as an APP1 segment straight after the
+// start of image marker.
+func withEXIF(jpegData, exif []byte) []byte {
+	if exif == nil {
+		return jpegData
+	}
+	out := make([]byte, 0, len(jpegData)+4+len(exifHeader)+len(exif))
+	out = append(out, jpegData[:2]...)
+	out = append(out, 0xFF, 0xE1)
+	// exif came out of a segment, so it still fits in one
+	out = binary.BigEndian.AppendUint16(out, uint16(2+len(exifHeader)+len(exif)))
+	out = append(out, exifHeader...)
+	out = append(out, exif...)
+	return append(out, jpegData[2:]...)
+}
+
+// applyEXIFOrientation flips and rotates img so that an image with the given
+// EXIF orientation displays upright without it.
+func applyEXIFOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	src := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	// Orientations 5 to 8 swap the axes
+	dstWidth, dstHeight := w, h
+	if orientation >= 5 {
+		dstWidth, dstHeight = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		for x := 0; x < dstWidth; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.SetRGBA(x, y, src.RGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	thumbnailKeyPrefix = "thumbnails/"
+
+	// Generated thumbnails come from this far into the video, past most
+	// fade-ins and title cards
+	autoThumbnailPosition = 0.1
+	thumbnailMaxWidth     = 1280
+)
+
+// extractFrameJPEG grabs the frame at the given offset, in seconds, as a JPEG
+// no wider than thumbnailMaxWidth. input can be a path or a URL ffmpeg can
+// read with range requests.
+func extractFrameJPEG(ctx context.Context, input string, at float64) ([]byte, error) {
+	var out bytes.Buffer
+	err := runMediaCommand(ctx, "ffmpeg_thumbnail", ffmpegTimeout, &out, "ffmpeg", "-v", "error",
+		"-ss", strconv.FormatFloat(at, 'f', 3, 64), "-i", input,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':-2", thumbnailMaxWidth),
+		"-q:v", "3", "-f", "image2pipe", "-c:v", "mjpeg", "pipe:1")
+	if err != nil {
+		return nil, fmt.Errorf("extracting frame: %w", err)
+	}
+	if out.Len() == 0 {
+		return nil, fmt.Errorf("no frame at %vs", at)
+	}
+	return out.Bytes(), nil
+}
+
+// storeThumbnail saves thumbnail data under a content-versioned name, in S3
+// when THUMBNAIL_STORAGE is "s3" and in the local assets dir otherwise, and
+// returns the URL to record on the video.
+func (cfg *apiConfig) storeThumbnail(ctx context.Context, video database.Video, mediaType string, data []byte) (string, error) {
+	fileExtension := strings.Split(mediaType, "/")[1]
+	assetName := getVersionedAssetName(video.ID, data, fileExtension)
+
+	if cfg.thumbnailsInS3 {
+		key := thumbnailKeyPrefix + assetName
+		err := cfg.putObjectBytes(ctx, key, mediaType, videoObjectTags(video), data)
+		if err != nil {
+			return "", err
+		}
+		return cfg.getObjectURL(key), nil
+	}
+
+	err := cfg.writeAsset(assetName, mediaType, data)
+	if err != nil {
+		return "", err
+	}
+	return cfg.getAssetURL(assetName), nil
+}
+
+// setThumbnail stores img at the standard thumbnail sizes, with exif if it
+// isn't nil, and points video at them. It returns the URLs of the thumbnails
+// being replaced, to delete with deleteThumbnails once the video is saved.
+func (cfg *apiConfig) setThumbnail(ctx context.Context, video *database.Video, img image.Image, exif []byte) ([]string, error) {
+	large, small, err := encodeThumbnails(img, exif)
+	if err != nil {
+		return nil, err
+	}
+	largeURL, err := cfg.storeThumbnail(ctx, *video, "image/jpeg", large)
+	if err != nil {
+		return nil, err
+	}
+	smallURL, err := cfg.storeThumbnail(ctx, *video, "image/jpeg", small)
+	if err != nil {
+		if err := cfg.deleteThumbnail(ctx, largeURL); err != nil {
+			loggerFromContext(ctx).Error("Couldn't delete unused thumbnail", "video_id", video.ID, "url", largeURL, "error", err)
+		}
+		return nil, err
+	}
+
+	// Unchanged images get the same versioned names, which mustn't be
+	// deleted
+	replaced := []string{}
+	for _, old := range []*string{video.ThumbnailURL, video.ThumbnailSmallURL} {
+		if old != nil && *old != largeURL && *old != smallURL {
+			replaced = append(replaced, *old)
+		}
+	}
+	video.ThumbnailURL = &largeURL
+	video.ThumbnailSmallURL = &smallURL
+	return replaced, nil
+}
+
+// videoThumbnailURLs returns the URLs of every size of a video's thumbnail
+// and its animated preview, which is stored the same way.
+func videoThumbnailURLs(video database.Video) []string {
+	urls := []string{}
+	for _, thumbnailURL := range []*string{video.ThumbnailURL, video.ThumbnailSmallURL, video.PreviewURL} {
+		if thumbnailURL != nil {
+			urls = append(urls, *thumbnailURL)
+		}
+	}
+	return urls
+}
+
+// deleteThumbnails removes replaced thumbnails, logging any that can't be.
+func (cfg *apiConfig) deleteThumbnails(ctx context.Context, videoID uuid.UUID, thumbnailURLs []string) {
+	for _, thumbnailURL := range thumbnailURLs {
+		err := cfg.deleteThumbnail(ctx, thumbnailURL)
+		if err != nil {
+			loggerFromContext(ctx).Error("Couldn't delete old thumbnail", "video_id", videoID, "url", thumbnailURL, "error", err)
+		}
+	}
+}
+
+// deleteThumbnail removes a thumbnail wherever it was stored.
+func (cfg *apiConfig) deleteThumbnail(ctx context.Context, thumbnailURL string) error {
+	if key, ok := cfg.objectKeyFromURL(thumbnailURL); ok {
+		return cfg.store.Delete(ctx, key)
+	}
+	return cfg.deleteAssetByURL(thumbnailURL)
+}
+
+// handlerMigrateThumbnails moves thumbnails still on local disk into S3.
+// It's safe to run repeatedly; videos that fail are left untouched and
+// reported so the migration can be retried. It's served behind
+// requireRole(auth.RoleAdmin).
+func (cfg *apiConfig) handlerMigrateThumbnails(w http.ResponseWriter, r *http.Request) {
+	if !cfg.thumbnailsInS3 {
+		respondWithError(w, http.StatusConflict, "Set THUMBNAIL_STORAGE=s3 before migrating thumbnails", nil)
+		return
+	}
+
+	videos, err := cfg.db.GetVideosByThumbnailPrefix(cfg.getAssetURLPrefix())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find local thumbnails", err)
+		return
+	}
+
+	type response struct {
+		Migrated int               `json:"migrated"`
+		Failed   map[string]string `json:"failed"`
+	}
+	resp := response{Failed: map[string]string{}}
+	logger := loggerFromContext(r.Context())
+	for _, video := range videos {
+		// Everything moves together, so the video never points at a mix
+		localURLs := []string{}
+		failed := ""
+		for _, thumbnailURL := range []*string{video.ThumbnailURL, video.ThumbnailSmallURL, video.PreviewURL} {
+			if thumbnailURL == nil || !strings.HasPrefix(*thumbnailURL, cfg.getAssetURLPrefix()) {
+				continue
+			}
+			assetName := path.Base(*thumbnailURL)
+			data, err := os.ReadFile(cfg.getAssetDiskPath(assetName))
+			if err != nil {
+				logger.Error("Couldn't read thumbnail", "video_id", video.ID, "file", assetName, "error", err)
+				failed = "couldn't read local thumbnail"
+				break
+			}
+
+			mediaType := mime.TypeByExtension(path.Ext(assetName))
+			key := thumbnailKeyPrefix + assetName
+			err = cfg.putObjectBytes(r.Context(), key, mediaType, videoObjectTags(video), data)
+			if err != nil {
+				logger.Error("Couldn't upload thumbnail", "video_id", video.ID, "file", assetName, "error", err)
+				failed = "couldn't upload to S3"
+				break
+			}
+			localURLs = append(localURLs, *thumbnailURL)
+			*thumbnailURL = cfg.getObjectURL(key)
+		}
+		if failed != "" {
+			resp.Failed[video.ID.String()] = failed
+			continue
+		}
+
+		err = cfg.db.UpdateVideo(video)
+		if err != nil {
+			logger.Error("Couldn't update video", "video_id", video.ID, "error", err)
+			resp.Failed[video.ID.String()] = "couldn't update video"
+			continue
+		}
+		resp.Migrated++
+
+		for _, localURL := range localURLs {
+			err = cfg.deleteAssetByURL(localURL)
+			if err != nil {
+				logger.Warn("Couldn't delete migrated thumbnail", "video_id", video.ID, "url", localURL, "error", err)
+			}
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+)
+
+// enqueueTranscode hands a processed upload to the transcode workers. The
+// file is moved rather than copied, so the caller's cleanup of filePath
+// becomes a no-op once the job is accepted.
+func (cfg *apiConfig) enqueueTranscode(video database.Video, key, filePath string) error {
+	p, err := cfg.userPlan(video.UserID)
+	if err != nil {
+		return err
+	}
+	jobPath := filePath + ".transcode"
+	err = os.Rename(filePath, jobPath)
+	if err != nil {
+		return err
+	}
+
+	job := transcode.Job{
+		VideoID:       video.ID,
+		SourceKey:     key,
+		SourcePath:    jobPath,
+		MaxRenditions: p.MaxRenditions,
+	}
+	if video.MediaInfo != nil {
+		job.SourceWidth = video.MediaInfo.Width
+		job.SourceHeight = video.MediaInfo.Height
+		job.SourceDuration = video.MediaInfo.Duration
+	}
+	err = cfg.transcodeQueue.Enqueue(job)
+	if err != nil {
+		os.Remove(jobPath)
+		return err
+	}
+	return nil
+}
+
+// renditionKey is where a video's rendition is stored.
+func renditionKey(video database.Video, rendition string) string {
+	return fmt.Sprintf("renditions/%s/%s.mp4", video.ID, rendition)
+}
+
+// hlsPrefix is the S3 directory holding a video's playlists and segments.
+func hlsPrefix(video database.Video) string {
+	return fmt.Sprintf("hls/%s/", video.ID)
+}
+
+// spritesPrefix is the S3 directory holding a video's sprite sheets and the
+// WebVTT file that maps them onto the timeline.
+func spritesPrefix(video database.Video) string {
+	return fmt.Sprintf("sprites/%s/", video.ID)
+}
+
+// handleTranscodeResult uploads finished renditions, HLS segments and sprite
+// sheets and records them on the video, as long as it still points at the file that was
+// transcoded.
+func (cfg *apiConfig) handleTranscodeResult(ctx context.Context, job transcode.Job, result transcode.Result, err error) {
+	commandDurationSeconds.Observe(result.Elapsed.Seconds(), "ffmpeg_transcode")
+	defer cfg.finishTranscodeStatus(ctx, job)
+	if err != nil {
+		slog.Error("Transcoding failed", "video_id", job.VideoID, "error", err)
+		return
+	}
+	// Outputs are tagged like the rest of the video's objects
+	video, err := cfg.db.GetVideoWithDeleted(job.VideoID)
+	if err != nil {
+		slog.Error("Couldn't load video to store renditions", "video_id", job.VideoID, "error", err)
+		return
+	}
+	tags := videoObjectTags(video)
+
+	keys := []string{}
+	renditions := database.URLMap{}
+	for _, output := range result.Renditions {
+		key := renditionKey(video, output.Rendition.Name)
+		_, err := cfg.putFile(ctx, key, "video/mp4", cfg.s3StorageClass, tags, output.Path)
+		if err != nil {
+			slog.Error("Couldn't upload rendition", "video_id", job.VideoID, "rendition", output.Rendition.Name, "error", err)
+			cfg.deleteOrphanedOutputs(ctx, keys)
+			return
+		}
+		keys = append(keys, key)
+		renditions[output.Rendition.Name] = cfg.getObjectURL(key)
+	}
+
+	var hlsURL *string
+	if result.HLSDir != "" {
+		prefix := hlsPrefix(database.Video{ID: job.VideoID})
+		hlsKeys, err := cfg.uploadOutputDir(ctx, prefix, result.HLSDir, tags)
+		keys = append(keys, hlsKeys...)
+		if err != nil {
+			slog.Error("Couldn't upload HLS playlists", "video_id", job.VideoID, "error", err)
+			cfg.deleteOrphanedOutputs(ctx, keys)
+			return
+		}
+		masterURL := cfg.getObjectURL(prefix + transcode.HLSMasterPlaylist)
+		hlsURL = &masterURL
+	}
+
+	var spritesVTTURL *string
+	if result.SpritesDir != "" {
+		prefix := spritesPrefix(database.Video{ID: job.VideoID})
+		spriteKeys, err := cfg.uploadOutputDir(ctx, prefix, result.SpritesDir, tags)
+		keys = append(keys, spriteKeys...)
+		if err != nil {
+			slog.Error("Couldn't upload sprite sheets", "video_id", job.VideoID, "error", err)
+			cfg.deleteOrphanedOutputs(ctx, keys)
+			return
+		}
+		vttURL := cfg.getObjectURL(prefix + transcode.SpritesVTT)
+		spritesVTTURL = &vttURL
+	}
+
+	// Videos in the trash keep their renditions in case they're restored
+	video, err = cfg.db.GetVideoWithDeleted(job.VideoID)
+	if err != nil {
+		slog.Error("Couldn't load video after transcoding", "video_id", job.VideoID, "error", err)
+		cfg.deleteOrphanedOutputs(ctx, keys)
+		return
+	}
+	// The video was deleted or re-uploaded while we were busy
+	if video.VideoURL == nil || *video.VideoURL != cfg.getObjectURL(job.SourceKey) {
+		cfg.deleteOrphanedOutputs(ctx, keys)
+		return
+	}
+
+	err = cfg.db.SetTranscodedOutputs(job.VideoID, renditions, hlsURL, spritesVTTURL)
+	if err != nil {
+		slog.Error("Couldn't record renditions", "video_id", job.VideoID, "error", err)
+		cfg.deleteOrphanedOutputs(ctx, keys)
+		return
+	}
+	video.Renditions = renditions
+	video.HLSURL = hlsURL
+	video.SpritesVTTURL = spritesVTTURL
+	// Captions may have been added before or during the transcode
+	if len(video.CaptionsURL) > 0 {
+		if err := cfg.updateHLSSubtitles(ctx, video); err != nil {
+			slog.Error("Couldn't add captions to HLS playlist", "video_id", job.VideoID, "error", err)
+		}
+	}
+	cfg.notifyWebhooks(ctx, video.UserID, eventVideoTranscoded, video)
+}
+
+// finishTranscodeStatus marks a video ready once its transcode is over.
+// That's so even if transcoding failed, since the original still plays.
+// Videos re-uploaded in the meantime are left alone.
+func (cfg *apiConfig) finishTranscodeStatus(ctx context.Context, job transcode.Job) {
+	video, err := cfg.db.GetVideoWithDeleted(job.VideoID)
+	if err != nil {
+		return
+	}
+	if video.Status != database.VideoStatusTranscoding || video.VideoURL == nil || *video.VideoURL != cfg.getObjectURL(job.SourceKey) {
+		return
+	}
+	cfg.setVideoStatus(ctx, job.VideoID, database.VideoStatusReady)
+}
+
+// uploadOutputDir uploads every file in dir under prefix, such as HLS
+// playlists and segments, returning the keys written so far even on failure.
+func (cfg *apiConfig) uploadOutputDir(ctx context.Context, prefix, dir string, tags map[string]string) ([]string, error) {
+	keys := []string{}
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		contentType := outputContentTypes[filepath.Ext(path)]
+		if contentType == "" {
+			return fmt.Errorf("unexpected output file %s", rel)
+		}
+
+		key := prefix + filepath.ToSlash(rel)
+		_, err = cfg.putFile(ctx, key, contentType, cfg.s3StorageClass, tags, path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	return keys, err
+}
+
+// deleteTranscodedOutputs removes the renditions, HLS segments and sprite
+// sheets made from a video file that is being replaced, and clears them from
+// the video.
+func (cfg *apiConfig) deleteTranscodedOutputs(r *http.Request, video *database.Video) {
+	for _, renditionURL := range video.Renditions {
+		if oldKey, ok := cfg.objectKeyFromURL(renditionURL); ok {
+			if err := cfg.store.Delete(r.Context(), oldKey); err != nil {
+				loggerFromContext(r.Context()).Error("Couldn't delete previous rendition", "video_id", video.ID, "key", oldKey, "error", err)
+			}
+		}
+	}
+	video.Renditions = nil
+	if video.HLSURL != nil {
+		hlsKeys, err := cfg.listObjectKeys(r.Context(), hlsPrefix(*video))
+		if err != nil {
+			loggerFromContext(r.Context()).Error("Couldn't list previous HLS segments", "video_id", video.ID, "error", err)
+		}
+		cfg.deleteOrphanedOutputs(r.Context(), hlsKeys)
+		video.HLSURL = nil
+	}
+	if video.SpritesVTTURL != nil {
+		spriteKeys, err := cfg.listObjectKeys(r.Context(), spritesPrefix(*video))
+		if err != nil {
+			loggerFromContext(r.Context()).Error("Couldn't list previous sprite sheets", "video_id", video.ID, "error", err)
+		}
+		cfg.deleteOrphanedOutputs(r.Context(), spriteKeys)
+		video.SpritesVTTURL = nil
+	}
+}
+
+// updateHLSSubtitles points a video's HLS master playlist at its current
+// caption tracks, writing a subtitle playlist for each and removing those
+// of tracks it no longer has. Videos without HLS are left alone.
+func (cfg *apiConfig) updateHLSSubtitles(ctx context.Context, video database.Video) error {
+	if video.HLSURL == nil {
+		return nil
+	}
+	masterKey, ok := cfg.objectKeyFromURL(*video.HLSURL)
+	if !ok {
+		return fmt.Errorf("HLS playlist %s isn't in storage", *video.HLSURL)
+	}
+	body, err := cfg.store.Get(ctx, masterKey)
+	if err != nil {
+		return err
+	}
+	master, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+
+	prefix := hlsPrefix(video) + transcode.HLSSubtitlesDir + "/"
+	tags := videoObjectTags(video)
+	duration := 0.0
+	if video.MediaInfo != nil {
+		duration = video.MediaInfo.Duration
+	}
+	langs := slices.Sorted(maps.Keys(video.CaptionsURL))
+	for _, lang := range langs {
+		playlist := transcode.SubtitlePlaylist(video.CaptionsURL[lang], duration)
+		err = cfg.putObjectBytes(ctx, prefix+lang+".m3u8", outputContentTypes[".m3u8"], tags, playlist)
+		if err != nil {
+			return err
+		}
+	}
+	err = cfg.putObjectBytes(ctx, masterKey, outputContentTypes[".m3u8"], tags, transcode.WithSubtitles(master, langs))
+	if err != nil {
+		return err
+	}
+
+	existing, err := cfg.listObjectKeys(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	stale := []string{}
+	for _, key := range existing {
+		lang := strings.TrimSuffix(strings.TrimPrefix(key, prefix), ".m3u8")
+		if _, ok := video.CaptionsURL[lang]; !ok {
+			stale = append(stale, key)
+		}
+	}
+	cfg.deleteOrphanedOutputs(ctx, stale)
+	return nil
+}
+
+// outputContentTypes are the types of the files transcode jobs produce, by
+// extension.
+var outputContentTypes = map[string]string{
+	".ts":   "video/mp2t",
+	".m3u8": "application/vnd.apple.mpegurl",
+	".jpg":  "image/jpeg",
+	".vtt":  "text/vtt",
+}
+
+func (cfg *apiConfig) deleteOrphanedOutputs(ctx context.Context, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	failed, err := cfg.deleteObjects(ctx, keys)
+	if err != nil {
+		slog.Error("Couldn't delete transcoded outputs", "error", err)
+		return
+	}
+	for key, keyErr := range failed {
+		slog.Error("Couldn't delete transcoded output", "key", key, "error", keyErr)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultTranscribeLanguage = "en-US"
+	transcriptionMaxAttempts  = 3
+	transcriptionBackoff      = time.Minute
+	transcriptionTimeout      = 2 * time.Hour
+)
+
+// transcriptionJob is the payload of a jobKindTranscription job. The video
+// URL is the file that was uploaded, so a job for a file since replaced
+// does nothing.
+type transcriptionJob struct {
+	VideoID  uuid.UUID `json:"video_id"`
+	VideoURL string    `json:"video_url"`
+}
+
+// enqueueTranscription queues speech-to-text captioning of a freshly
+// uploaded file, if it's turned on and the file has sound.
+func (cfg *apiConfig) enqueueTranscription(ctx context.Context, video database.Video) {
+	if cfg.transcriber == nil || video.VideoURL == nil || video.MediaInfo == nil || video.MediaInfo.AudioCodec == "" {
+		return
+	}
+	_, err := cfg.jobQueue.Enqueue(jobKindTranscription, transcriptionJob{
+		VideoID:  video.ID,
+		VideoURL: *video.VideoURL,
+	})
+	if err != nil {
+		loggerFromContext(ctx).Error("Couldn't queue transcription", "video_id", video.ID, "error", err)
+	}
+}
+
+// runTranscription makes one attempt at captioning a video's speech, and
+// attaches the result as an auto-generated track in cfg.transcribeLanguage.
+// A track uploaded in that language is never replaced.
+func (cfg *apiConfig) runTranscription(ctx context.Context, job database.Job) error {
+	var payload transcriptionJob
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	lang := cfg.transcribeLanguage
+	video, err := cfg.db.GetVideo(payload.VideoID)
+	if err != nil {
+		return err
+	}
+	if !wantsTranscription(video, payload.VideoURL, lang) {
+		return nil
+	}
+	videoKey, ok := cfg.objectKeyFromURL(payload.VideoURL)
+	if !ok {
+		return jobs.Permanent(fmt.Errorf("video %s isn't in storage", payload.VideoURL))
+	}
+
+	sourcePath, err := cfg.downloadObject(ctx, videoKey)
+	if err != nil {
+		return fmt.Errorf("downloading video: %w", err)
+	}
+	defer os.Remove(sourcePath)
+	audioPath := sourcePath + ".wav"
+	err = extractSpeechAudio(ctx, sourcePath, audioPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(audioPath)
+
+	vtt, err := cfg.transcriber.Transcribe(ctx, audioPath, lang)
+	if err != nil {
+		return err
+	}
+	vtt, err = normalizeCaptions(vtt, "vtt")
+	if err != nil {
+		// Speechless audio can come back without a single cue
+		return jobs.Permanent(err)
+	}
+
+	// Transcribing takes a while, and the video may have changed meanwhile
+	video, err = cfg.db.GetVideo(payload.VideoID)
+	if err != nil {
+		return err
+	}
+	if !wantsTranscription(video, payload.VideoURL, lang) {
+		return nil
+	}
+	captionsKey := captionsObjectKey(videoKey, lang)
+	err = cfg.putObjectBytes(ctx, captionsKey, "text/vtt", videoObjectTags(video), vtt)
+	if err != nil {
+		return err
+	}
+	if video.CaptionsURL == nil {
+		video.CaptionsURL = database.URLMap{}
+	}
+	video.CaptionsURL[lang] = cfg.getObjectURL(captionsKey)
+	if !slices.Contains(video.AutoCaptions, lang) {
+		video.AutoCaptions = append(video.AutoCaptions, lang)
+	}
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		return err
+	}
+	if err := cfg.updateHLSSubtitles(ctx, video); err != nil {
+		loggerFromContext(ctx).Warn("Couldn't add captions to HLS playlist", "video_id", video.ID, "error", err)
+	}
+	loggerFromContext(ctx).Info("Video transcribed", "video_id", video.ID, "lang", lang)
+	return nil
+}
+
+// wantsTranscription reports whether a video still has the file a job was
+// queued for, and no uploaded captions in lang that a transcript would
+// replace.
+func wantsTranscription(video database.Video, videoURL, lang string) bool {
+	if video.ID == uuid.Nil || video.VideoURL == nil || *video.VideoURL != videoURL {
+		return false
+	}
+	_, hasTrack := video.CaptionsURL[lang]
+	return !hasTrack || slices.Contains(video.AutoCaptions, lang)
+}
+
+// extractSpeechAudio writes a file's audio track as the 16 kHz mono WAV
+// speech-to-text backends expect.
+func extractSpeechAudio(ctx context.Context, input, output string) error {
+	err := runMediaCommand(ctx, "ffmpeg_speech_audio", ffmpegTimeout, nil, "ffmpeg", "-y", "-v", "error",
+		"-i", input, "-vn", "-ac", "1", "-ar", "16000", "-c:a", "pcm_s16le", "-f", "wav", output)
+	if err != nil {
+		return fmt.Errorf("extracting audio: %w", err)
+	}
+	return nil
+}
+
+// captionsObjectKey is where a caption track is stored, alongside the
+// video file, e.g. landscape/abc.en.vtt.
+func captionsObjectKey(videoKey, lang string) string {
+	return strings.TrimSuffix(videoKey, path.Ext(videoKey)) + "." + lang + ".vtt"
+}
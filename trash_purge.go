@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultTrashRetention = 30 * 24 * time.Hour
+	trashPurgeInterval    = time.Hour
+)
+
+// runTrashPurger permanently deletes videos that have been in the trash for
+// longer than retention, checking every trashPurgeInterval until ctx is
+// cancelled.
+func (cfg *apiConfig) runTrashPurger(ctx context.Context, retention time.Duration) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		purged, err := cfg.purgeTrash(ctx, retention)
+		if err != nil {
+			slog.Error("Trash purge failed", "error", err)
+			continue
+		}
+		if purged > 0 {
+			slog.Info("Trash purge deleted videos", "count", purged)
+		}
+	}
+}
+
+// purgeTrash deletes videos trashed more than retention ago. Videos that
+// can't be deleted stay in the trash and are retried on the next run.
+func (cfg *apiConfig) purgeTrash(ctx context.Context, retention time.Duration) (int, error) {
+	videos, err := cfg.db.GetVideosTrashedBefore(time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, video := range videos {
+		if ctx.Err() != nil {
+			break
+		}
+		err := cfg.deleteVideo(ctx, video)
+		if err != nil {
+			slog.Error("Couldn't purge video", "video_id", video.ID, "error", err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	defaultUploadSessionTTL = 24 * time.Hour
+	uploadExpiryInterval    = 10 * time.Minute
+)
+
+// runUploadExpirer drops idle upload sessions, videos whose file never
+// arrived and objects whose upload was never finalized, checking every
+// uploadExpiryInterval until ctx is cancelled.
+func (cfg *apiConfig) runUploadExpirer(ctx context.Context) {
+	ticker := time.NewTicker(uploadExpiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		sessions, videos, err := cfg.expireUploads(ctx)
+		if err != nil {
+			slog.Error("Upload expiry failed", "error", err)
+			continue
+		}
+		if sessions > 0 || videos > 0 {
+			slog.Info("Upload expiry removed abandoned uploads", "sessions", sessions, "videos", videos)
+		}
+
+		chunked, err := cfg.expireChunkedUploads()
+		if err != nil {
+			slog.Error("Chunked upload expiry failed", "error", err)
+		} else if chunked > 0 {
+			slog.Info("Upload expiry removed abandoned chunked uploads", "uploads", chunked)
+		}
+
+		objects, err := cfg.expirePendingObjects(ctx)
+		if err != nil {
+			slog.Error("Pending object expiry failed", "error", err)
+			continue
+		}
+		if objects > 0 {
+			slog.Info("Upload expiry rolled back unfinished uploads", "objects", objects)
+		}
+	}
+}
+
+// expireUploads discards sessions that haven't had a chunk in
+// uploadSessionTTL, then deletes pending or failed videos created with an
+// upload session that haven't changed for as long, and have no file and no
+// session left. Drafts created without a session are never expired. Those
+// that can't be deleted are retried on the next run.
+func (cfg *apiConfig) expireUploads(ctx context.Context) (int, int, error) {
+	cutoff := time.Now().Add(-cfg.uploadSessionTTL)
+	sessions, err := cfg.db.GetUploadSessionsIdleSince(cutoff)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, session := range sessions {
+		cfg.discardUploadSession(ctx, session)
+	}
+
+	videos, err := cfg.db.GetAbandonedVideos(cutoff, database.VideoStatusPending, database.VideoStatusFailed)
+	if err != nil {
+		return len(sessions), 0, err
+	}
+	deleted := 0
+	for _, video := range videos {
+		if ctx.Err() != nil {
+			break
+		}
+		err := cfg.deleteVideo(ctx, video)
+		if err != nil {
+			slog.Error("Couldn't delete abandoned video", "video_id", video.ID, "error", err)
+			continue
+		}
+		deleted++
+	}
+	return len(sessions), deleted, nil
+}
+
+// expirePendingObjects deletes objects recorded as pending for longer than
+// uploadSessionTTL, which a server stopped between storing them and saving
+// the video. A finalized upload's record is gone, so none of these is in use.
+func (cfg *apiConfig) expirePendingObjects(ctx context.Context) (int, error) {
+	objects, err := cfg.db.GetPendingObjectsBefore(time.Now().Add(-cfg.uploadSessionTTL))
+	if err != nil {
+		return 0, err
+	}
+	for _, object := range objects {
+		if ctx.Err() != nil {
+			break
+		}
+		cfg.abortVideoUpload(ctx, object.VideoID, object.Key)
+	}
+	return len(objects), nil
+}
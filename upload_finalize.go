@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// A video file is stored in steps that can each be undone: its key is
+// recorded as pending before anything is written, the video is pointed at
+// it in the transaction that clears the record, and if that fails the
+// object is deleted again. Only then are the files it replaced removed, so
+// a failure at any point leaves the video as it was. Records a crash left
+// behind are rolled back by the upload expirer.
+
+// beginVideoUpload records that key is about to be written for a video. It
+// responds itself and returns false if it can't.
+func (cfg *apiConfig) beginVideoUpload(w http.ResponseWriter, videoID uuid.UUID, key string) bool {
+	err := cfg.db.CreatePendingObject(key, videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record upload", err)
+		return false
+	}
+	return true
+}
+
+// abortVideoUpload deletes a pending object that won't be used and forgets
+// it. If the delete fails the record is kept, for the expirer to try again.
+func (cfg *apiConfig) abortVideoUpload(ctx context.Context, videoID uuid.UUID, key string) {
+	// The request may be what was cancelled, and cleaning up mustn't be
+	ctx = context.WithoutCancel(ctx)
+	err := cfg.store.Delete(ctx, key)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		loggerFromContext(ctx).Error("Couldn't delete abandoned upload", "video_id", videoID, "key", key, "error", err)
+		return
+	}
+	err = cfg.db.DeletePendingObject(key)
+	if err != nil {
+		loggerFromContext(ctx).Error("Couldn't clear pending upload", "video_id", videoID, "key", key, "error", err)
+	}
+}
+
+// finalizeVideoUpload saves video now that its new file is stored under
+// pendingKey, which is empty when an existing object was reused. If that
+// fails the new object is deleted and the error returned. Otherwise the
+// files of replaced, the video as it was before the upload, are removed
+// where video no longer uses them.
+func (cfg *apiConfig) finalizeVideoUpload(r *http.Request, video, replaced database.Video, pendingKey string) error {
+	err := cfg.db.FinalizeVideoUpload(video, pendingKey)
+	if err != nil {
+		if pendingKey != "" {
+			cfg.abortVideoUpload(r.Context(), video.ID, pendingKey)
+		}
+		if video.PreviewURL != nil && !sameURL(video.PreviewURL, replaced.PreviewURL) {
+			cfg.deletePreview(r.Context(), &video)
+		}
+		return err
+	}
+
+	if replaced.VideoURL != nil && !sameURL(replaced.VideoURL, video.VideoURL) {
+		err := cfg.deleteVideoFile(r.Context(), replaced)
+		if err != nil {
+			loggerFromContext(r.Context()).Error("Couldn't delete previous video", "video_id", video.ID, "error", err)
+		}
+	}
+	cfg.deleteTranscodedOutputs(r, &replaced)
+	// Previews have content-versioned names, so an identical upload's is
+	// the same object
+	if !sameURL(replaced.PreviewURL, video.PreviewURL) {
+		cfg.deletePreview(r.Context(), &replaced)
+	}
+	return nil
+}
+
+// clearReplacedOutputs drops what was made from a video's previous file,
+// which finalizeVideoUpload deletes once the new one is saved.
+func clearReplacedOutputs(video *database.Video) {
+	video.Renditions = nil
+	video.HLSURL = nil
+	video.SpritesVTTURL = nil
+	video.PreviewURL = nil
+}
+
+func sameURL(a, b *string) bool {
+	return a != nil && b != nil && *a == *b
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+)
+
+// multipartOverheadBytes is allowed on top of a file's size limit for the
+// boundaries, part headers and small fields a multipart form wraps it in.
+const multipartOverheadBytes = 64 << 10
+
+// parseUploadForm reads a multipart form holding a single file, in field,
+// of at most limit bytes. The form as a whole may be only a little larger.
+// It responds itself and returns false if the form is malformed, or with a
+// 413 naming the limit if the file is too large. what is what the file is
+// called in that message.
+func parseUploadForm(w http.ResponseWriter, r *http.Request, field, what string, limit int64) (*multipart.FileHeader, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, limit+multipartOverheadBytes)
+	if err := r.ParseMultipartForm(limit); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("%s can be at most %s", what, formatByteLimit(limit)), err)
+			return nil, false
+		}
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
+		return nil, false
+	}
+	files := r.MultipartForm.File[field]
+	if len(files) == 0 {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Missing %s file", field), http.ErrMissingFile)
+		return nil, false
+	}
+	if len(files) > 1 {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Only one %s file can be uploaded", field), nil)
+		return nil, false
+	}
+	// The body limit has some slack for the form around the file
+	if files[0].Size > limit {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("%s can be at most %s", what, formatByteLimit(limit)), nil)
+		return nil, false
+	}
+	return files[0], true
+}
+
+// formatByteLimit writes a limit in the largest unit it's a whole number
+// of.
+func formatByteLimit(limit int64) string {
+	switch {
+	case limit >= 1<<20 && limit%(1<<20) == 0:
+		return fmt.Sprintf("%d MB", limit>>20)
+	case limit >= 1<<10 && limit%(1<<10) == 0:
+		return fmt.Sprintf("%d KB", limit>>10)
+	default:
+		return fmt.Sprintf("%d bytes", limit)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// Stages an upload moves through after its bytes have arrived
+const (
+	uploadStageReceiving  = "receiving"
+	uploadStageProcessing = "processing"
+	uploadStageStoring    = "storing"
+	uploadStageComplete   = "complete"
+	uploadStageFailed     = "failed"
+)
+
+const (
+	// Finished uploads stay queryable for a while so a client polling on an
+	// interval still sees how it ended
+	uploadProgressRetention = 5 * time.Minute
+	uploadProgressInterval  = 500 * time.Millisecond
+)
+
+// uploadProgress tracks one in-flight upload. Clients pick the ID and pass it
+// as ?upload_id= so they can ask about the upload while it's still running.
+type uploadProgress struct {
+	userID   uuid.UUID
+	total    int64
+	received atomic.Int64
+
+	mu    sync.Mutex
+	stage string
+}
+
+type uploadProgressSnapshot struct {
+	Received int64  `json:"received"`
+	Total    int64  `json:"total"`
+	Stage    string `json:"stage"`
+}
+
+func (p *uploadProgress) setStage(stage string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stage = stage
+}
+
+func (p *uploadProgress) snapshot() uploadProgressSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return uploadProgressSnapshot{
+		Received: p.received.Load(),
+		Total:    p.total,
+		Stage:    p.stage,
+	}
+}
+
+// uploadProgressTracker holds progress for uploads in this process.
+type uploadProgressTracker struct {
+	mu      sync.Mutex
+	uploads map[uuid.UUID]*uploadProgress
+}
+
+func newUploadProgressTracker() *uploadProgressTracker {
+	return &uploadProgressTracker{uploads: map[uuid.UUID]*uploadProgress{}}
+}
+
+// start registers an upload, refusing an ID that's already in use.
+func (t *uploadProgressTracker) start(id, userID uuid.UUID, total int64) (*uploadProgress, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.uploads[id]; ok {
+		return nil, false
+	}
+	p := &uploadProgress{userID: userID, total: total, stage: uploadStageReceiving}
+	t.uploads[id] = p
+	return p, true
+}
+
+// finish records how the upload ended and forgets it after the retention
+// period.
+func (t *uploadProgressTracker) finish(id uuid.UUID, p *uploadProgress, ok bool) {
+	if ok {
+		p.setStage(uploadStageComplete)
+	} else {
+		p.setStage(uploadStageFailed)
+	}
+	time.AfterFunc(uploadProgressRetention, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.uploads, id)
+	})
+}
+
+func (t *uploadProgressTracker) get(id uuid.UUID) (*uploadProgress, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.uploads[id]
+	return p, ok
+}
+
+// countingReadCloser adds every byte read to an upload's progress.
+type countingReadCloser struct {
+	io.ReadCloser
+	progress *uploadProgress
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.progress.received.Add(int64(n))
+	return n, err
+}
+
+type uploadProgressKey struct{}
+
+// trackUploadProgress starts tracking the request body if the client named an
+// upload ID. The returned function must be called with the upload's outcome.
+// It responds itself and returns false on a bad or duplicate ID.
+func (cfg *apiConfig) trackUploadProgress(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (*http.Request, func(ok bool), bool) {
+	value := r.URL.Query().Get("upload_id")
+	if value == "" {
+		return r, func(bool) {}, true
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "upload_id must be a UUID", err)
+		return nil, nil, false
+	}
+	progress, ok := cfg.uploadProgress.start(id, userID, r.ContentLength)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "upload_id is already in use", nil)
+		return nil, nil, false
+	}
+
+	r.Body = &countingReadCloser{ReadCloser: r.Body, progress: progress}
+	r = r.WithContext(context.WithValue(r.Context(), uploadProgressKey{}, progress))
+	return r, func(ok bool) { cfg.uploadProgress.finish(id, progress, ok) }, true
+}
+
+// setUploadStage updates the progress of the upload a request is carrying,
+// if it's being tracked.
+func setUploadStage(ctx context.Context, stage string) {
+	if progress, ok := ctx.Value(uploadProgressKey{}).(*uploadProgress); ok {
+		progress.setStage(stage)
+	}
+}
+
+// handlerUploadProgress reports how far along an upload is. Clients that
+// accept text/event-stream get a stream of updates until it finishes.
+func (cfg *apiConfig) handlerUploadProgress(w http.ResponseWriter, r *http.Request) {
+	uploadID, err := uuid.Parse(r.PathValue("uploadID"))
+	if err != nil {
+		respondWithAPIError(w, &apiError{http.StatusBadRequest, codeInvalidID, "Invalid upload ID"}, err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	progress, ok := cfg.uploadProgress.get(uploadID)
+	if !ok || progress.userID != userID {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return
+	}
+
+	if r.Header.Get("Accept") != "text/event-stream" {
+		respondWithJSON(w, http.StatusOK, progress.snapshot())
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusNotAcceptable, "Streaming isn't supported", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(uploadProgressInterval)
+	defer ticker.Stop()
+	for {
+		snapshot := progress.snapshot()
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+		if snapshot.Stage == uploadStageComplete || snapshot.Stage == uploadStageFailed {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
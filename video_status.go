@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// videoEventsKeepAlive is how often an idle stream gets a comment, so
+	// proxies don't time it out
+	videoEventsKeepAlive = 30 * time.Second
+	// videoEventsBuffer is how many updates a slow subscriber can fall
+	// behind before it misses some
+	videoEventsBuffer = 16
+)
+
+type videoStatusUpdate struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// statusBroker fans status changes out to the streams watching each video.
+// It only knows about changes made by this process.
+type statusBroker struct {
+	mu          sync.Mutex
+	subscribers map[uuid.UUID]map[chan videoStatusUpdate]struct{}
+	closed      bool
+}
+
+func newStatusBroker() *statusBroker {
+	return &statusBroker{subscribers: map[uuid.UUID]map[chan videoStatusUpdate]struct{}{}}
+}
+
+// subscribe returns a channel of videoID's status changes, which is closed
+// when unsubscribe is called or the broker shuts down.
+func (b *statusBroker) subscribe(videoID uuid.UUID) (updates <-chan videoStatusUpdate, unsubscribe func()) {
+	ch := make(chan videoStatusUpdate, videoEventsBuffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if b.subscribers[videoID] == nil {
+		b.subscribers[videoID] = map[chan videoStatusUpdate]struct{}{}
+	}
+	b.subscribers[videoID][ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[videoID][ch]; !ok {
+			return
+		}
+		delete(b.subscribers[videoID], ch)
+		if len(b.subscribers[videoID]) == 0 {
+			delete(b.subscribers, videoID)
+		}
+		close(ch)
+	}
+}
+
+// publish sends an update to the video's subscribers without waiting on any
+// of them.
+func (b *statusBroker) publish(update videoStatusUpdate) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers[update.VideoID] {
+		select {
+		case ch <- update:
+		default:
+		}
+	}
+}
+
+// close ends every stream, so they don't hold up a graceful shutdown.
+func (b *statusBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for videoID, subscribers := range b.subscribers {
+		for ch := range subscribers {
+			close(ch)
+		}
+		delete(b.subscribers, videoID)
+	}
+}
+
+// setVideoStatus records a pipeline transition and tells anyone watching.
+// Failing to record it doesn't fail the upload, but watchers only hear of
+// transitions that were recorded.
+func (cfg *apiConfig) setVideoStatus(ctx context.Context, videoID uuid.UUID, status string) {
+	err := cfg.db.SetVideoStatus(videoID, status)
+	if err != nil {
+		loggerFromContext(ctx).Error("Couldn't record video status", "video_id", videoID, "status", status, "error", err)
+		return
+	}
+	cfg.videoStatus.publish(videoStatusUpdate{
+		VideoID:   videoID,
+		Status:    status,
+		UpdatedAt: time.Now().UTC(),
+	})
+}
+
+// handlerVideoEvents streams a video's processing status as server-sent
+// events, starting with its current status, until the client goes away. It's
+// served behind requireOwnerOrAdmin.
+func (cfg *apiConfig) handlerVideoEvents(w http.ResponseWriter, r *http.Request) {
+	video := authVideoFromContext(r.Context())
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusNotAcceptable, "Streaming isn't supported", nil)
+		return
+	}
+
+	// Subscribe before reading the current status so no change is missed
+	updates, unsubscribe := cfg.videoStatus.subscribe(video.ID)
+	defer unsubscribe()
+	current, err := cfg.db.GetVideoWithDeleted(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(update videoStatusUpdate) bool {
+		data, err := json.Marshal(update)
+		if err != nil {
+			return false
+		}
+		_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+		return err == nil
+	}
+	if !send(videoStatusUpdate{VideoID: current.ID, Status: current.Status, UpdatedAt: current.UpdatedAt}) {
+		return
+	}
+
+	keepAlive := time.NewTicker(videoEventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case update, ok := <-updates:
+			if !ok || !send(update) {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultViewDebounce = 30 * time.Minute
+	defaultStatsDays    = 30
+	maxStatsDays        = 365
+)
+
+// botUserAgent matches the user agents of crawlers, link previewers and
+// other clients that fetch videos without anyone watching them.
+var botUserAgent = regexp.MustCompile(`(?i)bot|crawl|spider|slurp|preview|facebookexternalhit|headless|lighthouse`)
+
+// viewTracker remembers who has viewed which video recently, so a player
+// fetching a video again, or a viewer reloading the page, counts once.
+type viewTracker struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+func newViewTracker(window time.Duration) *viewTracker {
+	return &viewTracker{
+		window:    window,
+		seen:      map[string]time.Time{},
+		lastSweep: time.Now(),
+	}
+}
+
+// firstView reports whether key hasn't been seen within the window, and
+// starts the window over either way.
+func (t *viewTracker) firstView(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.lastSweep) > t.window {
+		for k, at := range t.seen {
+			if now.Sub(at) > t.window {
+				delete(t.seen, k)
+			}
+		}
+		t.lastSweep = now
+	}
+
+	last, ok := t.seen[key]
+	t.seen[key] = now
+	return !ok || now.Sub(last) > t.window
+}
+
+// recordView counts a request for video as a view, unless it comes from a
+// bot, has already been counted for this client recently, or fetches
+// anything but the start of the file, as players seeking do. A failure to
+// count is logged rather than getting in the way of playback.
+func (cfg *apiConfig) recordView(r *http.Request, video database.Video) {
+	if r.Method != http.MethodGet {
+		return
+	}
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && !strings.HasPrefix(rangeHeader, "bytes=0-") {
+		return
+	}
+	userAgent := r.Header.Get("User-Agent")
+	if userAgent == "" || botUserAgent.MatchString(userAgent) {
+		return
+	}
+	if !cfg.viewTracker.firstView(clientIP(r) + "|" + video.ID.String()) {
+		return
+	}
+	err := cfg.db.RecordVideoView(video.ID, time.Now())
+	if err != nil {
+		loggerFromContext(r.Context()).Error("Couldn't record view", "video_id", video.ID, "error", err)
+	}
+}
+
+// handlerVideoStats reports a video's views for each of the last days days,
+// 30 unless set, along with its views of all time. It's served behind
+// requireOwnerOrAdmin.
+func (cfg *apiConfig) handlerVideoStats(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		VideoID    uuid.UUID             `json:"video_id"`
+		TotalViews int64                 `json:"total_views"`
+		Views      int64                 `json:"views"`
+		From       string                `json:"from"`
+		To         string                `json:"to"`
+		Daily      []database.DailyViews `json:"daily"`
+	}
+
+	video := authVideoFromContext(r.Context())
+	days := defaultStatsDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 1 || days > maxStatsDays {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("days must be between 1 and %d", maxStatsDays), err)
+			return
+		}
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from := today.AddDate(0, 0, 1-days)
+	counted, total, err := cfg.db.GetVideoViews(video.ID, from)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get views", err)
+		return
+	}
+
+	// Days nobody watched are filled in, so charts don't skip them
+	byDay := map[string]int64{}
+	for _, day := range counted {
+		byDay[day.Date] = day.Views
+	}
+	resp := response{
+		VideoID:    video.ID,
+		TotalViews: total,
+		From:       from.Format(time.DateOnly),
+		To:         today.Format(time.DateOnly),
+		Daily:      make([]database.DailyViews, 0, days),
+	}
+	for day := from; !day.After(today); day = day.AddDate(0, 0, 1) {
+		date := day.Format(time.DateOnly)
+		resp.Daily = append(resp.Daily, database.DailyViews{Date: date, Views: byDay[date]})
+		resp.Views += byDay[date]
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
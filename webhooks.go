@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/webhook"
+	"github.com/google/uuid"
+)
+
+const (
+	eventVideoUploaded    = "video.uploaded"
+	eventVideoTranscoded  = "video.transcoded"
+	eventVideoDeleted     = "video.deleted"
+	eventThumbnailUpdated = "thumbnail.updated"
+	eventExportCompleted  = "export.completed"
+	eventExportFailed     = "export.failed"
+
+	maxWebhooksPerUser = 10
+)
+
+var webhookEvents = []string{eventVideoUploaded, eventVideoTranscoded, eventVideoDeleted, eventThumbnailUpdated, eventExportCompleted, eventExportFailed}
+
+// webhookEvent is the body POSTed to webhooks.
+type webhookEvent struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// deletedVideoEvent is the data of a video.deleted event. Permanent is false
+// when the video was only moved to the trash.
+type deletedVideoEvent struct {
+	database.Video
+	Permanent bool `json:"permanent"`
+}
+
+// newWebhookHTTPClient returns the client events are sent with. Redirects
+// aren't followed, and outside dev only public addresses can be reached, so
+// webhooks can't be pointed at internal services.
+func newWebhookHTTPClient(allowPrivate bool) *http.Client {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if !allowPrivate {
+		client.Transport = newPublicTransport()
+	}
+	return client
+}
+
+// webhookDelivery is the payload of a webhook delivery job. The webhook's URL
+// and secret are looked up when it runs, so it's never sent to a webhook
+// that has since been deleted.
+type webhookDelivery struct {
+	ID        uuid.UUID       `json:"id"`
+	WebhookID uuid.UUID       `json:"webhook_id"`
+	Event     string          `json:"event"`
+	Body      json.RawMessage `json:"body"`
+}
+
+// notifyWebhooks sends an event to the webhooks userID has subscribed to it.
+// Delivery happens in the background, so failures are only logged.
+func (cfg *apiConfig) notifyWebhooks(ctx context.Context, userID uuid.UUID, eventType string, data any) {
+	if cfg.jobQueue == nil {
+		return
+	}
+	logger := loggerFromContext(ctx)
+	webhooks, err := cfg.db.GetWebhooksForEvent(userID, eventType)
+	if err != nil {
+		logger.Error("Couldn't look up webhooks", "user_id", userID, "event", eventType, "error", err)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+
+	event := webhookEvent{
+		ID:        uuid.New(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("Couldn't encode webhook event", "event", eventType, "error", err)
+		return
+	}
+	for _, hook := range webhooks {
+		_, err := cfg.jobQueue.Enqueue(jobKindWebhookDelivery, webhookDelivery{
+			ID:        uuid.New(),
+			WebhookID: hook.ID,
+			Event:     eventType,
+			Body:      body,
+		})
+		if err != nil {
+			logger.Error("Couldn't queue webhook delivery", "webhook_id", hook.ID, "event", eventType, "error", err)
+		}
+	}
+}
+
+// runWebhookDelivery makes one attempt at POSTing an event to a webhook.
+func (cfg *apiConfig) runWebhookDelivery(ctx context.Context, job database.Job) error {
+	var delivery webhookDelivery
+	err := json.Unmarshal(job.Payload, &delivery)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	hook, err := cfg.db.GetWebhook(delivery.WebhookID)
+	if err != nil {
+		return err
+	}
+	if hook.ID == uuid.Nil {
+		return nil
+	}
+	return webhook.Send(ctx, cfg.webhookClient, webhook.Delivery{
+		ID:        delivery.ID,
+		WebhookID: hook.ID,
+		URL:       hook.URL,
+		Secret:    hook.Secret,
+		Event:     delivery.Event,
+		Body:      delivery.Body,
+	}, job.Attempts)
+}
+
+func (cfg *apiConfig) handlerWebhooksCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	type response struct {
+		database.Webhook
+		Secret string `json:"secret"`
+	}
+
+	userID := authUserFromContext(r.Context()).ID
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	target, err := url.Parse(params.URL)
+	if err != nil || target.Host == "" || (target.Scheme != "https" && !(cfg.platform == "dev" && target.Scheme == "http")) {
+		respondWithError(w, http.StatusBadRequest, "url must be an absolute https URL", err)
+		return
+	}
+	if len(params.Events) == 0 {
+		respondWithError(w, http.StatusBadRequest, "events must list at least one event", nil)
+		return
+	}
+	for _, event := range params.Events {
+		if !slices.Contains(webhookEvents, event) {
+			respondWithError(w, http.StatusBadRequest, "Unknown event "+event, nil)
+			return
+		}
+	}
+	slices.Sort(params.Events)
+	params.Events = slices.Compact(params.Events)
+
+	existing, err := cfg.db.GetWebhooks(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhooks", err)
+		return
+	}
+	if len(existing) >= maxWebhooksPerUser {
+		respondWithError(w, http.StatusBadRequest, "You already have the maximum number of webhooks", nil)
+		return
+	}
+
+	secret, err := auth.MakeWebhookSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook", err)
+		return
+	}
+	hook, err := cfg.db.CreateWebhook(database.CreateWebhookParams{
+		UserID: userID,
+		URL:    target.String(),
+		Events: params.Events,
+	}, secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save webhook", err)
+		return
+	}
+
+	// This is the only time the secret is returned
+	respondWithJSON(w, http.StatusCreated, response{Webhook: hook, Secret: secret})
+}
+
+func (cfg *apiConfig) handlerWebhooksList(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := cfg.db.GetWebhooks(authUserFromContext(r.Context()).ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhooks", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, webhooks)
+}
+
+func (cfg *apiConfig) handlerWebhooksDelete(w http.ResponseWriter, r *http.Request) {
+	webhookID, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		respondWithAPIError(w, errInvalidID, err)
+		return
+	}
+	deleted, err := cfg.db.DeleteWebhook(webhookID, authUserFromContext(r.Context()).ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete webhook", err)
+		return
+	}
+	if !deleted {
+		respondWithError(w, http.StatusNotFound, "Webhook not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}